@@ -1,22 +1,217 @@
+// Package api implements the versioned JSON API served on the api. host.
 package api
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"math"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/plifk/market/internal/ratelimit"
+	"github.com/plifk/market/internal/router"
 	"github.com/plifk/market/internal/services"
+	"github.com/plifk/market/internal/strictapi"
 )
 
 // Router for the API.
 type Router struct {
 	modules *services.Modules
+	mux     *router.Mux
+	allowed strictapi.AllowedEndpoints
+
+	// routes, limited by the rate limit middleware.
+	routes http.Handler
+
+	// invalidToken responds to requests with invalid tokens, limited by IP address by the same middleware.
+	invalidToken http.Handler
+}
+
+// rateLimitRules of the API.
+var rateLimitRules = []ratelimit.Rule{
+	{
+		Pattern: "/oauth/token",
+		Policy:  ratelimit.Policy{Name: "api:token", Limit: 30, Period: time.Minute},
+	},
+	{
+		Pattern: "/v1/orders/*",
+		Methods: []string{http.MethodPost},
+		Policy:  ratelimit.Policy{Name: "api:orders", Limit: 20, Period: time.Minute},
+	},
 }
 
+// defaultRateLimit of the API.
+var defaultRateLimit = ratelimit.Policy{Name: "api", Limit: 300, Period: time.Minute, Burst: 60}
+
 // Load API.
 func (rh *Router) Load(modules *services.Modules) {
 	rh.modules = modules
+	rh.mux = &router.Mux{
+		DefaultHandler: http.HandlerFunc(rh.notFound),
+		Routes: []router.Route{
+			{Pattern: "/v1/products", Methods: []string{http.MethodGet}, Handler: http.HandlerFunc(rh.listProducts)},
+			{Pattern: "/v1/products/:product_id", Methods: []string{http.MethodGet}, Handler: http.HandlerFunc(rh.getProduct)},
+			{Pattern: "/v1/cart", Methods: []string{http.MethodGet}, Handler: rh.authenticated(services.ScopeCart, rh.getCart)},
+			{Pattern: "/v1/cart", Methods: []string{http.MethodDelete}, Handler: rh.authenticated(services.ScopeCart, rh.clearCart)},
+			{Pattern: "/v1/cart/items", Methods: []string{http.MethodPost}, Handler: rh.authenticated(services.ScopeCart, rh.addCartItem)},
+			{Pattern: "/v1/cart/items/:variant_id", Methods: []string{http.MethodPut}, Handler: rh.authenticated(services.ScopeCart, rh.setCartItem)},
+			{Pattern: "/v1/cart/items/:variant_id", Methods: []string{http.MethodDelete}, Handler: rh.authenticated(services.ScopeCart, rh.removeCartItem)},
+			{Pattern: "/v1/orders", Methods: []string{http.MethodGet}, Handler: rh.authenticated(services.ScopeOrders, rh.listOrders)},
+			{Pattern: "/v1/orders", Methods: []string{http.MethodPost}, Handler: rh.authenticated(services.ScopeOrders, rh.checkout)},
+			{Pattern: "/v1/orders/:order_id", Methods: []string{http.MethodGet}, Handler: rh.authenticated(services.ScopeOrders, rh.getOrder)},
+			{Pattern: "/v1/orders/:order_id/pay", Methods: []string{http.MethodPost}, Handler: rh.authenticated(services.ScopeOrders, rh.payOrder)},
+			{Pattern: "/v1/orders/:order_id/cancel", Methods: []string{http.MethodPost}, Handler: rh.authenticated(services.ScopeOrders, rh.cancelOrder)},
+			{Pattern: "/v1/me", Methods: []string{http.MethodGet}, Handler: rh.authenticated(services.ScopeProfile, rh.me)},
+			{Pattern: "/oauth/token", Methods: []string{http.MethodPost}, Handler: http.HandlerFunc(rh.token)},
+		},
+	}
+	rh.mux.Validate()
+	limiter := modules.RateLimits.Middleware(rateLimitRules, defaultRateLimit, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusTooManyRequests, "rate_limited", "Too many requests. Try again after the number of seconds on the Retry-After header.")
+	}))
+	rh.routes = limiter.Handler(rh.mux)
+	rh.invalidToken = limiter.Handler(http.HandlerFunc(writeInvalidToken))
+	for _, route := range rh.mux.Routes {
+		rh.allowed.Add(allowListPath(route.Pattern), route.Methods...)
+	}
+}
+
+// allowListPath converts a route pattern to the format used by the allow-list.
+func allowListPath(pattern string) string {
+	parts := strings.Split(pattern, "/")
+	for i, p := range parts {
+		if strings.HasPrefix(p, ":") {
+			parts[i] = "%s"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// AllowedEndpoints returns the allow-list of endpoints served by the API.
+func (rh *Router) AllowedEndpoints() []strictapi.Endpoint {
+	return rh.allowed.Endpoints()
 }
 
 func (rh *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintln(w, "API")
+	// Requests not on the allow-list never reach the handlers.
+	if !rh.allowed.Check(r) {
+		methods := rh.allowed.Methods(r)
+		if len(methods) == 0 {
+			rh.notFound(w, r)
+			return
+		}
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed for this endpoint.")
+		return
+	}
+	if !acceptsJSON(r.Header.Get("Accept")) {
+		writeError(w, http.StatusNotAcceptable, "not_acceptable", "This API only serves application/json.")
+		return
+	}
+	if token, ok := bearerToken(r); ok {
+		if r, ok = rh.authenticate(w, r, token); !ok {
+			return
+		}
+	}
+	rh.routes.ServeHTTP(w, r)
+}
+
+// writeInvalidToken responds to a request with an invalid token.
+func writeInvalidToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="market", error="invalid_token"`)
+	writeError(w, http.StatusUnauthorized, "invalid_token", "Access token is invalid, expired, or revoked.")
+}
+
+// bearerToken from the Authorization header. Other authentication schemes are ignored.
+func bearerToken(r *http.Request) (string, bool) {
+	const scheme = "bearer "
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) <= len(scheme) || !strings.EqualFold(authorization[:len(scheme)], scheme) {
+		return "", false
+	}
+	return strings.TrimSpace(authorization[len(scheme):]), true
+}
+
+// authenticate the request with a Bearer token.
+// It writes the error to the response and returns false if the token is invalid.
+//
+// Failed authentications are counted per IP address by the LoginThrottle, and checked before the token,
+// so that tokens cannot be guessed. Requests with invalid tokens are also rate limited by IP address.
+func (rh *Router) authenticate(w http.ResponseWriter, r *http.Request, bearer string) (*http.Request, bool) {
+	modules := rh.modules
+	attempt := services.LoginAttempt{IP: modules.Security.ClientIP(r)}
+	err := modules.LoginThrottle.Check(r.Context(), attempt)
+	var throttled *services.LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		writeError(w, http.StatusTooManyRequests, "rate_limited", "Too many invalid access tokens. Try again after the number of seconds on the Retry-After header.")
+		return r, false
+	case err != nil:
+		log.Printf("cannot check failed authentications: %v", err)
+	}
+	token, user, err := modules.Tokens.Authenticate(r.Context(), bearer)
+	switch {
+	case err == services.ErrInvalidToken:
+		if err := modules.LoginThrottle.Fail(r.Context(), attempt); err != nil {
+			log.Printf("cannot record failed authentication: %v", err)
+		}
+		rh.invalidToken.ServeHTTP(w, r)
+		return r, false
+	case err != nil:
+		writeInternalError(w, r, err)
+		return r, false
+	}
+	ctx := services.UserContext(r.Context(), user)
+	return r.Clone(services.TokenContext(ctx, token)), true
+}
+
+func (rh *Router) notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, "not_found", "Endpoint not found.")
+}
+
+// authenticated handlers require a user and an access token granting the given scope on the request context.
+func (rh *Router) authenticated(scope services.TokenScope, fn func(w http.ResponseWriter, r *http.Request, user *services.User)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := services.UserFromRequest(r)
+		if user == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="market"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized", "Authentication required.")
+			return
+		}
+		if token := services.TokenFromRequest(r); token == nil || !token.HasScope(scope) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="market", error="insufficient_scope", scope="%s"`, scope))
+			writeError(w, http.StatusForbidden, "insufficient_scope", fmt.Sprintf("Access token must have the %q scope.", scope))
+			return
+		}
+		fn(w, r, user)
+	})
+}
+
+// acceptsJSON checks if a client accepts a JSON response given the value of its Accept header.
+// An empty header means any media type is accepted.
+func acceptsJSON(accept string) bool {
+	if accept == "" {
+		return true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if q, ok := params["q"]; ok {
+			if v, err := strconv.ParseFloat(q, 64); err != nil || v == 0 {
+				continue
+			}
+		}
+		switch mediaType {
+		case "*/*", "application/*", "application/json":
+			return true
+		}
+	}
+	return false
 }
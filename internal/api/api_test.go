@@ -0,0 +1,304 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plifk/market/internal/services"
+)
+
+// userContext authenticates a request with an access token with the given scopes (default: all).
+func userContext(ctx context.Context, user *services.User, scopes ...services.TokenScope) context.Context {
+	if len(scopes) == 0 {
+		scopes = services.TokenScopes
+	}
+	ctx = services.UserContext(ctx, user)
+	return services.TokenContext(ctx, &services.APIToken{UserID: user.UserID, Scopes: scopes})
+}
+
+func TestAcceptsJSON(t *testing.T) {
+	testCases := []struct {
+		accept string
+		want   bool
+	}{
+		{accept: "", want: true},
+		{accept: "*/*", want: true},
+		{accept: "application/*", want: true},
+		{accept: "application/json", want: true},
+		{accept: "application/json; charset=utf-8", want: true},
+		{accept: "text/html, application/json;q=0.9", want: true},
+		{accept: "text/html", want: false},
+		{accept: "application/xml", want: false},
+		{accept: "application/json;q=0", want: false},
+		{accept: "text/html, */*;q=0.0", want: false},
+		{accept: "invalid;;", want: false},
+	}
+	for _, tc := range testCases {
+		if got := acceptsJSON(tc.accept); got != tc.want {
+			t.Errorf("acceptsJSON(%q) = %v, wanted %v instead", tc.accept, got, tc.want)
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	user := &services.User{
+		UserID:    "u1",
+		Name:      "Jane Doe",
+		Email:     "jane@example.com",
+		CreatedAt: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	testCases := []struct {
+		name        string
+		method      string
+		path        string
+		accept      string
+		contentType string
+		body        string
+		auth        string
+		user        *services.User
+		scopes      []services.TokenScope
+
+		wantCode  int
+		wantError string
+	}{
+		{name: "not acceptable", method: http.MethodGet, path: "/v1/me", accept: "text/html", user: user, wantCode: http.StatusNotAcceptable, wantError: "not_acceptable"},
+		{name: "not found", method: http.MethodGet, path: "/v1/unknown", wantCode: http.StatusNotFound, wantError: "not_found"},
+		{name: "wrong method", method: http.MethodPatch, path: "/v1/me", user: user, wantCode: http.StatusMethodNotAllowed, wantError: "method_not_allowed"},
+		{name: "not found on allow-list", method: http.MethodGet, path: "/v1/products/p1/reviews", wantCode: http.StatusNotFound, wantError: "not_found"},
+		{name: "me unauthorized", method: http.MethodGet, path: "/v1/me", wantCode: http.StatusUnauthorized, wantError: "unauthorized"},
+		{name: "cart unauthorized", method: http.MethodGet, path: "/v1/cart", wantCode: http.StatusUnauthorized, wantError: "unauthorized"},
+		{name: "orders unauthorized", method: http.MethodPost, path: "/v1/orders", wantCode: http.StatusUnauthorized, wantError: "unauthorized"},
+		{name: "other authorization scheme", method: http.MethodGet, path: "/v1/me", auth: "Basic Zm9vOmJhcg==", wantCode: http.StatusUnauthorized, wantError: "unauthorized"},
+		{name: "invalid token", method: http.MethodGet, path: "/v1/products", auth: "Bearer abc", wantCode: http.StatusUnauthorized, wantError: "invalid_token"},
+		{name: "insufficient scope", method: http.MethodGet, path: "/v1/cart", user: user, scopes: []services.TokenScope{services.ScopeProfile}, wantCode: http.StatusForbidden, wantError: "insufficient_scope"},
+		{name: "me", method: http.MethodGet, path: "/v1/me", accept: "application/json", user: user, wantCode: http.StatusOK},
+		{name: "unsupported media type", method: http.MethodPost, path: "/v1/cart/items", contentType: "text/plain", body: "{}", user: user, wantCode: http.StatusUnsupportedMediaType, wantError: "unsupported_media_type"},
+		{name: "invalid body", method: http.MethodPost, path: "/v1/cart/items", contentType: "application/json", body: "{", user: user, wantCode: http.StatusBadRequest, wantError: "invalid_body"},
+		{name: "unknown field", method: http.MethodPut, path: "/v1/cart/items/v1", contentType: "application/json", body: `{"qty": 1}`, user: user, wantCode: http.StatusBadRequest, wantError: "invalid_body"},
+		{name: "invalid page", method: http.MethodGet, path: "/v1/products?page=0", wantCode: http.StatusBadRequest, wantError: "invalid_pagination"},
+		{name: "invalid per page", method: http.MethodGet, path: "/v1/products?per_page=1000", wantCode: http.StatusBadRequest, wantError: "invalid_pagination"},
+	}
+	rh := &Router{}
+	rh.Load(&services.Modules{})
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, "http://api.example.com"+tc.path, strings.NewReader(tc.body))
+			if tc.accept != "" {
+				r.Header.Set("Accept", tc.accept)
+			}
+			if tc.contentType != "" {
+				r.Header.Set("Content-Type", tc.contentType)
+			}
+			if tc.auth != "" {
+				r.Header.Set("Authorization", tc.auth)
+			}
+			if tc.user != nil {
+				r = r.WithContext(userContext(r.Context(), tc.user, tc.scopes...))
+			}
+			w := httptest.NewRecorder()
+			rh.ServeHTTP(w, r)
+
+			if w.Code != tc.wantCode {
+				t.Errorf("wanted status code %d, got %d instead", tc.wantCode, w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+				t.Errorf("wanted JSON content type, got %q instead", ct)
+			}
+			var resp struct {
+				Data  json.RawMessage `json:"data"`
+				Error *errorObject    `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("cannot decode response: %v", err)
+			}
+			switch {
+			case tc.wantError == "" && resp.Error != nil:
+				t.Errorf("wanted no error, got %+v instead", resp.Error)
+			case tc.wantError == "" && len(resp.Data) == 0:
+				t.Error("wanted data on response")
+			case tc.wantError != "" && (resp.Error == nil || resp.Error.Code != tc.wantError):
+				t.Errorf("wanted error code %q, got %+v instead", tc.wantError, resp.Error)
+			case tc.wantError != "" && resp.Data != nil:
+				t.Errorf("wanted no data on error response, got %s instead", resp.Data)
+			}
+		})
+	}
+}
+
+func TestMe(t *testing.T) {
+	rh := &Router{}
+	rh.Load(&services.Modules{})
+	r := httptest.NewRequest(http.MethodGet, "http://api.example.com/v1/me", nil)
+	r = r.WithContext(userContext(r.Context(), &services.User{
+		UserID:    "u1",
+		Name:      "Jane Doe",
+		Email:     "jane@example.com",
+		CreatedAt: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}))
+	w := httptest.NewRecorder()
+	rh.ServeHTTP(w, r)
+	const want = `{
+  "data": {
+    "id": "u1",
+    "name": "Jane Doe",
+    "email": "jane@example.com",
+    "created_at": "2020-01-02T03:04:05Z"
+  }
+}
+`
+	if got := w.Body.String(); got != want {
+		t.Errorf("wanted response %s, got %s instead", want, got)
+	}
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	rh := &Router{}
+	rh.Load(&services.Modules{})
+	r := httptest.NewRequest(http.MethodPatch, "http://api.example.com/v1/cart/items/v1", nil)
+	w := httptest.NewRecorder()
+	rh.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("wanted status code %d, got %d instead", http.StatusMethodNotAllowed, w.Code)
+	}
+	if got, want := w.Header().Get("Allow"), "PUT, DELETE"; got != want {
+		t.Errorf("wanted Allow header %q, got %q instead", want, got)
+	}
+}
+
+func TestAllowedEndpoints(t *testing.T) {
+	rh := &Router{}
+	rh.Load(&services.Modules{})
+	endpoints := rh.AllowedEndpoints()
+	for _, route := range rh.mux.Routes {
+		path := allowListPath(route.Pattern)
+		var found bool
+		for _, e := range endpoints {
+			if e.Path == path {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("route %s is not on the allow-list", route.Pattern)
+		}
+	}
+	if got, want := allowListPath("/v1/orders/:order_id/pay"), "/v1/orders/%s/pay"; got != want {
+		t.Errorf("wanted allow-list path %q, got %q instead", want, got)
+	}
+}
+
+func TestTokenEndpointErrors(t *testing.T) {
+	rh := &Router{}
+	rh.Load(&services.Modules{})
+	testCases := []struct {
+		name        string
+		contentType string
+		body        string
+		wantCode    int
+		wantError   string
+	}{
+		{name: "json body", contentType: "application/json", body: "{}", wantCode: http.StatusBadRequest, wantError: "invalid_request"},
+		{name: "missing client", contentType: "application/x-www-form-urlencoded", body: "grant_type=authorization_code", wantCode: http.StatusUnauthorized, wantError: "invalid_client"},
+		{name: "unsupported grant type", contentType: "application/x-www-form-urlencoded", body: "grant_type=password&client_id=c1", wantCode: http.StatusBadRequest, wantError: "unsupported_grant_type"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "http://api.example.com/oauth/token", strings.NewReader(tc.body))
+			r.Header.Set("Content-Type", tc.contentType)
+			w := httptest.NewRecorder()
+			rh.ServeHTTP(w, r)
+			if w.Code != tc.wantCode {
+				t.Errorf("wanted status code %d, got %d instead", tc.wantCode, w.Code)
+			}
+			if cc := w.Header().Get("Cache-Control"); cc != "no-store" {
+				t.Errorf("wanted Cache-Control no-store, got %q instead", cc)
+			}
+			var resp oauthErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("cannot decode response: %v", err)
+			}
+			if resp.Error != tc.wantError {
+				t.Errorf("wanted error %q, got %+v instead", tc.wantError, resp)
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	rh := &Router{}
+	rh.Load(&services.Modules{})
+	request := func(user *services.User) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "http://api.example.com/oauth/token", strings.NewReader("grant_type=password"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if user != nil {
+			r = r.WithContext(userContext(r.Context(), user))
+		}
+		w := httptest.NewRecorder()
+		rh.ServeHTTP(w, r)
+		return w
+	}
+	for i := 0; i < 30; i++ {
+		if w := request(nil); w.Code == http.StatusTooManyRequests {
+			t.Fatalf("request %d was rate limited", i)
+		}
+	}
+	w := request(nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("wanted status code %d, got %d instead", http.StatusTooManyRequests, w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" || w.Header().Get("RateLimit-Limit") != "30" {
+		t.Errorf("wanted rate limit headers, got %v instead", w.Header())
+	}
+	var resp envelope
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("cannot decode response: %v", err)
+	}
+	if resp.Error == nil || resp.Error.Code != "rate_limited" {
+		t.Errorf("wanted error code rate_limited, got %+v instead", resp.Error)
+	}
+	if w := request(&services.User{UserID: "admin", Access: services.AdminAuthorization}); w.Code == http.StatusTooManyRequests {
+		t.Error("admins should not be rate limited")
+	}
+}
+
+func TestRateLimitInvalidTokens(t *testing.T) {
+	rh := &Router{}
+	rh.Load(&services.Modules{})
+	request := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "http://api.example.com/v1/me", nil)
+		r.Header.Set("Authorization", "Bearer guess")
+		w := httptest.NewRecorder()
+		rh.ServeHTTP(w, r)
+		return w
+	}
+	for i := 0; i < defaultRateLimit.Burst; i++ {
+		if w := request(); w.Code != http.StatusUnauthorized {
+			t.Fatalf("wanted request %d to be unauthorized, got status code %d instead", i, w.Code)
+		}
+	}
+	if w := request(); w.Code != http.StatusTooManyRequests {
+		t.Errorf("wanted requests with invalid tokens to be rate limited, got status code %d instead", w.Code)
+	}
+}
+
+func TestOrderJSONItems(t *testing.T) {
+	order := &services.Order{OrderID: "o1", Status: services.OrderPending}
+	b, err := json.Marshal(newOrderJSON(order))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), `"items"`) {
+		t.Errorf("wanted items to be left out of orders listed without them, got %s", b)
+	}
+	order.Items = []services.OrderItem{{VariantID: "v1", ProductName: "Kettle", Quantity: 2}}
+	if b, err = json.Marshal(newOrderJSON(order)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"items":[{"variant_id":"v1"`) {
+		t.Errorf("wanted items of order, got %s", b)
+	}
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/plifk/market/internal/router"
+	"github.com/plifk/market/internal/services"
+)
+
+type cartJSON struct {
+	ID       string         `json:"id,omitempty"`
+	Items    []cartItemJSON `json:"items"`
+	Quantity int            `json:"quantity"`
+	Total    priceJSON      `json:"total"`
+}
+
+type cartItemJSON struct {
+	VariantID   string    `json:"variant_id"`
+	ProductID   string    `json:"product_id"`
+	ProductName string    `json:"product_name"`
+	VariantName string    `json:"variant_name"`
+	Image       string    `json:"image,omitempty"`
+	Price       priceJSON `json:"price"`
+	Quantity    int       `json:"quantity"`
+	Subtotal    priceJSON `json:"subtotal"`
+	Available   bool      `json:"available"`
+	AddedAt     time.Time `json:"added_at"`
+}
+
+func newCartJSON(c *services.Cart) cartJSON {
+	cj := cartJSON{
+		ID:       c.CartID,
+		Items:    []cartItemJSON{},
+		Quantity: c.Quantity(),
+		Total:    newPriceJSON(c.Total()),
+	}
+	for _, i := range c.Items {
+		cj.Items = append(cj.Items, cartItemJSON{
+			VariantID:   i.VariantID,
+			ProductID:   i.ProductID,
+			ProductName: i.ProductName,
+			VariantName: i.VariantName,
+			Image:       i.Image,
+			Price:       newPriceJSON(i.Price),
+			Quantity:    i.Quantity,
+			Subtotal:    newPriceJSON(i.Subtotal()),
+			Available:   i.Available,
+			AddedAt:     i.AddedAt,
+		})
+	}
+	return cj
+}
+
+// cartItemRequest is the body of the requests to add or update an item on the cart.
+// VariantID is ignored when updating an item, as it is taken from the URL.
+type cartItemRequest struct {
+	VariantID string `json:"variant_id"`
+	Quantity  int    `json:"quantity"`
+}
+
+func (rh *Router) getCart(w http.ResponseWriter, r *http.Request, user *services.User) {
+	rh.writeCart(w, r, user, http.StatusOK)
+}
+
+func (rh *Router) writeCart(w http.ResponseWriter, r *http.Request, user *services.User, code int) {
+	cart, err := rh.modules.Carts.Get(r.Context(), services.CartOwner{UserID: user.UserID})
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeData(w, code, newCartJSON(cart))
+}
+
+func (rh *Router) clearCart(w http.ResponseWriter, r *http.Request, user *services.User) {
+	if err := rh.modules.Carts.Clear(r.Context(), services.CartOwner{UserID: user.UserID}); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	rh.writeCart(w, r, user, http.StatusOK)
+}
+
+func (rh *Router) addCartItem(w http.ResponseWriter, r *http.Request, user *services.User) {
+	var req cartItemRequest
+	if err := decodeBody(w, r, &req); err != nil {
+		return
+	}
+	err := rh.modules.Carts.AddItem(r.Context(), services.CartOwner{UserID: user.UserID}, req.VariantID, req.Quantity)
+	if !writeCartError(w, r, err) {
+		rh.writeCart(w, r, user, http.StatusCreated)
+	}
+}
+
+func (rh *Router) setCartItem(w http.ResponseWriter, r *http.Request, user *services.User) {
+	var req cartItemRequest
+	if err := decodeBody(w, r, &req); err != nil {
+		return
+	}
+	variantID := router.ReadParams(r.Context()).Get("variant_id")
+	err := rh.modules.Carts.SetQuantity(r.Context(), services.CartOwner{UserID: user.UserID}, variantID, req.Quantity)
+	if !writeCartError(w, r, err) {
+		rh.writeCart(w, r, user, http.StatusOK)
+	}
+}
+
+func (rh *Router) removeCartItem(w http.ResponseWriter, r *http.Request, user *services.User) {
+	variantID := router.ReadParams(r.Context()).Get("variant_id")
+	err := rh.modules.Carts.RemoveItem(r.Context(), services.CartOwner{UserID: user.UserID}, variantID)
+	if !writeCartError(w, r, err) {
+		rh.writeCart(w, r, user, http.StatusOK)
+	}
+}
+
+// writeCartError writes the error of a cart operation to the response, if any, and reports whether it did so.
+func writeCartError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch err {
+	case nil:
+		return false
+	case services.ErrInvalidQuantity:
+		writeError(w, http.StatusUnprocessableEntity, "invalid_quantity", err.Error())
+	case services.ErrVariantNotFound:
+		writeError(w, http.StatusNotFound, "variant_not_found", err.Error())
+	default:
+		writeInternalError(w, r, err)
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/plifk/market/internal/services"
+)
+
+// oauthErrorResponse is the error body of the token endpoint.
+// It follows RFC 6749 section 5.2 instead of the envelope used by the rest of the API, as OAuth client libraries expect it.
+type oauthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// token endpoint of the OAuth authorization server (RFC 6749 section 3.2).
+// Clients authenticate with HTTP Basic authentication or with the client_id and client_secret parameters.
+func (rh *Router) token(w http.ResponseWriter, r *http.Request) {
+	// Tokens must not be cached.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/x-www-form-urlencoded" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "request body must be application/x-www-form-urlencoded")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "cannot parse request body")
+		return
+	}
+	req := services.TokenRequest{
+		GrantType:    r.PostForm.Get("grant_type"),
+		ClientID:     r.PostForm.Get("client_id"),
+		ClientSecret: r.PostForm.Get("client_secret"),
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		RefreshToken: r.PostForm.Get("refresh_token"),
+		Scope:        r.PostForm.Get("scope"),
+	}
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		req.ClientID, req.ClientSecret = id, secret
+	}
+
+	resp, err := rh.modules.OAuth.Token(r.Context(), req)
+	var oerr *services.OAuthError
+	switch {
+	case errors.As(err, &oerr) && oerr.Code == "invalid_client":
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="market"`)
+		}
+		writeOAuthError(w, http.StatusUnauthorized, oerr.Code, oerr.Description)
+	case errors.As(err, &oerr):
+		writeOAuthError(w, http.StatusBadRequest, oerr.Code, oerr.Description)
+	case err != nil:
+		log.Printf("cannot issue OAuth token: %v", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+	default:
+		writeOAuthJSON(w, http.StatusOK, resp)
+	}
+}
+
+func writeOAuthError(w http.ResponseWriter, code int, errorCode, description string) {
+	writeOAuthJSON(w, code, oauthErrorResponse{Error: errorCode, ErrorDescription: description})
+}
+
+func writeOAuthJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("cannot encode OAuth response: %v", err)
+	}
+}
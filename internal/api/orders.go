@@ -0,0 +1,166 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/plifk/market/internal/router"
+	"github.com/plifk/market/internal/services"
+)
+
+type orderJSON struct {
+	ID        string          `json:"id"`
+	Status    string          `json:"status"`
+	Items     []orderItemJSON `json:"items,omitempty"` // Left out of order lists.
+	Total     priceJSON       `json:"total"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+type orderItemJSON struct {
+	VariantID   string    `json:"variant_id"`
+	ProductID   string    `json:"product_id"`
+	ProductName string    `json:"product_name"`
+	VariantName string    `json:"variant_name"`
+	Price       priceJSON `json:"price"`
+	Quantity    int       `json:"quantity"`
+	Subtotal    priceJSON `json:"subtotal"`
+}
+
+func newOrderJSON(o *services.Order) orderJSON {
+	oj := orderJSON{
+		ID:        o.OrderID,
+		Status:    string(o.Status),
+		Total:     newPriceJSON(o.Total),
+		CreatedAt: o.CreatedAt,
+		UpdatedAt: o.UpdatedAt,
+	}
+	for _, i := range o.Items {
+		oj.Items = append(oj.Items, orderItemJSON{
+			VariantID:   i.VariantID,
+			ProductID:   i.ProductID,
+			ProductName: i.ProductName,
+			VariantName: i.VariantName,
+			Price:       newPriceJSON(i.Price),
+			Quantity:    i.Quantity,
+			Subtotal:    newPriceJSON(i.Subtotal()),
+		})
+	}
+	return oj
+}
+
+// payOrderRequest is the body of the request to pay an order.
+type payOrderRequest struct {
+	// PaymentToken from the payment form of the payment provider.
+	PaymentToken string `json:"payment_token"`
+}
+
+func (rh *Router) listOrders(w http.ResponseWriter, r *http.Request, user *services.User) {
+	orders, err := rh.modules.Orders.ListOrders(r.Context(), user.UserID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	resp := []orderJSON{}
+	for i := range orders {
+		resp = append(resp, newOrderJSON(&orders[i]))
+	}
+	writeData(w, http.StatusOK, resp)
+}
+
+// checkout places a pending order with the items on the cart of the user.
+// The order must be paid afterwards with /v1/orders/:order_id/pay.
+func (rh *Router) checkout(w http.ResponseWriter, r *http.Request, user *services.User) {
+	order, err := rh.modules.Orders.Checkout(r.Context(), user.UserID)
+	switch err {
+	case nil:
+		writeData(w, http.StatusCreated, newOrderJSON(order))
+	case services.ErrEmptyCart:
+		writeError(w, http.StatusUnprocessableEntity, "empty_cart", err.Error())
+	case services.ErrCartItemUnavailable:
+		writeError(w, http.StatusConflict, "cart_item_unavailable", err.Error())
+	case services.ErrOutOfStock:
+		writeError(w, http.StatusConflict, "out_of_stock", err.Error())
+	default:
+		writeInternalError(w, r, err)
+	}
+}
+
+// userOrder writes an error to the response and returns nil if the order doesn't exist or belongs to another user.
+func (rh *Router) userOrder(w http.ResponseWriter, r *http.Request, user *services.User) *services.Order {
+	orderID := router.ReadParams(r.Context()).Get("order_id")
+	order, err := rh.modules.Orders.GetOrder(r.Context(), orderID)
+	switch {
+	case err == services.ErrOrderNotFound, err == nil && order.UserID != user.UserID:
+		writeError(w, http.StatusNotFound, "order_not_found", services.ErrOrderNotFound.Error())
+		return nil
+	case err != nil:
+		writeInternalError(w, r, err)
+		return nil
+	}
+	return order
+}
+
+func (rh *Router) getOrder(w http.ResponseWriter, r *http.Request, user *services.User) {
+	if order := rh.userOrder(w, r, user); order != nil {
+		writeData(w, http.StatusOK, newOrderJSON(order))
+	}
+}
+
+func (rh *Router) payOrder(w http.ResponseWriter, r *http.Request, user *services.User) {
+	var req payOrderRequest
+	if err := decodeBody(w, r, &req); err != nil {
+		return
+	}
+	order := rh.userOrder(w, r, user)
+	if order == nil {
+		return
+	}
+	switch err := rh.modules.Orders.Pay(r.Context(), order.OrderID, req.PaymentToken); {
+	case err == nil:
+		rh.writeOrder(w, r, order.OrderID)
+	case errors.Is(err, services.ErrInvalidPaymentToken):
+		writeError(w, http.StatusBadRequest, "invalid_payment_token", services.ErrInvalidPaymentToken.Error())
+	case errors.Is(err, services.ErrPaymentDeclined):
+		writeError(w, http.StatusPaymentRequired, "payment_declined", services.ErrPaymentDeclined.Error())
+	case err == services.ErrPaymentProviderUnavailable:
+		writeError(w, http.StatusServiceUnavailable, "payment_provider_unavailable", err.Error())
+	case err == services.ErrInvalidOrderTransition:
+		writeError(w, http.StatusConflict, "invalid_order_transition", "Order cannot be paid.")
+	default:
+		writeInternalError(w, r, err)
+	}
+}
+
+func (rh *Router) cancelOrder(w http.ResponseWriter, r *http.Request, user *services.User) {
+	order := rh.userOrder(w, r, user)
+	if order == nil {
+		return
+	}
+	// Shipped orders can only be refunded by admins.
+	if !order.Status.Cancellable() {
+		writeError(w, http.StatusConflict, "invalid_order_transition", "Order cannot be cancelled after it is shipped.")
+		return
+	}
+	switch err := rh.modules.Orders.Cancel(r.Context(), order.OrderID); {
+	case err == nil:
+		rh.writeOrder(w, r, order.OrderID)
+	case err == services.ErrInvalidOrderTransition:
+		writeError(w, http.StatusConflict, "invalid_order_transition", "Order cannot be cancelled.")
+	case errors.Is(err, services.ErrPaymentProviderUnavailable):
+		writeError(w, http.StatusServiceUnavailable, "payment_provider_unavailable", services.ErrPaymentProviderUnavailable.Error())
+	default:
+		writeInternalError(w, r, err)
+	}
+}
+
+// writeOrder reloads the order after it changed and writes it to the response.
+func (rh *Router) writeOrder(w http.ResponseWriter, r *http.Request, orderID string) {
+	order, err := rh.modules.Orders.GetOrder(r.Context(), orderID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeData(w, http.StatusOK, newOrderJSON(order))
+}
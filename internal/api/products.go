@@ -0,0 +1,163 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/plifk/market/internal/router"
+	"github.com/plifk/market/internal/services"
+)
+
+// maxPerPage is the maximum number of items on a page of a listing.
+const maxPerPage = 100
+
+type priceJSON struct {
+	Amount    int64  `json:"amount"`
+	Currency  string `json:"currency"`
+	Formatted string `json:"formatted"`
+}
+
+func newPriceJSON(p services.Price) priceJSON {
+	return priceJSON{
+		Amount:    p.Amount,
+		Currency:  p.Currency,
+		Formatted: p.String(),
+	}
+}
+
+type productJSON struct {
+	ID          string                   `json:"id"`
+	CategoryID  string                   `json:"category_id,omitempty"`
+	Name        string                   `json:"name"`
+	Description string                   `json:"description"`
+	Brand       string                   `json:"brand"`
+	Images      []string                 `json:"images"`
+	Attributes  map[string]string        `json:"attributes"`
+	Options     []services.ProductOption `json:"options"`
+	Variants    []variantJSON            `json:"variants"`
+	CreatedAt   time.Time                `json:"created_at"`
+	UpdatedAt   time.Time                `json:"updated_at"`
+}
+
+type variantJSON struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Price   priceJSON         `json:"price"`
+	Options map[string]string `json:"options"`
+	Images  []string          `json:"images"`
+	Stock   int               `json:"stock"`
+}
+
+func newProductJSON(p *services.Product) productJSON {
+	pj := productJSON{
+		ID:          p.ProductID,
+		CategoryID:  p.CategoryID,
+		Name:        p.Name,
+		Description: p.Description,
+		Brand:       p.Brand,
+		Images:      p.Images,
+		Attributes:  p.Attributes,
+		Options:     p.Options,
+		Variants:    []variantJSON{},
+		CreatedAt:   p.CreatedAt,
+		UpdatedAt:   p.UpdatedAt,
+	}
+	if pj.Images == nil {
+		pj.Images = []string{}
+	}
+	if pj.Attributes == nil {
+		pj.Attributes = map[string]string{}
+	}
+	if pj.Options == nil {
+		pj.Options = []services.ProductOption{}
+	}
+	for _, v := range p.Variants {
+		vj := variantJSON{
+			ID:      v.VariantID,
+			Name:    v.Name,
+			Price:   newPriceJSON(v.Price),
+			Options: v.Options,
+			Images:  v.Images,
+			Stock:   v.Stock,
+		}
+		if vj.Options == nil {
+			vj.Options = map[string]string{}
+		}
+		if vj.Images == nil {
+			vj.Images = []string{}
+		}
+		pj.Variants = append(pj.Variants, vj)
+	}
+	return pj
+}
+
+type productsPage struct {
+	Products []productJSON `json:"products"`
+	Page     int           `json:"page"`
+	PerPage  int           `json:"per_page"`
+}
+
+// pagination reads the page and per_page query parameters.
+func pagination(r *http.Request) (page, perPage int, ok bool) {
+	page, perPage = 1, 20
+	q := r.URL.Query()
+	if v := q.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, false
+		}
+		page = n
+	}
+	if v := q.Get("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPerPage {
+			return 0, 0, false
+		}
+		perPage = n
+	}
+	return page, perPage, true
+}
+
+func (rh *Router) listProducts(w http.ResponseWriter, r *http.Request) {
+	page, perPage, ok := pagination(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_pagination", "Parameter page must be a positive integer, and per_page must be between 1 and 100.")
+		return
+	}
+	products, err := rh.modules.Catalog.ListProducts(r.Context(), services.ListProductsParams{
+		CategoryID: r.URL.Query().Get("category_id"),
+		Limit:      perPage,
+		Offset:     (page - 1) * perPage,
+	})
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	resp := productsPage{
+		Products: []productJSON{},
+		Page:     page,
+		PerPage:  perPage,
+	}
+	for i := range products {
+		resp.Products = append(resp.Products, newProductJSON(&products[i]))
+	}
+	writeData(w, http.StatusOK, resp)
+}
+
+func (rh *Router) getProduct(w http.ResponseWriter, r *http.Request) {
+	productID := router.ReadParams(r.Context()).Get("product_id")
+	product, err := rh.modules.Catalog.GetProduct(r.Context(), productID)
+	switch {
+	case err == services.ErrProductNotFound:
+		writeError(w, http.StatusNotFound, "product_not_found", "Product not found.")
+		return
+	case err != nil:
+		writeInternalError(w, r, err)
+		return
+	case product.Status == services.ProductArchived:
+		writeError(w, http.StatusGone, "product_archived", "Product is no longer available.")
+		return
+	}
+	writeData(w, http.StatusOK, newProductJSON(product))
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+)
+
+// maxRequestBodySize is the maximum size of a request body.
+const maxRequestBodySize = 1 << 20
+
+// envelope of every response body. Successful responses have Data, and failed ones have Error.
+type envelope struct {
+	Data  interface{}  `json:"data,omitempty"`
+	Error *errorObject `json:"error,omitempty"`
+}
+
+// errorObject describes why a request failed.
+// Code is a stable machine-readable identifier, and Message is meant for humans and might change.
+type errorObject struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v envelope) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("cannot encode API response: %v", err)
+	}
+}
+
+func writeData(w http.ResponseWriter, code int, data interface{}) {
+	writeJSON(w, code, envelope{Data: data})
+}
+
+func writeError(w http.ResponseWriter, code int, errorCode, message string) {
+	writeJSON(w, code, envelope{Error: &errorObject{Code: errorCode, Message: message}})
+}
+
+// writeInternalError logs the error and hides its details from the client.
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("API request %s %s failed: %v", r.Method, r.URL.Path, err)
+	writeError(w, http.StatusInternalServerError, "internal_error", "Internal server error.")
+}
+
+// errBadRequestBody is returned when the request body cannot be decoded. It is already written to the response.
+var errBadRequestBody = errors.New("bad request body")
+
+// decodeBody decodes a JSON request body into v, writing the error to the response on failure.
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", "Request body must be application/json.")
+		return errBadRequestBody
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", fmt.Sprintf("Cannot decode request body: %v.", err))
+		return errBadRequestBody
+	}
+	if dec.More() {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Request body must contain a single JSON object.")
+		return errBadRequestBody
+	}
+	return nil
+}
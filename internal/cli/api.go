@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/henvic/clino"
+	"github.com/plifk/market/internal/api"
+	"github.com/plifk/market/internal/services"
+)
+
+type apiCommand struct {
+	s *State
+}
+
+func (c *apiCommand) Name() string {
+	return "api"
+}
+
+func (c *apiCommand) Short() string {
+	return "inspect the JSON API"
+}
+
+func (c *apiCommand) Commands() []clino.Command {
+	return []clino.Command{
+		&apiEndpointsCommand{s: c.s},
+	}
+}
+
+type apiEndpointsCommand struct {
+	s *State
+}
+
+func (c *apiEndpointsCommand) Name() string {
+	return "endpoints"
+}
+
+func (c *apiEndpointsCommand) Short() string {
+	return "print the allow-list of API endpoints"
+}
+
+func (c *apiEndpointsCommand) Long() string {
+	return `Usage: market api endpoints
+
+Print the endpoints on the allow-list of the API, and their allowed methods.
+Requests to any other endpoint or method are rejected before reaching the handlers.
+Parameters are shown as %s.`
+}
+
+func (c *apiEndpointsCommand) Run(ctx context.Context, args ...string) error {
+	// The allow-list is built from the routes, so no configuration or database is needed.
+	var rh api.Router
+	rh.Load(&services.Modules{})
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATH\tMETHODS")
+	for _, e := range rh.AllowedEndpoints() {
+		fmt.Fprintf(tw, "%s\t%s\n", e.Path, strings.Join(e.Methods, ", "))
+	}
+	return tw.Flush()
+}
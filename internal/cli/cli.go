@@ -48,6 +48,18 @@ func (c *RootCommand) Commands() []clino.Command {
 		&tasksCommand{
 			s: c.State,
 		},
+		&searchCommand{
+			s: c.State,
+		},
+		&inventoryCommand{
+			s: c.State,
+		},
+		&apiCommand{
+			s: c.State,
+		},
+		&tokensCommand{
+			s: c.State,
+		},
 	}
 }
 
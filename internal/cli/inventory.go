@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/henvic/clino"
+	"github.com/plifk/market"
+)
+
+type inventoryCommand struct {
+	s *State
+}
+
+func (c *inventoryCommand) Name() string {
+	return "inventory"
+}
+
+func (c *inventoryCommand) Short() string {
+	return "manage the stock of products"
+}
+
+func (c *inventoryCommand) Commands() []clino.Command {
+	return []clino.Command{
+		&setStockCommand{s: c.s},
+	}
+}
+
+type setStockCommand struct {
+	s *State
+}
+
+func (c *setStockCommand) Name() string {
+	return "set-stock"
+}
+
+func (c *setStockCommand) Short() string {
+	return "set the units on hand of a product variant"
+}
+
+func (c *setStockCommand) Long() string {
+	return `Usage: market inventory set-stock <variant ID> <units>
+
+Set the number of units of a product variant in the warehouse.
+Units reserved by pending orders are subtracted from it to compute the available stock.`
+}
+
+func (c *setStockCommand) Run(ctx context.Context, args ...string) (err error) {
+	if len(args) != 2 {
+		return errors.New("expected variant ID and number of units")
+	}
+	units, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid number of units: %w", err)
+	}
+	var system market.System
+	if err := system.Load(c.s.ConfigPath); err != nil {
+		return err
+	}
+
+	modules := system.Modules
+	if err := modules.Inventory.SetStock(ctx, args[0], units); err != nil {
+		return err
+	}
+	stock, err := modules.Inventory.GetStock(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d units on hand, %d reserved, %d available.\n", stock.OnHand, stock.Reserved, stock.Available())
+	return nil
+}
@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/henvic/clino"
+	"github.com/plifk/market"
+)
+
+type searchCommand struct {
+	s *State
+}
+
+func (c *searchCommand) Name() string {
+	return "search"
+}
+
+func (c *searchCommand) Short() string {
+	return "manage the search engine"
+}
+
+func (c *searchCommand) Commands() []clino.Command {
+	return []clino.Command{
+		&createSearchIndexCommand{s: c.s},
+		&reindexCommand{s: c.s},
+	}
+}
+
+type createSearchIndexCommand struct {
+	s *State
+}
+
+func (c *createSearchIndexCommand) Name() string {
+	return "create-index"
+}
+
+func (c *createSearchIndexCommand) Short() string {
+	return "create the search index"
+}
+
+func (c *createSearchIndexCommand) Long() string {
+	return `Create the Elasticsearch index for the products with its mapping.
+Nothing is done if the index already exists.`
+}
+
+func (c *createSearchIndexCommand) Run(ctx context.Context, args ...string) (err error) {
+	var system market.System
+	if err := system.Load(c.s.ConfigPath); err != nil {
+		return err
+	}
+
+	modules := system.Modules
+	created, err := modules.Search.CreateIndex(ctx)
+	if err != nil {
+		return err
+	}
+	if !created {
+		fmt.Println("Search index already exists.")
+		return nil
+	}
+	fmt.Println("Search index created.")
+	return nil
+}
+
+type reindexCommand struct {
+	s *State
+}
+
+func (c *reindexCommand) Name() string {
+	return "reindex"
+}
+
+func (c *reindexCommand) Short() string {
+	return "index all products of the catalog"
+}
+
+func (c *reindexCommand) Run(ctx context.Context, args ...string) (err error) {
+	var system market.System
+	if err := system.Load(c.s.ConfigPath); err != nil {
+		return err
+	}
+
+	modules := system.Modules
+	indexed, err := modules.Search.Reindex(ctx)
+	fmt.Printf("%d products indexed.\n", indexed)
+	return err
+}
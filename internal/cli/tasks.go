@@ -3,6 +3,9 @@ package cli
 import (
 	"context"
 	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
 
 	"github.com/henvic/clino"
 	"github.com/plifk/market"
@@ -23,6 +26,11 @@ func (c *tasksCommand) Short() string {
 func (c *tasksCommand) Commands() []clino.Command {
 	return []clino.Command{
 		&cleanupSessionsCommand{s: c.s},
+		&releaseStockCommand{s: c.s},
+		&sendMailCommand{s: c.s},
+		&failedMailCommand{s: c.s},
+		&purgeMailCommand{s: c.s},
+		&tasksStatusCommand{s: c.s},
 	}
 }
 
@@ -58,3 +66,162 @@ func (c *cleanupSessionsCommand) Run(ctx context.Context, args ...string) (err e
 	fmt.Printf("%d expired sessions had their status field changed from \"active\" to \"expired\".\n", marked)
 	return nil
 }
+
+type releaseStockCommand struct {
+	s *State
+}
+
+func (c *releaseStockCommand) Name() string {
+	return "release-stock"
+}
+
+func (c *releaseStockCommand) Short() string {
+	return "release stock of expired pending orders"
+}
+
+func (c *releaseStockCommand) Long() string {
+	return `Stock is reserved when an order is placed and held while the order is pending payment.
+This command cancels pending orders whose reservation expired, making their units available for sale again.`
+}
+
+func (c *releaseStockCommand) Run(ctx context.Context, args ...string) (err error) {
+	var system market.System
+	if err := system.Load(c.s.ConfigPath); err != nil {
+		return err
+	}
+
+	modules := system.Modules
+	released, err := modules.Inventory.ReleaseExpired(ctx)
+	fmt.Printf("%d expired pending orders were cancelled and had their stock released.\n", released)
+	return err
+}
+
+type sendMailCommand struct {
+	s *State
+}
+
+func (c *sendMailCommand) Name() string {
+	return "send-mail"
+}
+
+func (c *sendMailCommand) Short() string {
+	return "deliver queued emails"
+}
+
+func (c *sendMailCommand) Long() string {
+	return `Emails are queued on the database and delivered by the market server in the background.
+This command delivers the queued emails that are due right away, such as after fixing the mail server settings.
+Emails that cannot be delivered are retried later.`
+}
+
+func (c *sendMailCommand) Run(ctx context.Context, args ...string) (err error) {
+	var system market.System
+	if err := system.Load(c.s.ConfigPath); err != nil {
+		return err
+	}
+
+	modules := system.Modules
+	sent, err := modules.Mailer.ProcessQueue(ctx)
+	fmt.Printf("%d queued emails were delivered.\n", sent)
+	return err
+}
+
+type failedMailCommand struct {
+	s *State
+}
+
+func (c *failedMailCommand) Name() string {
+	return "failed-mail"
+}
+
+func (c *failedMailCommand) Short() string {
+	return "list emails that couldn't be delivered"
+}
+
+func (c *failedMailCommand) Long() string {
+	return `Emails are given up on after failing to be delivered 10 times, and kept on the queue for a week for inspection.
+This command lists them with the error of their last attempt.`
+}
+
+func (c *failedMailCommand) Run(ctx context.Context, args ...string) error {
+	var system market.System
+	if err := system.Load(c.s.ConfigPath); err != nil {
+		return err
+	}
+
+	failed, err := system.Modules.Mailer.FailedMail(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "MAIL ID\tTO\tSUBJECT\tCREATED AT\tATTEMPTS\tLAST ERROR")
+	for _, f := range failed {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", f.MailID, f.To, f.Subject, f.CreatedAt.Format(time.RFC3339), f.Attempts, f.LastError)
+	}
+	return tw.Flush()
+}
+
+type purgeMailCommand struct {
+	s *State
+}
+
+func (c *purgeMailCommand) Name() string {
+	return "purge-mail"
+}
+
+func (c *purgeMailCommand) Short() string {
+	return "delete old emails that couldn't be delivered"
+}
+
+func (c *purgeMailCommand) Long() string {
+	return `Emails that couldn't be delivered are kept on the queue for a week for inspection.
+This command deletes the ones given up on before that.`
+}
+
+func (c *purgeMailCommand) Run(ctx context.Context, args ...string) (err error) {
+	var system market.System
+	if err := system.Load(c.s.ConfigPath); err != nil {
+		return err
+	}
+
+	modules := system.Modules
+	purged, err := modules.Mailer.PurgeFailed(ctx)
+	fmt.Printf("%d emails that couldn't be delivered were deleted from the queue.\n", purged)
+	return err
+}
+
+type tasksStatusCommand struct {
+	s *State
+}
+
+func (c *tasksStatusCommand) Name() string {
+	return "status"
+}
+
+func (c *tasksStatusCommand) Short() string {
+	return "print the last run of each scheduled task"
+}
+
+func (c *tasksStatusCommand) Long() string {
+	return `The market server runs maintenance tasks, such as cleaning up sessions and releasing stock, on a schedule.
+Only one server runs each task at a time, and this command prints the last run of each task by any of them.
+The status of the tasks on each server is also exposed with expvar on the inspection address, as "tasks".`
+}
+
+func (c *tasksStatusCommand) Run(ctx context.Context, args ...string) error {
+	var system market.System
+	if err := system.Load(c.s.ConfigPath); err != nil {
+		return err
+	}
+
+	runs, err := system.Modules.Scheduler.LastRuns(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TASK\tLAST RUN\tDURATION\tRESULT\tERROR")
+	for _, run := range runs {
+		fmt.Fprintf(tw, "%s\t%s\t%v\t%d\t%s\n", run.Name, run.LastRunAt.Format(time.RFC3339), run.LastDuration, run.LastResult, run.LastError)
+	}
+	return tw.Flush()
+}
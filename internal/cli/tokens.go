@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/henvic/clino"
+	"github.com/plifk/market"
+	"github.com/plifk/market/internal/services"
+)
+
+type tokensCommand struct {
+	s *State
+}
+
+func (c *tokensCommand) Name() string {
+	return "tokens"
+}
+
+func (c *tokensCommand) Short() string {
+	return "manage API tokens"
+}
+
+func (c *tokensCommand) Commands() []clino.Command {
+	return []clino.Command{
+		&createServiceTokenCommand{s: c.s},
+	}
+}
+
+type createServiceTokenCommand struct {
+	s *State
+}
+
+func (c *createServiceTokenCommand) Name() string {
+	return "create-service"
+}
+
+func (c *createServiceTokenCommand) Short() string {
+	return "create an API token for a machine integration"
+}
+
+func (c *createServiceTokenCommand) Long() string {
+	return `Usage: market tokens create-service <user ID> <name> <scopes> [days]
+
+Create a service token for an integration acting as the given user.
+Scopes are comma separated (e.g., cart,orders). The token expires after the given number of days, or never if omitted.
+The token is printed only once, and can be revoked by the user from their account page.`
+}
+
+func (c *createServiceTokenCommand) Run(ctx context.Context, args ...string) error {
+	if len(args) != 3 && len(args) != 4 {
+		return errors.New("expected user ID, name, scopes, and optionally the number of days until the token expires")
+	}
+	params := services.NewTokenParams{
+		UserID: args[0],
+		Name:   args[1],
+		Kind:   services.ServiceToken,
+	}
+	for _, s := range strings.Split(args[2], ",") {
+		params.Scopes = append(params.Scopes, services.TokenScope(strings.TrimSpace(s)))
+	}
+	if len(args) == 4 {
+		days, err := strconv.Atoi(args[3])
+		if err != nil || days < 1 {
+			return fmt.Errorf("invalid number of days: %q", args[3])
+		}
+		params.ExpiresAt = time.Now().AddDate(0, 0, days)
+	}
+
+	var system market.System
+	if err := system.Load(c.s.ConfigPath); err != nil {
+		return err
+	}
+	modules := system.Modules
+	if _, err := modules.Accounts.GetUserByID(ctx, params.UserID); err != nil {
+		return err
+	}
+	token, at, err := modules.Tokens.Create(ctx, params)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Service token %q created with ID %s. Store it safely, as it won't be shown again:\n%s\n", at.Name, at.TokenID, token)
+	return nil
+}
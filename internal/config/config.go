@@ -20,6 +20,11 @@ type Settings struct {
 	// HTTPKeyFile path to a certificate key. Set this to listen for HTTPS requests.
 	HTTPKeyFile string
 
+	// BehindProxy should be set when requests come through a reverse proxy such as Caddy,
+	// so that the client IP address is read from the X-Forwarded-For header it sets.
+	// Don't set it otherwise, as clients could then send any IP address.
+	BehindProxy bool
+
 	// SQLDataSourceName for the PostgreSQL database.
 	SQLDataSourceName string
 
@@ -32,6 +37,13 @@ type Settings struct {
 	// RedisPassword for the key-value storage.
 	RedisPassword string
 
+	// SessionStorage where HTTP sessions are kept: "postgres" (default) or "redis".
+	// Redis avoids querying PostgreSQL on every request, and expires sessions by itself.
+	SessionStorage string
+
+	// SessionAudit records sessions on PostgreSQL too when using the "redis" session storage, for auditing.
+	SessionAudit bool
+
 	// ElasticsearchHost for the search engine.
 	ElasticsearchHost string
 
@@ -65,6 +77,36 @@ type Settings struct {
 
 	// ThumbnailServiceHost for the imaginary microservice.
 	ThumbnailServiceHost string
+
+	// PaymentProvider used to pay orders. Use "fake" to test the checkout locally without charging anything.
+	PaymentProvider string
+
+	// PublicURL of the market (e.g., https://www.example.com), used to create links sent to users by email.
+	PublicURL string
+
+	// SecretKey used to sign tokens sent to users, such as email verification links.
+	// Use a long random string, and keep it secret: anyone knowing it can forge tokens.
+	SecretKey string
+
+	// MailTransport used to send emails: "smtp" or "file".
+	// Use "file" to save emails to the MailDirectory instead of sending them, when developing locally.
+	MailTransport string
+
+	// MailFrom is the sender address of emails.
+	MailFrom string
+
+	// MailDirectory where emails are saved when using the "file" mail transport.
+	MailDirectory string
+
+	// SMTPAddress (host:port) of the mail server, when using the "smtp" mail transport.
+	// For development, you can use an SMTP sink such as MailHog.
+	SMTPAddress string
+
+	// SMTPUsername for the mail server. Authentication is skipped if empty.
+	SMTPUsername string
+
+	// SMTPPassword for the mail server.
+	SMTPPassword string
 }
 
 // ReadFile loads the settings from a configuration file.
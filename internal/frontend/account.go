@@ -1,6 +1,8 @@
 package frontend
 
 import (
+	"errors"
+	"log"
 	"net/http"
 
 	"github.com/plifk/market/internal/services"
@@ -11,16 +13,158 @@ type AccountHandler struct {
 	Frontend *Frontend
 }
 
+// AccountContent to render the account page.
+type AccountContent struct {
+	// Name and Phone on the profile form.
+	Name  string
+	Phone string
+
+	// Notice of changes made.
+	Notice string
+
+	// PendingEmail the confirmation link of an email change was sent to.
+	PendingEmail string
+
+	ProfileError  error
+	PasswordError error
+	EmailError    error
+}
+
+// AccountEmailContent to render the email change confirmation page.
+type AccountEmailContent struct {
+	// Email the account was changed to.
+	Email string
+
+	Error error
+}
+
 func (h *AccountHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := dirRouter(r.URL.Path)
+	// The link to confirm a new email address might be opened on another device, where the user isn't logged in.
+	if route.is("/account/email/confirm") {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+			return
+		}
+		h.confirmEmail(w, r)
+		return
+	}
 	user := services.UserFromRequest(r)
 	if user == nil {
 		h.Frontend.HTTPError(w, r, http.StatusNotFound)
 		return
 	}
+	switch {
+	case route.is("/account") && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		h.view(w, r, AccountContent{Name: user.Name, Phone: user.Phone})
+	case route.is("/account/profile") && r.Method == http.MethodPost:
+		h.profile(w, r, user)
+	case route.is("/account/password") && r.Method == http.MethodPost:
+		h.password(w, r, user)
+	case route.is("/account/email") && r.Method == http.MethodPost:
+		h.email(w, r, user)
+	case route.is("/account"), route.is("/account/profile"), route.is("/account/password"), route.is("/account/email"):
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+	default:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+	}
+}
 
+func (h *AccountHandler) view(w http.ResponseWriter, r *http.Request, content AccountContent) {
 	resp := &HTMLResponse{
 		Template: "account",
 		Title:    "Your Account",
+		Content:  content,
+	}
+	h.Frontend.Respond(w, r, resp)
+}
+
+func (h *AccountHandler) profile(w http.ResponseWriter, r *http.Request, user *services.User) {
+	content := AccountContent{
+		Name:  r.PostFormValue("name"),
+		Phone: r.PostFormValue("phone"),
+	}
+	if err := h.Frontend.Modules.Accounts.UpdateProfile(r.Context(), services.UpdateProfileParams{
+		UserID: user.UserID,
+		Name:   content.Name,
+		Phone:  content.Phone,
+	}); err != nil {
+		log.Printf("cannot update profile of user %q: %v", user.UserID, err)
+		content.ProfileError = formError(w, err)
+		h.view(w, r, content)
+		return
+	}
+	// The user on the request was read before the change.
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+func (h *AccountHandler) password(w http.ResponseWriter, r *http.Request, user *services.User) {
+	content := AccountContent{Name: user.Name, Phone: user.Phone}
+	password := r.PostFormValue("new_password")
+	if password != r.PostFormValue("new_password_confirmation") {
+		w.WriteHeader(http.StatusBadRequest)
+		content.PasswordError = errPasswordsDontMatch
+		h.view(w, r, content)
+		return
+	}
+	// Wrong current passwords count as failed logins, as they could be used to guess the password of a stolen session.
+	attempt := services.LoginAttempt{IP: h.Frontend.Modules.Security.ClientIP(r), Email: user.Email}
+	if err := h.Frontend.throttleLogin(w, r, attempt); err != nil {
+		content.PasswordError = err
+		h.view(w, r, content)
+		return
+	}
+	params := services.ChangePasswordParams{
+		UserID:          user.UserID,
+		CurrentPassword: r.PostFormValue("current_password"),
+		NewPassword:     password,
+	}
+	if session := services.SessionFromRequest(r); session != nil {
+		params.StickyID = session.StickyID
+	}
+	if err := h.Frontend.Modules.Accounts.ChangePassword(r.Context(), params); err != nil {
+		log.Printf("cannot change password of user %q: %v", user.UserID, err)
+		if errors.Is(err, services.ErrWrongPassword) {
+			h.Frontend.failLogin(r, attempt)
+		}
+		content.PasswordError = formError(w, err, services.ErrWrongPassword)
+		h.view(w, r, content)
+		return
+	}
+	content.Notice = "Your password was changed, and you were logged out of your other devices."
+	h.view(w, r, content)
+}
+
+func (h *AccountHandler) email(w http.ResponseWriter, r *http.Request, user *services.User) {
+	content := AccountContent{Name: user.Name, Phone: user.Phone}
+	email := r.PostFormValue("email")
+	if err := h.Frontend.Modules.Accounts.RequestEmailChange(r.Context(), user, email); err != nil {
+		log.Printf("cannot request email change of user %q: %v", user.UserID, err)
+		content.EmailError = formError(w, err, services.ErrSameEmail)
+		h.view(w, r, content)
+		return
+	}
+	content.PendingEmail = email
+	h.view(w, r, content)
+}
+
+func (h *AccountHandler) confirmEmail(w http.ResponseWriter, r *http.Request) {
+	var content AccountEmailContent
+	switch u, err := h.Frontend.Modules.Accounts.ConfirmEmailChange(r.Context(), r.URL.Query().Get("token")); {
+	case err == services.ErrInvalidSignedToken, err == services.ErrEmailTaken:
+		w.WriteHeader(http.StatusBadRequest)
+		content.Error = err
+	case err != nil:
+		log.Printf("cannot change email address: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	default:
+		content.Email = u.Email
+	}
+	resp := &HTMLResponse{
+		Template: "account-email",
+		Title:    "Confirm your new email address",
+		Content:  content,
 	}
 	h.Frontend.Respond(w, r, resp)
 }
@@ -1,8 +1,13 @@
 package frontend
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strings"
 
+	"github.com/jackc/pgx/v4"
 	"github.com/plifk/market/internal/services"
 )
 
@@ -13,8 +18,10 @@ type AdminHandler struct {
 	dashboardHandler *AdminDashboardHandler
 	usersHandler     *AdminUsersHandler
 	securityHandler  *AdminSecurityHandler
+	ordersHandler    *AdminOrdersHandler
 	reportsHandler   *AdminReportsHandler
 	rolesHandler     *AdminRolesHandler
+	oauthHandler     *AdminOAuthHandler
 }
 
 // Load /admin routes.
@@ -22,32 +29,56 @@ func (h *AdminHandler) Load() {
 	h.dashboardHandler = &AdminDashboardHandler{Frontend: h.Frontend}
 	h.usersHandler = &AdminUsersHandler{Frontend: h.Frontend}
 	h.securityHandler = &AdminSecurityHandler{Frontend: h.Frontend}
+	h.ordersHandler = &AdminOrdersHandler{Frontend: h.Frontend}
 	h.reportsHandler = &AdminReportsHandler{Frontend: h.Frontend}
 	h.rolesHandler = &AdminRolesHandler{Frontend: h.Frontend}
+	h.oauthHandler = &AdminOAuthHandler{Frontend: h.Frontend}
 }
 
 func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if user := services.UserFromRequest(r); user.Access != services.AdminAuthorization {
+	user := services.UserFromRequest(r)
+	if !services.Can(user, services.PermissionAdminArea) {
 		h.Frontend.HTTPError(w, r, http.StatusNotFound)
 		return
 	}
+	// Admins who logged in before two-factor authentication was required must enroll first.
+	switch enabled, err := h.Frontend.Modules.MFA.Enabled(r.Context(), user.UserID); {
+	case err != nil:
+		log.Printf("cannot check two-factor authentication of user %q: %v", user.UserID, err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	case !enabled:
+		http.Redirect(w, r, "/account/mfa", http.StatusSeeOther)
+		return
+	}
 
-	var handler http.Handler
+	var (
+		handler    http.Handler
+		permission = services.PermissionAdminArea
+	)
 	switch route := dirRouter(r.URL.Path); {
 	case route.is("/admin"):
 		handler = h.dashboardHandler
 	case route.is("/admin/users"):
-		handler = h.usersHandler
-	case route.is("/admin/security"):
-		handler = h.securityHandler
+		handler, permission = h.usersHandler, services.PermissionManageUsers
+	case route.is("/admin/security") || strings.HasPrefix(r.URL.Path, "/admin/security/"):
+		handler, permission = h.securityHandler, services.PermissionManageSecurity
+	case route.is("/admin/orders") || route.is("/admin/orders/refund"):
+		handler, permission = h.ordersHandler, services.PermissionViewOrders
 	case route.is("/admin/reports"):
-		handler = h.reportsHandler
-	case route.is("/admin/roles"):
-		handler = h.rolesHandler
+		handler, permission = h.reportsHandler, services.PermissionViewReports
+	case route.is("/admin/roles") || strings.HasPrefix(r.URL.Path, "/admin/roles/"):
+		handler, permission = h.rolesHandler, services.PermissionManageRoles
+	case route.is("/admin/oauth") || route.is("/admin/oauth/delete"):
+		handler, permission = h.oauthHandler, services.PermissionManageOAuth
 	}
 	if handler == nil {
 		handler = h.Frontend.staticHandler
 	}
+	if !services.Can(user, permission) {
+		h.Frontend.HTTPError(w, r, http.StatusForbidden)
+		return
+	}
 	handler.ServeHTTP(w, r)
 }
 
@@ -72,8 +103,248 @@ type AdminSecurityHandler struct {
 	Frontend *Frontend
 }
 
-// UsersHandler for /admin/security.
-func (h *AdminSecurityHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {}
+// AdminSecurityContent to render the security page.
+type AdminSecurityContent struct {
+	// Lockouts of IP addresses and accounts after too many failed login attempts.
+	Lockouts []services.LoginLockout
+}
+
+// ServeHTTP for /admin/security.
+func (h *AdminSecurityHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch route := dirRouter(r.URL.Path); {
+	case route.is("/admin/security") && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		h.view(w, r)
+	case route.is("/admin/security/unlock") && r.Method == http.MethodPost:
+		h.unlock(w, r)
+	case route.is("/admin/security/sessions") && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		h.sessions(w, r, AdminSessionsContent{})
+	case route.is("/admin/security/sessions/close") && r.Method == http.MethodPost:
+		h.closeSession(w, r)
+	case route.is("/admin/security/sessions/close-all") && r.Method == http.MethodPost:
+		h.closeAllSessions(w, r)
+	case route.is("/admin/security"), route.is("/admin/security/unlock"), route.is("/admin/security/sessions"),
+		route.is("/admin/security/sessions/close"), route.is("/admin/security/sessions/close-all"):
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+	default:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+	}
+}
+
+func (h *AdminSecurityHandler) view(w http.ResponseWriter, r *http.Request) {
+	lockouts, err := h.Frontend.Modules.LoginThrottle.Lockouts(r.Context())
+	if err != nil {
+		log.Printf("cannot list login lockouts: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	resp := &HTMLResponse{
+		Template:   "admin-security",
+		Title:      "Security",
+		Breadcrumb: []Breadcrumb{{Text: "Admin", Link: "/admin"}, {Text: "Security", Active: true}},
+		Content:    AdminSecurityContent{Lockouts: lockouts},
+	}
+	h.Frontend.Respond(w, r, resp)
+}
+
+func (h *AdminSecurityHandler) unlock(w http.ResponseWriter, r *http.Request) {
+	switch err := h.Frontend.Modules.LoginThrottle.Unlock(r.Context(), r.PostFormValue("lockout_id")); {
+	case err == services.ErrLoginLockoutNotFound:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("cannot lift login lockout: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin/security", http.StatusSeeOther)
+}
+
+// AdminSessionsContent to render the devices of a user.
+type AdminSessionsContent struct {
+	// Email address of the user looked up.
+	Email string
+
+	User    *services.User
+	Devices []services.Device
+
+	// Notice of devices logged out.
+	Notice string
+
+	Error error
+}
+
+// sessions shows the devices of the user with the email address on the query string.
+func (h *AdminSecurityHandler) sessions(w http.ResponseWriter, r *http.Request, content AdminSessionsContent) {
+	modules := h.Frontend.Modules
+	if content.User == nil {
+		content.Email = strings.TrimSpace(r.FormValue("email"))
+	}
+	if content.User == nil && content.Email != "" {
+		switch u, err := modules.Accounts.GetUserByEmail(r.Context(), content.Email); {
+		case err == services.ErrUserNotFound:
+			w.WriteHeader(http.StatusNotFound)
+			content.Error = err
+		case err != nil:
+			log.Printf("cannot get user to list devices: %v", err)
+			h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+			return
+		default:
+			content.User = u
+		}
+	}
+	if content.User != nil {
+		devices, err := modules.Sessions.Devices(r.Context(), content.User.UserID, "")
+		if err != nil {
+			log.Printf("cannot list devices: %v", err)
+			h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+			return
+		}
+		content.Email = content.User.Email
+		content.Devices = devices
+	}
+	resp := &HTMLResponse{
+		Template: "admin-security-sessions",
+		Title:    "Devices",
+		Breadcrumb: []Breadcrumb{
+			{Text: "Admin", Link: "/admin"},
+			{Text: "Security", Link: "/admin/security"},
+			{Text: "Devices", Active: true},
+		},
+		Content: content,
+	}
+	h.Frontend.Respond(w, r, resp)
+}
+
+// sessionsUser gets the user whose devices are logged out, writing the error to the response on failure.
+func (h *AdminSecurityHandler) sessionsUser(w http.ResponseWriter, r *http.Request) (*services.User, bool) {
+	u, err := h.Frontend.Modules.Accounts.GetUserByID(r.Context(), r.PostFormValue("user_id"))
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+		return nil, false
+	case err != nil:
+		log.Printf("cannot get user to log out of devices: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return nil, false
+	}
+	return u, true
+}
+
+func (h *AdminSecurityHandler) closeSession(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.sessionsUser(w, r)
+	if !ok {
+		return
+	}
+	switch err := h.Frontend.Modules.Sessions.CloseDevice(r.Context(), u.UserID, r.PostFormValue("device_id")); {
+	case err == services.ErrDeviceNotFound:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("cannot log out of device: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	h.sessions(w, r, AdminSessionsContent{User: u, Notice: "The device was signed out."})
+}
+
+func (h *AdminSecurityHandler) closeAllSessions(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.sessionsUser(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Frontend.Modules.Sessions.CloseUserSessions(r.Context(), u.UserID, ""); err != nil {
+		log.Printf("cannot log out of devices: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	h.sessions(w, r, AdminSessionsContent{User: u, Notice: "All devices of the user were signed out."})
+}
+
+// AdminOrdersHandler for the application.
+type AdminOrdersHandler struct {
+	Frontend *Frontend
+}
+
+// AdminOrdersContent to render the orders page.
+type AdminOrdersContent struct {
+	// OrderID looked up.
+	OrderID string
+
+	Order *services.Order
+
+	// CanRefund is set if the admin can refund the order.
+	CanRefund bool
+
+	// Notice of a change made.
+	Notice string
+
+	Error error
+}
+
+// ServeHTTP for /admin/orders, where orders are looked up and refunded.
+func (h *AdminOrdersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch route := dirRouter(r.URL.Path); {
+	case route.is("/admin/orders") && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		h.view(w, r, AdminOrdersContent{OrderID: strings.TrimSpace(r.FormValue("order_id"))})
+	case route.is("/admin/orders/refund") && r.Method == http.MethodPost:
+		h.refund(w, r)
+	case route.is("/admin/orders"), route.is("/admin/orders/refund"):
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+	default:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+	}
+}
+
+func (h *AdminOrdersHandler) view(w http.ResponseWriter, r *http.Request, content AdminOrdersContent) {
+	if content.OrderID != "" {
+		switch order, err := h.Frontend.Modules.Orders.GetOrder(r.Context(), content.OrderID); {
+		case err == services.ErrOrderNotFound:
+			w.WriteHeader(http.StatusNotFound)
+			content.Error = err
+		case err != nil:
+			log.Printf("cannot get order %q: %v", content.OrderID, err)
+			h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+			return
+		default:
+			content.Order = order
+			content.CanRefund = services.Can(services.UserFromRequest(r), services.PermissionManageOrders) &&
+				(order.Status.CanTransition(services.OrderRefunding) || order.Status == services.OrderRefunding)
+		}
+	}
+	resp := &HTMLResponse{
+		Template:   "admin-orders",
+		Title:      "Orders",
+		Breadcrumb: []Breadcrumb{{Text: "Admin", Link: "/admin"}, {Text: "Orders", Active: true}},
+		Content:    content,
+	}
+	h.Frontend.Respond(w, r, resp)
+}
+
+// refund an order, which customers can only do before it is shipped.
+func (h *AdminOrdersHandler) refund(w http.ResponseWriter, r *http.Request) {
+	if !services.Can(services.UserFromRequest(r), services.PermissionManageOrders) {
+		h.Frontend.HTTPError(w, r, http.StatusForbidden)
+		return
+	}
+	content := AdminOrdersContent{OrderID: r.PostFormValue("order_id")}
+	switch err := h.Frontend.Modules.Orders.Refund(r.Context(), content.OrderID); {
+	case err == services.ErrOrderNotFound:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+		return
+	case err == services.ErrInvalidOrderTransition:
+		w.WriteHeader(http.StatusConflict)
+		content.Error = err
+	case err != nil:
+		log.Printf("cannot refund order %q: %v", content.OrderID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		content.Error = errOrderRefund
+	default:
+		content.Notice = "The order was refunded."
+	}
+	h.view(w, r, content)
+}
+
+var errOrderRefund = errors.New("the order could not be refunded, please try again")
 
 // AdminReportsHandler for the application.
 type AdminReportsHandler struct {
@@ -88,5 +359,285 @@ type AdminRolesHandler struct {
 	Frontend *Frontend
 }
 
-// UsersHandler for /admin/roles.
-func (h *AdminRolesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {}
+// AdminRolesContent to render the roles page.
+type AdminRolesContent struct {
+	Roles []AdminRole
+
+	// Permissions that can be chosen for a role.
+	Permissions []services.Permission
+
+	// Notice of a change made.
+	Notice string
+
+	Error error
+}
+
+// AdminRole is a role and its members.
+type AdminRole struct {
+	services.Role
+	Members []services.RoleMember
+}
+
+// ServeHTTP for /admin/roles, where roles are managed and assigned to users.
+func (h *AdminRolesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch route := dirRouter(r.URL.Path); {
+	case route.is("/admin/roles") && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		h.view(w, r, AdminRolesContent{})
+	case route.is("/admin/roles") && r.Method == http.MethodPost:
+		h.create(w, r)
+	case route.is("/admin/roles/defaults") && r.Method == http.MethodPost:
+		h.createDefaults(w, r)
+	case route.is("/admin/roles/update") && r.Method == http.MethodPost:
+		h.update(w, r)
+	case route.is("/admin/roles/delete") && r.Method == http.MethodPost:
+		h.delete(w, r)
+	case route.is("/admin/roles/assign") && r.Method == http.MethodPost:
+		h.assign(w, r)
+	case route.is("/admin/roles/revoke") && r.Method == http.MethodPost:
+		h.revoke(w, r)
+	case route.is("/admin/roles"), route.is("/admin/roles/defaults"), route.is("/admin/roles/update"),
+		route.is("/admin/roles/delete"), route.is("/admin/roles/assign"), route.is("/admin/roles/revoke"):
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+	default:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+	}
+}
+
+func (h *AdminRolesHandler) view(w http.ResponseWriter, r *http.Request, content AdminRolesContent) {
+	modules := h.Frontend.Modules
+	roles, err := modules.Roles.List(r.Context())
+	if err != nil {
+		log.Printf("cannot list roles: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	members, err := modules.Roles.Members(r.Context())
+	if err != nil {
+		log.Printf("cannot list role members: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	for _, role := range roles {
+		ar := AdminRole{Role: role}
+		for _, m := range members {
+			if m.RoleID == role.RoleID {
+				ar.Members = append(ar.Members, m)
+			}
+		}
+		content.Roles = append(content.Roles, ar)
+	}
+	content.Permissions = services.Permissions
+	resp := &HTMLResponse{
+		Template:   "admin-roles",
+		Title:      "Roles",
+		Breadcrumb: []Breadcrumb{{Text: "Admin", Link: "/admin"}, {Text: "Roles", Active: true}},
+		Content:    content,
+	}
+	h.Frontend.Respond(w, r, resp)
+}
+
+// denied renders the roles page for changes granting permissions the user doesn't have.
+func (h *AdminRolesHandler) denied(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusForbidden)
+	h.view(w, r, AdminRolesContent{Error: services.ErrPermissionDenied})
+}
+
+// roleParams from the form.
+func roleParams(r *http.Request) services.RoleParams {
+	p := services.RoleParams{
+		RoleID:      r.PostForm.Get("role_id"),
+		Name:        r.PostForm.Get("name"),
+		Description: r.PostForm.Get("description"),
+	}
+	for _, s := range r.PostForm["permission"] {
+		p.Permissions = append(p.Permissions, services.Permission(s))
+	}
+	return p
+}
+
+func (h *AdminRolesHandler) create(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.Frontend.HTTPError(w, r, http.StatusBadRequest)
+		return
+	}
+	role, err := h.Frontend.Modules.Roles.Create(r.Context(), services.UserFromRequest(r), roleParams(r))
+	switch {
+	case err == services.ErrPermissionDenied:
+		h.denied(w, r)
+		return
+	case err != nil:
+		log.Printf("cannot create role: %v", err)
+		h.view(w, r, AdminRolesContent{Error: formError(w, err, services.ErrRoleExists)})
+		return
+	}
+	h.view(w, r, AdminRolesContent{Notice: fmt.Sprintf("Role %s created.", role.Name)})
+}
+
+func (h *AdminRolesHandler) createDefaults(w http.ResponseWriter, r *http.Request) {
+	created, err := h.Frontend.Modules.Roles.CreateDefaults(r.Context(), services.UserFromRequest(r))
+	if err != nil {
+		log.Printf("cannot create default roles: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	h.view(w, r, AdminRolesContent{Notice: fmt.Sprintf("%d default roles created.", created)})
+}
+
+func (h *AdminRolesHandler) update(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.Frontend.HTTPError(w, r, http.StatusBadRequest)
+		return
+	}
+	switch err := h.Frontend.Modules.Roles.Update(r.Context(), services.UserFromRequest(r), roleParams(r)); {
+	case err == services.ErrRoleNotFound:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+	case err == services.ErrPermissionDenied:
+		h.denied(w, r)
+	case err != nil:
+		log.Printf("cannot update role: %v", err)
+		h.view(w, r, AdminRolesContent{Error: formError(w, err)})
+	default:
+		http.Redirect(w, r, "/admin/roles", http.StatusSeeOther)
+	}
+}
+
+func (h *AdminRolesHandler) delete(w http.ResponseWriter, r *http.Request) {
+	switch err := h.Frontend.Modules.Roles.Delete(r.Context(), services.UserFromRequest(r), r.PostFormValue("role_id")); {
+	case err == services.ErrRoleNotFound:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+	case err == services.ErrPermissionDenied:
+		h.denied(w, r)
+	case err != nil:
+		log.Printf("cannot delete role: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+	default:
+		http.Redirect(w, r, "/admin/roles", http.StatusSeeOther)
+	}
+}
+
+func (h *AdminRolesHandler) assign(w http.ResponseWriter, r *http.Request) {
+	modules := h.Frontend.Modules
+	u, err := modules.Accounts.GetUserByEmail(r.Context(), strings.TrimSpace(r.PostFormValue("email")))
+	switch {
+	case err == services.ErrUserNotFound:
+		h.view(w, r, AdminRolesContent{Error: err})
+		return
+	case err != nil:
+		log.Printf("cannot get user to assign role: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	switch err := modules.Roles.Assign(r.Context(), services.UserFromRequest(r), u.UserID, r.PostFormValue("role_id")); {
+	case err == services.ErrRoleNotFound:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+	case err == services.ErrPermissionDenied:
+		h.denied(w, r)
+	case err != nil:
+		log.Printf("cannot assign role: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+	default:
+		http.Redirect(w, r, "/admin/roles", http.StatusSeeOther)
+	}
+}
+
+func (h *AdminRolesHandler) revoke(w http.ResponseWriter, r *http.Request) {
+	switch err := h.Frontend.Modules.Roles.Revoke(r.Context(), services.UserFromRequest(r), r.PostFormValue("user_id"), r.PostFormValue("role_id")); {
+	case err == services.ErrRoleNotFound:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+	case err == services.ErrPermissionDenied:
+		h.denied(w, r)
+	case err != nil:
+		log.Printf("cannot revoke role: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+	default:
+		http.Redirect(w, r, "/admin/roles", http.StatusSeeOther)
+	}
+}
+
+// AdminOAuthHandler for the application.
+type AdminOAuthHandler struct {
+	Frontend *Frontend
+}
+
+// AdminOAuthContent to render the OAuth clients page.
+type AdminOAuthContent struct {
+	Clients []services.OAuthClient
+
+	// Scopes that can be granted to a client.
+	Scopes []services.TokenScope
+
+	// NewClient and its secret are only shown right after it is created, as the secret cannot be retrieved later.
+	NewClient *services.OAuthClient
+	Secret    string
+
+	Error error
+}
+
+// ServeHTTP for /admin/oauth, where OAuth clients are registered.
+func (h *AdminOAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch route := dirRouter(r.URL.Path); {
+	case route.is("/admin/oauth") && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		h.view(w, r, AdminOAuthContent{})
+	case route.is("/admin/oauth") && r.Method == http.MethodPost:
+		h.create(w, r)
+	case route.is("/admin/oauth/delete") && r.Method == http.MethodPost:
+		h.delete(w, r)
+	case route.is("/admin/oauth"), route.is("/admin/oauth/delete"):
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+	default:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+	}
+}
+
+func (h *AdminOAuthHandler) view(w http.ResponseWriter, r *http.Request, content AdminOAuthContent) {
+	clients, err := h.Frontend.Modules.OAuth.ListClients(r.Context())
+	if err != nil {
+		log.Printf("cannot list OAuth clients: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	content.Clients = clients
+	content.Scopes = services.TokenScopes
+	resp := &HTMLResponse{
+		Template:   "admin-oauth",
+		Title:      "OAuth clients",
+		Breadcrumb: []Breadcrumb{{Text: "Admin", Link: "/admin"}, {Text: "OAuth clients", Active: true}},
+		Content:    content,
+	}
+	h.Frontend.Respond(w, r, resp)
+}
+
+func (h *AdminOAuthHandler) create(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.Frontend.HTTPError(w, r, http.StatusBadRequest)
+		return
+	}
+	params := services.NewOAuthClientParams{
+		Name:         r.PostForm.Get("name"),
+		RedirectURIs: strings.Split(r.PostForm.Get("redirect_uris"), "\n"),
+		Confidential: r.PostForm.Get("confidential") == "on",
+	}
+	for _, s := range r.PostForm["scope"] {
+		params.Scopes = append(params.Scopes, services.TokenScope(s))
+	}
+	client, secret, err := h.Frontend.Modules.OAuth.NewClient(r.Context(), params)
+	if err != nil {
+		log.Printf("cannot create OAuth client: %v", err)
+		h.view(w, r, AdminOAuthContent{Error: formError(w, err)})
+		return
+	}
+	h.view(w, r, AdminOAuthContent{NewClient: client, Secret: secret})
+}
+
+func (h *AdminOAuthHandler) delete(w http.ResponseWriter, r *http.Request) {
+	switch err := h.Frontend.Modules.OAuth.DeleteClient(r.Context(), r.PostFormValue("client_id")); {
+	case err == services.ErrOAuthClientNotFound:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("cannot delete OAuth client: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin/oauth", http.StatusSeeOther)
+}
@@ -1,13 +1,16 @@
 package frontend
 
 import (
+	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/plifk/market/internal/services"
 	"github.com/plifk/market/internal/validator"
+	"github.com/plifk/market/internal/webauthn"
 )
 
 // LoginHandler handles the user authentication.
@@ -15,17 +18,22 @@ type LoginHandler struct {
 	Frontend *Frontend
 }
 
-// redirectAfterLogin checks whether the redirect parameter is a path to a safe internal URL.
-// It tests if it is safe to redirect. If not, returns to the home page (/).
+// redirectAfterLogin redirects to the redirect_uri parameter if it is safe, or to the home page (/) otherwise.
 func redirectAfterLogin(w http.ResponseWriter, r *http.Request) {
-	q := r.URL.Query()
-	redirect := q.Get("redirect_uri")
-	// We want Scheme, Opaque, User, Host, RawQuery, and Fragment not to be defined.
-	// Testing for non parsing error and path = redirect should be enough.
-	if u, err := url.Parse(redirect); err != nil || u.Path != redirect {
-		redirect = "/"
+	http.Redirect(w, r, safeRedirectPath(r.URL.Query().Get("redirect_uri")), http.StatusSeeOther)
+}
+
+// safeRedirectPath checks whether redirect is a path (with an optional query) to an internal URL.
+// If not, it returns the home page (/).
+func safeRedirectPath(redirect string) string {
+	// We want Scheme, Opaque, User, Host, and Fragment not to be defined.
+	// Browsers treat backslashes as slashes, so //host and /\host are both rejected.
+	u, err := url.Parse(redirect)
+	if err != nil || u.Scheme != "" || u.Opaque != "" || u.User != nil || u.Host != "" || u.Fragment != "" ||
+		!strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.ContainsAny(redirect, "\\\r\n") {
+		return "/"
 	}
-	http.Redirect(w, r, redirect, http.StatusSeeOther)
+	return redirect
 }
 
 func (h *LoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -42,11 +50,24 @@ func (h *LoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	if r.Method == http.MethodPost {
+	switch route := dirRouter(r.URL.Path); {
+	case route.is("/login/mfa") && r.Method == http.MethodPost:
+		h.mfaPostHandler(w, r)
+	case route.is("/login/mfa"), route.is("/login/passkey") && r.Method != http.MethodPost:
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	case route.is("/login/passkey"):
+		h.passkeyPostHandler(w, r)
+	case route.is("/login/passkey/options") && r.Method == http.MethodPost:
+		h.passkeyOptionsHandler(w, r)
+	case route.is("/login/passkey/options"):
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+	case !route.is("/login"):
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+	case r.Method == http.MethodPost:
 		h.loginPostHandler(w, r)
-		return
+	default:
+		h.loginGetHandler(w, r, nil)
 	}
-	h.loginGetHandler(w, r, nil)
 }
 
 // LoginForm for /login.
@@ -54,19 +75,33 @@ type LoginForm struct {
 	Email      string
 	RememberMe bool
 
+	// RedirectURI to go to after logging in.
+	RedirectURI string
+
+	// Unverified is set when the user must verify their email address before logging in.
+	Unverified bool
+
 	Error error
 }
 
 func (h *LoginHandler) loginGetHandler(w http.ResponseWriter, r *http.Request, err error) {
+	h.loginPage(w, r, newLoginForm(r, err))
+}
+
+func newLoginForm(r *http.Request, err error) LoginForm {
 	rememberMe := true
 	if r.Method == http.MethodPost {
 		rememberMe = r.PostFormValue("remember_me") == "on"
 	}
-	form := LoginForm{
-		Email:      r.PostFormValue("email"),
-		RememberMe: rememberMe,
-		Error:      err,
+	return LoginForm{
+		Email:       r.PostFormValue("email"),
+		RememberMe:  rememberMe,
+		RedirectURI: r.URL.Query().Get("redirect_uri"),
+		Error:       err,
 	}
+}
+
+func (h *LoginHandler) loginPage(w http.ResponseWriter, r *http.Request, form LoginForm) {
 	resp := &HTMLResponse{
 		Template: "account-login",
 		Title:    "Login",
@@ -81,34 +116,163 @@ func (h *LoginHandler) loginPostHandler(w http.ResponseWriter, r *http.Request)
 	rememberMe := r.PostFormValue("remember_me") == "on"
 
 	var fe validator.FormError
-	if len(email) > 255 {
-		h.loginGetHandler(w, r, fe.Append("email", errors.New("email address is too long")))
+	modules := h.Frontend.Modules
+	attempt := services.LoginAttempt{IP: modules.Security.ClientIP(r), Email: email}
+	if err := h.Frontend.throttleLogin(w, r, attempt); err != nil {
+		h.loginGetHandler(w, r, fe.Append("password", err))
 		return
 	}
 
-	modules := h.Frontend.Modules
-	accounts := modules.Accounts
-	u, err := accounts.GetUserByEmail(r.Context(), email)
+	u, err := modules.Accounts.Authenticate(r.Context(), email, password)
 	switch {
-	case err == services.ErrUserNotFound:
-		h.loginGetHandler(w, r, fe.Append("email", err))
+	case err == services.ErrInvalidCredentials:
+		h.Frontend.failLogin(r, attempt)
+		h.loginGetHandler(w, r, fe.Append("password", err))
+		return
+	case err != nil:
+		log.Printf("cannot authenticate user on /login: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	// Checked only after the password, so that this doesn't tell others whether an account was verified.
+	if !u.EmailVerified() {
+		form := newLoginForm(r, nil)
+		form.Unverified = true
+		h.loginPage(w, r, form)
+		return
+	}
+
+	enabled, err := modules.MFA.Enabled(r.Context(), u.UserID)
+	if err != nil {
+		log.Printf("cannot check two-factor authentication of user %q: %v", u.UserID, err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	if !enabled {
+		h.login(w, r, u, rememberMe, false)
+		return
+	}
+	challenge, err := modules.MFA.NewLoginChallenge(u.UserID, rememberMe)
+	if err != nil {
+		log.Printf("cannot create two-factor authentication challenge: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	h.mfaPage(w, r, LoginMFAForm{Challenge: challenge})
+}
+
+// LoginMFAForm for the second login step, when the user has two-factor authentication enabled.
+type LoginMFAForm struct {
+	// Challenge proves the user entered the right password.
+	Challenge string
+
+	// RedirectURI to go to after logging in.
+	RedirectURI string
+
+	Error error
+}
+
+func (h *LoginHandler) mfaPage(w http.ResponseWriter, r *http.Request, form LoginMFAForm) {
+	form.RedirectURI = r.URL.Query().Get("redirect_uri")
+	resp := &HTMLResponse{
+		Template: "account-login-mfa",
+		Title:    "Two-factor authentication",
+		Content:  form,
+	}
+	h.Frontend.Respond(w, r, resp)
+}
+
+var errLoginExpired = errors.New("too much time passed since you entered your password, please try again")
+
+func (h *LoginHandler) mfaPostHandler(w http.ResponseWriter, r *http.Request) {
+	modules := h.Frontend.Modules
+	challenge := r.PostFormValue("challenge")
+	userID, rememberMe, err := modules.MFA.ParseLoginChallenge(challenge)
+	if err != nil {
+		var fe validator.FormError
+		h.loginGetHandler(w, r, fe.Append("password", errLoginExpired))
+		return
+	}
+	u, err := modules.Accounts.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("cannot get user %q for two-factor authentication: %v", userID, err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	// Codes are short, so guessing them is throttled like guessing passwords.
+	attempt := services.LoginAttempt{IP: modules.Security.ClientIP(r), Email: u.Email}
+	if err := h.Frontend.throttleLogin(w, r, attempt); err != nil {
+		h.mfaPage(w, r, LoginMFAForm{Challenge: challenge, Error: err})
+		return
+	}
+	switch err := modules.MFA.Verify(r.Context(), u.UserID, r.PostFormValue("code")); {
+	case err == services.ErrInvalidMFACode:
+		h.Frontend.failLogin(r, attempt)
+		h.mfaPage(w, r, LoginMFAForm{Challenge: challenge, Error: err})
 		return
 	case err != nil:
-		log.Printf("cannot get user by email on /login: %v", err)
+		log.Printf("cannot verify two-factor authentication code: %v", err)
 		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
 		return
 	}
+	h.login(w, r, u, rememberMe, true)
+}
 
-	err = accounts.CheckPassword(r.Context(), u.UserID, password)
+// passkeyOptionsHandler starts logging in with a passkey, returning the WebAuthn options for the browser.
+func (h *LoginHandler) passkeyOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := h.Frontend.Modules.Passkeys.BeginLogin(r.Context())
+	if err != nil {
+		log.Printf("cannot begin passkey login: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, options)
+}
+
+var errPasskeyLogin = errors.New("cannot log in with this passkey")
+
+// passkeyPostHandler logs in with the passkey assertion the browser posted as JSON on the credential field.
+// Passkeys verify the user, so there is no second login step.
+func (h *LoginHandler) passkeyPostHandler(w http.ResponseWriter, r *http.Request) {
+	var fe validator.FormError
+	var assertion webauthn.AssertionResponse
+	if err := json.Unmarshal([]byte(r.PostFormValue("credential")), &assertion); err != nil {
+		h.loginGetHandler(w, r, fe.Append("passkey", errPasskeyLogin))
+		return
+	}
+	modules := h.Frontend.Modules
+	userID, err := modules.Passkeys.FinishLogin(r.Context(), &assertion)
 	switch {
-	case err == services.ErrWrongPassword:
-		h.loginGetHandler(w, r, fe.Append("password", err))
+	case err == services.ErrPasskeyNotFound || errors.Is(err, services.ErrInvalidPasskey):
+		log.Printf("cannot log in with passkey: %v", err)
+		h.loginGetHandler(w, r, fe.Append("passkey", errPasskeyLogin))
 		return
 	case err != nil:
+		log.Printf("cannot log in with passkey: %v", err)
 		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
 		return
 	}
+	u, err := modules.Accounts.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("cannot get user %q after passkey login: %v", userID, err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	enabled, err := modules.MFA.Enabled(r.Context(), u.UserID)
+	if err != nil {
+		log.Printf("cannot check two-factor authentication of user %q: %v", u.UserID, err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	h.login(w, r, u, r.PostFormValue("remember_me") == "on", enabled)
+}
 
+// login the user after they were authenticated.
+// Users who must use two-factor authentication but didn't enable it yet are sent to enroll.
+func (h *LoginHandler) login(w http.ResponseWriter, r *http.Request, u *services.User, rememberMe, mfaEnabled bool) {
+	modules := h.Frontend.Modules
 	if session := services.SessionFromRequest(r); session != nil {
 		if err := modules.Sessions.Close(r.Context(), session.StickyID); err != nil {
 			log.Printf("cannot close session with sticky id %q: %v", session.StickyID, err)
@@ -121,11 +285,19 @@ func (h *LoginHandler) loginPostHandler(w http.ResponseWriter, r *http.Request)
 		RememberMe: rememberMe,
 	}
 	modules.Security.RegenerateCSRFToken(w, r) // Create new CSRF token.
-	if session, err := modules.Sessions.Login(w, r, u.UserID, params); err != nil {
-		log.Printf("cannot login user %q (with sticky id %q): %v", u.UserID, session.StickyID, err)
+	if _, err := modules.Sessions.Login(w, r, u.UserID, params); err != nil {
+		log.Printf("cannot login user %q: %v", u.UserID, err)
 		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
 		return
 	}
+	// Cleared only now, and not after the password step, so that codes cannot be guessed by alternating with the right password.
+	if err := modules.LoginThrottle.Succeed(r.Context(), services.LoginAttempt{Email: u.Email}); err != nil {
+		log.Printf("cannot clear failed login attempts of user %q: %v", u.UserID, err)
+	}
+	if !mfaEnabled && modules.MFA.Required(u) {
+		http.Redirect(w, r, "/account/mfa", http.StatusSeeOther)
+		return
+	}
 	redirectAfterLogin(w, r)
 }
 
@@ -0,0 +1,25 @@
+package frontend
+
+import "testing"
+
+func TestSafeRedirectPath(t *testing.T) {
+	testCases := []struct {
+		redirect string
+		want     string
+	}{
+		{redirect: "", want: "/"},
+		{redirect: "/checkout", want: "/checkout"},
+		{redirect: "/oauth/authorize?client_id=abc&scope=profile+orders", want: "/oauth/authorize?client_id=abc&scope=profile+orders"},
+		{redirect: "checkout", want: "/"},
+		{redirect: "//evil.example.com", want: "/"},
+		{redirect: "/\\evil.example.com", want: "/"},
+		{redirect: "https://evil.example.com/", want: "/"},
+		{redirect: "javascript:alert(1)", want: "/"},
+		{redirect: "/account#top", want: "/"},
+	}
+	for _, tc := range testCases {
+		if got := safeRedirectPath(tc.redirect); got != tc.want {
+			t.Errorf("safeRedirectPath(%q) = %q, wanted %q instead", tc.redirect, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package frontend
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/plifk/market/internal/services"
+)
+
+// CartHandler for the /cart pages.
+type CartHandler struct {
+	Frontend *Frontend
+}
+
+// CartContent to render the shopping cart.
+type CartContent struct {
+	Cart *services.Cart
+
+	// MaxQuantity of each item.
+	MaxQuantity int
+}
+
+func (h *CartHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session := services.SessionFromRequest(r)
+	if session == nil {
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	owner := services.CartOwnerFromSession(session)
+
+	switch route := dirRouter(r.URL.Path); {
+	case route.is("/cart"):
+		h.view(w, r, owner)
+	case route.is("/cart/add"):
+		h.change(w, r, owner, h.Frontend.Modules.Carts.AddItem)
+	case route.is("/cart/update"):
+		h.change(w, r, owner, h.Frontend.Modules.Carts.SetQuantity)
+	case route.is("/cart/remove"):
+		h.change(w, r, owner, nil)
+	default:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+	}
+}
+
+func (h *CartHandler) view(w http.ResponseWriter, r *http.Request, owner services.CartOwner) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	cart, err := h.Frontend.Modules.Carts.Get(r.Context(), owner)
+	if err != nil {
+		log.Printf("cannot get cart: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	resp := &HTMLResponse{
+		Template:      "cart",
+		Title:         "Shopping cart",
+		CanonicalLink: "/cart",
+		Breadcrumb:    []Breadcrumb{{Text: "Shopping cart", Active: true}},
+		Content: CartContent{
+			Cart:        cart,
+			MaxQuantity: services.MaxCartItemQuantity,
+		},
+	}
+	h.Frontend.Respond(w, r, resp)
+}
+
+// change the cart using the variant_id and quantity form values, and go back to the cart page.
+// If fn is nil, the item is removed.
+func (h *CartHandler) change(w http.ResponseWriter, r *http.Request, owner services.CartOwner,
+	fn func(ctx context.Context, owner services.CartOwner, variantID string, quantity int) error) {
+	if r.Method != http.MethodPost {
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	variantID := r.PostFormValue("variant_id")
+	if variantID == "" {
+		h.Frontend.HTTPError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if fn == nil {
+		err = h.Frontend.Modules.Carts.RemoveItem(r.Context(), owner, variantID)
+	} else {
+		quantity, perr := strconv.Atoi(r.PostFormValue("quantity"))
+		if perr != nil {
+			h.Frontend.HTTPError(w, r, http.StatusBadRequest)
+			return
+		}
+		err = fn(r.Context(), owner, variantID, quantity)
+	}
+	switch {
+	case err == services.ErrVariantNotFound:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+		return
+	case err == services.ErrInvalidQuantity:
+		h.Frontend.HTTPError(w, r, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		log.Printf("cannot change cart: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
@@ -0,0 +1,103 @@
+package frontend
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/plifk/market/internal/services"
+)
+
+// CategoryHandler for the /c/:slug pages.
+type CategoryHandler struct {
+	Frontend *Frontend
+}
+
+// CategoryContent to render a category page.
+type CategoryContent struct {
+	Category      *services.Category
+	Subcategories []services.Category
+	Products      []services.Product
+}
+
+func (h *CategoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	slug := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/c/"), "/")
+	if slug == "" || strings.Contains(slug, "/") {
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+		return
+	}
+
+	modules := h.Frontend.Modules
+	category, err := modules.Categories.GetCategoryBySlug(r.Context(), slug)
+	switch {
+	case err == services.ErrCategoryNotFound:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("cannot get category %q: %v", slug, err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	breadcrumb, err := h.Frontend.categoryBreadcrumb(r, category.CategoryID)
+	if err != nil {
+		log.Printf("cannot get breadcrumb for category %q: %v", category.CategoryID, err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	if len(breadcrumb) != 0 {
+		breadcrumb[len(breadcrumb)-1].Active = true
+	}
+	subcategories, err := modules.Categories.Children(r.Context(), category.CategoryID)
+	if err != nil {
+		log.Printf("cannot get subcategories of %q: %v", category.CategoryID, err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	products, err := modules.Catalog.ListProducts(r.Context(), services.ListProductsParams{
+		CategoryID: category.CategoryID,
+	})
+	if err != nil {
+		log.Printf("cannot list products of category %q: %v", category.CategoryID, err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	resp := &HTMLResponse{
+		Template:      "category",
+		Title:         category.Name,
+		CanonicalLink: "/c/" + category.Slug,
+		Breadcrumb:    breadcrumb,
+		Content: CategoryContent{
+			Category:      category,
+			Subcategories: subcategories,
+			Products:      products,
+		},
+	}
+	h.Frontend.Respond(w, r, resp)
+}
+
+// categoryBreadcrumb returns the path from the root category up to the given category.
+func (f *Frontend) categoryBreadcrumb(r *http.Request, categoryID string) ([]Breadcrumb, error) {
+	ancestors, err := f.Modules.Categories.Ancestors(r.Context(), categoryID)
+	if err != nil {
+		return nil, err
+	}
+	return makeCategoryBreadcrumb(ancestors), nil
+}
+
+// makeCategoryBreadcrumb from a list of categories ordered from the root to the leaf.
+func makeCategoryBreadcrumb(ancestors []services.Category) []Breadcrumb {
+	breadcrumb := make([]Breadcrumb, 0, len(ancestors))
+	for _, c := range ancestors {
+		breadcrumb = append(breadcrumb, Breadcrumb{
+			Text: c.Name,
+			Link: "/c/" + c.Slug,
+		})
+	}
+	return breadcrumb
+}
@@ -0,0 +1,27 @@
+package frontend
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/plifk/market/internal/services"
+)
+
+func TestMakeCategoryBreadcrumb(t *testing.T) {
+	ancestors := []services.Category{
+		{CategoryID: "a", Slug: "computers", Name: "Computers"},
+		{CategoryID: "b", ParentID: "a", Slug: "computers-displays", Name: "Displays"},
+		{CategoryID: "c", ParentID: "b", Slug: "computers-displays-4k", Name: "4K"},
+	}
+	want := []Breadcrumb{
+		{Text: "Computers", Link: "/c/computers"},
+		{Text: "Displays", Link: "/c/computers-displays"},
+		{Text: "4K", Link: "/c/computers-displays-4k"},
+	}
+	if got := makeCategoryBreadcrumb(ancestors); !reflect.DeepEqual(got, want) {
+		t.Errorf("makeCategoryBreadcrumb() = %+v, wanted %+v", got, want)
+	}
+	if got := makeCategoryBreadcrumb(nil); len(got) != 0 {
+		t.Errorf("makeCategoryBreadcrumb(nil) = %+v, wanted empty breadcrumb", got)
+	}
+}
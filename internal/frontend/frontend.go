@@ -1,10 +1,15 @@
 package frontend
 
 import (
+	"errors"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/plifk/market/internal/ratelimit"
 	"github.com/plifk/market/internal/services"
 )
 
@@ -36,20 +41,116 @@ func (f *Frontend) HTTPError(w http.ResponseWriter, r *http.Request, code int, e
 	f.Respond(w, r, resp)
 }
 
+// errUnexpected is shown to users instead of errors they cannot act upon, such as database failures.
+var errUnexpected = errors.New("something went wrong, please try again later")
+
+// formError returns the error to show to the user on a form, and writes the status code of the response.
+// Only validation errors and the given known errors are shown, with 400 Bad Request.
+// Other errors are replaced by a generic message, with 500 Internal Server Error, so log them before.
+func formError(w http.ResponseWriter, err error, known ...error) error {
+	var ve *services.ValidationError
+	if errors.As(err, &ve) {
+		w.WriteHeader(http.StatusBadRequest)
+		return ve
+	}
+	for _, k := range known {
+		if errors.Is(err, k) {
+			w.WriteHeader(http.StatusBadRequest)
+			return k
+		}
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+	return errUnexpected
+}
+
+// throttleLogin checks if the password or code of the attempt can be checked now.
+// If not, it returns an error to show to the user, and writes the status code of the response.
+// Attempts aren't blocked if the failed attempts cannot be checked, such as when Redis is unavailable.
+func (f *Frontend) throttleLogin(w http.ResponseWriter, r *http.Request, attempt services.LoginAttempt) error {
+	err := f.Modules.LoginThrottle.Check(r.Context(), attempt)
+	var throttled *services.LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		w.WriteHeader(http.StatusTooManyRequests)
+		return throttled
+	case err != nil:
+		log.Printf("cannot check failed login attempts: %v", err)
+	}
+	return nil
+}
+
+// failLogin records a wrong password or code of the attempt.
+func (f *Frontend) failLogin(r *http.Request, attempt services.LoginAttempt) {
+	if err := f.Modules.LoginThrottle.Fail(r.Context(), attempt); err != nil {
+		log.Printf("cannot record failed login attempt: %v", err)
+	}
+}
+
 // Router for the webpages.
 type Router struct {
 	Frontend *Frontend
 
 	homepageHandler *HomepageHandler
 	loginHandler    *LoginHandler
+	signupHandler   *SignupHandler
+	recoverHandler  *RecoverHandler
 	logoutHandler   *LogoutHandler
 	staticHandler   *StaticHandler
 	searchHandler   *SearchHandler
 	productHandler  *ProductHandler
+	categoryHandler *CategoryHandler
+	cartHandler     *CartHandler
+	checkoutHandler *CheckoutHandler
+	ordersHandler   *OrdersHandler
 	accountHandler  *AccountHandler
+	tokensHandler   *TokensHandler
+	mfaHandler      *MFAHandler
+	passkeysHandler *PasskeysHandler
+	sessionsHandler *SessionsHandler
+	oauthHandler    *OAuthHandler
 	adminHandler    *AdminHandler
+
+	// routes, limited by the rate limit middleware.
+	routes http.Handler
 }
 
+// rateLimitRules of the webpages. Requests checking credentials or sending emails are limited the most.
+var rateLimitRules = []ratelimit.Rule{
+	{
+		Pattern: "/login/*",
+		Methods: []string{http.MethodPost},
+		Policy:  ratelimit.Policy{Name: "www:login", Limit: 20, Period: time.Minute},
+	},
+	{
+		Pattern: "/signup/*",
+		Methods: []string{http.MethodPost},
+		Policy:  ratelimit.Policy{Name: "www:signup", Limit: 5, Period: 10 * time.Minute},
+	},
+	{
+		Pattern: "/recover/*",
+		Methods: []string{http.MethodPost},
+		Policy:  ratelimit.Policy{Name: "www:recover", Limit: 5, Period: 10 * time.Minute},
+	},
+	{
+		Pattern: "/account/password",
+		Methods: []string{http.MethodPost},
+		Policy:  ratelimit.Policy{Name: "www:password", Limit: 10, Period: 10 * time.Minute},
+	},
+	{
+		Pattern: "/account/email",
+		Methods: []string{http.MethodPost},
+		Policy:  ratelimit.Policy{Name: "www:email", Limit: 5, Period: 10 * time.Minute},
+	},
+	{
+		Pattern: "/s",
+		Policy:  ratelimit.Policy{Name: "www:search", Limit: 60, Period: time.Minute},
+	},
+}
+
+// defaultRateLimit of the webpages. Pages load many static files at once, hence the burst.
+var defaultRateLimit = ratelimit.Policy{Name: "www", Limit: 600, Period: time.Minute, Burst: 200}
+
 // Load HTTP handlers.
 func (rh *Router) Load(modules *services.Modules) {
 	frontend := &Frontend{
@@ -62,12 +163,26 @@ func (rh *Router) Load(modules *services.Modules) {
 	rh.Frontend.staticHandler = rh.staticHandler
 	rh.homepageHandler = &HomepageHandler{Frontend: frontend}
 	rh.loginHandler = &LoginHandler{Frontend: frontend}
+	rh.signupHandler = &SignupHandler{Frontend: frontend}
+	rh.recoverHandler = &RecoverHandler{Frontend: frontend}
 	rh.logoutHandler = &LogoutHandler{Frontend: frontend}
 	rh.searchHandler = &SearchHandler{Frontend: frontend}
 	rh.productHandler = &ProductHandler{Frontend: frontend}
+	rh.categoryHandler = &CategoryHandler{Frontend: frontend}
+	rh.cartHandler = &CartHandler{Frontend: frontend}
+	rh.checkoutHandler = &CheckoutHandler{Frontend: frontend}
+	rh.ordersHandler = &OrdersHandler{Frontend: frontend}
 	rh.accountHandler = &AccountHandler{Frontend: frontend}
+	rh.tokensHandler = &TokensHandler{Frontend: frontend}
+	rh.mfaHandler = &MFAHandler{Frontend: frontend}
+	rh.passkeysHandler = &PasskeysHandler{Frontend: frontend}
+	rh.sessionsHandler = &SessionsHandler{Frontend: frontend}
+	rh.oauthHandler = &OAuthHandler{Frontend: frontend}
 	rh.adminHandler = &AdminHandler{Frontend: frontend}
 	rh.adminHandler.Load()
+	rh.routes = modules.RateLimits.Middleware(rateLimitRules, defaultRateLimit, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		frontend.HTTPError(w, r, http.StatusTooManyRequests)
+	})).Handler(http.HandlerFunc(rh.route))
 }
 
 func (rh *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -79,8 +194,7 @@ func (rh *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if session != nil {
 		ctx := services.SessionContext(r.Context(), session)
 		if session.UserID != "" {
-			// TODO(henvic): Add caching layer.
-			u, err := modules.Accounts.GetUserByID(r.Context(), session.UserID)
+			u, err := modules.Accounts.GetCachedUserByID(r.Context(), session.UserID)
 			if err != nil {
 				log.Printf("request %s failed to get user: %v\n", r.Header.Get("X-Request-ID"), err)
 			}
@@ -96,8 +210,15 @@ func (rh *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, url.String(), http.StatusMovedPermanently)
 		return
 	}
+	rh.routes.ServeHTTP(w, r)
+}
 
-	var handler http.Handler
+// route the request to its handler.
+func (rh *Router) route(w http.ResponseWriter, r *http.Request) {
+	var (
+		handler http.Handler
+		path    = r.URL.Path
+	)
 	switch route := dirRouter(path); {
 	case path == "/":
 		handler = rh.homepageHandler
@@ -105,14 +226,36 @@ func (rh *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		handler = rh.searchHandler
 	case strings.HasPrefix(path, "/p/"):
 		handler = rh.productHandler
-	case route.is("/account"):
+	case strings.HasPrefix(path, "/c/"):
+		handler = rh.categoryHandler
+	case route.is("/cart") || strings.HasPrefix(path, "/cart/"):
+		handler = rh.cartHandler
+	case route.is("/checkout"):
+		handler = rh.checkoutHandler
+	case route.is("/account/orders") || strings.HasPrefix(path, "/account/orders/"):
+		handler = rh.ordersHandler
+	case route.is("/account/tokens") || strings.HasPrefix(path, "/account/tokens/"):
+		handler = rh.tokensHandler
+	case route.is("/account/mfa") || strings.HasPrefix(path, "/account/mfa/"):
+		handler = rh.mfaHandler
+	case route.is("/account/passkeys") || strings.HasPrefix(path, "/account/passkeys/"):
+		handler = rh.passkeysHandler
+	case route.is("/account/sessions") || strings.HasPrefix(path, "/account/sessions/"):
+		handler = rh.sessionsHandler
+	case route.is("/account") || strings.HasPrefix(path, "/account/"):
 		handler = rh.accountHandler
-	case route.is("/admin"):
+	case route.is("/oauth/authorize"):
+		handler = rh.oauthHandler
+	case route.is("/admin") || strings.HasPrefix(path, "/admin/"):
 		handler = rh.adminHandler
-	case route.is("/login"):
+	case route.is("/login") || strings.HasPrefix(path, "/login/"):
 		handler = rh.loginHandler
 	case route.is("/logout"):
 		handler = rh.logoutHandler
+	case route.is("/signup") || strings.HasPrefix(path, "/signup/"):
+		handler = rh.signupHandler
+	case route.is("/recover") || strings.HasPrefix(path, "/recover/"):
+		handler = rh.recoverHandler
 	}
 	if handler == nil {
 		handler = rh.staticHandler
@@ -1,7 +1,13 @@
 package frontend
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/plifk/market/internal/services"
 )
 
 func TestDirRouter(t *testing.T) {
@@ -40,3 +46,28 @@ func TestDirRouter(t *testing.T) {
 		t.Errorf("unexpected r.within(%q) = false", route)
 	}
 }
+
+func TestFormError(t *testing.T) {
+	validation := &services.ValidationError{Err: errors.New("missing name")}
+	testCases := []struct {
+		err      error
+		known    []error
+		want     error
+		wantCode int
+	}{
+		{err: validation, want: validation, wantCode: http.StatusBadRequest},
+		{err: fmt.Errorf("cannot sign up: %w", validation), want: validation, wantCode: http.StatusBadRequest},
+		{err: services.ErrEmailTaken, known: []error{services.ErrEmailTaken}, want: services.ErrEmailTaken, wantCode: http.StatusBadRequest},
+		{err: services.ErrEmailTaken, want: errUnexpected, wantCode: http.StatusInternalServerError},
+		{err: errors.New("cannot queue email: connection refused"), known: []error{services.ErrEmailTaken}, want: errUnexpected, wantCode: http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		if got := formError(w, tc.err, tc.known...); got != tc.want {
+			t.Errorf("formError(%v) = %v, wanted %v instead", tc.err, got, tc.want)
+		}
+		if w.Code != tc.wantCode {
+			t.Errorf("formError(%v) status code = %d, wanted %d instead", tc.err, w.Code, tc.wantCode)
+		}
+	}
+}
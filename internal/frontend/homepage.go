@@ -1,7 +1,10 @@
 package frontend
 
 import (
+	"log"
 	"net/http"
+
+	"github.com/plifk/market/internal/services"
 )
 
 // HomepageHandler handles the / page.
@@ -10,15 +13,16 @@ type HomepageHandler struct {
 }
 
 func (h *HomepageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	products, err := h.Frontend.Modules.Catalog.ListProducts(r.Context(), services.ListProductsParams{
+		Limit: 16,
+	})
+	if err != nil {
+		log.Printf("cannot list products for the homepage: %v", err)
+	}
 	resp := &HTMLResponse{
 		Template: "homepage",
 		Title:    "homepage",
-		Breadcrumb: []Breadcrumb{
-			{Text: "Computers", Link: "/c/computers"},
-			{Text: "Displays", Link: "/c/computers-displays"},
-			{Text: "4K", Link: "/c/computers-displays-4k"},
-			{Text: "LG Ultrafine 24\" 4K", Active: true},
-		},
+		Content:  products,
 	}
 	h.Frontend.Respond(w, r, resp)
 }
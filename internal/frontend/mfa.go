@@ -0,0 +1,152 @@
+package frontend
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/plifk/market/internal/services"
+)
+
+// MFAHandler for the /account/mfa pages, where users manage their two-factor authentication.
+type MFAHandler struct {
+	Frontend *Frontend
+}
+
+// MFAContent to render the two-factor authentication page.
+type MFAContent struct {
+	// Enrollment is set while the user didn't enable two-factor authentication yet.
+	Enrollment *services.MFAEnrollment
+
+	Enabled bool
+
+	// Required is set when the user cannot disable two-factor authentication.
+	Required bool
+
+	// RecoveryCodes are only shown right after they are created, as they cannot be retrieved later.
+	RecoveryCodes []string
+
+	// Remaining recovery codes.
+	Remaining int
+
+	Error error
+}
+
+func (h *MFAHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user := services.UserFromRequest(r)
+	if user == nil {
+		http.Redirect(w, r, "/login?redirect_uri=/account/mfa", http.StatusSeeOther)
+		return
+	}
+	switch route := dirRouter(r.URL.Path); {
+	case route.is("/account/mfa") && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		h.view(w, r, user, MFAContent{})
+	case route.is("/account/mfa") && r.Method == http.MethodPost:
+		h.enable(w, r, user)
+	case route.is("/account/mfa/disable") && r.Method == http.MethodPost:
+		h.disable(w, r, user)
+	case route.is("/account/mfa/recovery-codes") && r.Method == http.MethodPost:
+		h.regenerate(w, r, user)
+	case route.is("/account/mfa"), route.is("/account/mfa/disable"), route.is("/account/mfa/recovery-codes"):
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+	default:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+	}
+}
+
+func (h *MFAHandler) view(w http.ResponseWriter, r *http.Request, user *services.User, content MFAContent) {
+	mfa := h.Frontend.Modules.MFA
+	enabled, err := mfa.Enabled(r.Context(), user.UserID)
+	if err != nil {
+		log.Printf("cannot check two-factor authentication: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	content.Enabled = enabled
+	content.Required = mfa.Required(user)
+	if enabled {
+		if content.Remaining, err = mfa.RemainingRecoveryCodes(r.Context(), user.UserID); err != nil {
+			log.Printf("cannot count recovery codes: %v", err)
+			h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+			return
+		}
+	} else if content.Enrollment, err = mfa.StartEnrollment(r.Context(), user); err != nil {
+		log.Printf("cannot start two-factor authentication enrollment: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	resp := &HTMLResponse{
+		Template:   "account-mfa",
+		Title:      "Two-factor authentication",
+		Breadcrumb: []Breadcrumb{{Text: "Your Account", Link: "/account"}, {Text: "Two-factor authentication", Active: true}},
+		Content:    content,
+	}
+	h.Frontend.Respond(w, r, resp)
+}
+
+func (h *MFAHandler) enable(w http.ResponseWriter, r *http.Request, user *services.User) {
+	codes, err := h.Frontend.Modules.MFA.ConfirmEnrollment(r.Context(), user.UserID, r.PostFormValue("code"))
+	switch {
+	case err == services.ErrInvalidMFACode:
+		h.view(w, r, user, MFAContent{Error: err})
+		return
+	case err == services.ErrMFAAlreadyEnabled:
+		http.Redirect(w, r, "/account/mfa", http.StatusSeeOther)
+		return
+	case err != nil:
+		log.Printf("cannot enable two-factor authentication: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	h.view(w, r, user, MFAContent{RecoveryCodes: codes})
+}
+
+// attempt of the user to enter a code, throttled like logging in, as a stolen session could otherwise guess it.
+func (h *MFAHandler) attempt(r *http.Request, user *services.User) services.LoginAttempt {
+	return services.LoginAttempt{IP: h.Frontend.Modules.Security.ClientIP(r), Email: user.Email}
+}
+
+func (h *MFAHandler) disable(w http.ResponseWriter, r *http.Request, user *services.User) {
+	attempt := h.attempt(r, user)
+	if err := h.Frontend.throttleLogin(w, r, attempt); err != nil {
+		h.view(w, r, user, MFAContent{Error: err})
+		return
+	}
+	switch err := h.Frontend.Modules.MFA.Disable(r.Context(), user, r.PostFormValue("code")); {
+	case err == services.ErrInvalidMFACode:
+		h.Frontend.failLogin(r, attempt)
+		h.view(w, r, user, MFAContent{Error: err})
+		return
+	case err == services.ErrMFARequired:
+		h.view(w, r, user, MFAContent{Error: err})
+		return
+	case err == services.ErrMFANotEnabled:
+	case err != nil:
+		log.Printf("cannot disable two-factor authentication: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/account/mfa", http.StatusSeeOther)
+}
+
+func (h *MFAHandler) regenerate(w http.ResponseWriter, r *http.Request, user *services.User) {
+	attempt := h.attempt(r, user)
+	if err := h.Frontend.throttleLogin(w, r, attempt); err != nil {
+		h.view(w, r, user, MFAContent{Error: err})
+		return
+	}
+	codes, err := h.Frontend.Modules.MFA.RegenerateRecoveryCodes(r.Context(), user.UserID, r.PostFormValue("code"))
+	switch {
+	case err == services.ErrInvalidMFACode:
+		h.Frontend.failLogin(r, attempt)
+		h.view(w, r, user, MFAContent{Error: err})
+		return
+	case err == services.ErrMFANotEnabled:
+		http.Redirect(w, r, "/account/mfa", http.StatusSeeOther)
+		return
+	case err != nil:
+		log.Printf("cannot create recovery codes: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	h.view(w, r, user, MFAContent{RecoveryCodes: codes})
+}
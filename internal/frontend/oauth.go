@@ -0,0 +1,108 @@
+package frontend
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/plifk/market/internal/services"
+)
+
+// OAuthHandler for the /oauth/authorize consent page, where users authorize OAuth clients to act on their behalf.
+type OAuthHandler struct {
+	Frontend *Frontend
+}
+
+// OAuthConsentContent to render the consent page.
+type OAuthConsentContent struct {
+	Client  *services.OAuthClient
+	Scopes  []services.TokenScope
+	Request services.AuthorizationRequest
+}
+
+func (h *OAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPost {
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	user := services.UserFromRequest(r)
+	if user == nil {
+		// The authorization request is carried on the query string, so the user comes back to it after logging in.
+		http.Redirect(w, r, "/login?"+url.Values{"redirect_uri": {r.URL.RequestURI()}}.Encode(), http.StatusSeeOther)
+		return
+	}
+	// Parameters come from the query string on GET, and from the consent form on POST.
+	if err := r.ParseForm(); err != nil {
+		h.Frontend.HTTPError(w, r, http.StatusBadRequest)
+		return
+	}
+	req := services.AuthorizationRequest{
+		ResponseType:        r.Form.Get("response_type"),
+		ClientID:            r.Form.Get("client_id"),
+		RedirectURI:         r.Form.Get("redirect_uri"),
+		Scope:               r.Form.Get("scope"),
+		State:               r.Form.Get("state"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+	}
+	oauth := h.Frontend.Modules.OAuth
+	client, scopes, err := oauth.ValidateAuthorizationRequest(r.Context(), &req)
+	var oerr *services.OAuthError
+	switch {
+	case err == services.ErrOAuthClientNotFound, err == services.ErrOAuthRedirectURIMismatch:
+		// The user must not be redirected to a URI the client didn't register.
+		h.Frontend.HTTPError(w, r, http.StatusBadRequest, err)
+		return
+	case errors.As(err, &oerr):
+		h.redirect(w, r, req, url.Values{"error": {oerr.Code}, "error_description": {oerr.Description}})
+		return
+	case err != nil:
+		log.Printf("cannot validate OAuth authorization request: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		resp := &HTMLResponse{
+			Template: "oauth-consent",
+			Title:    "Authorize " + client.Name,
+			Content: OAuthConsentContent{
+				Client:  client,
+				Scopes:  scopes,
+				Request: req,
+			},
+		}
+		h.Frontend.Respond(w, r, resp)
+		return
+	}
+	if r.PostForm.Get("decision") != "approve" {
+		h.redirect(w, r, req, url.Values{"error": {"access_denied"}})
+		return
+	}
+	code, err := oauth.Authorize(r.Context(), user.UserID, req)
+	if err != nil {
+		log.Printf("cannot authorize OAuth client %q: %v", client.ClientID, err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	h.redirect(w, r, req, url.Values{"code": {code}})
+}
+
+// redirect back to the client with the result of the authorization request and its state.
+func (h *OAuthHandler) redirect(w http.ResponseWriter, r *http.Request, req services.AuthorizationRequest, params url.Values) {
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		h.Frontend.HTTPError(w, r, http.StatusBadRequest)
+		return
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+}
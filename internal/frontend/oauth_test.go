@@ -0,0 +1,37 @@
+package frontend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/plifk/market/internal/services"
+)
+
+func TestOAuthHandlerRedirectsToLogin(t *testing.T) {
+	h := &OAuthHandler{Frontend: &Frontend{Modules: &services.Modules{}}}
+	r := httptest.NewRequest(http.MethodGet, "https://www.example.com/oauth/authorize?client_id=c1&scope=profile+orders", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("wanted status code %d, got %d instead", http.StatusSeeOther, w.Code)
+	}
+	const want = "/login?redirect_uri=%2Foauth%2Fauthorize%3Fclient_id%3Dc1%26scope%3Dprofile%2Borders"
+	if got := w.Header().Get("Location"); got != want {
+		t.Errorf("wanted redirect to %q, got %q instead", want, got)
+	}
+}
+
+func TestOAuthHandlerRedirect(t *testing.T) {
+	h := &OAuthHandler{Frontend: &Frontend{Modules: &services.Modules{}}}
+	r := httptest.NewRequest(http.MethodPost, "https://www.example.com/oauth/authorize", nil)
+	w := httptest.NewRecorder()
+	h.redirect(w, r, services.AuthorizationRequest{
+		RedirectURI: "https://partner.example.com/callback?tenant=1",
+		State:       "xyz",
+	}, map[string][]string{"code": {"abc"}})
+	const want = "https://partner.example.com/callback?code=abc&state=xyz&tenant=1"
+	if got := w.Header().Get("Location"); got != want {
+		t.Errorf("wanted redirect to %q, got %q instead", want, got)
+	}
+}
@@ -0,0 +1,200 @@
+package frontend
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/plifk/market/internal/services"
+)
+
+// CheckoutHandler for the /checkout page.
+type CheckoutHandler struct {
+	Frontend *Frontend
+}
+
+// CheckoutContent to render the checkout page.
+type CheckoutContent struct {
+	Cart  *services.Cart
+	Error error
+}
+
+func (h *CheckoutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user := services.UserFromRequest(r)
+	if user == nil {
+		http.Redirect(w, r, "/login?redirect_uri=/checkout", http.StatusSeeOther)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.view(w, r, user, nil)
+	case http.MethodPost:
+		h.checkout(w, r, user)
+	default:
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *CheckoutHandler) view(w http.ResponseWriter, r *http.Request, user *services.User, err error) {
+	cart, cerr := h.Frontend.Modules.Carts.Get(r.Context(), services.CartOwner{UserID: user.UserID})
+	if cerr != nil {
+		log.Printf("cannot get cart for checkout: %v", cerr)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	if len(cart.Items) == 0 {
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+	resp := &HTMLResponse{
+		Template:   "checkout",
+		Title:      "Checkout",
+		Breadcrumb: []Breadcrumb{{Text: "Shopping cart", Link: "/cart"}, {Text: "Checkout", Active: true}},
+		Content: CheckoutContent{
+			Cart:  cart,
+			Error: err,
+		},
+	}
+	h.Frontend.Respond(w, r, resp)
+}
+
+func (h *CheckoutHandler) checkout(w http.ResponseWriter, r *http.Request, user *services.User) {
+	orders := h.Frontend.Modules.Orders
+	order, err := orders.Checkout(r.Context(), user.UserID)
+	switch {
+	case err == services.ErrEmptyCart:
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	case err == services.ErrCartItemUnavailable, err == services.ErrOutOfStock:
+		h.view(w, r, user, err)
+		return
+	case err != nil:
+		log.Printf("cannot checkout: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	// The order is placed even if the payment fails, so that it can be paid again from the order page.
+	if err := orders.Pay(r.Context(), order.OrderID, r.PostFormValue("payment_token")); err != nil {
+		log.Printf("cannot pay order %q: %v", order.OrderID, err)
+	}
+	http.Redirect(w, r, "/account/orders/"+order.OrderID, http.StatusSeeOther)
+}
+
+// OrdersHandler for the /account/orders pages.
+type OrdersHandler struct {
+	Frontend *Frontend
+}
+
+// OrderContent to render an order page.
+type OrderContent struct {
+	Order *services.Order
+	Error error
+}
+
+func (h *OrdersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user := services.UserFromRequest(r)
+	if user == nil {
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+		return
+	}
+	if route := dirRouter(r.URL.Path); route.is("/account/orders") {
+		h.list(w, r, user)
+		return
+	}
+
+	// Handles /account/orders/:id, /account/orders/:id/pay, and /account/orders/:id/cancel.
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/account/orders/"), "/"), "/")
+	if len(parts) > 2 {
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+		return
+	}
+	order, err := h.Frontend.Modules.Orders.GetOrder(r.Context(), parts[0])
+	switch {
+	case err == services.ErrOrderNotFound, err == nil && order.UserID != user.UserID:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("cannot get order %q: %v", parts[0], err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+			return
+		}
+		h.view(w, r, order, nil)
+		return
+	}
+	if r.Method != http.MethodPost {
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	orders := h.Frontend.Modules.Orders
+	var failure error
+	switch parts[1] {
+	case "pay":
+		err = orders.Pay(r.Context(), order.OrderID, r.PostFormValue("payment_token"))
+		failure = errOrderPayment
+	case "cancel":
+		err = orders.Cancel(r.Context(), order.OrderID)
+		failure = errOrderCancel
+	default:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("cannot %s order %q: %v", parts[1], order.OrderID, err)
+		if order, err = orders.GetOrder(r.Context(), order.OrderID); err != nil {
+			log.Printf("cannot get order %q: %v", parts[0], err)
+			h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+			return
+		}
+		h.view(w, r, order, failure)
+		return
+	}
+	http.Redirect(w, r, "/account/orders/"+order.OrderID, http.StatusSeeOther)
+}
+
+func (h *OrdersHandler) list(w http.ResponseWriter, r *http.Request, user *services.User) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	orders, err := h.Frontend.Modules.Orders.ListOrders(r.Context(), user.UserID)
+	if err != nil {
+		log.Printf("cannot list orders: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	resp := &HTMLResponse{
+		Template:   "orders",
+		Title:      "Your orders",
+		Breadcrumb: []Breadcrumb{{Text: "Your Account", Link: "/account"}, {Text: "Your orders", Active: true}},
+		Content:    orders,
+	}
+	h.Frontend.Respond(w, r, resp)
+}
+
+func (h *OrdersHandler) view(w http.ResponseWriter, r *http.Request, order *services.Order, err error) {
+	resp := &HTMLResponse{
+		Template: "order",
+		Title:    "Order " + order.OrderID,
+		Breadcrumb: []Breadcrumb{
+			{Text: "Your Account", Link: "/account"},
+			{Text: "Your orders", Link: "/account/orders"},
+			{Text: order.OrderID, Active: true},
+		},
+		Content: OrderContent{
+			Order: order,
+			Error: err,
+		},
+	}
+	h.Frontend.Respond(w, r, resp)
+}
+
+var (
+	errOrderPayment = errors.New("your payment could not be processed, please try again")
+	errOrderCancel  = errors.New("your order could not be cancelled")
+)
@@ -0,0 +1,72 @@
+package frontend
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// Pagination links for a list of results.
+type Pagination struct {
+	Previous string
+	Next     string
+	Pages    []PaginationPage
+}
+
+// PaginationPage is a page link. Ellipsis is used to represent a gap between pages.
+type PaginationPage struct {
+	Number   int
+	Link     string
+	Current  bool
+	Ellipsis bool
+}
+
+// newPagination creates pagination links using the "page" query parameter of the given URL.
+// It returns nil if all results fit in a single page.
+func newPagination(u *url.URL, page, perPage, total int) *Pagination {
+	if perPage <= 0 || total <= perPage {
+		return nil
+	}
+	last := (total + perPage - 1) / perPage
+	link := func(n int) string {
+		l := *u
+		q := l.Query()
+		if n == 1 {
+			q.Del("page")
+		} else {
+			q.Set("page", strconv.Itoa(n))
+		}
+		l.RawQuery = q.Encode()
+		return l.RequestURI()
+	}
+
+	var p Pagination
+	if page > 1 {
+		p.Previous = link(page - 1)
+	}
+	if page < last {
+		p.Next = link(page + 1)
+	}
+	// Show the first, the last, and the pages around the current one.
+	for n := 1; n <= last; n++ {
+		switch {
+		case n == 1, n == last, n >= page-1 && n <= page+1:
+			p.Pages = append(p.Pages, PaginationPage{
+				Number:  n,
+				Link:    link(n),
+				Current: n == page,
+			})
+		case len(p.Pages) != 0 && !p.Pages[len(p.Pages)-1].Ellipsis:
+			p.Pages = append(p.Pages, PaginationPage{Ellipsis: true})
+		}
+	}
+	return &p
+}
+
+// pageFromQuery returns the page number from the "page" query parameter.
+func pageFromQuery(q url.Values) int {
+	page, err := strconv.Atoi(q.Get("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+	return page
+}
@@ -0,0 +1,82 @@
+package frontend
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestNewPagination(t *testing.T) {
+	u, err := url.Parse("/s?q=lg+4k&page=46")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := newPagination(u, 46, 16, 1376)
+	want := &Pagination{
+		Previous: "/s?page=45&q=lg+4k",
+		Next:     "/s?page=47&q=lg+4k",
+		Pages: []PaginationPage{
+			{Number: 1, Link: "/s?q=lg+4k"},
+			{Ellipsis: true},
+			{Number: 45, Link: "/s?page=45&q=lg+4k"},
+			{Number: 46, Link: "/s?page=46&q=lg+4k", Current: true},
+			{Number: 47, Link: "/s?page=47&q=lg+4k"},
+			{Ellipsis: true},
+			{Number: 86, Link: "/s?page=86&q=lg+4k"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("newPagination() = %+v, wanted %+v", got, want)
+	}
+}
+
+func TestNewPaginationFirstPage(t *testing.T) {
+	u, err := url.Parse("/s?q=display")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := newPagination(u, 1, 10, 25)
+	want := &Pagination{
+		Next: "/s?page=2&q=display",
+		Pages: []PaginationPage{
+			{Number: 1, Link: "/s?q=display", Current: true},
+			{Number: 2, Link: "/s?page=2&q=display"},
+			{Number: 3, Link: "/s?page=3&q=display"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("newPagination() = %+v, wanted %+v", got, want)
+	}
+}
+
+func TestNewPaginationSinglePage(t *testing.T) {
+	u, err := url.Parse("/s?q=display")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := newPagination(u, 1, 16, 16); got != nil {
+		t.Errorf("newPagination() = %+v, wanted nil", got)
+	}
+}
+
+func TestPageFromQuery(t *testing.T) {
+	testCases := []struct {
+		query string
+		want  int
+	}{
+		{query: "", want: 1},
+		{query: "page=3", want: 3},
+		{query: "page=0", want: 1},
+		{query: "page=-2", want: 1},
+		{query: "page=abc", want: 1},
+	}
+	for _, tc := range testCases {
+		q, err := url.ParseQuery(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pageFromQuery(q); got != tc.want {
+			t.Errorf("pageFromQuery(%q) = %d, wanted %d", tc.query, got, tc.want)
+		}
+	}
+}
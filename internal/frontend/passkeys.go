@@ -0,0 +1,132 @@
+package frontend
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/plifk/market/internal/services"
+	"github.com/plifk/market/internal/webauthn"
+)
+
+// PasskeysHandler for the /account/passkeys pages, where users manage the passkeys they log in with.
+type PasskeysHandler struct {
+	Frontend *Frontend
+}
+
+// PasskeysContent to render the passkeys page.
+type PasskeysContent struct {
+	Passkeys []services.Passkey
+
+	// Added is set right after a passkey is registered.
+	Added bool
+
+	Error error
+}
+
+func (h *PasskeysHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user := services.UserFromRequest(r)
+	if user == nil {
+		http.Redirect(w, r, "/login?redirect_uri=/account/passkeys", http.StatusSeeOther)
+		return
+	}
+	switch route := dirRouter(r.URL.Path); {
+	case route.is("/account/passkeys") && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		h.view(w, r, user, PasskeysContent{})
+	case route.is("/account/passkeys") && r.Method == http.MethodPost:
+		h.register(w, r, user)
+	case route.is("/account/passkeys/options") && r.Method == http.MethodPost:
+		h.options(w, r, user)
+	case route.is("/account/passkeys/delete") && r.Method == http.MethodPost:
+		h.delete(w, r, user)
+	case route.is("/account/passkeys"), route.is("/account/passkeys/options"), route.is("/account/passkeys/delete"):
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+	default:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+	}
+}
+
+func (h *PasskeysHandler) view(w http.ResponseWriter, r *http.Request, user *services.User, content PasskeysContent) {
+	passkeys, err := h.Frontend.Modules.Passkeys.List(r.Context(), user.UserID)
+	if err != nil {
+		log.Printf("cannot list passkeys: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	content.Passkeys = passkeys
+	resp := &HTMLResponse{
+		Template:   "account-passkeys",
+		Title:      "Passkeys",
+		Breadcrumb: []Breadcrumb{{Text: "Your Account", Link: "/account"}, {Text: "Passkeys", Active: true}},
+		Content:    content,
+	}
+	h.Frontend.Respond(w, r, resp)
+}
+
+// options starts registering a passkey, returning the WebAuthn options for the browser.
+func (h *PasskeysHandler) options(w http.ResponseWriter, r *http.Request, user *services.User) {
+	options, err := h.Frontend.Modules.Passkeys.BeginRegistration(r.Context(), user)
+	if err != nil {
+		log.Printf("cannot begin passkey registration: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, options)
+}
+
+var errPasskeyRegistration = errors.New("cannot add this passkey")
+
+// register the passkey the browser posted as JSON on the credential field.
+func (h *PasskeysHandler) register(w http.ResponseWriter, r *http.Request, user *services.User) {
+	var attestation webauthn.AttestationResponse
+	if err := json.Unmarshal([]byte(r.PostFormValue("credential")), &attestation); err != nil {
+		h.view(w, r, user, PasskeysContent{Error: errPasskeyRegistration})
+		return
+	}
+	// Wrong passwords count as failed logins, as they could be used to guess the password of a stolen session.
+	attempt := services.LoginAttempt{IP: h.Frontend.Modules.Security.ClientIP(r), Email: user.Email}
+	if err := h.Frontend.throttleLogin(w, r, attempt); err != nil {
+		h.view(w, r, user, PasskeysContent{Error: err})
+		return
+	}
+	_, err := h.Frontend.Modules.Passkeys.FinishRegistration(r.Context(), user, r.PostFormValue("name"), r.PostFormValue("password"), &attestation)
+	switch {
+	case err == services.ErrWrongPassword:
+		h.Frontend.failLogin(r, attempt)
+		w.WriteHeader(http.StatusBadRequest)
+		h.view(w, r, user, PasskeysContent{Error: err})
+		return
+	case errors.Is(err, services.ErrInvalidPasskey):
+		log.Printf("cannot register passkey: %v", err)
+		h.view(w, r, user, PasskeysContent{Error: errPasskeyRegistration})
+		return
+	case err != nil:
+		log.Printf("cannot register passkey: %v", err)
+		h.view(w, r, user, PasskeysContent{Error: formError(w, err, services.ErrPasskeysUnavailable)})
+		return
+	}
+	h.view(w, r, user, PasskeysContent{Added: true})
+}
+
+func (h *PasskeysHandler) delete(w http.ResponseWriter, r *http.Request, user *services.User) {
+	switch err := h.Frontend.Modules.Passkeys.Delete(r.Context(), user.UserID, r.PostFormValue("passkey_id")); {
+	case err == services.ErrPasskeyNotFound:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("cannot delete passkey: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/account/passkeys", http.StatusSeeOther)
+}
+
+// respondJSON for the scripts of the pages, such as the WebAuthn options.
+func respondJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("cannot encode JSON response: %v", err)
+	}
+}
@@ -1,7 +1,11 @@
 package frontend
 
 import (
+	"log"
 	"net/http"
+	"strings"
+
+	"github.com/plifk/market/internal/services"
 )
 
 // ProductHandler for the application.
@@ -9,10 +13,76 @@ type ProductHandler struct {
 	Frontend *Frontend
 }
 
+// ProductContent to render a product page.
+type ProductContent struct {
+	*services.Product
+
+	// Variant selected with the variant query parameter, or the default variant of the product.
+	Variant *services.Variant
+
+	// Selectors to choose another variant.
+	Selectors []services.OptionSelector
+
+	// Gallery of images of the selected variant, or of the product if the variant has no images.
+	Gallery []string
+}
+
+func newProductContent(product *services.Product, variantID string) ProductContent {
+	c := ProductContent{
+		Product: product,
+		Variant: product.Variant(variantID),
+		Gallery: product.Images,
+	}
+	if c.Variant == nil {
+		c.Variant = product.DefaultVariant()
+	}
+	c.Selectors = product.OptionSelectors(c.Variant)
+	if c.Variant != nil && len(c.Variant.Images) != 0 {
+		c.Gallery = c.Variant.Images
+	}
+	return c
+}
+
 func (h *ProductHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	productID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/p/"), "/")
+	if productID == "" || strings.Contains(productID, "/") {
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+		return
+	}
+
+	modules := h.Frontend.Modules
+	product, err := modules.Catalog.GetProduct(r.Context(), productID)
+	switch {
+	case err == services.ErrProductNotFound:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("cannot get product %q: %v", productID, err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	case product.Status == services.ProductArchived:
+		h.Frontend.HTTPError(w, r, http.StatusGone)
+		return
+	}
+
+	var breadcrumb []Breadcrumb
+	if product.CategoryID != "" {
+		if breadcrumb, err = h.Frontend.categoryBreadcrumb(r, product.CategoryID); err != nil {
+			log.Printf("cannot get breadcrumb for product %q: %v", productID, err)
+		}
+	}
+	breadcrumb = append(breadcrumb, Breadcrumb{Text: product.Name, Active: true})
+
 	resp := &HTMLResponse{
-		Template: "product",
-		Title:    "Product",
+		Template:      "product",
+		Title:         product.Name,
+		CanonicalLink: "/p/" + product.ProductID,
+		Breadcrumb:    breadcrumb,
+		Content:       newProductContent(product, r.URL.Query().Get("variant")),
 	}
 	h.Frontend.Respond(w, r, resp)
 }
@@ -0,0 +1,128 @@
+package frontend
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/plifk/market/internal/services"
+)
+
+// RecoverHandler for the /recover pages, where users reset a forgotten password.
+type RecoverHandler struct {
+	Frontend *Frontend
+}
+
+// RecoverContent to render the forgot password page.
+type RecoverContent struct {
+	Email string
+
+	// Sent is set after the password reset link is requested.
+	Sent bool
+
+	Error error
+}
+
+// ResetPasswordContent to render the reset password page.
+type ResetPasswordContent struct {
+	Token string
+
+	// Done is set after the password is changed.
+	Done bool
+
+	// InvalidToken is set when the link is invalid, was used, or expired.
+	InvalidToken bool
+
+	Error error
+}
+
+func (h *RecoverHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch route := dirRouter(r.URL.Path); {
+	case route.is("/recover") && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		h.recoverPage(w, r, RecoverContent{})
+	case route.is("/recover") && r.Method == http.MethodPost:
+		h.request(w, r)
+	case route.is("/recover/reset") && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		h.resetForm(w, r)
+	case route.is("/recover/reset") && r.Method == http.MethodPost:
+		h.reset(w, r)
+	case route.is("/recover"), route.is("/recover/reset"):
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+	default:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+	}
+}
+
+func (h *RecoverHandler) recoverPage(w http.ResponseWriter, r *http.Request, content RecoverContent) {
+	resp := &HTMLResponse{
+		Template: "account-recover",
+		Title:    "Forgot your password?",
+		Content:  content,
+	}
+	h.Frontend.Respond(w, r, resp)
+}
+
+func (h *RecoverHandler) request(w http.ResponseWriter, r *http.Request) {
+	content := RecoverContent{Email: r.PostFormValue("email")}
+	if err := h.Frontend.Modules.Accounts.RequestPasswordReset(r.Context(), content.Email); err != nil {
+		log.Printf("cannot request password reset: %v", err)
+		content.Error = formError(w, err)
+		h.recoverPage(w, r, content)
+		return
+	}
+	content.Sent = true
+	h.recoverPage(w, r, content)
+}
+
+func (h *RecoverHandler) resetPage(w http.ResponseWriter, r *http.Request, content ResetPasswordContent) {
+	if content.InvalidToken {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	resp := &HTMLResponse{
+		Template: "account-reset-password",
+		Title:    "Reset your password",
+		Content:  content,
+	}
+	h.Frontend.Respond(w, r, resp)
+}
+
+func (h *RecoverHandler) resetForm(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	switch err := h.Frontend.Modules.Accounts.CheckPasswordResetToken(r.Context(), token); {
+	case err == services.ErrInvalidPasswordResetToken:
+		h.resetPage(w, r, ResetPasswordContent{InvalidToken: true})
+	case err != nil:
+		log.Printf("cannot check password reset token: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+	default:
+		h.resetPage(w, r, ResetPasswordContent{Token: token})
+	}
+}
+
+var errPasswordsDontMatch = errors.New("passwords don't match")
+
+func (h *RecoverHandler) reset(w http.ResponseWriter, r *http.Request) {
+	token := r.PostFormValue("token")
+	password := r.PostFormValue("password")
+	if password != r.PostFormValue("password_confirmation") {
+		h.resetPage(w, r, ResetPasswordContent{Token: token, Error: errPasswordsDontMatch})
+		return
+	}
+	params := services.ResetPasswordParams{
+		Token:    token,
+		Password: password,
+	}
+	if session := services.SessionFromRequest(r); session != nil {
+		params.StickyID = session.StickyID
+	}
+	switch _, err := h.Frontend.Modules.Accounts.ResetPassword(r.Context(), params); {
+	case err == services.ErrInvalidPasswordResetToken:
+		h.resetPage(w, r, ResetPasswordContent{InvalidToken: true})
+		return
+	case err != nil:
+		log.Printf("cannot reset password: %v", err)
+		h.resetPage(w, r, ResetPasswordContent{Token: token, Error: formError(w, err)})
+		return
+	}
+	h.resetPage(w, r, ResetPasswordContent{Done: true})
+}
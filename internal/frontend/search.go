@@ -1,7 +1,12 @@
 package frontend
 
 import (
+	"log"
 	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/plifk/market/internal/services"
 )
 
 // SearchHandler for the application.
@@ -9,10 +14,78 @@ type SearchHandler struct {
 	Frontend *Frontend
 }
 
+// SearchContent to render the search results page.
+type SearchContent struct {
+	Query      string
+	Results    *services.SearchResults
+	First      int
+	Last       int
+	Pagination *Pagination
+	Filters    *SearchFilterView
+}
+
 func (h *SearchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	query := truncateQuery(strings.TrimSpace(q.Get("q")), 250)
+
+	filters := parseSearchFilters(q)
+	modules := h.Frontend.Modules
+	results, err := modules.Search.Query(r.Context(), services.SearchParams{
+		Query:   query,
+		Page:    pageFromQuery(q),
+		Filters: filters,
+	})
+	switch {
+	case err == services.ErrSearchPageOutOfRange:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("cannot search for %q: %v", query, err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	content := SearchContent{
+		Query:      query,
+		Results:    results,
+		Pagination: newPagination(r.URL, results.Page, results.PerPage, searchPagesTotal(results.Total)),
+		Filters:    newSearchFilterView(r.URL, filters, results.Facets),
+	}
+	if len(results.Hits) != 0 {
+		content.First = (results.Page-1)*results.PerPage + 1
+		content.Last = content.First + len(results.Hits) - 1
+	}
+	title := "Search"
+	if query != "" {
+		title = query + " - Search"
+	}
 	resp := &HTMLResponse{
 		Template: "search",
-		Title:    "Search",
+		Title:    title,
+		Content:  content,
 	}
 	h.Frontend.Respond(w, r, resp)
 }
+
+// truncateQuery to at most n bytes, without cutting a multi-byte character in half.
+func truncateQuery(query string, n int) string {
+	if len(query) <= n {
+		return query
+	}
+	for n > 0 && !utf8.RuneStart(query[n]) {
+		n--
+	}
+	return query[:n]
+}
+
+// searchPagesTotal caps the total of results to link pages to, as pages past the search window cannot be read.
+func searchPagesTotal(total int) int {
+	if total > services.MaxSearchWindow {
+		return services.MaxSearchWindow
+	}
+	return total
+}
@@ -0,0 +1,272 @@
+package frontend
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/plifk/market/internal/services"
+)
+
+// Query parameters used to filter search results.
+// Prices are in the major unit of the currency, so that links are easy to read and share.
+const (
+	brandParam           = "brand"
+	priceMinParam        = "price_min"
+	priceMaxParam        = "price_max"
+	attributeParamPrefix = "attr."
+)
+
+// maxFilterValues is the maximum number of values accepted for a given filter.
+const maxFilterValues = 20
+
+// maxFilterAttributes is the maximum number of attributes filtered by, as each one adds clauses to the search query.
+const maxFilterAttributes = 10
+
+// parseSearchFilters from the URL query parameters.
+func parseSearchFilters(q url.Values) services.SearchFilters {
+	var f services.SearchFilters
+	f.Brands = filterValues(q[brandParam])
+	f.PriceMin = parsePriceParam(q.Get(priceMinParam))
+	f.PriceMax = parsePriceParam(q.Get(priceMaxParam))
+	// Keys are sorted, so that the same attributes are kept when there are too many.
+	keys := make([]string, 0, len(q))
+	for key := range q {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, attributeParamPrefix)
+		if name == key || name == "" {
+			continue
+		}
+		if len(f.Attributes) == maxFilterAttributes {
+			break
+		}
+		if values := filterValues(q[key]); len(values) != 0 {
+			if f.Attributes == nil {
+				f.Attributes = map[string][]string{}
+			}
+			f.Attributes[name] = values
+		}
+	}
+	return f
+}
+
+func filterValues(values []string) []string {
+	var filtered []string
+	for _, v := range values {
+		if v != "" && len(filtered) < maxFilterValues {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered
+}
+
+// parsePriceParam converts a price in the major unit of the currency to its minor unit.
+func parsePriceParam(s string) int64 {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v <= 0 || v > 1e12 {
+		return 0
+	}
+	return v * 100
+}
+
+// encodeSearchFilters as URL query parameters.
+func encodeSearchFilters(f services.SearchFilters, q url.Values) {
+	q.Del(brandParam)
+	q.Del(priceMinParam)
+	q.Del(priceMaxParam)
+	for key := range q {
+		if strings.HasPrefix(key, attributeParamPrefix) {
+			q.Del(key)
+		}
+	}
+	for _, b := range f.Brands {
+		q.Add(brandParam, b)
+	}
+	if f.PriceMin != 0 {
+		q.Set(priceMinParam, strconv.FormatInt(f.PriceMin/100, 10))
+	}
+	if f.PriceMax != 0 {
+		q.Set(priceMaxParam, strconv.FormatInt(f.PriceMax/100, 10))
+	}
+	for name, values := range f.Attributes {
+		for _, v := range values {
+			q.Add(attributeParamPrefix+name, v)
+		}
+	}
+}
+
+// FilterOption to render on the search filters.
+type FilterOption struct {
+	Text     string
+	Count    int
+	Selected bool
+	Link     string
+}
+
+// AttributeFilter lists the options for a given product attribute.
+type AttributeFilter struct {
+	Name    string
+	Options []FilterOption
+}
+
+// SearchFilterView to render the search filters.
+type SearchFilterView struct {
+	Brands     []FilterOption
+	Prices     []FilterOption
+	Attributes []AttributeFilter
+
+	// ClearLink removes all filters, if any filter is selected.
+	ClearLink string
+}
+
+// newSearchFilterView creates the links to select or unselect each facet value, using the current URL.
+func newSearchFilterView(u *url.URL, f services.SearchFilters, facets services.SearchFacets) *SearchFilterView {
+	var v SearchFilterView
+	link := func(fn func(f *services.SearchFilters)) string {
+		c := f
+		c.Brands = append([]string{}, f.Brands...)
+		c.Attributes = map[string][]string{}
+		for name, values := range f.Attributes {
+			c.Attributes[name] = append([]string{}, values...)
+		}
+		fn(&c)
+		l := *u
+		q := l.Query()
+		q.Del("page") // Filtering changes the number of results, so we go back to the first page.
+		encodeSearchFilters(c, q)
+		l.RawQuery = q.Encode()
+		return l.RequestURI()
+	}
+
+	for _, b := range mergeFacetValues(facets.Brands, f.Brands) {
+		value, selected := b.Value, contains(f.Brands, b.Value)
+		v.Brands = append(v.Brands, FilterOption{
+			Text:     value,
+			Count:    b.Count,
+			Selected: selected,
+			Link: link(func(f *services.SearchFilters) {
+				f.Brands = toggle(f.Brands, value)
+			}),
+		})
+	}
+
+	for _, p := range facets.Prices {
+		min, max := p.Min, p.Max
+		selected := f.PriceMin == min && f.PriceMax == max
+		v.Prices = append(v.Prices, FilterOption{
+			Text:     priceRangeText(min, max),
+			Count:    p.Count,
+			Selected: selected,
+			Link: link(func(f *services.SearchFilters) {
+				f.PriceMin, f.PriceMax = min, max
+				if selected {
+					f.PriceMin, f.PriceMax = 0, 0
+				}
+			}),
+		})
+	}
+
+	for _, a := range mergeAttributeFacets(facets.Attributes, f.Attributes) {
+		name := a.Name
+		af := AttributeFilter{Name: name}
+		for _, av := range mergeFacetValues(a.Values, f.Attributes[name]) {
+			value := av.Value
+			af.Options = append(af.Options, FilterOption{
+				Text:     value,
+				Count:    av.Count,
+				Selected: contains(f.Attributes[name], value),
+				Link: link(func(f *services.SearchFilters) {
+					f.Attributes[name] = toggle(f.Attributes[name], value)
+				}),
+			})
+		}
+		v.Attributes = append(v.Attributes, af)
+	}
+
+	if len(f.Brands) != 0 || f.PriceMin != 0 || f.PriceMax != 0 || len(f.Attributes) != 0 {
+		v.ClearLink = link(func(f *services.SearchFilters) {
+			*f = services.SearchFilters{}
+		})
+	}
+	return &v
+}
+
+// mergeFacetValues adds selected values that are missing from the facet values, so that they can be unselected.
+func mergeFacetValues(values []services.FacetValue, selected []string) []services.FacetValue {
+	merged := append([]services.FacetValue{}, values...)
+	for _, s := range selected {
+		found := false
+		for _, v := range values {
+			if v.Value == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, services.FacetValue{Value: s})
+		}
+	}
+	return merged
+}
+
+// mergeAttributeFacets adds selected attributes that are missing from the facets, so that they can be unselected.
+func mergeAttributeFacets(facets []services.AttributeFacet, selected map[string][]string) []services.AttributeFacet {
+	merged := append([]services.AttributeFacet{}, facets...)
+	var missing []string
+	for name := range selected {
+		found := false
+		for _, a := range facets {
+			if a.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		merged = append(merged, services.AttributeFacet{Name: name})
+	}
+	return merged
+}
+
+func priceRangeText(min, max int64) string {
+	switch {
+	case min == 0:
+		return fmt.Sprintf("Under %d", max/100)
+	case max == 0:
+		return fmt.Sprintf("%d & above", min/100)
+	default:
+		return fmt.Sprintf("%d to %d", min/100, max/100)
+	}
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// toggle a value on a list.
+func toggle(values []string, s string) []string {
+	var toggled []string
+	for _, v := range values {
+		if v != s {
+			toggled = append(toggled, v)
+		}
+	}
+	if len(toggled) == len(values) {
+		toggled = append(toggled, s)
+	}
+	return toggled
+}
@@ -0,0 +1,110 @@
+package frontend
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/plifk/market/internal/services"
+)
+
+func TestSearchFiltersRoundTrip(t *testing.T) {
+	q, err := url.ParseQuery("q=4k&brand=LG&brand=Dell&price_min=100&price_max=500&attr.Screen+size=24+inches&attr.Screen+size=27+inches&attr.Refresh+rate=60+Hz&page=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := parseSearchFilters(q)
+	want := services.SearchFilters{
+		Brands:   []string{"LG", "Dell"},
+		PriceMin: 10000,
+		PriceMax: 50000,
+		Attributes: map[string][]string{
+			"Screen size":  {"24 inches", "27 inches"},
+			"Refresh rate": {"60 Hz"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSearchFilters() = %+v, wanted %+v", got, want)
+	}
+
+	encoded := url.Values{"q": {"4k"}}
+	encodeSearchFilters(got, encoded)
+	if again := parseSearchFilters(encoded); !reflect.DeepEqual(again, want) {
+		t.Errorf("parseSearchFilters(encodeSearchFilters()) = %+v, wanted %+v", again, want)
+	}
+	if encoded.Get("q") != "4k" {
+		t.Errorf("expected query to be preserved, got %q", encoded.Get("q"))
+	}
+}
+
+func TestParseSearchFiltersInvalid(t *testing.T) {
+	q, err := url.ParseQuery("brand=&price_min=abc&price_max=-5&attr.=x&attr.Color=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := parseSearchFilters(q); !reflect.DeepEqual(got, services.SearchFilters{}) {
+		t.Errorf("parseSearchFilters() = %+v, wanted no filters", got)
+	}
+}
+
+func TestParseSearchFiltersTooManyAttributes(t *testing.T) {
+	q := url.Values{}
+	for i := 0; i < 100; i++ {
+		q.Set(fmt.Sprintf("attr.A%03d", i), "x")
+	}
+	got := parseSearchFilters(q)
+	if len(got.Attributes) != maxFilterAttributes {
+		t.Fatalf("wanted %d attributes, got %d", maxFilterAttributes, len(got.Attributes))
+	}
+	if _, ok := got.Attributes["A000"]; !ok {
+		t.Errorf("wanted the first attributes to be kept, got %v", got.Attributes)
+	}
+}
+
+func TestNewSearchFilterView(t *testing.T) {
+	u, err := url.Parse("/s?q=4k&brand=LG&page=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters := parseSearchFilters(u.Query())
+	facets := services.SearchFacets{
+		Brands: []services.FacetValue{{Value: "Dell", Count: 7}},
+		Prices: []services.PriceRangeFacet{{Max: 5000, Count: 2}, {Min: 250000, Count: 1}},
+		Attributes: []services.AttributeFacet{
+			{Name: "Screen size", Values: []services.FacetValue{{Value: "24 inches", Count: 3}}},
+		},
+	}
+	got := newSearchFilterView(u, filters, facets)
+	want := &SearchFilterView{
+		Brands: []FilterOption{
+			{Text: "Dell", Count: 7, Link: "/s?brand=LG&brand=Dell&q=4k"},
+			{Text: "LG", Selected: true, Link: "/s?q=4k"},
+		},
+		Prices: []FilterOption{
+			{Text: "Under 50", Count: 2, Link: "/s?brand=LG&price_max=50&q=4k"},
+			{Text: "2500 & above", Count: 1, Link: "/s?brand=LG&price_min=2500&q=4k"},
+		},
+		Attributes: []AttributeFilter{
+			{
+				Name: "Screen size",
+				Options: []FilterOption{
+					{Text: "24 inches", Count: 3, Link: "/s?attr.Screen+size=24+inches&brand=LG&q=4k"},
+				},
+			},
+		},
+		ClearLink: "/s?q=4k",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("newSearchFilterView() = %+v, wanted %+v", got, want)
+	}
+}
+
+func TestToggle(t *testing.T) {
+	if got, want := toggle([]string{"a", "b"}, "c"), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("toggle() = %v, wanted %v", got, want)
+	}
+	if got, want := toggle([]string{"a", "b"}, "a"), []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("toggle() = %v, wanted %v", got, want)
+	}
+}
@@ -0,0 +1,46 @@
+package frontend
+
+import (
+	"testing"
+	"unicode/utf8"
+
+	"github.com/plifk/market/internal/services"
+)
+
+func TestTruncateQuery(t *testing.T) {
+	testCases := []struct {
+		query string
+		n     int
+		want  string
+	}{
+		{query: "kettle", n: 250, want: "kettle"},
+		{query: "kettle", n: 3, want: "ket"},
+		{query: "café", n: 4, want: "caf"},
+		{query: "café", n: 5, want: "café"},
+		{query: "日本", n: 4, want: "日"},
+		{query: "日本", n: 2, want: ""},
+	}
+	for _, tc := range testCases {
+		got := truncateQuery(tc.query, tc.n)
+		if got != tc.want || !utf8.ValidString(got) {
+			t.Errorf("truncateQuery(%q, %d) = %q, wanted %q instead", tc.query, tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestSearchPagesTotal(t *testing.T) {
+	testCases := []struct {
+		total int
+		want  int
+	}{
+		{total: 0, want: 0},
+		{total: 42, want: 42},
+		{total: services.MaxSearchWindow, want: services.MaxSearchWindow},
+		{total: 123456, want: services.MaxSearchWindow},
+	}
+	for _, tc := range testCases {
+		if got := searchPagesTotal(tc.total); got != tc.want {
+			t.Errorf("searchPagesTotal(%d) = %d, wanted %d instead", tc.total, got, tc.want)
+		}
+	}
+}
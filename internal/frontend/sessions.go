@@ -0,0 +1,82 @@
+package frontend
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/plifk/market/internal/services"
+)
+
+// SessionsHandler for the /account/sessions pages, where users see the devices they are logged in on and log out of them.
+type SessionsHandler struct {
+	Frontend *Frontend
+}
+
+// SessionsContent to render the devices page.
+type SessionsContent struct {
+	Devices []services.Device
+
+	// Notice of devices logged out.
+	Notice string
+}
+
+func (h *SessionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user := services.UserFromRequest(r)
+	session := services.SessionFromRequest(r)
+	if user == nil || session == nil {
+		http.Redirect(w, r, "/login?redirect_uri=/account/sessions", http.StatusSeeOther)
+		return
+	}
+	switch route := dirRouter(r.URL.Path); {
+	case route.is("/account/sessions") && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		h.view(w, r, user, session, SessionsContent{})
+	case route.is("/account/sessions/close") && r.Method == http.MethodPost:
+		h.close(w, r, user, session)
+	case route.is("/account/sessions/close-others") && r.Method == http.MethodPost:
+		h.closeOthers(w, r, user, session)
+	case route.is("/account/sessions"), route.is("/account/sessions/close"), route.is("/account/sessions/close-others"):
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+	default:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+	}
+}
+
+func (h *SessionsHandler) view(w http.ResponseWriter, r *http.Request, user *services.User, session *services.Session, content SessionsContent) {
+	devices, err := h.Frontend.Modules.Sessions.Devices(r.Context(), user.UserID, session.StickyID)
+	if err != nil {
+		log.Printf("cannot list devices: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	content.Devices = devices
+	resp := &HTMLResponse{
+		Template:   "account-sessions",
+		Title:      "Your devices",
+		Breadcrumb: []Breadcrumb{{Text: "Your Account", Link: "/account"}, {Text: "Your devices", Active: true}},
+		Content:    content,
+	}
+	h.Frontend.Respond(w, r, resp)
+}
+
+// close logs the user out of another device. To log out of the current one, users use /logout.
+func (h *SessionsHandler) close(w http.ResponseWriter, r *http.Request, user *services.User, session *services.Session) {
+	switch err := h.Frontend.Modules.Sessions.CloseDevice(r.Context(), user.UserID, r.PostFormValue("device_id")); {
+	case err == services.ErrDeviceNotFound:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("cannot log out of device: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	h.view(w, r, user, session, SessionsContent{Notice: "The device was signed out."})
+}
+
+func (h *SessionsHandler) closeOthers(w http.ResponseWriter, r *http.Request, user *services.User, session *services.Session) {
+	if err := h.Frontend.Modules.Sessions.CloseUserSessions(r.Context(), user.UserID, session.StickyID); err != nil {
+		log.Printf("cannot log out of other devices: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	h.view(w, r, user, session, SessionsContent{Notice: "All your other devices were signed out."})
+}
@@ -0,0 +1,128 @@
+package frontend
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/plifk/market/internal/services"
+)
+
+// SignupHandler for creating accounts and verifying their email addresses.
+type SignupHandler struct {
+	Frontend *Frontend
+}
+
+// SignupForm for /signup.
+type SignupForm struct {
+	Name  string
+	Email string
+
+	Error error
+}
+
+// SignupVerifyContent to render the email verification pages.
+type SignupVerifyContent struct {
+	// Email the verification link was sent to.
+	Email string
+
+	// Verified is set when the email address was just verified.
+	Verified bool
+
+	Error error
+}
+
+func (h *SignupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch route := dirRouter(r.URL.Path); {
+	case route.is("/signup") && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		if session := services.SessionFromRequest(r); session != nil && session.UserID != "" {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		h.form(w, r, SignupForm{})
+	case route.is("/signup") && r.Method == http.MethodPost:
+		h.signup(w, r)
+	case route.is("/signup/verify") && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		h.verify(w, r)
+	case route.is("/signup/resend") && r.Method == http.MethodPost:
+		h.resend(w, r)
+	case route.is("/signup"), route.is("/signup/verify"), route.is("/signup/resend"):
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+	default:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+	}
+}
+
+func (h *SignupHandler) form(w http.ResponseWriter, r *http.Request, form SignupForm) {
+	resp := &HTMLResponse{
+		Template: "account-signup",
+		Title:    "Create your account",
+		Content:  form,
+	}
+	h.Frontend.Respond(w, r, resp)
+}
+
+func (h *SignupHandler) signup(w http.ResponseWriter, r *http.Request) {
+	if session := services.SessionFromRequest(r); session != nil && session.UserID != "" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	form := SignupForm{
+		Name:  r.PostFormValue("name"),
+		Email: r.PostFormValue("email"),
+	}
+	accounts := h.Frontend.Modules.Accounts
+	u, err := accounts.Signup(r.Context(), services.SignupParams{
+		Name:     form.Name,
+		Email:    form.Email,
+		Password: r.PostFormValue("password"),
+	})
+	switch {
+	case err == services.ErrEmailTaken:
+		// The owner of the email address was emailed about it, and the page is the same, so that it doesn't disclose who is registered.
+		h.verifyPage(w, r, SignupVerifyContent{Email: form.Email})
+		return
+	case err != nil:
+		log.Printf("cannot sign up: %v", err)
+		form.Error = formError(w, err)
+		h.form(w, r, form)
+		return
+	}
+	content := SignupVerifyContent{Email: u.Email}
+	if err := accounts.SendVerificationEmail(r.Context(), u); err != nil {
+		log.Printf("cannot send verification email to user %q: %v", u.UserID, err)
+		content.Error = formError(w, err)
+	}
+	h.verifyPage(w, r, content)
+}
+
+func (h *SignupHandler) verify(w http.ResponseWriter, r *http.Request) {
+	switch _, err := h.Frontend.Modules.Accounts.VerifyEmail(r.Context(), r.URL.Query().Get("token")); {
+	case err == services.ErrInvalidSignedToken:
+		w.WriteHeader(http.StatusBadRequest)
+		h.verifyPage(w, r, SignupVerifyContent{Error: err})
+	case err != nil:
+		log.Printf("cannot verify email address: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+	default:
+		h.verifyPage(w, r, SignupVerifyContent{Verified: true})
+	}
+}
+
+func (h *SignupHandler) resend(w http.ResponseWriter, r *http.Request) {
+	email := r.PostFormValue("email")
+	content := SignupVerifyContent{Email: email}
+	if err := h.Frontend.Modules.Accounts.ResendVerificationEmail(r.Context(), email); err != nil {
+		log.Printf("cannot resend verification email: %v", err)
+		content.Error = formError(w, err)
+	}
+	h.verifyPage(w, r, content)
+}
+
+func (h *SignupHandler) verifyPage(w http.ResponseWriter, r *http.Request, content SignupVerifyContent) {
+	resp := &HTMLResponse{
+		Template: "account-verify",
+		Title:    "Verify your email address",
+		Content:  content,
+	}
+	h.Frontend.Respond(w, r, resp)
+}
@@ -18,8 +18,7 @@ type StaticHandler struct {
 
 func (h *StaticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if strings.HasPrefix(r.URL.Path, "/admin/") {
-		u := services.UserFromRequest(r)
-		if u.Access != services.AdminAuthorization {
+		if !services.Can(services.UserFromRequest(r), services.PermissionAdminArea) {
 			h.Frontend.HTTPError(w, r, http.StatusNotFound)
 			return
 		}
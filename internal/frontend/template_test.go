@@ -0,0 +1,222 @@
+package frontend
+
+import (
+	"errors"
+	"html/template"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/plifk/market/internal/services"
+	"github.com/plifk/market/internal/validator"
+)
+
+func TestPrepareTemplates(t *testing.T) {
+	f := &Frontend{
+		Modules: &services.Modules{},
+	}
+	if _, err := f.prepareTemplates("../../templates"); err != nil {
+		t.Errorf("cannot prepare templates: %v", err)
+	}
+}
+
+func TestExecuteTemplates(t *testing.T) {
+	f := &Frontend{
+		Modules: &services.Modules{},
+	}
+	tmpl, err := f.prepareTemplates("../../templates")
+	if err != nil {
+		t.Fatalf("cannot prepare templates: %v", err)
+	}
+	// The images module requires the thumbnail service settings, so we replace it.
+	tmpl.Funcs(template.FuncMap{
+		"img": func(path string, width int, alt string) (template.HTML, error) {
+			return "", nil
+		},
+	})
+	product := &services.Product{
+		ProductID:   "N3oCS85HvpY",
+		Name:        `LG Ultrafine 24" 4K`,
+		Brand:       "LG",
+		Description: "Optimized color performance for mac.",
+		Attributes:  map[string]string{"Resolution": "3840-by-2160"},
+		Options: []services.ProductOption{
+			{Name: "Color", Values: []string{"Black", "White", "Orange"}},
+			{Name: "Power cord", Values: []string{"US", "UK"}},
+		},
+		Variants: []services.Variant{
+			{VariantID: "a", Name: "Black", Price: services.Price{Amount: 99900, Currency: "EUR"}, Options: map[string]string{"Color": "Black", "Power cord": "US"}, Stock: 3},
+			{VariantID: "b", Name: "White", Price: services.Price{Amount: 89900, Currency: "EUR"}, Options: map[string]string{"Color": "White", "Power cord": "UK"}, Images: []string{"white.jpg"}, Stock: 1},
+			{VariantID: "c", Name: "Orange", Price: services.Price{Amount: 89900, Currency: "EUR"}, Options: map[string]string{"Color": "Orange", "Power cord": "US"}},
+		},
+	}
+	now := time.Now()
+	testCases := []*HTMLResponse{
+		{Template: "homepage", Content: []services.Product{*product}},
+		{Template: "product", Content: newProductContent(product, ""), Breadcrumb: []Breadcrumb{{Text: product.Name, Active: true}}},
+		{Template: "product", Content: newProductContent(product, "c")},
+		{Template: "category", Content: CategoryContent{
+			Category: &services.Category{Name: "Displays", Slug: "computers-displays"},
+			Products: []services.Product{*product},
+		}},
+		{Template: "cart", Content: CartContent{Cart: &services.Cart{}}},
+		{Template: "cart", Content: CartContent{
+			Cart: &services.Cart{
+				CartID: "x",
+				Items: []services.CartItem{
+					{VariantID: "a", ProductID: product.ProductID, ProductName: product.Name, VariantName: "Black", Image: "lg.jpg", Price: product.Variants[0].Price, Quantity: 2},
+				},
+			},
+			MaxQuantity: services.MaxCartItemQuantity,
+		}},
+		{Template: "checkout", Content: CheckoutContent{
+			Cart: &services.Cart{
+				Items: []services.CartItem{{VariantID: "a", ProductName: product.Name, Price: product.Variants[0].Price, Quantity: 1}},
+			},
+			Error: services.ErrCartItemUnavailable,
+		}},
+		{Template: "orders", Content: []services.Order{{OrderID: "x", Status: services.OrderPaid}}},
+		{Template: "order", Content: OrderContent{
+			Order: &services.Order{
+				OrderID: "x",
+				Status:  services.OrderPending,
+				Items:   []services.OrderItem{{VariantID: "a", ProductName: product.Name, Price: product.Variants[0].Price, Quantity: 2}},
+				Total:   services.Price{Amount: 199800, Currency: "EUR"},
+			},
+		}},
+		{Template: "account-tokens", Content: TokensContent{Scopes: services.TokenScopes}},
+		{Template: "account-tokens", Content: TokensContent{
+			Tokens: []services.APIToken{
+				{TokenID: "t1", Name: "mobile", Kind: services.PersonalToken, Scopes: []services.TokenScope{services.ScopeCart}, ExpiresAt: &now, LastUsedAt: &now},
+				{TokenID: "t2", Name: "erp", Kind: services.ServiceToken, Scopes: services.TokenScopes},
+			},
+			Scopes:   services.TokenScopes,
+			NewToken: "mkt_secret",
+		}},
+		{Template: "account-mfa", Content: MFAContent{
+			Enrollment: &services.MFAEnrollment{Secret: "JBSWY3DPEHPK3PXP", URI: "otpauth://totp/Market:jane@example.com?secret=JBSWY3DPEHPK3PXP"},
+			Required:   true,
+			Error:      services.ErrInvalidMFACode,
+		}},
+		{Template: "account-mfa", Content: MFAContent{
+			Enabled:       true,
+			RecoveryCodes: []string{"7kq2m-x9fhd"},
+			Remaining:     10,
+		}},
+		{Template: "account-login-mfa", Content: LoginMFAForm{Challenge: "x.y", RedirectURI: "/cart", Error: services.ErrInvalidMFACode}},
+		{Template: "account-passkeys", Content: PasskeysContent{}},
+		{Template: "account-passkeys", Content: PasskeysContent{
+			Passkeys: []services.Passkey{{ID: "AQID", Name: "Laptop", CreatedAt: now, LastUsedAt: &now}},
+			Added:    true,
+		}},
+		{Template: "account-sessions", Content: SessionsContent{
+			Devices: []services.Device{
+				{ID: "d1", UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) Firefox/118.0", IP: "192.0.2.1", SignedInAt: now, LastSeenAt: now, Current: true},
+				{ID: "d2", UserAgent: "curl/8.0", IP: "192.0.2.2", SignedInAt: now, LastSeenAt: now},
+			},
+			Notice: "The device was signed out.",
+		}},
+		{Template: "account-login", Content: LoginForm{RedirectURI: "/cart", RememberMe: true}},
+		{Template: "account", Content: AccountContent{Name: "Jane Doe", Phone: "+12015550123", Notice: "Your password was changed."}},
+		{Template: "account", Content: AccountContent{
+			PendingEmail:  "jane@example.net",
+			ProfileError:  errors.New("invalid phone number"),
+			PasswordError: services.ErrWrongPassword,
+			EmailError:    services.ErrSameEmail,
+		}},
+		{Template: "account-email", Content: AccountEmailContent{Email: "jane@example.net"}},
+		{Template: "account-email", Content: AccountEmailContent{Error: services.ErrInvalidSignedToken}},
+		{Template: "oauth-consent", Content: OAuthConsentContent{
+			Client: &services.OAuthClient{ClientID: "c1", Name: "Partner"},
+			Scopes: []services.TokenScope{services.ScopeProfile, services.ScopeOrders},
+			Request: services.AuthorizationRequest{
+				ResponseType:        "code",
+				ClientID:            "c1",
+				RedirectURI:         "https://partner.example.com/callback?a=b",
+				Scope:               "profile orders",
+				State:               `"><script>`,
+				CodeChallenge:       "challenge",
+				CodeChallengeMethod: "S256",
+			},
+		}},
+		{Template: "admin-oauth", Content: AdminOAuthContent{Scopes: services.TokenScopes}},
+		{Template: "admin-oauth", Content: AdminOAuthContent{
+			Clients: []services.OAuthClient{
+				{ClientID: "c1", Name: "Partner", RedirectURIs: []string{"https://partner.example.com/callback"}, Scopes: services.TokenScopes, Confidential: true},
+			},
+			Scopes:    services.TokenScopes,
+			NewClient: &services.OAuthClient{ClientID: "c1", Name: "Partner"},
+			Secret:    "mkt_secret",
+		}},
+		{Template: "admin-security", Content: AdminSecurityContent{}},
+		{Template: "admin-security", Content: AdminSecurityContent{
+			Lockouts: []services.LoginLockout{
+				{ID: "ip:192.0.2.1", Kind: services.LoginThrottleIP, Subject: "192.0.2.1", Until: now},
+				{ID: "account:x", Kind: services.LoginThrottleAccount, Subject: "jane@example.com", Until: now},
+			},
+		}},
+		{Template: "admin-security-sessions", Content: AdminSessionsContent{Email: "nobody@example.com", Error: services.ErrUserNotFound}},
+		{Template: "admin-security-sessions", Content: AdminSessionsContent{
+			Email:   "jane@example.com",
+			User:    &services.User{UserID: "u1", Name: "Jane Doe", Email: "jane@example.com"},
+			Devices: []services.Device{{ID: "d1", UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Safari/604.1", SignedInAt: now, LastSeenAt: now}},
+		}},
+		{Template: "admin-security-sessions", Content: AdminSessionsContent{User: &services.User{UserID: "u1"}}},
+		{Template: "admin-orders", Content: AdminOrdersContent{OrderID: "x", Error: services.ErrOrderNotFound}},
+		{Template: "admin-orders", Content: AdminOrdersContent{
+			OrderID: "x",
+			Order: &services.Order{
+				OrderID: "x",
+				Status:  services.OrderShipped,
+				Items:   []services.OrderItem{{VariantID: "a", ProductName: product.Name, Price: product.Variants[0].Price, Quantity: 1}},
+				Total:   product.Variants[0].Price,
+			},
+			CanRefund: true,
+			Notice:    "The order was refunded.",
+		}},
+		{Template: "admin-roles", Content: AdminRolesContent{Permissions: services.Permissions}},
+		{Template: "admin-roles", Content: AdminRolesContent{
+			Roles: []AdminRole{{
+				Role: services.Role{
+					RoleID:      "catalog-editor",
+					Name:        "Catalog editor",
+					Permissions: []services.Permission{services.PermissionAdminArea, services.PermissionManageCatalog},
+				},
+				Members: []services.RoleMember{{RoleID: "catalog-editor", UserID: "u2", Name: "John Doe", Email: "john@example.com", GrantedAt: now}},
+			}},
+			Permissions: services.Permissions,
+			Notice:      "Role Catalog editor created.",
+			Error:       services.ErrPermissionDenied,
+		}},
+		{Template: "account-login", Content: LoginForm{Email: "jane@example.com", Error: validator.FormError{}.Append("password", &services.LoginThrottledError{RetryAfter: 90 * time.Second, Locked: true})}},
+		{Template: "search", Content: SearchContent{
+			Query: "lg",
+			Results: &services.SearchResults{
+				Total:   40,
+				Page:    2,
+				PerPage: 16,
+				Hits:    []services.SearchHit{{ProductID: product.ProductID, Name: product.Name}},
+			},
+			Pagination: &Pagination{Pages: []PaginationPage{{Number: 1, Link: "/s?q=lg"}, {Number: 2, Current: true}}},
+			Filters: &SearchFilterView{
+				Brands:     []FilterOption{{Text: "LG", Count: 1, Selected: true, Link: "/s?q=lg"}},
+				Attributes: []AttributeFilter{{Name: "Color", Options: []FilterOption{{Text: "Black", Count: 1}}}},
+				ClearLink:  "/s?q=lg",
+			},
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Template, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "https://www.example.com/", nil)
+			tc.Params = &HTMLResponseParams{
+				Settings: &f.Modules.Settings,
+				Request:  r,
+				User:     &services.User{UserID: "u1", Name: "Jane Doe"},
+			}
+			if err := tmpl.ExecuteTemplate(ioutil.Discard, tc.Template, tc); err != nil {
+				t.Errorf("cannot execute template %q: %v", tc.Template, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package frontend
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/plifk/market/internal/services"
+)
+
+// TokensHandler for the /account/tokens pages, where users manage their API tokens.
+type TokensHandler struct {
+	Frontend *Frontend
+}
+
+// TokensContent to render the API tokens page.
+type TokensContent struct {
+	Tokens []services.APIToken
+
+	// Scopes that can be granted to a token.
+	Scopes []services.TokenScope
+
+	// NewToken is only shown right after it is created, as it cannot be retrieved later.
+	NewToken string
+
+	Error error
+}
+
+func (h *TokensHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user := services.UserFromRequest(r)
+	if user == nil {
+		http.Redirect(w, r, "/login?redirect_uri=/account/tokens", http.StatusSeeOther)
+		return
+	}
+	switch route := dirRouter(r.URL.Path); {
+	case route.is("/account/tokens") && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		h.view(w, r, user, TokensContent{})
+	case route.is("/account/tokens") && r.Method == http.MethodPost:
+		h.create(w, r, user)
+	case route.is("/account/tokens/revoke") && r.Method == http.MethodPost:
+		h.revoke(w, r, user)
+	case route.is("/account/tokens"), route.is("/account/tokens/revoke"):
+		h.Frontend.HTTPError(w, r, http.StatusMethodNotAllowed)
+	default:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+	}
+}
+
+func (h *TokensHandler) view(w http.ResponseWriter, r *http.Request, user *services.User, content TokensContent) {
+	tokens, err := h.Frontend.Modules.Tokens.List(r.Context(), user.UserID)
+	if err != nil {
+		log.Printf("cannot list API tokens: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	content.Tokens = tokens
+	content.Scopes = services.TokenScopes
+	resp := &HTMLResponse{
+		Template:   "account-tokens",
+		Title:      "API tokens",
+		Breadcrumb: []Breadcrumb{{Text: "Your Account", Link: "/account"}, {Text: "API tokens", Active: true}},
+		Content:    content,
+	}
+	h.Frontend.Respond(w, r, resp)
+}
+
+func (h *TokensHandler) create(w http.ResponseWriter, r *http.Request, user *services.User) {
+	if err := r.ParseForm(); err != nil {
+		h.Frontend.HTTPError(w, r, http.StatusBadRequest)
+		return
+	}
+	params := services.NewTokenParams{
+		UserID: user.UserID,
+		Name:   r.PostForm.Get("name"),
+		Kind:   services.PersonalToken,
+	}
+	for _, s := range r.PostForm["scope"] {
+		params.Scopes = append(params.Scopes, services.TokenScope(s))
+	}
+	if days := r.PostForm.Get("expires_in"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 {
+			h.Frontend.HTTPError(w, r, http.StatusBadRequest)
+			return
+		}
+		params.ExpiresAt = time.Now().AddDate(0, 0, n)
+	}
+	token, _, err := h.Frontend.Modules.Tokens.Create(r.Context(), params)
+	if err != nil {
+		log.Printf("cannot create API token: %v", err)
+		h.view(w, r, user, TokensContent{Error: formError(w, err)})
+		return
+	}
+	h.view(w, r, user, TokensContent{NewToken: token})
+}
+
+func (h *TokensHandler) revoke(w http.ResponseWriter, r *http.Request, user *services.User) {
+	switch err := h.Frontend.Modules.Tokens.Revoke(r.Context(), user.UserID, r.PostFormValue("token_id")); {
+	case err == services.ErrTokenNotFound:
+		h.Frontend.HTTPError(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("cannot revoke API token: %v", err)
+		h.Frontend.HTTPError(w, r, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/account/tokens", http.StatusSeeOther)
+}
@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/plifk/market/internal/router"
+)
+
+// Rule applying a policy to some requests.
+type Rule struct {
+	// Pattern of the path, in the router format (e.g., /v1/orders/:order_id).
+	// A trailing /* matches any path under it.
+	Pattern string
+
+	// Methods the rule applies to. If empty, it applies to all methods.
+	Methods []string
+
+	Policy Policy
+}
+
+// Match request.
+func (rule *Rule) Match(r *http.Request) bool {
+	if len(rule.Methods) != 0 {
+		var ok bool
+		for _, m := range rule.Methods {
+			if r.Method == m {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if prefix := strings.TrimSuffix(rule.Pattern, "/*"); prefix != rule.Pattern {
+		return r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/")
+	}
+	route := router.Route{Pattern: rule.Pattern}
+	_, ok := route.MatchPath(r.URL.Path)
+	return ok
+}
+
+// Middleware limiting the rate of requests of each client.
+//
+// Allowed requests get the RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, and RateLimit-Policy headers,
+// and denied requests also get Retry-After.
+// See https://datatracker.ietf.org/doc/draft-ietf-httpapi-ratelimit-headers/
+type Middleware struct {
+	Store Store
+
+	// Rules for requests. The first matching rule applies.
+	Rules []Rule
+
+	// Default policy for requests not matching any rule.
+	Default Policy
+
+	// Key identifying the client of a request, such as its IP address.
+	Key func(r *http.Request) string
+
+	// Exempt requests from rate limits if it returns true.
+	Exempt func(r *http.Request) bool
+
+	// Denied handles requests over the limit. If nil, a plain text error is returned.
+	Denied http.Handler
+
+	// now is used by tests.
+	now func() time.Time
+}
+
+// Handler limiting the rate of requests to next.
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.Allow(w, r) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// Allow checks if a request is within the limits, and writes the response if it is not.
+// If the store fails, the request is allowed, so that the site is still available.
+func (m *Middleware) Allow(w http.ResponseWriter, r *http.Request) bool {
+	p := m.policy(r)
+	if p.Unlimited() || (m.Exempt != nil && m.Exempt(r)) {
+		return true
+	}
+	now := time.Now()
+	if m.now != nil {
+		now = m.now()
+	}
+	res, err := m.Store.Allow(r.Context(), m.Key(r), p, now)
+	if err != nil {
+		log.Printf("request %s: %v\n", r.Header.Get("X-Request-ID"), err)
+		return true
+	}
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", seconds(res.ResetAfter))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%s", p.Limit, seconds(p.Period)))
+	if res.Allowed {
+		return true
+	}
+	h.Set("Retry-After", seconds(res.RetryAfter))
+	if m.Denied != nil {
+		m.Denied.ServeHTTP(w, r)
+		return false
+	}
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	return false
+}
+
+func (m *Middleware) policy(r *http.Request) Policy {
+	for _, rule := range m.Rules {
+		if rule.Match(r) {
+			return rule.Policy
+		}
+	}
+	return m.Default
+}
+
+// seconds rounded up, as clients must not retry too early.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
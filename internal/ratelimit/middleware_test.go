@@ -0,0 +1,164 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRuleMatch(t *testing.T) {
+	testCases := []struct {
+		rule   Rule
+		method string
+		path   string
+		want   bool
+	}{
+		{rule: Rule{Pattern: "/login"}, method: http.MethodGet, path: "/login", want: true},
+		{rule: Rule{Pattern: "/login"}, method: http.MethodGet, path: "/login/", want: true},
+		{rule: Rule{Pattern: "/login"}, method: http.MethodGet, path: "/login/mfa", want: false},
+		{rule: Rule{Pattern: "/login/*"}, method: http.MethodGet, path: "/login", want: true},
+		{rule: Rule{Pattern: "/login/*"}, method: http.MethodGet, path: "/login/mfa", want: true},
+		{rule: Rule{Pattern: "/login/*"}, method: http.MethodGet, path: "/loginx", want: false},
+		{rule: Rule{Pattern: "/v1/orders/:order_id"}, method: http.MethodGet, path: "/v1/orders/o1", want: true},
+		{rule: Rule{Pattern: "/v1/orders/:order_id"}, method: http.MethodGet, path: "/v1/orders", want: false},
+		{rule: Rule{Pattern: "/signup", Methods: []string{http.MethodPost}}, method: http.MethodPost, path: "/signup", want: true},
+		{rule: Rule{Pattern: "/signup", Methods: []string{http.MethodPost}}, method: http.MethodGet, path: "/signup", want: false},
+	}
+	for _, tc := range testCases {
+		r := httptest.NewRequest(tc.method, tc.path, nil)
+		if got := tc.rule.Match(r); got != tc.want {
+			t.Errorf("rule %+v match %s %s = %v, wanted %v instead", tc.rule, tc.method, tc.path, got, tc.want)
+		}
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Allow(ctx context.Context, key string, p Policy, now time.Time) (Result, error) {
+	return Result{}, errors.New("unavailable")
+}
+
+func TestMiddleware(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	m := &Middleware{
+		Store: &MemoryStore{},
+		Rules: []Rule{
+			{Pattern: "/static/*", Policy: Unlimited},
+			{Pattern: "/login", Methods: []string{http.MethodPost}, Policy: Policy{Name: "login", Limit: 2, Period: time.Minute}},
+		},
+		Default: Policy{Name: "default", Limit: 100, Period: time.Minute},
+		Key: func(r *http.Request) string {
+			return r.RemoteAddr
+		},
+		Exempt: func(r *http.Request) bool {
+			return r.Header.Get("X-Admin") != ""
+		},
+		now: func() time.Time {
+			return now
+		},
+	}
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(method, path, remoteAddr string, admin bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.RemoteAddr = remoteAddr
+		if admin {
+			r.Header.Set("X-Admin", "1")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	wantHeaders := func(w *httptest.ResponseRecorder, want map[string]string) {
+		t.Helper()
+		for k, v := range want {
+			if got := w.Header().Get(k); got != v {
+				t.Errorf("header %s = %q, wanted %q instead", k, got, v)
+			}
+		}
+	}
+
+	w := serve(http.MethodPost, "/login", "192.0.2.1", false)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("got status %d, wanted %d instead", w.Code, http.StatusNoContent)
+	}
+	wantHeaders(w, map[string]string{
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "1",
+		"RateLimit-Reset":     "30",
+		"RateLimit-Policy":    "2;w=60",
+		"Retry-After":         "",
+	})
+	serve(http.MethodPost, "/login", "192.0.2.1", false)
+
+	w = serve(http.MethodPost, "/login", "192.0.2.1", false)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("got status %d, wanted %d instead", w.Code, http.StatusTooManyRequests)
+	}
+	wantHeaders(w, map[string]string{
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "60",
+		"Retry-After":         "30",
+	})
+
+	// Admins, other clients, and other policies aren't affected.
+	if w := serve(http.MethodPost, "/login", "192.0.2.1", true); w.Code != http.StatusNoContent || w.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("admin request got status %d and headers %v", w.Code, w.Header())
+	}
+	if w := serve(http.MethodPost, "/login", "192.0.2.2", false); w.Code != http.StatusNoContent {
+		t.Errorf("request of another client got status %d, wanted %d instead", w.Code, http.StatusNoContent)
+	}
+	w = serve(http.MethodGet, "/login", "192.0.2.1", false)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("request on default policy got status %d, wanted %d instead", w.Code, http.StatusNoContent)
+	}
+	wantHeaders(w, map[string]string{"RateLimit-Limit": "100", "RateLimit-Remaining": "99"})
+
+	// Unlimited requests have no headers.
+	if w := serve(http.MethodGet, "/static/app.css", "192.0.2.1", false); w.Code != http.StatusNoContent || w.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("unlimited request got status %d and headers %v", w.Code, w.Header())
+	}
+
+	now = now.Add(30 * time.Second)
+	if w := serve(http.MethodPost, "/login", "192.0.2.1", false); w.Code != http.StatusNoContent {
+		t.Errorf("got status %d after waiting, wanted %d instead", w.Code, http.StatusNoContent)
+	}
+
+	// Requests are allowed if the store fails.
+	m.Store = failingStore{}
+	if w := serve(http.MethodPost, "/login", "192.0.2.1", false); w.Code != http.StatusNoContent {
+		t.Errorf("got status %d with a failing store, wanted %d instead", w.Code, http.StatusNoContent)
+	}
+}
+
+func TestMiddlewareDenied(t *testing.T) {
+	m := &Middleware{
+		Store:   &MemoryStore{},
+		Default: Policy{Name: "default", Limit: 1, Period: time.Minute},
+		Key: func(r *http.Request) string {
+			return r.RemoteAddr
+		},
+		Denied: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}),
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if !m.Allow(httptest.NewRecorder(), r) {
+		t.Error("first request should be allowed")
+	}
+	w := httptest.NewRecorder()
+	if m.Allow(w, r) {
+		t.Error("second request should be denied")
+	}
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d from the Denied handler, wanted %d instead", w.Code, http.StatusServiceUnavailable)
+	}
+	if w.Header().Get("Retry-After") != "60" {
+		t.Errorf("got Retry-After = %q, wanted 60 instead", w.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,132 @@
+// Package ratelimit limits the rate of HTTP requests per client using the generic cell rate algorithm (GCRA).
+//
+// GCRA works like a token bucket refilled continuously, but it only needs to store a single timestamp per client:
+// the theoretical arrival time (TAT) of the next request if the client sent requests at exactly the allowed rate.
+// See https://en.wikipedia.org/wiki/Generic_cell_rate_algorithm
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Policy of a rate limit.
+type Policy struct {
+	// Name of the policy. Each policy has its own counters, so names must be unique.
+	Name string
+
+	// Limit of requests per Period. Requests are not limited if zero.
+	Limit  int
+	Period time.Duration
+
+	// Burst of requests allowed at once. Defaults to the Limit.
+	Burst int
+}
+
+// Unlimited policy, for requests that shouldn't be limited.
+var Unlimited = Policy{}
+
+// Unlimited tells whether the policy doesn't limit requests.
+func (p Policy) Unlimited() bool {
+	return p.Limit <= 0 || p.Period <= 0
+}
+
+// interval between requests at the allowed rate.
+func (p Policy) interval() time.Duration {
+	if i := p.Period / time.Duration(p.Limit); i > time.Millisecond {
+		return i
+	}
+	return time.Millisecond
+}
+
+func (p Policy) burst() int {
+	if p.Burst > 0 {
+		return p.Burst
+	}
+	return p.Limit
+}
+
+// Result of checking a request against a policy.
+type Result struct {
+	Allowed bool
+
+	// Limit is the number of requests allowed at once (the burst).
+	Limit int
+
+	// Remaining requests allowed right now.
+	Remaining int
+
+	// ResetAfter is how long until the client can send Limit requests again.
+	ResetAfter time.Duration
+
+	// RetryAfter is how long until the next request is allowed, if this one wasn't.
+	RetryAfter time.Duration
+}
+
+// Store keeps the state of the rate limits.
+type Store interface {
+	// Allow a request of the client identified by the key under the policy, counting it if allowed.
+	Allow(ctx context.Context, key string, p Policy, now time.Time) (Result, error)
+}
+
+// gcra checks a request arriving at now, given the theoretical arrival time of the client (zero if unknown).
+// It returns the new theoretical arrival time, which must be stored only if the request is allowed.
+func gcra(p Policy, tat, now time.Time) (time.Time, Result) {
+	if tat.Before(now) {
+		tat = now
+	}
+	var (
+		interval  = p.interval()
+		tolerance = interval * time.Duration(p.burst())
+		newTAT    = tat.Add(interval)
+		allowAt   = newTAT.Add(-tolerance)
+	)
+	if now.Before(allowAt) {
+		return tat, Result{
+			Limit:      p.burst(),
+			ResetAfter: tat.Sub(now),
+			RetryAfter: allowAt.Sub(now),
+		}
+	}
+	return newTAT, Result{
+		Allowed:    true,
+		Limit:      p.burst(),
+		Remaining:  int(now.Sub(allowAt) / interval),
+		ResetAfter: newTAT.Sub(now),
+	}
+}
+
+// MemoryStore keeps the state of the rate limits in memory.
+// It is meant for tests and development, as limits aren't shared between servers.
+type MemoryStore struct {
+	mu        sync.Mutex
+	tat       map[string]time.Time
+	lastSweep time.Time
+}
+
+// sweepInterval is how often expired keys are removed from a MemoryStore.
+const sweepInterval = time.Minute
+
+// Allow a request of the client identified by the key under the policy, counting it if allowed.
+func (m *MemoryStore) Allow(ctx context.Context, key string, p Policy, now time.Time) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.tat == nil {
+		m.tat = map[string]time.Time{}
+	}
+	if now.Sub(m.lastSweep) > sweepInterval {
+		for k, tat := range m.tat {
+			if tat.Before(now) {
+				delete(m.tat, k)
+			}
+		}
+		m.lastSweep = now
+	}
+	key = p.Name + ":" + key
+	tat, res := gcra(p, m.tat[key], now)
+	if res.Allowed {
+		m.tat[key] = tat
+	}
+	return res, nil
+}
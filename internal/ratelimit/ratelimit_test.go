@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		store MemoryStore
+		p     = Policy{Name: "test", Limit: 60, Period: time.Minute, Burst: 3}
+		now   = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	)
+	allow := func(key string, wantAllowed bool, wantRemaining int, wantRetryAfter time.Duration) {
+		t.Helper()
+		res, err := store.Allow(ctx, key, p, now)
+		if err != nil {
+			t.Fatalf("cannot check rate limit: %v", err)
+		}
+		if res.Allowed != wantAllowed || res.Remaining != wantRemaining || res.RetryAfter != wantRetryAfter || res.Limit != 3 {
+			t.Errorf("got %+v, wanted allowed = %v, remaining = %d, retry after %v", res, wantAllowed, wantRemaining, wantRetryAfter)
+		}
+	}
+	// The burst is allowed at once.
+	allow("a", true, 2, 0)
+	allow("a", true, 1, 0)
+	allow("a", true, 0, 0)
+	allow("a", false, 0, time.Second)
+	allow("a", false, 0, time.Second)
+
+	// Other clients have their own limits.
+	allow("b", true, 2, 0)
+
+	// Each request is allowed again after an interval.
+	now = now.Add(500 * time.Millisecond)
+	allow("a", false, 0, 500*time.Millisecond)
+	now = now.Add(500 * time.Millisecond)
+	allow("a", true, 0, 0)
+	allow("a", false, 0, time.Second)
+
+	// The whole burst is allowed again after a while.
+	now = now.Add(time.Hour)
+	allow("a", true, 2, 0)
+
+	// Other policies have their own limits.
+	p.Name = "other"
+	allow("a", true, 2, 0)
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		store MemoryStore
+		p     = Policy{Name: "test", Limit: 1, Period: time.Minute}
+		now   = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	)
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := store.Allow(ctx, key, p, now); err != nil {
+			t.Fatalf("cannot check rate limit: %v", err)
+		}
+	}
+	if _, err := store.Allow(ctx, "d", p, now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("cannot check rate limit: %v", err)
+	}
+	if len(store.tat) != 1 {
+		t.Errorf("expired keys should have been removed, got %v", store.tat)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	testCases := []struct {
+		p         Policy
+		unlimited bool
+		interval  time.Duration
+		burst     int
+	}{
+		{p: Unlimited, unlimited: true},
+		{p: Policy{Limit: 10}, unlimited: true},
+		{p: Policy{Period: time.Minute}, unlimited: true},
+		{p: Policy{Limit: 60, Period: time.Minute}, interval: time.Second, burst: 60},
+		{p: Policy{Limit: 60, Period: time.Minute, Burst: 5}, interval: time.Second, burst: 5},
+		{p: Policy{Limit: 1e6, Period: time.Second}, interval: time.Millisecond, burst: 1e6},
+	}
+	for _, tc := range testCases {
+		if got := tc.p.Unlimited(); got != tc.unlimited {
+			t.Errorf("%+v.Unlimited() = %v, wanted %v instead", tc.p, got, tc.unlimited)
+		}
+		if tc.unlimited {
+			continue
+		}
+		if got := tc.p.interval(); got != tc.interval {
+			t.Errorf("%+v.interval() = %v, wanted %v instead", tc.p, got, tc.interval)
+		}
+		if got := tc.p.burst(); got != tc.burst {
+			t.Errorf("%+v.burst() = %v, wanted %v instead", tc.p, got, tc.burst)
+		}
+	}
+}
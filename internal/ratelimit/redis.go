@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisStore keeps the state of the rate limits on Redis, so that limits are shared between servers.
+type RedisStore struct {
+	Client *redis.Client
+
+	// Prefix of the keys.
+	Prefix string
+}
+
+// allowGCRA is the Redis version of gcra, with times in milliseconds.
+// The theoretical arrival time expires once it is in the past, as it is then the same as not knowing it.
+// KEYS: tat. ARGV: now, interval, burst.
+// Returns: allowed (0 or 1), retry after, reset after.
+var allowGCRA = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local tat = tonumber(redis.call("GET", KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+local new_tat = tat + interval
+local allow_at = new_tat - interval * tonumber(ARGV[3])
+if now < allow_at then
+	return {0, allow_at - now, tat - now}
+end
+redis.call("SET", KEYS[1], new_tat, "PX", new_tat - now)
+return {1, 0, new_tat - now}
+`)
+
+// Allow a request of the client identified by the key under the policy, counting it if allowed.
+func (s *RedisStore) Allow(ctx context.Context, key string, p Policy, now time.Time) (Result, error) {
+	interval := p.interval()
+	v, err := allowGCRA.Run(ctx, s.Client, []string{s.Prefix + p.Name + ":" + key},
+		now.UnixNano()/int64(time.Millisecond), interval.Milliseconds(), p.burst()).Result()
+	if err != nil {
+		return Result{}, fmt.Errorf("cannot check rate limit: %w", err)
+	}
+	reply, ok := v.([]interface{})
+	if !ok || len(reply) != 3 {
+		return Result{}, fmt.Errorf("cannot check rate limit: unexpected reply %v", v)
+	}
+	var ms [3]int64
+	for i, r := range reply {
+		if ms[i], ok = r.(int64); !ok {
+			return Result{}, fmt.Errorf("cannot check rate limit: unexpected reply %v", v)
+		}
+	}
+	res := Result{
+		Allowed:    ms[0] == 1,
+		Limit:      p.burst(),
+		RetryAfter: time.Duration(ms[1]) * time.Millisecond,
+		ResetAfter: time.Duration(ms[2]) * time.Millisecond,
+	}
+	if res.Allowed {
+		res.Remaining = int((interval*time.Duration(res.Limit) - res.ResetAfter) / interval)
+	}
+	return res, nil
+}
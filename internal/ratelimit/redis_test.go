@@ -0,0 +1,43 @@
+package ratelimit
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// TestRedisStore checks the Redis store behaves like the memory store.
+// It is skipped unless MARKET_TEST_REDIS is set with the address of a Redis server.
+func TestRedisStore(t *testing.T) {
+	addr := os.Getenv("MARKET_TEST_REDIS")
+	if addr == "" {
+		t.Skip("skipping test depending on Redis: MARKET_TEST_REDIS is not set")
+	}
+	ctx := context.Background()
+	kv := redis.NewClient(&redis.Options{Addr: addr})
+	defer kv.Close()
+
+	var (
+		redisStore  = &RedisStore{Client: kv, Prefix: "market:test:ratelimit:" + time.Now().Format(time.RFC3339Nano) + ":"}
+		memoryStore = &MemoryStore{}
+		p           = Policy{Name: "test", Limit: 60, Period: time.Minute, Burst: 3}
+		now         = time.Now().Truncate(time.Millisecond)
+	)
+	for i, step := range []time.Duration{0, 0, 0, 0, 500 * time.Millisecond, 500 * time.Millisecond, 0, time.Minute} {
+		now = now.Add(step)
+		want, err := memoryStore.Allow(ctx, "a", p, now)
+		if err != nil {
+			t.Fatalf("cannot check rate limit on memory: %v", err)
+		}
+		got, err := redisStore.Allow(ctx, "a", p, now)
+		if err != nil {
+			t.Fatalf("cannot check rate limit on Redis: %v", err)
+		}
+		if got != want {
+			t.Errorf("request %d: got %+v on Redis, wanted %+v instead", i, got, want)
+		}
+	}
+}
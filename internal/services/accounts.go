@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"net/mail"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v4"
@@ -24,16 +25,27 @@ type User struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	Access    Authorization
+
+	// Permissions granted by the roles of the user. Check them with Can.
+	Permissions []Permission
+
+	// EmailVerifiedAt is set when the user confirms they own the email address.
+	EmailVerifiedAt *time.Time
 }
 
-// Authorization role levels.
+// EmailVerified checks if the user confirmed their email address.
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// Authorization level of a user. Other than admins, users get permissions through roles.
 type Authorization string
 
 var (
-	// UserAuthorization role.
+	// UserAuthorization level.
 	UserAuthorization Authorization = "user"
 
-	// AdminAuthorization role.
+	// AdminAuthorization level, which has every permission.
 	AdminAuthorization Authorization = "admin"
 )
 
@@ -43,6 +55,9 @@ type NewUserParams struct {
 	Email  string
 	Phone  string
 	Access Authorization
+
+	// EmailVerified should only be set when the email address was verified by other means.
+	EmailVerified bool
 }
 
 // ValidateAndNormalize user params.
@@ -57,12 +72,15 @@ func (p *NewUserParams) ValidateAndNormalize() error {
 		return err
 	}
 	if p.Phone != "" {
-		// TODO(henvic): Set default region and normalize phone.
+		// TODO(henvic): Set default region.
 		num, err := phonenumbers.Parse(p.Phone, "US")
 		if err != nil {
 			return fmt.Errorf("invalid phone number: %w", err)
 		}
-		p.Phone = num.String()
+		if !phonenumbers.IsValidNumber(num) {
+			return errors.New("invalid phone number")
+		}
+		p.Phone = phonenumbers.Format(num, phonenumbers.E164)
 	}
 	return nil
 }
@@ -90,16 +108,19 @@ type userCtxKey struct{}
 
 // NewUser creates a new user.
 func (a *Accounts) NewUser(ctx context.Context, p NewUserParams) (id string, err error) {
+	return newUser(ctx, a.core.Postgres, p)
+}
+
+func newUser(ctx context.Context, q pgQuerier, p NewUserParams) (id string, err error) {
 	if err = p.ValidateAndNormalize(); err != nil {
-		return "", err
+		return "", invalid(err)
 	}
 	id = new11RandomID()
-	pg := a.core.Postgres
 	if p.Access == "" {
 		p.Access = UserAuthorization
 	}
-	sql := `INSERT INTO users ("user_id", "name", "email", "phone", "created_at", "access") VALUES ($1, $2, $3, $4, NOW(), $5)`
-	c, err := pg.Exec(ctx, sql, id, p.Name, p.Phone, p.Email, string(p.Access))
+	sql := `INSERT INTO users ("user_id", "name", "email", "phone", "created_at", "access", "email_verified_at") VALUES ($1, $2, $3, $4, NOW(), $5, CASE WHEN $6 THEN NOW() END)`
+	c, err := q.Exec(ctx, sql, id, p.Name, p.Email, p.Phone, string(p.Access), p.EmailVerified)
 	if err == nil && c.RowsAffected() == 0 {
 		err = errors.New("cannot save to database")
 	}
@@ -112,14 +133,12 @@ func (a *Accounts) NewUser(ctx context.Context, p NewUserParams) (id string, err
 // GetUserByID and return user object.
 func (a *Accounts) GetUserByID(ctx context.Context, userID string) (*User, error) {
 	pg := a.core.Postgres
-	const sql = `SELECT "user_id", "name", "email", "phone", "created_at", "updated_at", "access" FROM users WHERE "user_id" = $1 LIMIT 1`
-	row := pg.QueryRow(ctx, sql, userID)
-	var u User
-	err := row.Scan(&u.UserID, &u.Name, &u.Email, &u.Phone, &u.CreatedAt, &u.UpdatedAt, &u.Access)
+	const sql = selectUserSQL + `WHERE "user_id" = $1 LIMIT 1`
+	u, err := scanUser(pg.QueryRow(ctx, sql, userID))
 	if err == pgx.ErrNoRows {
 		return nil, fmt.Errorf("user not found: %w", err)
 	}
-	return &u, err
+	return u, err
 }
 
 // ErrUserNotFound occurs when no user is found.
@@ -128,14 +147,29 @@ var ErrUserNotFound = errors.New("user not found")
 // GetUserByEmail and return user object.
 func (a *Accounts) GetUserByEmail(ctx context.Context, email string) (*User, error) {
 	pg := a.core.Postgres
-	const sql = `SELECT "user_id", "name", "email", "phone", "created_at", "updated_at", "access" FROM users WHERE "email" = $1 LIMIT 1`
-	row := pg.QueryRow(ctx, sql, email)
-	var u User
-	err := row.Scan(&u.UserID, &u.Name, &u.Email, &u.Phone, &u.CreatedAt, &u.UpdatedAt, &u.Access)
+	const sql = selectUserSQL + `WHERE "email" = $1 LIMIT 1`
+	u, err := scanUser(pg.QueryRow(ctx, sql, email))
 	if err == pgx.ErrNoRows {
 		return nil, ErrUserNotFound
 	}
-	return &u, err
+	return u, err
+}
+
+// selectUserSQL selects the columns read by scanUser, including the permissions granted by the roles of the user.
+const selectUserSQL = `SELECT "user_id", "name", "email", "phone", "created_at", "updated_at", "access", "email_verified_at",
+ARRAY(SELECT DISTINCT unnest("roles"."permissions") FROM users_roles JOIN roles ON "roles"."role_id" = "users_roles"."role_id" WHERE "users_roles"."user_id" = "users"."user_id")
+FROM users `
+
+func scanUser(row pgx.Row) (*User, error) {
+	var (
+		u           User
+		permissions []string
+	)
+	if err := row.Scan(&u.UserID, &u.Name, &u.Email, &u.Phone, &u.CreatedAt, &u.UpdatedAt, &u.Access, &u.EmailVerifiedAt, &permissions); err != nil {
+		return nil, err
+	}
+	u.Permissions = stringsToPermissions(permissions)
+	return &u, nil
 }
 
 // NewAdminParams required to create a new admin user.
@@ -144,11 +178,13 @@ type NewAdminParams struct {
 	Password string
 }
 
-// NewAdmin creates a new admin user.
+// NewAdmin creates a new admin user, bypassing the email address verification.
 func (a *Accounts) NewAdmin(ctx context.Context, p NewAdminParams) (id string, err error) {
 	if err = passwords.Validate(p.Password); err != nil {
-		return "", err
+		return "", invalid(err)
 	}
+	p.Access = AdminAuthorization
+	p.EmailVerified = true
 	id, err = a.NewUser(ctx, p.NewUserParams)
 	if err != nil {
 		return "", err
@@ -172,17 +208,23 @@ type SetPasswordParams struct {
 // SetCredentials for user.
 func (a *Accounts) SetCredentials(ctx context.Context, p SetPasswordParams) error {
 	if err := passwords.Validate(p.Password); err != nil {
+		return invalid(err)
+	}
+	if err := setCredentials(ctx, a.core.Postgres, p); err != nil {
 		return err
 	}
+	invalidateCachedUsers(ctx, a.core, p.UserID)
+	return nil
+}
 
+func setCredentials(ctx context.Context, q pgQuerier, p SetPasswordParams) error {
 	hash, err := bcrypt.GenerateFromPassword([]byte(p.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return fmt.Errorf("cannot encrypt password: %w", err)
 	}
 
-	pg := a.core.Postgres
 	const sql = `INSERT INTO users_credentials ("user_id", "password_hash") VALUES($1, $2) ON CONFLICT (user_id) DO UPDATE SET password_hash = EXCLUDED.password_hash`
-	switch c, err := pg.Exec(ctx, sql, p.UserID, hash); {
+	switch c, err := q.Exec(ctx, sql, p.UserID, hash); {
 	case err != nil:
 		return fmt.Errorf("error setting a credential for user %q: %w", p.UserID, err)
 	case c.RowsAffected() == 0:
@@ -199,13 +241,55 @@ type checkPasswordRow struct {
 // ErrWrongPassword is used after failing to verify password.
 var ErrWrongPassword = errors.New("wrong password")
 
+// ErrInvalidCredentials is returned when logging in with an email address that isn't registered or with the wrong password.
+// It doesn't tell which one, so that others cannot find out which email addresses are registered.
+var ErrInvalidCredentials = errors.New("wrong email address or password")
+
+// Authenticate the user with the given email address and password.
+func (a *Accounts) Authenticate(ctx context.Context, email, password string) (*User, error) {
+	if password == "" || len(password) > passwords.MaxPasswordLength || len(email) > 255 {
+		return nil, ErrInvalidCredentials
+	}
+	u, err := a.GetUserByEmail(ctx, email)
+	switch {
+	case err == ErrUserNotFound:
+		// Comparing a password anyway takes about the same time as for a registered user, so timing doesn't tell them apart.
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return nil, ErrInvalidCredentials
+	case err != nil:
+		return nil, err
+	}
+	switch err := a.CheckPassword(ctx, u.UserID, password); {
+	case err == ErrWrongPassword:
+		return nil, ErrInvalidCredentials
+	case err != nil:
+		return nil, err
+	}
+	return u, nil
+}
+
+var (
+	dummyPasswordHashOnce  sync.Once
+	dummyPasswordHashValue []byte
+)
+
+func dummyPasswordHash() []byte {
+	dummyPasswordHashOnce.Do(func() {
+		var err error
+		if dummyPasswordHashValue, err = bcrypt.GenerateFromPassword([]byte(new11RandomID()), bcrypt.DefaultCost); err != nil {
+			panic(err)
+		}
+	})
+	return dummyPasswordHashValue
+}
+
 // CheckPassword for user.
 func (a *Accounts) CheckPassword(ctx context.Context, userID, password string) error {
 	if password == "" {
-		return errors.New("password is empty")
+		return invalid(errors.New("password is empty"))
 	}
 	if len(password) > passwords.MaxPasswordLength {
-		return errors.New("password is longer than acceptable")
+		return invalid(errors.New("password is longer than acceptable"))
 	}
 
 	pg := a.core.Postgres
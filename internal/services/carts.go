@@ -0,0 +1,295 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// MaxCartItemQuantity is the maximum quantity of a given item on a shopping cart.
+const MaxCartItemQuantity = 99
+
+// Cart of a shopper.
+type Cart struct {
+	CartID string
+	Items  []CartItem
+}
+
+// Quantity of items on the cart.
+func (c *Cart) Quantity() int {
+	var q int
+	for _, i := range c.Items {
+		q += i.Quantity
+	}
+	return q
+}
+
+// Total of the cart. All items are expected to use the same currency.
+func (c *Cart) Total() Price {
+	var total Price
+	for _, i := range c.Items {
+		total.Currency = i.Price.Currency
+		total.Amount += i.Subtotal().Amount
+	}
+	return total
+}
+
+// CartItem is a product variant on the shopping cart.
+type CartItem struct {
+	VariantID   string
+	ProductID   string
+	ProductName string
+	VariantName string
+	Image       string
+	Price       Price
+	Quantity    int
+	AddedAt     time.Time
+
+	// Available is false if the product was archived after the item was added to the cart.
+	Available bool
+}
+
+// Subtotal of the item.
+func (i CartItem) Subtotal() Price {
+	return Price{
+		Amount:   i.Price.Amount * int64(i.Quantity),
+		Currency: i.Price.Currency,
+	}
+}
+
+// CartOwner identifies the cart of a shopper.
+// Logged in users have a persistent cart, while anonymous shoppers have a cart tied to their session sticky ID.
+type CartOwner struct {
+	UserID   string
+	StickyID string
+}
+
+// CartOwnerFromSession returns the owner of the cart for the given session.
+func CartOwnerFromSession(session *Session) CartOwner {
+	if session.UserID != "" {
+		return CartOwner{UserID: session.UserID}
+	}
+	return CartOwner{StickyID: session.StickyID}
+}
+
+// ErrInvalidQuantity is returned when trying to use an invalid item quantity.
+var ErrInvalidQuantity = fmt.Errorf("quantity must be between 0 and %d", MaxCartItemQuantity)
+
+// Carts services.
+type Carts struct {
+	core *Core
+}
+
+// pgQuerier is implemented by both the connection pool and transactions.
+type pgQuerier interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// cartID returns the ID of the cart of the owner. If create is true, the cart is created if it doesn't exist yet.
+// An empty cart ID is returned when no cart is found.
+func cartID(ctx context.Context, q pgQuerier, owner CartOwner, create bool) (string, error) {
+	if owner.UserID == "" && owner.StickyID == "" {
+		return "", errors.New("cart owner is missing")
+	}
+	if create {
+		const sql = `INSERT INTO carts ("cart_id", "user_id", "sticky_id", "created_at", "updated_at") VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NOW(), NOW()) ON CONFLICT DO NOTHING`
+		if _, err := q.Exec(ctx, sql, new11RandomID(), owner.UserID, owner.StickyID); err != nil {
+			return "", fmt.Errorf("cannot create cart: %w", err)
+		}
+	}
+	sql := `SELECT "cart_id" FROM carts WHERE "user_id" = $1 LIMIT 1`
+	key := owner.UserID
+	if owner.UserID == "" {
+		sql = `SELECT "cart_id" FROM carts WHERE "sticky_id" = $1 LIMIT 1`
+		key = owner.StickyID
+	}
+	var id string
+	switch err := q.QueryRow(ctx, sql, key).Scan(&id); {
+	case err == pgx.ErrNoRows:
+		return "", nil
+	case err != nil:
+		return "", fmt.Errorf("cannot get cart: %w", err)
+	}
+	return id, nil
+}
+
+// Get the cart of the owner. An empty cart is returned if the owner has no cart yet.
+func (c *Carts) Get(ctx context.Context, owner CartOwner) (*Cart, error) {
+	pg := c.core.Postgres
+	id, err := cartID(ctx, pg, owner, false)
+	if err != nil || id == "" {
+		return &Cart{}, err
+	}
+	items, err := cartItems(ctx, pg, id)
+	if err != nil {
+		return nil, err
+	}
+	return &Cart{CartID: id, Items: items}, nil
+}
+
+// cartItems returns the items of a cart with the current product name and price.
+func cartItems(ctx context.Context, q pgQuerier, cartID string) ([]CartItem, error) {
+	const sql = `SELECT i."variant_id", v."product_id", p."name", v."name", COALESCE(p."images"[1], ''), v."price_amount", v."price_currency", i."quantity", p."status" = $2, i."added_at"
+FROM carts_items i
+INNER JOIN products_variants v ON v."variant_id" = i."variant_id"
+INNER JOIN products p ON p."product_id" = v."product_id"
+WHERE i."cart_id" = $1 ORDER BY i."added_at", i."variant_id"`
+	rows, err := q.Query(ctx, sql, cartID, string(ProductActive))
+	if err != nil {
+		return nil, fmt.Errorf("cannot get cart items: %w", err)
+	}
+	defer rows.Close()
+	var items []CartItem
+	for rows.Next() {
+		var i CartItem
+		if err := rows.Scan(&i.VariantID, &i.ProductID, &i.ProductName, &i.VariantName, &i.Image, &i.Price.Amount, &i.Price.Currency, &i.Quantity, &i.Available, &i.AddedAt); err != nil {
+			return nil, fmt.Errorf("cannot read cart item: %w", err)
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot get cart items: %w", err)
+	}
+	return items, nil
+}
+
+// AddItem to the cart. If the item is already on the cart, its quantity is increased.
+func (c *Carts) AddItem(ctx context.Context, owner CartOwner, variantID string, quantity int) error {
+	if quantity < 1 || quantity > MaxCartItemQuantity {
+		return ErrInvalidQuantity
+	}
+	tx, err := c.core.Postgres.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot add item to cart: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	const checkSQL = `SELECT 1 FROM products_variants v INNER JOIN products p ON p."product_id" = v."product_id" WHERE v."variant_id" = $1 AND p."status" = $2`
+	var ok int
+	switch err := tx.QueryRow(ctx, checkSQL, variantID, string(ProductActive)).Scan(&ok); {
+	case err == pgx.ErrNoRows:
+		return ErrVariantNotFound
+	case err != nil:
+		return fmt.Errorf("cannot add item to cart: %w", err)
+	}
+
+	id, err := cartID(ctx, tx, owner, true)
+	if err != nil {
+		return err
+	}
+	if err := upsertCartItem(ctx, tx, id, variantID, quantity); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("cannot add item to cart: %w", err)
+	}
+	return nil
+}
+
+// upsertCartItem adds an item to a cart or increases its quantity, up to MaxCartItemQuantity.
+func upsertCartItem(ctx context.Context, q pgQuerier, cartID, variantID string, quantity int) error {
+	const sql = `INSERT INTO carts_items ("cart_id", "variant_id", "quantity", "added_at") VALUES ($1, $2, LEAST($3, $4), NOW())
+ON CONFLICT ("cart_id", "variant_id") DO UPDATE SET "quantity" = LEAST(carts_items."quantity" + EXCLUDED."quantity", $4)`
+	if _, err := q.Exec(ctx, sql, cartID, variantID, quantity, MaxCartItemQuantity); err != nil {
+		return fmt.Errorf("cannot save cart item: %w", err)
+	}
+	const touchSQL = `UPDATE carts SET "updated_at" = NOW() WHERE "cart_id" = $1`
+	if _, err := q.Exec(ctx, touchSQL, cartID); err != nil {
+		return fmt.Errorf("cannot update cart: %w", err)
+	}
+	return nil
+}
+
+// SetQuantity of an item on the cart. Setting the quantity to zero removes the item.
+func (c *Carts) SetQuantity(ctx context.Context, owner CartOwner, variantID string, quantity int) error {
+	if quantity < 0 || quantity > MaxCartItemQuantity {
+		return ErrInvalidQuantity
+	}
+	if quantity == 0 {
+		return c.RemoveItem(ctx, owner, variantID)
+	}
+	pg := c.core.Postgres
+	id, err := cartID(ctx, pg, owner, false)
+	if err != nil {
+		return err
+	}
+	const sql = `UPDATE carts_items SET "quantity" = $3 WHERE "cart_id" = $1 AND "variant_id" = $2`
+	switch ct, err := pg.Exec(ctx, sql, id, variantID, quantity); {
+	case err != nil:
+		return fmt.Errorf("cannot update cart item: %w", err)
+	case ct.RowsAffected() == 0:
+		return ErrVariantNotFound
+	}
+	return nil
+}
+
+// RemoveItem from the cart.
+func (c *Carts) RemoveItem(ctx context.Context, owner CartOwner, variantID string) error {
+	pg := c.core.Postgres
+	id, err := cartID(ctx, pg, owner, false)
+	if err != nil || id == "" {
+		return err
+	}
+	const sql = `DELETE FROM carts_items WHERE "cart_id" = $1 AND "variant_id" = $2`
+	if _, err := pg.Exec(ctx, sql, id, variantID); err != nil {
+		return fmt.Errorf("cannot remove cart item: %w", err)
+	}
+	return nil
+}
+
+// Clear removes all items from the cart.
+func (c *Carts) Clear(ctx context.Context, owner CartOwner) error {
+	pg := c.core.Postgres
+	id, err := cartID(ctx, pg, owner, false)
+	if err != nil || id == "" {
+		return err
+	}
+	const sql = `DELETE FROM carts_items WHERE "cart_id" = $1`
+	if _, err := pg.Exec(ctx, sql, id); err != nil {
+		return fmt.Errorf("cannot clear cart: %w", err)
+	}
+	return nil
+}
+
+// mergeAnonymousCart moves the items of an anonymous cart to the persistent cart of the user after logging in.
+func (c *Carts) mergeAnonymousCart(ctx context.Context, stickyID, userID string) error {
+	tx, err := c.core.Postgres.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot merge carts: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	anonymous, err := cartID(ctx, tx, CartOwner{StickyID: stickyID}, false)
+	if err != nil || anonymous == "" {
+		return err
+	}
+	persistent, err := cartID(ctx, tx, CartOwner{UserID: userID}, true)
+	if err != nil {
+		return err
+	}
+
+	const sql = `INSERT INTO carts_items ("cart_id", "variant_id", "quantity", "added_at")
+SELECT $2, "variant_id", "quantity", "added_at" FROM carts_items WHERE "cart_id" = $1
+ON CONFLICT ("cart_id", "variant_id") DO UPDATE SET "quantity" = LEAST(carts_items."quantity" + EXCLUDED."quantity", $3)`
+	if _, err := tx.Exec(ctx, sql, anonymous, persistent, MaxCartItemQuantity); err != nil {
+		return fmt.Errorf("cannot merge carts: %w", err)
+	}
+	const deleteItemsSQL = `DELETE FROM carts_items WHERE "cart_id" = $1`
+	if _, err := tx.Exec(ctx, deleteItemsSQL, anonymous); err != nil {
+		return fmt.Errorf("cannot remove anonymous cart items: %w", err)
+	}
+	const deleteSQL = `DELETE FROM carts WHERE "cart_id" = $1`
+	if _, err := tx.Exec(ctx, deleteSQL, anonymous); err != nil {
+		return fmt.Errorf("cannot remove anonymous cart: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("cannot merge carts: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package services
+
+import "testing"
+
+func TestCartTotal(t *testing.T) {
+	cart := &Cart{
+		Items: []CartItem{
+			{VariantID: "a", Price: Price{Amount: 1050, Currency: "EUR"}, Quantity: 2},
+			{VariantID: "b", Price: Price{Amount: 99900, Currency: "EUR"}, Quantity: 1},
+		},
+	}
+	if got, want := cart.Total(), (Price{Amount: 102000, Currency: "EUR"}); got != want {
+		t.Errorf("wanted total to be %v, got %v instead", want, got)
+	}
+	if got, want := cart.Quantity(), 3; got != want {
+		t.Errorf("wanted quantity to be %d, got %d instead", want, got)
+	}
+	if got, want := cart.Items[0].Subtotal(), (Price{Amount: 2100, Currency: "EUR"}); got != want {
+		t.Errorf("wanted subtotal to be %v, got %v instead", want, got)
+	}
+	if got := (&Cart{}).Total(); got != (Price{}) {
+		t.Errorf("wanted empty cart total to be zero, got %v instead", got)
+	}
+}
+
+func TestCartOwnerFromSession(t *testing.T) {
+	if got, want := CartOwnerFromSession(&Session{StickyID: "sticky"}), (CartOwner{StickyID: "sticky"}); got != want {
+		t.Errorf("wanted anonymous cart owner %+v, got %+v instead", want, got)
+	}
+	if got, want := CartOwnerFromSession(&Session{StickyID: "sticky", UserID: "user"}), (CartOwner{UserID: "user"}); got != want {
+		t.Errorf("wanted user cart owner %+v, got %+v instead", want, got)
+	}
+}
@@ -0,0 +1,488 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// ProductStatus of a product on the catalog.
+type ProductStatus string
+
+var (
+	// ProductActive is a product available on the catalog.
+	ProductActive ProductStatus = "active"
+
+	// ProductArchived is a product that was removed from the catalog.
+	// Archived products are kept for historical purposes (e.g., past orders).
+	ProductArchived ProductStatus = "archived"
+)
+
+// Product of the catalog.
+type Product struct {
+	ProductID   string
+	CategoryID  string
+	Name        string
+	Description string
+	Brand       string
+	Images      []string
+	Attributes  map[string]string
+	Status      ProductStatus
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+
+	// Options in which the variants differ, such as color or size.
+	// Each variant is a unique combination of the option values.
+	Options  []ProductOption
+	Variants []Variant
+}
+
+// LowestPrice of the product variants.
+func (p *Product) LowestPrice() Price {
+	var lowest Price
+	for i, v := range p.Variants {
+		if i == 0 || v.Price.Amount < lowest.Amount {
+			lowest = v.Price
+		}
+	}
+	return lowest
+}
+
+// Variant of a product, such as a given color or size. Every product has at least one variant.
+type Variant struct {
+	VariantID string
+	ProductID string
+	Name      string
+	Price     Price
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// Options values of the variant, by option name.
+	Options map[string]string
+
+	// Images of the variant, if different from the product images.
+	Images []string
+
+	// Stock is the number of units available for sale.
+	Stock int
+}
+
+// Price in the minor unit of a currency (i.e., cents).
+type Price struct {
+	Amount   int64
+	Currency string
+}
+
+var currencySymbols = map[string]string{
+	"BRL": "R$",
+	"EUR": "€",
+	"GBP": "£",
+	"USD": "$",
+}
+
+func (p Price) String() string {
+	symbol, ok := currencySymbols[p.Currency]
+	if !ok {
+		symbol = p.Currency
+	}
+	amount, sign := p.Amount, ""
+	if amount < 0 {
+		amount, sign = -amount, "-"
+	}
+	return fmt.Sprintf("%s%s %d.%02d", sign, symbol, amount/100, amount%100)
+}
+
+// Validate price.
+func (p Price) Validate() error {
+	if p.Amount < 0 {
+		return errors.New("price cannot be negative")
+	}
+	if len(p.Currency) != 3 || strings.ToUpper(p.Currency) != p.Currency {
+		return errors.New("currency must be an ISO 4217 code such as USD or EUR")
+	}
+	return nil
+}
+
+// ErrProductNotFound occurs when no product is found.
+var ErrProductNotFound = errors.New("product not found")
+
+// ErrVariantNotFound occurs when no product variant is found.
+var ErrVariantNotFound = errors.New("product variant not found")
+
+// Catalog of products.
+type Catalog struct {
+	core *Core
+}
+
+// ProductParams are the editable fields of a product.
+type ProductParams struct {
+	CategoryID  string
+	Name        string
+	Description string
+	Brand       string
+	Images      []string
+	Attributes  map[string]string
+}
+
+// ValidateAndNormalize product params.
+func (p *ProductParams) ValidateAndNormalize() error {
+	p.Name = strings.TrimSpace(p.Name)
+	p.Brand = strings.TrimSpace(p.Brand)
+	if p.Name == "" {
+		return errors.New("missing product name")
+	}
+	if len(p.Name) > 250 {
+		return errors.New("product name must be at most 250 chars")
+	}
+	if len(p.Brand) > 100 {
+		return errors.New("brand must be at most 100 chars")
+	}
+	if p.Images == nil {
+		p.Images = []string{}
+	}
+	if p.Attributes == nil {
+		p.Attributes = map[string]string{}
+	}
+	return nil
+}
+
+// VariantParams are the editable fields of a product variant.
+type VariantParams struct {
+	Name  string
+	Price Price
+
+	// Options values of the variant, by option name. Required if the product has options.
+	Options map[string]string
+
+	// Images of the variant (optional).
+	Images []string
+}
+
+// ValidateAndNormalize variant params.
+func (p *VariantParams) ValidateAndNormalize() error {
+	p.Name = strings.TrimSpace(p.Name)
+	if len(p.Name) > 150 {
+		return errors.New("variant name must be at most 150 chars")
+	}
+	if p.Options == nil {
+		p.Options = map[string]string{}
+	}
+	if p.Images == nil {
+		p.Images = []string{}
+	}
+	return p.Price.Validate()
+}
+
+// NewProductParams to create a product with its variants.
+type NewProductParams struct {
+	ProductParams
+
+	// Options in which the variants differ (optional).
+	// Options cannot be changed after the product is created.
+	Options []ProductOption
+
+	Variants []VariantParams
+}
+
+// NewProduct creates a new product on the catalog.
+func (c *Catalog) NewProduct(ctx context.Context, p NewProductParams) (id string, err error) {
+	if err = p.ValidateAndNormalize(); err != nil {
+		return "", err
+	}
+	if len(p.Variants) == 0 {
+		return "", errors.New("product must have at least one variant")
+	}
+	if err = validateProductOptions(p.Options); err != nil {
+		return "", err
+	}
+	if p.Options == nil {
+		p.Options = []ProductOption{}
+	}
+	combinations := make([]map[string]string, len(p.Variants))
+	for i := range p.Variants {
+		if err = p.Variants[i].ValidateAndNormalize(); err != nil {
+			return "", err
+		}
+		combinations[i] = p.Variants[i].Options
+	}
+	if err = validateVariantsMatrix(p.Options, combinations); err != nil {
+		return "", err
+	}
+
+	id = new11RandomID()
+	tx, err := c.core.Postgres.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("cannot create product: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	const sql = `INSERT INTO products ("product_id", "category_id", "name", "description", "brand", "images", "attributes", "options", "status", "created_at", "updated_at") VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9, NOW(), NOW())`
+	if _, err = tx.Exec(ctx, sql, id, p.CategoryID, p.Name, p.Description, p.Brand, p.Images, p.Attributes, p.Options, string(ProductActive)); err != nil {
+		return "", fmt.Errorf("cannot create product %q: %w", id, err)
+	}
+	for _, v := range p.Variants {
+		if _, err = insertVariant(ctx, tx, id, v); err != nil {
+			return "", err
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("cannot create product %q: %w", id, err)
+	}
+	c.updateSearchIndex(id)
+	return id, nil
+}
+
+// NewVariant adds a variant to an existing product.
+func (c *Catalog) NewVariant(ctx context.Context, productID string, p VariantParams) (id string, err error) {
+	if err = p.ValidateAndNormalize(); err != nil {
+		return "", err
+	}
+	tx, err := c.core.Postgres.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("cannot create variant: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	if err = checkVariantsMatrix(ctx, tx, productID, "", p.Options); err != nil {
+		return "", err
+	}
+	if id, err = insertVariant(ctx, tx, productID, p); err != nil {
+		return "", err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("cannot create variant %q: %w", id, err)
+	}
+	c.updateSearchIndex(productID)
+	return id, nil
+}
+
+func insertVariant(ctx context.Context, tx pgx.Tx, productID string, p VariantParams) (id string, err error) {
+	id = new11RandomID()
+	const sql = `INSERT INTO products_variants ("variant_id", "product_id", "name", "price_amount", "price_currency", "options", "images", "created_at", "updated_at") VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW())`
+	if _, err = tx.Exec(ctx, sql, id, productID, p.Name, p.Price.Amount, p.Price.Currency, p.Options, p.Images); err != nil {
+		return "", fmt.Errorf("cannot create variant %q for product %q: %w", id, productID, err)
+	}
+	return id, nil
+}
+
+// checkVariantsMatrix checks if the options of a new or changed variant are valid for the product.
+// The product is locked until the transaction finishes to avoid concurrent changes creating duplicated combinations.
+func checkVariantsMatrix(ctx context.Context, tx pgx.Tx, productID, variantID string, options map[string]string) error {
+	const sql = `SELECT "options" FROM products WHERE "product_id" = $1 FOR UPDATE`
+	var productOptions []ProductOption
+	switch err := tx.QueryRow(ctx, sql, productID).Scan(&productOptions); {
+	case err == pgx.ErrNoRows:
+		return ErrProductNotFound
+	case err != nil:
+		return fmt.Errorf("cannot get options of product %q: %w", productID, err)
+	}
+	const variantsSQL = `SELECT "options" FROM products_variants WHERE "product_id" = $1 AND "variant_id" != $2`
+	rows, err := tx.Query(ctx, variantsSQL, productID, variantID)
+	if err != nil {
+		return fmt.Errorf("cannot get variants of product %q: %w", productID, err)
+	}
+	defer rows.Close()
+	combinations := []map[string]string{options}
+	for rows.Next() {
+		var o map[string]string
+		if err := rows.Scan(&o); err != nil {
+			return fmt.Errorf("cannot read variant options: %w", err)
+		}
+		combinations = append(combinations, o)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("cannot get variants of product %q: %w", productID, err)
+	}
+	return validateVariantsMatrix(productOptions, combinations)
+}
+
+// GetProduct with its variants.
+func (c *Catalog) GetProduct(ctx context.Context, productID string) (*Product, error) {
+	pg := c.core.Postgres
+	const sql = `SELECT "product_id", COALESCE("category_id", ''), "name", "description", "brand", "images", "attributes", "options", "status", "created_at", "updated_at" FROM products WHERE "product_id" = $1 LIMIT 1`
+	row := pg.QueryRow(ctx, sql, productID)
+	var p Product
+	switch err := row.Scan(&p.ProductID, &p.CategoryID, &p.Name, &p.Description, &p.Brand, &p.Images, &p.Attributes, &p.Options, &p.Status, &p.CreatedAt, &p.UpdatedAt); {
+	case err == pgx.ErrNoRows:
+		return nil, ErrProductNotFound
+	case err != nil:
+		return nil, fmt.Errorf("cannot get product %q: %w", productID, err)
+	}
+	products := []Product{p}
+	if err := c.loadVariants(ctx, products); err != nil {
+		return nil, err
+	}
+	return &products[0], nil
+}
+
+// loadVariants of the given products.
+func (c *Catalog) loadVariants(ctx context.Context, products []Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+	var (
+		ids = make([]string, len(products))
+		pos = map[string]int{}
+	)
+	for i, p := range products {
+		ids[i] = p.ProductID
+		pos[p.ProductID] = i
+	}
+	pg := c.core.Postgres
+	const sql = `SELECT v."variant_id", v."product_id", v."name", v."price_amount", v."price_currency", v."options", v."images", v."created_at", v."updated_at", COALESCE(i."on_hand" - i."reserved", 0)
+FROM products_variants v LEFT JOIN inventory i ON i."variant_id" = v."variant_id"
+WHERE v."product_id" = ANY($1) ORDER BY v."created_at", v."variant_id"`
+	rows, err := pg.Query(ctx, sql, ids)
+	if err != nil {
+		return fmt.Errorf("cannot get product variants: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v Variant
+		if err := rows.Scan(&v.VariantID, &v.ProductID, &v.Name, &v.Price.Amount, &v.Price.Currency, &v.Options, &v.Images, &v.CreatedAt, &v.UpdatedAt, &v.Stock); err != nil {
+			return fmt.Errorf("cannot read product variant: %w", err)
+		}
+		i := pos[v.ProductID]
+		products[i].Variants = append(products[i].Variants, v)
+	}
+	return rows.Err()
+}
+
+// UpdateProductParams to replace the editable fields of a product.
+type UpdateProductParams struct {
+	ProductID string
+	ProductParams
+}
+
+// UpdateProduct on the catalog.
+func (c *Catalog) UpdateProduct(ctx context.Context, p UpdateProductParams) error {
+	if err := p.ValidateAndNormalize(); err != nil {
+		return err
+	}
+	pg := c.core.Postgres
+	const sql = `UPDATE products SET "category_id" = NULLIF($2, ''), "name" = $3, "description" = $4, "brand" = $5, "images" = $6, "attributes" = $7, "updated_at" = NOW() WHERE "product_id" = $1`
+	switch ct, err := pg.Exec(ctx, sql, p.ProductID, p.CategoryID, p.Name, p.Description, p.Brand, p.Images, p.Attributes); {
+	case err != nil:
+		return fmt.Errorf("cannot update product %q: %w", p.ProductID, err)
+	case ct.RowsAffected() == 0:
+		return ErrProductNotFound
+	}
+	c.updateSearchIndex(p.ProductID)
+	return nil
+}
+
+// UpdateVariantParams to replace the editable fields of a product variant.
+type UpdateVariantParams struct {
+	VariantID string
+	VariantParams
+}
+
+// UpdateVariant of a product.
+func (c *Catalog) UpdateVariant(ctx context.Context, p UpdateVariantParams) error {
+	if err := p.ValidateAndNormalize(); err != nil {
+		return err
+	}
+	tx, err := c.core.Postgres.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot update variant %q: %w", p.VariantID, err)
+	}
+	defer tx.Rollback(ctx)
+	const productSQL = `SELECT "product_id" FROM products_variants WHERE "variant_id" = $1`
+	var productID string
+	switch err := tx.QueryRow(ctx, productSQL, p.VariantID).Scan(&productID); {
+	case err == pgx.ErrNoRows:
+		return ErrVariantNotFound
+	case err != nil:
+		return fmt.Errorf("cannot update variant %q: %w", p.VariantID, err)
+	}
+	if err := checkVariantsMatrix(ctx, tx, productID, p.VariantID, p.Options); err != nil {
+		return err
+	}
+	const sql = `UPDATE products_variants SET "name" = $2, "price_amount" = $3, "price_currency" = $4, "options" = $5, "images" = $6, "updated_at" = NOW() WHERE "variant_id" = $1`
+	if _, err := tx.Exec(ctx, sql, p.VariantID, p.Name, p.Price.Amount, p.Price.Currency, p.Options, p.Images); err != nil {
+		return fmt.Errorf("cannot update variant %q: %w", p.VariantID, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("cannot update variant %q: %w", p.VariantID, err)
+	}
+	c.updateSearchIndex(productID)
+	return nil
+}
+
+// ArchiveProduct removes a product from the catalog.
+func (c *Catalog) ArchiveProduct(ctx context.Context, productID string) error {
+	pg := c.core.Postgres
+	const sql = `UPDATE products SET "status" = $2, "updated_at" = NOW() WHERE "product_id" = $1`
+	switch ct, err := pg.Exec(ctx, sql, productID, string(ProductArchived)); {
+	case err != nil:
+		return fmt.Errorf("cannot archive product %q: %w", productID, err)
+	case ct.RowsAffected() == 0:
+		return ErrProductNotFound
+	}
+	c.updateSearchIndex(productID)
+	return nil
+}
+
+// updateSearchIndex after a product is changed.
+func (c *Catalog) updateSearchIndex(productID string) {
+	search := Search{core: c.core}
+	search.reindexProduct(productID)
+}
+
+// ListProductsParams to filter and paginate the catalog.
+type ListProductsParams struct {
+	// Status of the products to list (default: active).
+	Status ProductStatus
+
+	// CategoryID to list products from (optional).
+	// Products from its subcategories are also listed.
+	CategoryID string
+
+	// Limit of products to return (default: 50).
+	Limit int
+
+	// Offset to start listing products from.
+	Offset int
+}
+
+// ListProducts of the catalog, most recently updated first.
+func (c *Catalog) ListProducts(ctx context.Context, p ListProductsParams) ([]Product, error) {
+	if p.Status == "" {
+		p.Status = ProductActive
+	}
+	if p.Limit <= 0 || p.Limit > 1000 {
+		p.Limit = 50
+	}
+	pg := c.core.Postgres
+	const sql = `WITH RECURSIVE subtree AS (
+	SELECT "category_id" FROM categories WHERE "category_id" = $4
+	UNION
+	SELECT c."category_id" FROM categories c INNER JOIN subtree s ON c."parent_id" = s."category_id"
+)
+SELECT "product_id", COALESCE("category_id", ''), "name", "description", "brand", "images", "attributes", "options", "status", "created_at", "updated_at" FROM products
+WHERE "status" = $1 AND ($4 = '' OR "category_id" IN (SELECT "category_id" FROM subtree))
+ORDER BY "updated_at" DESC, "product_id" LIMIT $2 OFFSET $3`
+	rows, err := pg.Query(ctx, sql, string(p.Status), p.Limit, p.Offset, p.CategoryID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list products: %w", err)
+	}
+	defer rows.Close()
+	var products []Product
+	for rows.Next() {
+		var p Product
+		if err := rows.Scan(&p.ProductID, &p.CategoryID, &p.Name, &p.Description, &p.Brand, &p.Images, &p.Attributes, &p.Options, &p.Status, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("cannot read product: %w", err)
+		}
+		products = append(products, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot list products: %w", err)
+	}
+	if err := c.loadVariants(ctx, products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
@@ -0,0 +1,53 @@
+package services
+
+import "testing"
+
+func TestPriceString(t *testing.T) {
+	testCases := []struct {
+		price Price
+		want  string
+	}{
+		{price: Price{Amount: 99900, Currency: "EUR"}, want: "€ 999.00"},
+		{price: Price{Amount: 58945, Currency: "USD"}, want: "$ 589.45"},
+		{price: Price{Amount: 5, Currency: "GBP"}, want: "£ 0.05"},
+		{price: Price{Amount: 0, Currency: "BRL"}, want: "R$ 0.00"},
+		{price: Price{Amount: 12345, Currency: "CHF"}, want: "CHF 123.45"},
+		{price: Price{Amount: -1050, Currency: "EUR"}, want: "-€ 10.50"},
+	}
+	for _, tc := range testCases {
+		if got := tc.price.String(); got != tc.want {
+			t.Errorf("Price%+v.String() = %q, wanted %q", tc.price, got, tc.want)
+		}
+	}
+}
+
+func TestPriceValidate(t *testing.T) {
+	if err := (Price{Amount: 100, Currency: "EUR"}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (Price{Amount: -1, Currency: "EUR"}).Validate(); err == nil {
+		t.Error("expected error for negative price")
+	}
+	if err := (Price{Amount: 100, Currency: "eur"}).Validate(); err == nil {
+		t.Error("expected error for invalid currency")
+	}
+	if err := (Price{Amount: 100}).Validate(); err == nil {
+		t.Error("expected error for missing currency")
+	}
+}
+
+func TestProductLowestPrice(t *testing.T) {
+	p := Product{
+		Variants: []Variant{
+			{Price: Price{Amount: 69900, Currency: "EUR"}},
+			{Price: Price{Amount: 49900, Currency: "EUR"}},
+			{Price: Price{Amount: 99900, Currency: "EUR"}},
+		},
+	}
+	if want, got := (Price{Amount: 49900, Currency: "EUR"}), p.LowestPrice(); got != want {
+		t.Errorf("LowestPrice() = %v, wanted %v", got, want)
+	}
+	if got := (&Product{}).LowestPrice(); got != (Price{}) {
+		t.Errorf("LowestPrice() of product without variants = %v, wanted zero value", got)
+	}
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Category of products. Categories are organized as a tree.
+type Category struct {
+	CategoryID string
+	ParentID   string // Empty for root categories.
+	Slug       string
+	Name       string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// ErrCategoryNotFound occurs when no category is found.
+var ErrCategoryNotFound = errors.New("category not found")
+
+// ErrCategorySlugTaken occurs when trying to use a slug that is already in use by another category.
+var ErrCategorySlugTaken = errors.New("category slug is already in use")
+
+// Categories of the catalog.
+type Categories struct {
+	core *Core
+}
+
+// NewCategoryParams to create a new category.
+type NewCategoryParams struct {
+	// ParentID of the category (optional).
+	ParentID string
+
+	// Name of the category.
+	Name string
+
+	// Slug used on the category URL.
+	// If empty, it is generated from the parent slug and the name of the category.
+	Slug string
+}
+
+// NewCategory creates a new category.
+func (c *Categories) NewCategory(ctx context.Context, p NewCategoryParams) (id string, err error) {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return "", errors.New("missing category name")
+	}
+	if len(p.Name) > 100 {
+		return "", errors.New("category name must be at most 100 chars")
+	}
+	if p.Slug == "" {
+		p.Slug = Slugify(p.Name)
+		if p.ParentID != "" {
+			parent, err := c.GetCategory(ctx, p.ParentID)
+			if err != nil {
+				return "", fmt.Errorf("cannot get parent category: %w", err)
+			}
+			p.Slug = parent.Slug + "-" + p.Slug
+		}
+	}
+	if p.Slug == "" || Slugify(p.Slug) != p.Slug {
+		return "", fmt.Errorf("invalid category slug %q", p.Slug)
+	}
+
+	id = new11RandomID()
+	pg := c.core.Postgres
+	const sql = `INSERT INTO categories ("category_id", "parent_id", "slug", "name", "created_at", "updated_at") VALUES ($1, NULLIF($2, ''), $3, $4, NOW(), NOW())`
+	switch _, err = pg.Exec(ctx, sql, id, p.ParentID, p.Slug, p.Name); {
+	case isUniqueViolation(err):
+		return "", ErrCategorySlugTaken
+	case err != nil:
+		return "", fmt.Errorf("cannot create category %q: %w", id, err)
+	}
+	return id, nil
+}
+
+// GetCategory by its ID.
+func (c *Categories) GetCategory(ctx context.Context, categoryID string) (*Category, error) {
+	const sql = `SELECT "category_id", COALESCE("parent_id", ''), "slug", "name", "created_at", "updated_at" FROM categories WHERE "category_id" = $1 LIMIT 1`
+	return c.getCategory(ctx, sql, categoryID)
+}
+
+// GetCategoryBySlug and return category object.
+func (c *Categories) GetCategoryBySlug(ctx context.Context, slug string) (*Category, error) {
+	const sql = `SELECT "category_id", COALESCE("parent_id", ''), "slug", "name", "created_at", "updated_at" FROM categories WHERE "slug" = $1 LIMIT 1`
+	return c.getCategory(ctx, sql, slug)
+}
+
+func (c *Categories) getCategory(ctx context.Context, sql string, args ...interface{}) (*Category, error) {
+	pg := c.core.Postgres
+	row := pg.QueryRow(ctx, sql, args...)
+	var cat Category
+	switch err := row.Scan(&cat.CategoryID, &cat.ParentID, &cat.Slug, &cat.Name, &cat.CreatedAt, &cat.UpdatedAt); {
+	case err == pgx.ErrNoRows:
+		return nil, ErrCategoryNotFound
+	case err != nil:
+		return nil, fmt.Errorf("cannot get category: %w", err)
+	}
+	return &cat, nil
+}
+
+// Ancestors of a category, starting from the root and ending with the category itself.
+// It can be used to generate breadcrumbs.
+func (c *Categories) Ancestors(ctx context.Context, categoryID string) ([]Category, error) {
+	const sql = `WITH RECURSIVE ancestors AS (
+	SELECT "category_id", "parent_id", "slug", "name", "created_at", "updated_at", 0 AS "depth" FROM categories WHERE "category_id" = $1
+	UNION ALL
+	SELECT c."category_id", c."parent_id", c."slug", c."name", c."created_at", c."updated_at", a."depth" + 1 FROM categories c
+	INNER JOIN ancestors a ON c."category_id" = a."parent_id"
+	WHERE a."depth" < 100
+)
+SELECT "category_id", COALESCE("parent_id", ''), "slug", "name", "created_at", "updated_at" FROM ancestors ORDER BY "depth" DESC`
+	categories, err := c.queryCategories(ctx, sql, categoryID)
+	if err != nil {
+		return nil, err
+	}
+	if len(categories) == 0 {
+		return nil, ErrCategoryNotFound
+	}
+	return categories, nil
+}
+
+// Children of a category. If the category ID is empty, the root categories are returned.
+func (c *Categories) Children(ctx context.Context, categoryID string) ([]Category, error) {
+	const sql = `SELECT "category_id", COALESCE("parent_id", ''), "slug", "name", "created_at", "updated_at" FROM categories WHERE COALESCE("parent_id", '') = $1 ORDER BY "name"`
+	return c.queryCategories(ctx, sql, categoryID)
+}
+
+func (c *Categories) queryCategories(ctx context.Context, sql string, args ...interface{}) ([]Category, error) {
+	pg := c.core.Postgres
+	rows, err := pg.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get categories: %w", err)
+	}
+	defer rows.Close()
+	var categories []Category
+	for rows.Next() {
+		var cat Category
+		if err := rows.Scan(&cat.CategoryID, &cat.ParentID, &cat.Slug, &cat.Name, &cat.CreatedAt, &cat.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("cannot read category: %w", err)
+		}
+		categories = append(categories, cat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot get categories: %w", err)
+	}
+	return categories, nil
+}
+
+// Slugify a name to use it on a URL.
+// Letters are lowercased, and any sequence of other chars besides letters and digits is replaced by a hyphen.
+func Slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, c := range strings.ToLower(name) {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			if hyphen && b.Len() != 0 {
+				b.WriteByte('-')
+			}
+			hyphen = false
+			b.WriteRune(c)
+			continue
+		}
+		hyphen = true
+	}
+	return b.String()
+}
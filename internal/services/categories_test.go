@@ -0,0 +1,26 @@
+package services
+
+import "testing"
+
+func TestSlugify(t *testing.T) {
+	testCases := []struct {
+		name string
+		want string
+	}{
+		{name: "Computers", want: "computers"},
+		{name: "Computers & Tablets", want: "computers-tablets"},
+		{name: "  Image & sound  ", want: "image-sound"},
+		{name: "4K", want: "4k"},
+		{name: "Photo/Video", want: "photo-video"},
+		{name: "Beauty & Health!", want: "beauty-health"},
+		{name: "computers-displays-4k", want: "computers-displays-4k"},
+		{name: "Eletrônicos", want: "eletrônicos"},
+		{name: "&&&", want: ""},
+		{name: "", want: ""},
+	}
+	for _, tc := range testCases {
+		if got := Slugify(tc.name); got != tc.want {
+			t.Errorf("Slugify(%q) = %q, wanted %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Device a user is logged in on.
+// Each login starts a session with a new sticky ID, which is kept as the session ID is renewed, so it identifies the device.
+type Device struct {
+	// ID of the device. It is derived from the sticky ID, which isn't disclosed.
+	ID string
+
+	UserAgent  string
+	IP         string
+	SignedInAt time.Time
+	LastSeenAt time.Time
+
+	// Current is set for the device the list was requested from.
+	Current bool
+
+	stickyID string
+}
+
+// ErrDeviceNotFound is returned when a device doesn't exist, or isn't logged in anymore.
+var ErrDeviceNotFound = errors.New("device not found")
+
+// Name of the browser and operating system of the device, as told by its user agent.
+func (d *Device) Name() string {
+	ua := d.UserAgent
+	var browser string
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(ua, "Firefox/"), strings.Contains(ua, "FxiOS/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/"), strings.Contains(ua, "CriOS/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	default:
+		browser = "Unknown browser"
+	}
+	var os string
+	switch {
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+		os = "iOS"
+	case strings.Contains(ua, "Android"):
+		os = "Android"
+	case strings.Contains(ua, "Windows"):
+		os = "Windows"
+	case strings.Contains(ua, "Mac OS X"), strings.Contains(ua, "Macintosh"):
+		os = "macOS"
+	case strings.Contains(ua, "CrOS"):
+		os = "ChromeOS"
+	case strings.Contains(ua, "Linux"):
+		os = "Linux"
+	default:
+		return browser
+	}
+	return browser + " on " + os
+}
+
+// Devices the user is logged in on, last seen first.
+// The device with the given sticky ID, usually of the current session, is marked as current.
+func (s *Sessions) Devices(ctx context.Context, userID, currentStickyID string) ([]Device, error) {
+	devices, err := s.store().Devices(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range devices {
+		d := &devices[i]
+		d.ID = hashAPIToken(d.stickyID)[:32]
+		d.Current = currentStickyID != "" && d.stickyID == currentStickyID
+	}
+	return devices, nil
+}
+
+// CloseDevice logs the user out of a device.
+func (s *Sessions) CloseDevice(ctx context.Context, userID, deviceID string) error {
+	devices, err := s.Devices(ctx, userID, "")
+	if err != nil {
+		return err
+	}
+	for _, d := range devices {
+		if d.ID == deviceID {
+			return s.Close(ctx, d.stickyID)
+		}
+	}
+	return ErrDeviceNotFound
+}
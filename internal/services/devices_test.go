@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDeviceName(t *testing.T) {
+	testCases := []struct {
+		ua   string
+		want string
+	}{
+		{ua: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/605.1.15", want: "Safari on macOS"},
+		{ua: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36 Edg/118.0.2088.46", want: "Edge on Windows"},
+		{ua: "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Mobile Safari/537.36", want: "Chrome on Android"},
+		{ua: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) FxiOS/118.0 Mobile/15E148 Safari/605.1.15", want: "Firefox on iOS"},
+		{ua: "Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/118.0", want: "Firefox on Linux"},
+		{ua: "curl/8.0.1", want: "Unknown browser"},
+		{ua: "", want: "Unknown browser"},
+	}
+	for _, tc := range testCases {
+		d := Device{UserAgent: tc.ua}
+		if got := d.Name(); got != tc.want {
+			t.Errorf("wanted name of %q to be %q, got %q instead", tc.ua, tc.want, got)
+		}
+	}
+}
+
+func TestDevices(t *testing.T) {
+	core := newTestCore(t)
+	ctx := context.Background()
+	s := Sessions{core: core}
+
+	// Device a has a renewed session, device b is logged in, and device c is logged out.
+	const sql = `INSERT INTO http_sessions ("id", "sticky_id", "created_at", "expiration", "state", "user_id", "type", "user_agent", "ip", "last_seen_at") VALUES
+('a1', 'a', NOW() - INTERVAL '2 days', NOW() + INTERVAL '1 minute', 'active', 'u1', 'persistent', 'Firefox/118.0', '192.0.2.1', NOW() - INTERVAL '1 day'),
+('a2', 'a', NOW() - INTERVAL '1 day', NOW() + INTERVAL '1 day', 'active', 'u1', 'persistent', 'Chrome/118.0', '192.0.2.2', NOW()),
+('b1', 'b', NOW() - INTERVAL '1 day', NOW() + INTERVAL '1 day', 'active', 'u1', 'ephemeral', 'Safari/605.1.15', '192.0.2.3', NOW() - INTERVAL '1 hour'),
+('c1', 'c', NOW() - INTERVAL '1 day', NOW() + INTERVAL '1 day', 'expired', 'u1', 'ephemeral', '', '', NOW()),
+('d1', 'd', NOW() - INTERVAL '1 day', NOW() + INTERVAL '1 day', 'active', 'u2', 'ephemeral', '', '', NOW())`
+	if _, err := core.Postgres.Exec(ctx, sql); err != nil {
+		t.Fatalf("cannot create sessions: %v", err)
+	}
+	devices, err := s.Devices(ctx, "u1", "b")
+	if err != nil {
+		t.Fatalf("cannot list devices: %v", err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("wanted 2 devices, got %+v instead", devices)
+	}
+	if a := devices[0]; a.stickyID != "a" || a.Current || a.UserAgent != "Chrome/118.0" || a.IP != "192.0.2.2" || !a.SignedInAt.Before(devices[1].SignedInAt) {
+		t.Errorf("unexpected device %+v", a)
+	}
+	if b := devices[1]; b.stickyID != "b" || !b.Current {
+		t.Errorf("wanted device b to be the current one, got %+v instead", b)
+	}
+
+	if err := s.CloseDevice(ctx, "u2", devices[0].ID); err != ErrDeviceNotFound {
+		t.Errorf("wanted error %v closing device of another user, got %v instead", ErrDeviceNotFound, err)
+	}
+	if err := s.CloseDevice(ctx, "u1", devices[0].ID); err != nil {
+		t.Fatalf("cannot close device: %v", err)
+	}
+	if devices, err = s.Devices(ctx, "u1", ""); err != nil || len(devices) != 1 || devices[0].stickyID != "b" {
+		t.Errorf("wanted only device b, got %+v (error: %v)", devices, err)
+	}
+}
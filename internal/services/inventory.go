@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// StockReservationTimeout is how long the stock of a pending order is held before it is released.
+const StockReservationTimeout = 30 * time.Minute
+
+// Stock of a product variant.
+type Stock struct {
+	VariantID string
+
+	// OnHand is the number of units in the warehouse.
+	OnHand int
+
+	// Reserved is the number of units held by pending orders.
+	Reserved int
+}
+
+// Available units for sale.
+func (s Stock) Available() int {
+	return s.OnHand - s.Reserved
+}
+
+// ErrOutOfStock is returned when there are not enough units of a variant to reserve.
+var ErrOutOfStock = errors.New("out of stock")
+
+// Inventory of product variants.
+// Variants without a stock count are considered out of stock.
+type Inventory struct {
+	core *Core
+}
+
+// SetStock sets the number of units on hand of a variant.
+// It fails if the new value is lower than the number of units currently reserved.
+func (i *Inventory) SetStock(ctx context.Context, variantID string, onHand int) error {
+	if onHand < 0 {
+		return errors.New("stock cannot be negative")
+	}
+	pg := i.core.Postgres
+	const sql = `INSERT INTO inventory ("variant_id", "on_hand", "reserved", "updated_at") VALUES ($1, $2, 0, NOW())
+ON CONFLICT ("variant_id") DO UPDATE SET "on_hand" = EXCLUDED."on_hand", "updated_at" = NOW() WHERE inventory."reserved" <= EXCLUDED."on_hand"`
+	switch ct, err := pg.Exec(ctx, sql, variantID, onHand); {
+	case err != nil:
+		return fmt.Errorf("cannot set stock of variant %q: %w", variantID, err)
+	case ct.RowsAffected() == 0:
+		return fmt.Errorf("cannot set stock of variant %q to less than the reserved units", variantID)
+	}
+	return nil
+}
+
+// GetStock of a variant.
+func (i *Inventory) GetStock(ctx context.Context, variantID string) (Stock, error) {
+	pg := i.core.Postgres
+	const sql = `SELECT "on_hand", "reserved" FROM inventory WHERE "variant_id" = $1`
+	s := Stock{VariantID: variantID}
+	switch err := pg.QueryRow(ctx, sql, variantID).Scan(&s.OnHand, &s.Reserved); {
+	case err == pgx.ErrNoRows:
+		return s, nil
+	case err != nil:
+		return s, fmt.Errorf("cannot get stock of variant %q: %w", variantID, err)
+	}
+	return s, nil
+}
+
+// reserve the stock of the items of an order.
+// The conditional update guarantees concurrent transactions cannot reserve more units than available.
+// Variants are locked in a stable order to avoid deadlocks between orders sharing variants.
+func reserveStock(ctx context.Context, tx pgx.Tx, orderID string, items []OrderItem) error {
+	quantities := map[string]int{}
+	var variants []string
+	for _, item := range items {
+		if _, ok := quantities[item.VariantID]; !ok {
+			variants = append(variants, item.VariantID)
+		}
+		quantities[item.VariantID] += item.Quantity
+	}
+	sort.Strings(variants)
+
+	const sql = `UPDATE inventory SET "reserved" = "reserved" + $2, "updated_at" = NOW() WHERE "variant_id" = $1 AND "on_hand" - "reserved" >= $2`
+	const reservationSQL = `INSERT INTO inventory_reservations ("order_id", "variant_id", "quantity", "expires_at") VALUES ($1, $2, $3, $4)`
+	expires := time.Now().Add(StockReservationTimeout)
+	for _, v := range variants {
+		ct, err := tx.Exec(ctx, sql, v, quantities[v])
+		if err != nil {
+			return fmt.Errorf("cannot reserve stock of variant %q: %w", v, err)
+		}
+		if ct.RowsAffected() == 0 {
+			return ErrOutOfStock
+		}
+		if _, err := tx.Exec(ctx, reservationSQL, orderID, v, quantities[v], expires); err != nil {
+			return fmt.Errorf("cannot reserve stock of variant %q: %w", v, err)
+		}
+	}
+	return nil
+}
+
+// releaseStock reserved by an order, making it available for sale again.
+func releaseStock(ctx context.Context, tx pgx.Tx, orderID string) error {
+	const sql = `WITH released AS (
+	DELETE FROM inventory_reservations WHERE "order_id" = $1 RETURNING "variant_id", "quantity"
+)
+UPDATE inventory i SET "reserved" = i."reserved" - r."quantity", "updated_at" = NOW() FROM released r WHERE i."variant_id" = r."variant_id"`
+	if _, err := tx.Exec(ctx, sql, orderID); err != nil {
+		return fmt.Errorf("cannot release stock of order %q: %w", orderID, err)
+	}
+	return nil
+}
+
+// fulfillStock removes the units reserved by a paid order from the stock on hand.
+func fulfillStock(ctx context.Context, tx pgx.Tx, orderID string) error {
+	const sql = `WITH fulfilled AS (
+	DELETE FROM inventory_reservations WHERE "order_id" = $1 RETURNING "variant_id", "quantity"
+)
+UPDATE inventory i SET "on_hand" = i."on_hand" - f."quantity", "reserved" = i."reserved" - f."quantity", "updated_at" = NOW() FROM fulfilled f WHERE i."variant_id" = f."variant_id"`
+	if _, err := tx.Exec(ctx, sql, orderID); err != nil {
+		return fmt.Errorf("cannot fulfill stock of order %q: %w", orderID, err)
+	}
+	return nil
+}
+
+// ReleaseExpired cancels pending orders whose stock reservation expired, releasing their stock.
+// It should be called on a schedule.
+func (i *Inventory) ReleaseExpired(ctx context.Context) (int, error) {
+	pg := i.core.Postgres
+	const sql = `SELECT DISTINCT "order_id" FROM inventory_reservations WHERE "expires_at" < NOW() LIMIT 1000`
+	rows, err := pg.Query(ctx, sql)
+	if err != nil {
+		return 0, fmt.Errorf("cannot get expired stock reservations: %w", err)
+	}
+	var orderIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("cannot read expired stock reservation: %w", err)
+		}
+		orderIDs = append(orderIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("cannot get expired stock reservations: %w", err)
+	}
+
+	orders := Orders{core: i.core}
+	var released int
+	for _, id := range orderIDs {
+		switch err := orders.cancelPending(ctx, id); {
+		case err == ErrInvalidOrderTransition:
+			// The order was paid or cancelled concurrently.
+		case err != nil:
+			return released, err
+		default:
+			released++
+		}
+	}
+	return released, nil
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestCheckoutConcurrentBuyersCannotOversell(t *testing.T) {
+	core := newTestCore(t)
+	ctx := context.Background()
+	_, variantID := newTestProduct(t, core, Price{Amount: 1000, Currency: "EUR"})
+
+	inventory := Inventory{core: core}
+	if err := inventory.SetStock(ctx, variantID, 1); err != nil {
+		t.Fatalf("cannot set stock: %v", err)
+	}
+
+	const buyers = 20
+	carts := Carts{core: core}
+	for i := 0; i < buyers; i++ {
+		if err := carts.AddItem(ctx, CartOwner{UserID: fmt.Sprintf("buyer-%d", i)}, variantID, 1); err != nil {
+			t.Fatalf("cannot add item to cart: %v", err)
+		}
+	}
+
+	var (
+		wg         sync.WaitGroup
+		mu         sync.Mutex
+		placed     []string
+		outOfStock int
+		start      = make(chan struct{})
+	)
+	orders := Orders{core: core}
+	for i := 0; i < buyers; i++ {
+		wg.Add(1)
+		go func(userID string) {
+			defer wg.Done()
+			<-start
+			order, err := orders.Checkout(ctx, userID)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == ErrOutOfStock:
+				outOfStock++
+			case err != nil:
+				t.Errorf("unexpected checkout error: %v", err)
+			default:
+				placed = append(placed, order.OrderID)
+			}
+		}(fmt.Sprintf("buyer-%d", i))
+	}
+	close(start)
+	wg.Wait()
+
+	if len(placed) != 1 || outOfStock != buyers-1 {
+		t.Fatalf("wanted 1 order placed and %d out of stock, got %d placed and %d out of stock instead", buyers-1, len(placed), outOfStock)
+	}
+	stock, err := inventory.GetStock(ctx, variantID)
+	if err != nil {
+		t.Fatalf("cannot get stock: %v", err)
+	}
+	if want := (Stock{VariantID: variantID, OnHand: 1, Reserved: 1}); stock != want {
+		t.Errorf("wanted stock %+v, got %+v instead", want, stock)
+	}
+
+	// Cancelling the order releases the reserved unit.
+	if err := orders.Cancel(ctx, placed[0]); err != nil {
+		t.Fatalf("cannot cancel order: %v", err)
+	}
+	if stock, err = inventory.GetStock(ctx, variantID); err != nil {
+		t.Fatalf("cannot get stock: %v", err)
+	}
+	if stock.Available() != 1 {
+		t.Errorf("wanted 1 unit available after cancelling order, got %+v instead", stock)
+	}
+}
+
+func TestInventoryReleaseExpired(t *testing.T) {
+	core := newTestCore(t)
+	ctx := context.Background()
+	_, variantID := newTestProduct(t, core, Price{Amount: 1000, Currency: "EUR"})
+
+	inventory := Inventory{core: core}
+	if err := inventory.SetStock(ctx, variantID, 3); err != nil {
+		t.Fatalf("cannot set stock: %v", err)
+	}
+	carts := Carts{core: core}
+	if err := carts.AddItem(ctx, CartOwner{UserID: "buyer"}, variantID, 2); err != nil {
+		t.Fatalf("cannot add item to cart: %v", err)
+	}
+	orders := Orders{core: core}
+	order, err := orders.Checkout(ctx, "buyer")
+	if err != nil {
+		t.Fatalf("cannot checkout: %v", err)
+	}
+
+	if released, err := inventory.ReleaseExpired(ctx); err != nil || released != 0 {
+		t.Fatalf("wanted no reservation to be released, got %d (error: %v) instead", released, err)
+	}
+	const expireSQL = `UPDATE inventory_reservations SET "expires_at" = NOW() - INTERVAL '1 SECOND' WHERE "order_id" = $1`
+	if _, err := core.Postgres.Exec(ctx, expireSQL, order.OrderID); err != nil {
+		t.Fatalf("cannot expire reservation: %v", err)
+	}
+	if released, err := inventory.ReleaseExpired(ctx); err != nil || released != 1 {
+		t.Fatalf("wanted 1 reservation to be released, got %d (error: %v) instead", released, err)
+	}
+
+	stock, err := inventory.GetStock(ctx, variantID)
+	if err != nil {
+		t.Fatalf("cannot get stock: %v", err)
+	}
+	if stock.Available() != 3 {
+		t.Errorf("wanted 3 units available after releasing reservation, got %+v instead", stock)
+	}
+	if order, err = orders.GetOrder(ctx, order.OrderID); err != nil {
+		t.Fatalf("cannot get order: %v", err)
+	}
+	if order.Status != OrderCancelled {
+		t.Errorf("wanted order to be cancelled, got %q instead", order.Status)
+	}
+}
+
+func TestStockAvailable(t *testing.T) {
+	if got := (Stock{OnHand: 10, Reserved: 3}).Available(); got != 7 {
+		t.Errorf("wanted 7 units available, got %d instead", got)
+	}
+}
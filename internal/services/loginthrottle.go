@@ -0,0 +1,341 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// LoginThrottle protects logins against brute-force attacks by limiting failed attempts per IP address and per account.
+//
+// Failures are counted on Redis over a sliding window. After a few failures, each new attempt must wait
+// twice as long as the previous one (progressive delay), and after too many failures the IP address
+// or account is locked out for a while. Lockouts are listed on /admin/security, where admins can lift them.
+type LoginThrottle struct {
+	core *Core
+}
+
+// loginPolicy for failed login attempts of a kind of subject.
+type loginPolicy struct {
+	kind string
+
+	// free failures before delays start.
+	free int
+
+	// limit of failures within the window before a lockout.
+	limit int
+
+	window   time.Duration
+	lockout  time.Duration
+	maxDelay time.Duration
+}
+
+// Kinds of subjects login attempts are limited by.
+const (
+	LoginThrottleAccount = "account"
+	LoginThrottleIP      = "ip"
+)
+
+var (
+	// accountLoginPolicy protects each account against password guessing from many IP addresses.
+	accountLoginPolicy = loginPolicy{
+		kind:     LoginThrottleAccount,
+		free:     3,
+		limit:    10,
+		window:   15 * time.Minute,
+		lockout:  15 * time.Minute,
+		maxDelay: time.Minute,
+	}
+
+	// ipLoginPolicy protects against an IP address trying many accounts.
+	// It is more lenient, as many users might share an IP address.
+	ipLoginPolicy = loginPolicy{
+		kind:     LoginThrottleIP,
+		free:     10,
+		limit:    50,
+		window:   15 * time.Minute,
+		lockout:  15 * time.Minute,
+		maxDelay: time.Minute,
+	}
+)
+
+// delay required since the last failure, after the given number of failures within the window.
+func (p loginPolicy) delay(failures int) time.Duration {
+	n := failures - p.free
+	switch {
+	case n <= 0:
+		return 0
+	case n > 30:
+		return p.maxDelay
+	}
+	if d := time.Second << uint(n-1); d < p.maxDelay {
+		return d
+	}
+	return p.maxDelay
+}
+
+// loginThrottlePrefix of the Redis keys.
+const loginThrottlePrefix = "market:login:"
+
+type loginSubject struct {
+	policy loginPolicy
+
+	// id of the subject on the Redis keys.
+	id string
+
+	// name of the subject shown to admins.
+	name string
+}
+
+func (s loginSubject) failuresKey() string {
+	return loginThrottlePrefix + "failures:" + s.policy.kind + ":" + s.id
+}
+
+func (s loginSubject) lockoutKey() string {
+	return loginThrottlePrefix + "lockout:" + s.policy.kind + ":" + s.id
+}
+
+// LoginAttempt identifies who is trying to log in.
+type LoginAttempt struct {
+	IP    string
+	Email string
+}
+
+func (a LoginAttempt) subjects() []loginSubject {
+	var subjects []loginSubject
+	if email := strings.ToLower(strings.TrimSpace(a.Email)); email != "" {
+		// The email address is hashed so that any value is safe on a Redis key.
+		subjects = append(subjects, loginSubject{policy: accountLoginPolicy, id: hashAPIToken(email)[:32], name: email})
+	}
+	if ip := loginIPSubject(a.IP); ip != "" {
+		subjects = append(subjects, loginSubject{policy: ipLoginPolicy, id: ip, name: ip})
+	}
+	return subjects
+}
+
+// loginIPSubject groups IPv6 addresses by their /64 network, as a single client usually has a whole one.
+func loginIPSubject(s string) string {
+	ip := net.ParseIP(s)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return ip.String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+// LoginThrottledError is returned when a login attempt must wait before being tried.
+type LoginThrottledError struct {
+	// RetryAfter is how long to wait before trying again.
+	RetryAfter time.Duration
+
+	// Locked is set when the IP address or account is locked out, rather than just delayed.
+	Locked bool
+}
+
+func (e *LoginThrottledError) Error() string {
+	wait := time.Duration(math.Ceil(e.RetryAfter.Seconds())) * time.Second
+	if e.Locked {
+		return fmt.Sprintf("too many failed login attempts, try again in %v", wait)
+	}
+	return fmt.Sprintf("please wait %v before trying again", wait)
+}
+
+// errLoginThrottleUnavailable is returned when failed login attempts cannot be counted, as Redis isn't configured.
+var errLoginThrottleUnavailable = errors.New("failed login attempts cannot be counted without Redis")
+
+func (l *LoginThrottle) available() error {
+	if l.core == nil || l.core.Redis == nil {
+		return errLoginThrottleUnavailable
+	}
+	return nil
+}
+
+// Check if a login attempt can be tried now, returning a *LoginThrottledError otherwise.
+// It should be called before checking the password.
+func (l *LoginThrottle) Check(ctx context.Context, a LoginAttempt) error {
+	if err := l.available(); err != nil {
+		return err
+	}
+	now := time.Now()
+	var throttled *LoginThrottledError
+	for _, s := range a.subjects() {
+		var (
+			lockout *redis.DurationCmd
+			count   *redis.IntCmd
+			last    *redis.ZSliceCmd
+		)
+		if _, err := l.core.Redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZRemRangeByScore(ctx, s.failuresKey(), "-inf", strconv.FormatInt(unixMilli(now.Add(-s.policy.window)), 10))
+			count = pipe.ZCard(ctx, s.failuresKey())
+			last = pipe.ZRangeWithScores(ctx, s.failuresKey(), -1, -1)
+			lockout = pipe.PTTL(ctx, s.lockoutKey())
+			return nil
+		}); err != nil {
+			return fmt.Errorf("cannot check failed login attempts: %w", err)
+		}
+		var e *LoginThrottledError
+		if ttl := lockout.Val(); ttl > 0 {
+			e = &LoginThrottledError{RetryAfter: ttl, Locked: true}
+		} else if z := last.Val(); len(z) != 0 {
+			lastFailure := time.Unix(0, int64(z[0].Score)*int64(time.Millisecond))
+			if wait := s.policy.delay(int(count.Val())) - now.Sub(lastFailure); wait > 0 {
+				e = &LoginThrottledError{RetryAfter: wait}
+			}
+		}
+		if e != nil && (throttled == nil || e.RetryAfter > throttled.RetryAfter) {
+			throttled = e
+		}
+	}
+	if throttled != nil {
+		return throttled
+	}
+	return nil
+}
+
+// recordLoginFailure adds a failure to the window, and locks the subject out once there are too many.
+// KEYS: failures, lockout. ARGV: now (ms), window (ms), member, limit, lockout (ms), name.
+var recordLoginFailure = redis.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", tonumber(ARGV[1]) - tonumber(ARGV[2]))
+redis.call("ZADD", KEYS[1], ARGV[1], ARGV[3])
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+local n = redis.call("ZCARD", KEYS[1])
+if n >= tonumber(ARGV[4]) then
+	redis.call("SET", KEYS[2], ARGV[6], "PX", ARGV[5])
+	redis.call("DEL", KEYS[1])
+end
+return n
+`)
+
+// Fail records a failed login attempt, such as a wrong password or two-factor authentication code.
+func (l *LoginThrottle) Fail(ctx context.Context, a LoginAttempt) error {
+	if err := l.available(); err != nil {
+		return err
+	}
+	now := unixMilli(time.Now())
+	for _, s := range a.subjects() {
+		keys := []string{s.failuresKey(), s.lockoutKey()}
+		err := recordLoginFailure.Run(ctx, l.core.Redis, keys,
+			now, s.policy.window.Milliseconds(), strconv.FormatInt(now, 10)+"-"+new11RandomID(),
+			s.policy.limit, s.policy.lockout.Milliseconds(), s.name).Err()
+		if err != nil {
+			return fmt.Errorf("cannot record failed login attempt: %w", err)
+		}
+	}
+	return nil
+}
+
+// Succeed clears the failed login attempts of the account after the user logs in.
+// Failures of the IP address are kept, as an attacker might own an account.
+func (l *LoginThrottle) Succeed(ctx context.Context, a LoginAttempt) error {
+	if err := l.available(); err != nil {
+		return err
+	}
+	for _, s := range (LoginAttempt{Email: a.Email}).subjects() {
+		if err := l.core.Redis.Del(ctx, s.failuresKey()).Err(); err != nil {
+			return fmt.Errorf("cannot clear failed login attempts: %w", err)
+		}
+	}
+	return nil
+}
+
+// LoginLockout of an IP address or account.
+type LoginLockout struct {
+	// ID of the lockout, to lift it.
+	ID string
+
+	// Kind of subject locked out: LoginThrottleAccount or LoginThrottleIP.
+	Kind string
+
+	// Subject is the email address or IP address locked out.
+	Subject string
+
+	Until time.Time
+}
+
+// Lockouts currently in effect, ending last first.
+func (l *LoginThrottle) Lockouts(ctx context.Context) ([]LoginLockout, error) {
+	kv := l.core.Redis
+	prefix := loginThrottlePrefix + "lockout:"
+	var (
+		lockouts []LoginLockout
+		cursor   uint64
+	)
+	for {
+		keys, next, err := kv.Scan(ctx, cursor, prefix+"*", 100).Result()
+		if err != nil {
+			return nil, fmt.Errorf("cannot list login lockouts: %w", err)
+		}
+		for _, key := range keys {
+			subject, err := kv.Get(ctx, key).Result()
+			switch {
+			case err == redis.Nil:
+				continue // Expired meanwhile.
+			case err != nil:
+				return nil, fmt.Errorf("cannot get login lockout: %w", err)
+			}
+			ttl, err := kv.PTTL(ctx, key).Result()
+			if err != nil {
+				return nil, fmt.Errorf("cannot get login lockout: %w", err)
+			}
+			if ttl <= 0 {
+				continue
+			}
+			id := strings.TrimPrefix(key, prefix)
+			lockouts = append(lockouts, LoginLockout{
+				ID:      id,
+				Kind:    id[:strings.Index(id, ":")],
+				Subject: subject,
+				Until:   time.Now().Add(ttl),
+			})
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	sort.Slice(lockouts, func(i, j int) bool {
+		return lockouts[i].Until.After(lockouts[j].Until)
+	})
+	return lockouts, nil
+}
+
+// ErrLoginLockoutNotFound is returned when lifting a lockout that doesn't exist or ended.
+var ErrLoginLockoutNotFound = errors.New("login lockout not found")
+
+// Unlock lifts a lockout, also clearing the failed attempts.
+func (l *LoginThrottle) Unlock(ctx context.Context, id string) error {
+	i := strings.Index(id, ":")
+	if i == -1 {
+		return ErrLoginLockoutNotFound
+	}
+	policy := accountLoginPolicy
+	switch id[:i] {
+	case LoginThrottleAccount:
+	case LoginThrottleIP:
+		policy = ipLoginPolicy
+	default:
+		return ErrLoginLockoutNotFound
+	}
+	s := loginSubject{policy: policy, id: id[i+1:]}
+	n, err := l.core.Redis.Del(ctx, s.lockoutKey(), s.failuresKey()).Result()
+	if err != nil {
+		return fmt.Errorf("cannot lift login lockout: %w", err)
+	}
+	if n == 0 {
+		return ErrLoginLockoutNotFound
+	}
+	return nil
+}
+
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoginPolicyDelay(t *testing.T) {
+	testCases := map[int]time.Duration{
+		0:   0,
+		3:   0,
+		4:   time.Second,
+		5:   2 * time.Second,
+		9:   32 * time.Second,
+		10:  time.Minute,
+		100: time.Minute,
+	}
+	for failures, want := range testCases {
+		if got := accountLoginPolicy.delay(failures); got != want {
+			t.Errorf("delay(%d) = %v, wanted %v", failures, got, want)
+		}
+	}
+}
+
+func TestLoginAttemptSubjects(t *testing.T) {
+	subjects := LoginAttempt{IP: "2001:db8:1:2:3:4:5:6", Email: " Jane@Example.com"}.subjects()
+	if len(subjects) != 2 {
+		t.Fatalf("wanted account and IP subjects, got %+v instead", subjects)
+	}
+	if account := subjects[0]; account.policy.kind != LoginThrottleAccount || account.name != "jane@example.com" ||
+		account.id != (LoginAttempt{Email: "jane@example.com"}).subjects()[0].id {
+		t.Errorf("unexpected account subject: %+v", account)
+	}
+	if ip := subjects[1]; ip.policy.kind != LoginThrottleIP || ip.id != "2001:db8:1:2::/64" {
+		t.Errorf("wanted IPv6 addresses grouped by /64 network, got %+v instead", ip)
+	}
+	if got := (LoginAttempt{IP: "192.0.2.1"}).subjects(); len(got) != 1 || got[0].id != "192.0.2.1" {
+		t.Errorf("unexpected IPv4 subject: %+v", got)
+	}
+	if got := (LoginAttempt{IP: "invalid"}).subjects(); len(got) != 0 {
+		t.Errorf("wanted no subjects for invalid IP address, got %+v instead", got)
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	core := newTestRedisCore(t)
+	ctx := context.Background()
+	l := LoginThrottle{core: core}
+	id := strings.ToLower(new11RandomID())
+	attempt := LoginAttempt{
+		IP:    fmt.Sprintf("2001:db8:%x::1", time.Now().UnixNano()&0xffff),
+		Email: id + "@example.com",
+	}
+	defer l.Unlock(ctx, "ip:"+loginIPSubject(attempt.IP))
+
+	for i := 0; i < accountLoginPolicy.free; i++ {
+		if err := l.Fail(ctx, attempt); err != nil {
+			t.Fatalf("cannot record failure: %v", err)
+		}
+	}
+	if err := l.Check(ctx, attempt); err != nil {
+		t.Errorf("wanted no delay after %d failures, got %v instead", accountLoginPolicy.free, err)
+	}
+	if err := l.Fail(ctx, attempt); err != nil {
+		t.Fatalf("cannot record failure: %v", err)
+	}
+	var throttled *LoginThrottledError
+	if err := l.Check(ctx, attempt); !errors.As(err, &throttled) || throttled.Locked || throttled.RetryAfter > time.Second {
+		t.Errorf("wanted a delay of up to a second, got %v instead", err)
+	}
+	if err := l.Succeed(ctx, attempt); err != nil {
+		t.Fatalf("cannot clear failures: %v", err)
+	}
+	if err := l.Check(ctx, attempt); err != nil {
+		t.Errorf("wanted no delay after logging in, got %v instead", err)
+	}
+
+	for i := 0; i < accountLoginPolicy.limit; i++ {
+		if err := l.Fail(ctx, LoginAttempt{Email: attempt.Email}); err != nil {
+			t.Fatalf("cannot record failure: %v", err)
+		}
+	}
+	if err := l.Check(ctx, attempt); !errors.As(err, &throttled) || !throttled.Locked {
+		t.Errorf("wanted account to be locked out, got %v instead", err)
+	}
+	lockouts, err := l.Lockouts(ctx)
+	if err != nil {
+		t.Fatalf("cannot list lockouts: %v", err)
+	}
+	var lockout *LoginLockout
+	for i := range lockouts {
+		if lockouts[i].Subject == attempt.Email {
+			lockout = &lockouts[i]
+		}
+	}
+	if lockout == nil || lockout.Kind != LoginThrottleAccount || time.Until(lockout.Until) > accountLoginPolicy.lockout {
+		t.Fatalf("wanted lockout to be listed, got %+v instead", lockouts)
+	}
+	if err := l.Unlock(ctx, lockout.ID); err != nil {
+		t.Errorf("cannot lift lockout: %v", err)
+	}
+	if err := l.Check(ctx, attempt); err != nil {
+		t.Errorf("wanted no delay after lifting lockout, got %v instead", err)
+	}
+	if err := l.Unlock(ctx, lockout.ID); err != ErrLoginLockoutNotFound {
+		t.Errorf("wanted error %v lifting lockout again, got %v instead", ErrLoginLockoutNotFound, err)
+	}
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Mailer sends emails rendered from the mail templates, such as account verification and order updates.
+// Emails are queued on the database and delivered by ProcessQueue, so a mail server outage never blocks a request.
+type Mailer struct {
+	core *Core
+}
+
+// MailData passed to the mail templates.
+type MailData struct {
+	// Name of the recipient.
+	Name string
+
+	// PublicURL of the market, to create links.
+	PublicURL string
+
+	// Content specific to the email.
+	Content interface{}
+}
+
+const (
+	// mailQueueInterval between attempts to deliver queued emails.
+	mailQueueInterval = 10 * time.Second
+
+	// mailQueueBatchSize is the maximum number of emails delivered at once.
+	mailQueueBatchSize = 20
+
+	// mailMaxAttempts to deliver an email. Emails that failed this many times are kept on the queue for inspection,
+	// and listed by FailedMail, until PurgeFailed deletes them after mailFailedRetention.
+	mailMaxAttempts = 10
+
+	// mailFailedRetention is how long emails that failed mailMaxAttempts times are kept on the queue.
+	mailFailedRetention = 7 * 24 * time.Hour
+
+	// mailLease is how long emails claimed by a worker are held from other workers while being sent.
+	// It must be longer than sending a batch takes, otherwise emails might be sent twice.
+	mailLease = 15 * time.Minute
+)
+
+// Send email using the mail template with the given name to the address.
+// The email is rendered immediately, but only queued for delivery.
+func (m *Mailer) Send(ctx context.Context, to, name string, data MailData) error {
+	if m.core.MailTransport == nil {
+		return ErrMailTransportUnavailable
+	}
+	if err := validateEmail(to); err != nil {
+		return fmt.Errorf("invalid recipient: %w", err)
+	}
+	msg, err := m.Render(name, data)
+	if err != nil {
+		return err
+	}
+	msg.To = to
+	pg := m.core.Postgres
+	const sql = `INSERT INTO mail_queue ("mail_id", "to", "subject", "text", "html", "attempts", "next_attempt_at", "created_at") VALUES ($1, $2, $3, $4, $5, 0, NOW(), NOW())`
+	if _, err := pg.Exec(ctx, sql, new11RandomID(), msg.To, msg.Subject, msg.Text, msg.HTML); err != nil {
+		return fmt.Errorf("cannot queue %s email: %w", name, err)
+	}
+	return nil
+}
+
+// Render the mail template with the given name, without a recipient.
+//
+// Each email has a text template, "<name>.txt", defining its "subject" and "body",
+// and an HTML template, "<name>.html", defining its "body".
+// The bodies are wrapped by the "layout" defined on layout.txt and layout.html.
+func (m *Mailer) Render(name string, data MailData) (Message, error) {
+	if data.PublicURL == "" {
+		data.PublicURL = strings.TrimSuffix(m.core.Settings.PublicURL, "/")
+	}
+	dir := filepath.Join(m.core.Settings.TemplatesDirectory, "mail")
+	tt, err := texttemplate.ParseFiles(filepath.Join(dir, "layout.txt"), filepath.Join(dir, name+".txt"))
+	if err != nil {
+		return Message{}, fmt.Errorf("cannot parse text mail templates: %w", err)
+	}
+	ht, err := htmltemplate.ParseFiles(filepath.Join(dir, "layout.html"), filepath.Join(dir, name+".html"))
+	if err != nil {
+		return Message{}, fmt.Errorf("cannot parse HTML mail templates: %w", err)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tt.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, fmt.Errorf("cannot render %s email subject: %w", name, err)
+	}
+	if err := tt.ExecuteTemplate(&text, "layout", data); err != nil {
+		return Message{}, fmt.Errorf("cannot render %s email text: %w", name, err)
+	}
+	if err := ht.ExecuteTemplate(&html, "layout", data); err != nil {
+		return Message{}, fmt.Errorf("cannot render %s email HTML: %w", name, err)
+	}
+	return Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+// Run delivers queued emails until the context is cancelled.
+func (m *Mailer) Run(ctx context.Context) {
+	if m.core.MailTransport == nil {
+		log.Println("mail transport is not configured: emails won't be delivered")
+		return
+	}
+	ticker := time.NewTicker(mailQueueInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := m.ProcessQueue(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("cannot process mail queue: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessQueue delivers the queued emails that are due, returning how many were sent.
+// Emails that cannot be delivered are retried later, with an exponential backoff.
+func (m *Mailer) ProcessQueue(ctx context.Context) (sent int, err error) {
+	if m.core.MailTransport == nil {
+		return 0, ErrMailTransportUnavailable
+	}
+	for {
+		n, more, err := m.processBatch(ctx)
+		sent += n
+		if err != nil || !more {
+			return sent, err
+		}
+	}
+}
+
+type queuedMail struct {
+	MailID   string
+	Attempts int
+	Message  Message
+}
+
+// processBatch delivers a batch of queued emails. It returns whether there might be more emails to deliver.
+//
+// Emails are claimed by moving their next attempt past mailLease on a statement of their own, so that concurrent
+// workers don't send them twice, and no transaction is held open while talking to the mail server.
+// If the worker stops before recording the result, the emails are sent again once the lease is over.
+func (m *Mailer) processBatch(ctx context.Context) (sent int, more bool, err error) {
+	pg := m.core.Postgres
+	const sql = `UPDATE mail_queue SET "next_attempt_at" = $3 WHERE "mail_id" IN (
+SELECT "mail_id" FROM mail_queue WHERE "next_attempt_at" <= NOW() AND "attempts" < $1 ORDER BY "created_at" LIMIT $2 FOR UPDATE SKIP LOCKED)
+RETURNING "mail_id", "attempts", "to", "subject", "text", "html"`
+	rows, err := pg.Query(ctx, sql, mailMaxAttempts, mailQueueBatchSize, time.Now().Add(mailLease))
+	if err != nil {
+		return 0, false, fmt.Errorf("cannot get queued emails: %w", err)
+	}
+	var mails []queuedMail
+	for rows.Next() {
+		var q queuedMail
+		if err := rows.Scan(&q.MailID, &q.Attempts, &q.Message.To, &q.Message.Subject, &q.Message.Text, &q.Message.HTML); err != nil {
+			rows.Close()
+			return 0, false, fmt.Errorf("cannot read queued email: %w", err)
+		}
+		mails = append(mails, q)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, false, fmt.Errorf("cannot get queued emails: %w", err)
+	}
+
+	transport := m.core.MailTransport
+	for _, q := range mails {
+		if serr := transport.Send(ctx, q.Message); serr != nil {
+			if q.Attempts+1 >= mailMaxAttempts {
+				log.Printf("giving up delivering email %q after %d attempts: %v", q.MailID, q.Attempts+1, serr)
+			}
+			const failSQL = `UPDATE mail_queue SET "attempts" = "attempts" + 1, "last_error" = $2, "next_attempt_at" = $3 WHERE "mail_id" = $1`
+			if _, err := pg.Exec(ctx, failSQL, q.MailID, serr.Error(), time.Now().Add(mailRetryBackoff(q.Attempts+1))); err != nil {
+				return sent, false, fmt.Errorf("cannot reschedule email %q: %w", q.MailID, err)
+			}
+			continue
+		}
+		sent++
+		const sentSQL = `DELETE FROM mail_queue WHERE "mail_id" = $1`
+		if _, err := pg.Exec(ctx, sentSQL, q.MailID); err != nil {
+			return sent, false, fmt.Errorf("cannot remove sent email %q from the queue: %w", q.MailID, err)
+		}
+	}
+	return sent, len(mails) == mailQueueBatchSize, nil
+}
+
+// FailedMail is an email that couldn't be delivered after mailMaxAttempts attempts.
+type FailedMail struct {
+	MailID    string
+	To        string
+	Subject   string
+	Attempts  int
+	LastError string
+	CreatedAt time.Time
+}
+
+// FailedMail lists the emails that couldn't be delivered and were given up on, from the newest.
+func (m *Mailer) FailedMail(ctx context.Context) ([]FailedMail, error) {
+	pg := m.core.Postgres
+	const sql = `SELECT "mail_id", "to", "subject", "attempts", COALESCE("last_error", ''), "created_at" FROM mail_queue
+WHERE "attempts" >= $1 ORDER BY "created_at" DESC`
+	rows, err := pg.Query(ctx, sql, mailMaxAttempts)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get failed emails: %w", err)
+	}
+	defer rows.Close()
+	var failed []FailedMail
+	for rows.Next() {
+		var f FailedMail
+		if err := rows.Scan(&f.MailID, &f.To, &f.Subject, &f.Attempts, &f.LastError, &f.CreatedAt); err != nil {
+			return nil, fmt.Errorf("cannot read failed email: %w", err)
+		}
+		failed = append(failed, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot get failed emails: %w", err)
+	}
+	return failed, nil
+}
+
+// PurgeFailed deletes the emails that were given up on more than mailFailedRetention ago, returning how many.
+func (m *Mailer) PurgeFailed(ctx context.Context) (int, error) {
+	pg := m.core.Postgres
+	// The last attempt is rescheduled after it fails, so the backoff is subtracted to get when it was made.
+	const sql = `DELETE FROM mail_queue WHERE "attempts" >= $1 AND "next_attempt_at" < $2`
+	ct, err := pg.Exec(ctx, sql, mailMaxAttempts, time.Now().Add(mailRetryBackoff(mailMaxAttempts)-mailFailedRetention))
+	if err != nil {
+		return 0, fmt.Errorf("cannot purge failed emails: %w", err)
+	}
+	return int(ct.RowsAffected()), nil
+}
+
+// mailRetryBackoff doubles the time to wait after each failed attempt, starting at one minute and up to 12 hours.
+func mailRetryBackoff(attempts int) time.Duration {
+	const max = 12 * time.Hour
+	if attempts > 10 {
+		return max
+	}
+	if d := time.Minute << uint(attempts-1); d < max {
+		return d
+	}
+	return max
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useMemoryTransport on the core, and return it so tests can read the delivered messages.
+func useMemoryTransport(core *Core) *MemoryTransport {
+	transport := &MemoryTransport{}
+	core.MailTransport = transport
+	core.Settings.TemplatesDirectory = "../../templates"
+	return transport
+}
+
+// deliverTestMail delivers the queued emails.
+func deliverTestMail(t *testing.T, core *Core) {
+	t.Helper()
+	mailer := Mailer{core: core}
+	if _, err := mailer.ProcessQueue(context.Background()); err != nil {
+		t.Fatalf("cannot deliver queued emails: %v", err)
+	}
+}
+
+func TestMailerRender(t *testing.T) {
+	core := &Core{}
+	core.Settings.PublicURL = "https://www.example.com/"
+	useMemoryTransport(core)
+	mailer := Mailer{core: core}
+	order := &Order{
+		OrderID: "o1",
+		Items:   []OrderItem{{ProductName: "Display", VariantName: "Black", Price: Price{Amount: 99900, Currency: "EUR"}, Quantity: 2}},
+		Total:   Price{Amount: 199800, Currency: "EUR"},
+	}
+	testCases := []struct {
+		name    string
+		content interface{}
+		subject string
+		want    []string
+	}{
+		{
+			name:    "verify-email",
+			content: VerifyEmailMail{Link: "https://www.example.com/signup/verify?token=a.b", ValidHours: 48},
+			subject: "Verify your email address",
+			want:    []string{"https://www.example.com/signup/verify?token=a.b", "48 hours"},
+		},
+		{
+			name:    "account-exists",
+			content: AccountExistsMail{LoginLink: "https://www.example.com/login", RecoverLink: "https://www.example.com/recover"},
+			subject: "You already have an account",
+			want:    []string{"https://www.example.com/login", "https://www.example.com/recover"},
+		},
+		{
+			name:    "reset-password",
+			content: ResetPasswordMail{Link: "https://www.example.com/recover/reset?token=x", ValidMinutes: 60},
+			subject: "Reset your password",
+			want:    []string{"https://www.example.com/recover/reset?token=x", "60 minutes"},
+		},
+		{
+			name:    "change-email",
+			content: ChangeEmailMail{Email: "jane@example.net", Link: "https://www.example.com/account/email/confirm?token=a.b", ValidHours: 48},
+			subject: "Confirm your new email address",
+			want:    []string{"jane@example.net", "https://www.example.com/account/email/confirm?token=a.b", "48 hours"},
+		},
+		{
+			name:    "email-in-use",
+			content: EmailInUseMail{RecoverLink: "https://www.example.com/recover"},
+			subject: "Someone tried to use your email address",
+			want:    []string{"https://www.example.com/recover"},
+		},
+		{
+			name:    "email-changed",
+			content: EmailChangedMail{Email: "jane@example.net"},
+			subject: "Your email address was changed",
+			want:    []string{"jane@example.net"},
+		},
+		{
+			name:    "order-confirmation",
+			content: OrderMail{Order: order, Link: "https://www.example.com/account/orders/o1"},
+			subject: "Your order o1 is confirmed",
+			want:    []string{"Display (Black)", "https://www.example.com/account/orders/o1"},
+		},
+		{
+			name:    "order-shipped",
+			content: OrderMail{Order: order, Link: "https://www.example.com/account/orders/o1"},
+			subject: "Your order o1 has shipped",
+			want:    []string{"Display (Black)", "https://www.example.com/account/orders/o1"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := mailer.Render(tc.name, MailData{Name: "<Jane>", Content: tc.content})
+			if err != nil {
+				t.Fatalf("cannot render email: %v", err)
+			}
+			if m.Subject != tc.subject {
+				t.Errorf("wanted subject %q, got %q instead", tc.subject, m.Subject)
+			}
+			for _, want := range append(tc.want, "https://www.example.com") {
+				if !strings.Contains(m.Text, want) || !strings.Contains(m.HTML, want) {
+					t.Errorf("wanted both text and HTML bodies to contain %q, got %q and %q instead", want, m.Text, m.HTML)
+				}
+			}
+			if !strings.Contains(m.Text, "Hi <Jane>,") || !strings.Contains(m.HTML, "Hi &lt;Jane&gt;,") {
+				t.Errorf("wanted name to be escaped on HTML body only, got %q and %q instead", m.Text, m.HTML)
+			}
+		})
+	}
+	if _, err := mailer.Render("unknown", MailData{}); err == nil {
+		t.Error("wanted error rendering unknown email")
+	}
+}
+
+func TestMailRetryBackoff(t *testing.T) {
+	testCases := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{5, 16 * time.Minute},
+		{10, 512 * time.Minute},
+		{11, 12 * time.Hour},
+		{100, 12 * time.Hour},
+	}
+	for _, tc := range testCases {
+		if got := mailRetryBackoff(tc.attempts); got != tc.want {
+			t.Errorf("wanted backoff %v after %d attempts, got %v instead", tc.want, tc.attempts, got)
+		}
+	}
+}
+
+// flakyTransport fails to deliver emails until it is fixed.
+type flakyTransport struct {
+	MemoryTransport
+	broken bool
+}
+
+func (f *flakyTransport) Send(ctx context.Context, m Message) error {
+	if f.broken {
+		return errors.New("connection refused")
+	}
+	return f.MemoryTransport.Send(ctx, m)
+}
+
+func TestMailerQueue(t *testing.T) {
+	core := newTestCore(t)
+	useMemoryTransport(core)
+	transport := &flakyTransport{broken: true}
+	core.MailTransport = transport
+	ctx := context.Background()
+	mailer := Mailer{core: core}
+
+	if err := mailer.Send(ctx, "jane@example.com", "reset-password", MailData{Name: "Jane", Content: ResetPasswordMail{Link: "x"}}); err != nil {
+		t.Fatalf("cannot queue email: %v", err)
+	}
+	if sent, err := mailer.ProcessQueue(ctx); err != nil || sent != 0 {
+		t.Fatalf("wanted no email to be sent while transport is broken, got %d (error: %v)", sent, err)
+	}
+	var attempts int
+	var lastError string
+	if err := core.Postgres.QueryRow(ctx, `SELECT "attempts", "last_error" FROM mail_queue`).Scan(&attempts, &lastError); err != nil {
+		t.Fatalf("cannot get queued email: %v", err)
+	}
+	if attempts != 1 || lastError != "connection refused" {
+		t.Errorf("wanted failed attempt to be recorded, got %d attempts (last error: %q)", attempts, lastError)
+	}
+
+	transport.broken = false
+	if sent, err := mailer.ProcessQueue(ctx); err != nil || sent != 0 {
+		t.Fatalf("wanted email to be retried only after the backoff, got %d sent (error: %v)", sent, err)
+	}
+	if _, err := core.Postgres.Exec(ctx, `UPDATE mail_queue SET "next_attempt_at" = NOW()`); err != nil {
+		t.Fatal(err)
+	}
+	if sent, err := mailer.ProcessQueue(ctx); err != nil || sent != 1 {
+		t.Fatalf("wanted email to be sent, got %d (error: %v)", sent, err)
+	}
+	if got := transport.Messages(); len(got) != 1 || got[0].To != "jane@example.com" || got[0].Subject != "Reset your password" || got[0].HTML == "" {
+		t.Errorf("unexpected messages %+v", got)
+	}
+	var queued int
+	if err := core.Postgres.QueryRow(ctx, `SELECT COUNT(*) FROM mail_queue`).Scan(&queued); err != nil || queued != 0 {
+		t.Errorf("wanted queue to be empty, got %d emails (error: %v)", queued, err)
+	}
+}
+
+func TestMailerLeaseAndFailed(t *testing.T) {
+	core := newTestCore(t)
+	useMemoryTransport(core)
+	transport := &flakyTransport{broken: true}
+	core.MailTransport = transport
+	ctx := context.Background()
+	mailer := Mailer{core: core}
+
+	if err := mailer.Send(ctx, "jane@example.com", "reset-password", MailData{Name: "Jane", Content: ResetPasswordMail{Link: "x"}}); err != nil {
+		t.Fatalf("cannot queue email: %v", err)
+	}
+	// A worker that stopped after claiming the email, without recording the result.
+	if _, err := core.Postgres.Exec(ctx, `UPDATE mail_queue SET "next_attempt_at" = $1`, time.Now().Add(mailLease)); err != nil {
+		t.Fatal(err)
+	}
+	if sent, err := mailer.ProcessQueue(ctx); err != nil || sent != 0 {
+		t.Fatalf("wanted leased email not to be sent, got %d (error: %v)", sent, err)
+	}
+	var attempts int
+	if err := core.Postgres.QueryRow(ctx, `SELECT "attempts" FROM mail_queue`).Scan(&attempts); err != nil || attempts != 0 {
+		t.Errorf("wanted leased email not to be attempted, got %d attempts (error: %v)", attempts, err)
+	}
+
+	if _, err := core.Postgres.Exec(ctx, `UPDATE mail_queue SET "attempts" = $1, "last_error" = 'connection refused', "next_attempt_at" = NOW()`, mailMaxAttempts); err != nil {
+		t.Fatal(err)
+	}
+	transport.broken = false
+	if sent, err := mailer.ProcessQueue(ctx); err != nil || sent != 0 {
+		t.Fatalf("wanted email given up on not to be sent, got %d (error: %v)", sent, err)
+	}
+	failed, err := mailer.FailedMail(ctx)
+	if err != nil {
+		t.Fatalf("cannot list failed emails: %v", err)
+	}
+	if len(failed) != 1 || failed[0].To != "jane@example.com" || failed[0].Subject != "Reset your password" || failed[0].LastError != "connection refused" {
+		t.Errorf("unexpected failed emails %+v", failed)
+	}
+	if n, err := mailer.PurgeFailed(ctx); err != nil || n != 0 {
+		t.Errorf("wanted recently failed email to be kept, got %d purged (error: %v)", n, err)
+	}
+	if _, err := core.Postgres.Exec(ctx, `UPDATE mail_queue SET "next_attempt_at" = NOW() - INTERVAL '8 days'`); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := mailer.PurgeFailed(ctx); err != nil || n != 1 {
+		t.Errorf("wanted old failed email to be purged, got %d (error: %v)", n, err)
+	}
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/plifk/market/internal/config"
+)
+
+// Message to send by email.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+
+	// HTML body. Optional.
+	HTML string
+}
+
+// MailTransport delivers emails.
+// Use the Mailer module to send emails instead of a transport directly, as it queues them.
+type MailTransport interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// ErrMailTransportUnavailable is returned when no mail transport is configured.
+var ErrMailTransportUnavailable = errors.New("mail transport is not configured")
+
+// Mail transports.
+const (
+	SMTPMailTransport = "smtp"
+	FileMailTransport = "file"
+)
+
+// NewMailTransport returns the mail transport of the settings.
+// An empty transport returns no transport, meaning emails cannot be sent.
+func NewMailTransport(s config.Settings) (MailTransport, error) {
+	if s.MailTransport != "" {
+		if _, err := mail.ParseAddress(s.MailFrom); err != nil {
+			return nil, fmt.Errorf("invalid sender email address: %w", err)
+		}
+	}
+	switch s.MailTransport {
+	case "":
+		return nil, nil
+	case SMTPMailTransport:
+		if _, _, err := net.SplitHostPort(s.SMTPAddress); err != nil {
+			return nil, fmt.Errorf("invalid SMTP address: %w", err)
+		}
+		return &SMTPTransport{
+			Address:  s.SMTPAddress,
+			Username: s.SMTPUsername,
+			Password: s.SMTPPassword,
+			From:     s.MailFrom,
+		}, nil
+	case FileMailTransport:
+		if s.MailDirectory == "" {
+			return nil, errors.New("missing mail directory")
+		}
+		return &FileTransport{
+			Directory: s.MailDirectory,
+			From:      s.MailFrom,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown mail transport %q", s.MailTransport)
+}
+
+// smtpTimeout to connect to the mail server and send an email.
+const smtpTimeout = 30 * time.Second
+
+// SMTPTransport sends emails through a mail server.
+type SMTPTransport struct {
+	Address  string
+	Username string
+	Password string
+	From     string
+}
+
+// Send email.
+// Like smtp.SendMail, STARTTLS is used if the mail server supports it, but it gives up after smtpTimeout.
+func (s *SMTPTransport) Send(ctx context.Context, m Message) error {
+	msg, err := m.encode(s.From, time.Now())
+	if err != nil {
+		return err
+	}
+	from, _ := mail.ParseAddress(s.From) // Checked by NewMailTransport.
+	ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+	defer cancel()
+	dialer := net.Dialer{Timeout: smtpTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.Address)
+	if err != nil {
+		return fmt.Errorf("cannot connect to mail server: %w", err)
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return fmt.Errorf("cannot connect to mail server: %w", err)
+	}
+	host, _, _ := net.SplitHostPort(s.Address)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return fmt.Errorf("cannot connect to mail server: %w", err)
+	}
+	defer c.Close()
+	if err := s.send(c, host, from.Address, m.To, msg); err != nil {
+		return fmt.Errorf("cannot send email: %w", err)
+	}
+	return nil
+}
+
+func (s *SMTPTransport) send(c *smtp.Client, host, from, to string, msg []byte) error {
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// FileTransport saves emails as .eml files to a directory instead of sending them.
+// It must not be used in production.
+type FileTransport struct {
+	Directory string
+	From      string
+}
+
+// Send email by saving it to the directory.
+func (f *FileTransport) Send(ctx context.Context, m Message) error {
+	now := time.Now()
+	msg, err := m.encode(f.From, now)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405"), new11RandomID())
+	if err := ioutil.WriteFile(filepath.Join(f.Directory, name), msg, 0600); err != nil {
+		return fmt.Errorf("cannot save email: %w", err)
+	}
+	return nil
+}
+
+// MemoryTransport keeps emails in memory instead of sending them. Useful for tests.
+type MemoryTransport struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// Send email by keeping it in memory.
+func (t *MemoryTransport) Send(ctx context.Context, m Message) error {
+	if err := validateEmail(m.To); err != nil {
+		return fmt.Errorf("invalid recipient: %w", err)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.messages = append(t.messages, m)
+	return nil
+}
+
+// Messages sent so far.
+func (t *MemoryTransport) Messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Message{}, t.messages...)
+}
+
+// encode message in the Internet Message Format (RFC 5322).
+// Messages with an HTML body are sent as multipart/alternative, with the text body first.
+func (m Message) encode(from string, date time.Time) ([]byte, error) {
+	if err := validateEmail(m.To); err != nil {
+		return nil, fmt.Errorf("invalid recipient: %w", err)
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	if m.HTML == "" {
+		b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&b, m.Text); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plifk/market/internal/config"
+)
+
+func TestNewMailTransport(t *testing.T) {
+	testCases := []struct {
+		name     string
+		settings config.Settings
+		wantErr  bool
+	}{
+		{name: "none"},
+		{name: "file", settings: config.Settings{MailTransport: "file", MailFrom: "market@example.com", MailDirectory: "/tmp"}},
+		{name: "smtp", settings: config.Settings{MailTransport: "smtp", MailFrom: "Market <market@example.com>", SMTPAddress: "localhost:1025"}},
+		{name: "missing directory", settings: config.Settings{MailTransport: "file", MailFrom: "market@example.com"}, wantErr: true},
+		{name: "missing port", settings: config.Settings{MailTransport: "smtp", MailFrom: "market@example.com", SMTPAddress: "localhost"}, wantErr: true},
+		{name: "invalid sender", settings: config.Settings{MailTransport: "file", MailFrom: "market", MailDirectory: "/tmp"}, wantErr: true},
+		{name: "unknown", settings: config.Settings{MailTransport: "pigeon", MailFrom: "market@example.com"}, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewMailTransport(tc.settings); (err != nil) != tc.wantErr {
+				t.Errorf("wanted error = %v, got %v instead", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestFileTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "market-mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := &FileTransport{Directory: dir, From: "Market <market@example.com>"}
+	if err := m.Send(context.Background(), Message{To: "jane@example.com", Subject: "Olá", Text: "Hi Jane"}); err != nil {
+		t.Fatalf("cannot send email: %v", err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("wanted a single email, got %v (error: %v) instead", files, err)
+	}
+	b, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"From: Market <market@example.com>\r\n", "To: jane@example.com\r\n", "Subject: =?utf-8?q?Ol=C3=A1?=\r\n", "\r\n\r\nHi Jane"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("wanted email to contain %q, got %q instead", want, b)
+		}
+	}
+
+	if err := m.Send(context.Background(), Message{To: "jane@example.com\r\nBcc: eve@example.com", Text: "x"}); err == nil {
+		t.Error("wanted error sending email to invalid recipient")
+	}
+}
+
+func TestSMTPTransportTimeout(t *testing.T) {
+	// The mail server accepts the connection, but never greets the client.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	s := &SMTPTransport{Address: l.Addr().String(), From: "market@example.com"}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := s.Send(ctx, Message{To: "jane@example.com", Subject: "Hi", Text: "Hi Jane"}); err == nil {
+		t.Error("wanted error sending email to unresponsive mail server")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("wanted sending to give up with the context, took %v instead", elapsed)
+	}
+}
+
+func TestMessageEncodeHTML(t *testing.T) {
+	m := Message{To: "jane@example.com", Subject: "Hi", Text: "Hi Jane", HTML: "<p>Hi Jane</p>"}
+	b, err := m.encode("market@example.com", time.Now())
+	if err != nil {
+		t.Fatalf("cannot encode message: %v", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("cannot read encoded message: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("wanted multipart/alternative message, got %q (error: %v) instead", mediaType, err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for _, want := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		p, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("cannot read part: %v", err)
+		}
+		body, err := ioutil.ReadAll(p) // Quoted-printable is decoded by the multipart reader.
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ct := p.Header.Get("Content-Type"); ct != want.contentType || string(body) != want.body {
+			t.Errorf("wanted part %q with body %q, got %q with body %q instead", want.contentType, want.body, ct, body)
+		}
+	}
+}
+
+func TestMemoryTransport(t *testing.T) {
+	var m MemoryTransport
+	if err := m.Send(context.Background(), Message{To: "jane@example.com", Subject: "Hi"}); err != nil {
+		t.Fatalf("cannot send email: %v", err)
+	}
+	if err := m.Send(context.Background(), Message{To: "jane"}); err == nil {
+		t.Error("wanted error sending email to invalid recipient")
+	}
+	if got := m.Messages(); len(got) != 1 || got[0].Subject != "Hi" {
+		t.Errorf("unexpected messages: %+v", got)
+	}
+}
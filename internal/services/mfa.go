@@ -0,0 +1,321 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/plifk/market/internal/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MFA manages the two-factor authentication of users with time-based one-time passwords (TOTP) and recovery codes.
+type MFA struct {
+	core *Core
+}
+
+const (
+	// mfaIssuer shown on authenticator apps.
+	mfaIssuer = "Market"
+
+	// recoveryCodesCount generated for each user.
+	recoveryCodesCount = 10
+
+	// mfaLoginPurpose of the signed tokens carrying the first login step to the second one.
+	mfaLoginPurpose = "mfa-login"
+
+	// mfaLoginLifetime is how long the user has to enter the code after entering the password.
+	mfaLoginLifetime = 5 * time.Minute
+)
+
+var (
+	// ErrInvalidMFACode is returned when a TOTP or recovery code is wrong, or was already used.
+	ErrInvalidMFACode = errors.New("invalid verification code")
+
+	// ErrMFANotEnabled is returned when the user didn't enable two-factor authentication.
+	ErrMFANotEnabled = errors.New("two-factor authentication is not enabled")
+
+	// ErrMFAAlreadyEnabled is returned when enrolling a user who already enabled two-factor authentication.
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+
+	// ErrMFARequired is returned when trying to disable two-factor authentication of a user who must use it.
+	ErrMFARequired = errors.New("two-factor authentication is required for your account")
+)
+
+// Required checks if the user must enroll in two-factor authentication, as anyone with access to the admin area does.
+func (m *MFA) Required(u *User) bool {
+	return Can(u, PermissionAdminArea)
+}
+
+// Enabled checks if the user enabled two-factor authentication.
+func (m *MFA) Enabled(ctx context.Context, userID string) (bool, error) {
+	var enabled bool
+	pg := m.core.Postgres
+	const sql = `SELECT "enabled_at" IS NOT NULL FROM users_mfa WHERE "user_id" = $1`
+	switch err := pg.QueryRow(ctx, sql, userID).Scan(&enabled); {
+	case err == pgx.ErrNoRows:
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("cannot check two-factor authentication: %w", err)
+	}
+	return enabled, nil
+}
+
+// MFAEnrollment to add the TOTP secret to an authenticator app.
+type MFAEnrollment struct {
+	Secret string
+
+	// URI with the secret, for authenticator apps.
+	URI string
+}
+
+// StartEnrollment creates a TOTP secret for the user, which is only used after ConfirmEnrollment.
+// Calling it again before confirming returns the same secret, so the user can reload the page.
+func (m *MFA) StartEnrollment(ctx context.Context, u *User) (*MFAEnrollment, error) {
+	pg := m.core.Postgres
+	const sql = `INSERT INTO users_mfa ("user_id", "totp_secret", "last_used_step", "created_at") VALUES ($1, $2, 0, NOW())
+ON CONFLICT ("user_id") DO UPDATE SET "user_id" = EXCLUDED."user_id" RETURNING "totp_secret", "enabled_at" IS NOT NULL`
+	var (
+		secret  string
+		enabled bool
+	)
+	if err := pg.QueryRow(ctx, sql, u.UserID, totp.NewSecret()).Scan(&secret, &enabled); err != nil {
+		return nil, fmt.Errorf("cannot create TOTP secret: %w", err)
+	}
+	if enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	return &MFAEnrollment{
+		Secret: secret,
+		URI:    totp.URI(secret, mfaIssuer, u.Email),
+	}, nil
+}
+
+// ConfirmEnrollment enables two-factor authentication after the user enters a valid code from their authenticator app.
+// It returns the recovery codes of the user, which are only known now.
+func (m *MFA) ConfirmEnrollment(ctx context.Context, userID, code string) (recoveryCodes []string, err error) {
+	tx, err := m.core.Postgres.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot enable two-factor authentication: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	var (
+		secret  string
+		enabled bool
+	)
+	const sql = `SELECT "totp_secret", "enabled_at" IS NOT NULL FROM users_mfa WHERE "user_id" = $1 FOR UPDATE`
+	switch err := tx.QueryRow(ctx, sql, userID).Scan(&secret, &enabled); {
+	case err == pgx.ErrNoRows:
+		return nil, ErrMFANotEnabled
+	case err != nil:
+		return nil, fmt.Errorf("cannot enable two-factor authentication: %w", err)
+	case enabled:
+		return nil, ErrMFAAlreadyEnabled
+	}
+	step, ok := totp.Validate(secret, normalizeMFACode(code), time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+	const enableSQL = `UPDATE users_mfa SET "enabled_at" = NOW(), "last_used_step" = $2 WHERE "user_id" = $1`
+	if _, err := tx.Exec(ctx, enableSQL, userID, step); err != nil {
+		return nil, fmt.Errorf("cannot enable two-factor authentication: %w", err)
+	}
+	if recoveryCodes, err = replaceRecoveryCodes(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("cannot enable two-factor authentication: %w", err)
+	}
+	return recoveryCodes, nil
+}
+
+// Verify a TOTP or recovery code of the user. Each code can be used only once.
+func (m *MFA) Verify(ctx context.Context, userID, code string) error {
+	code = normalizeMFACode(code)
+	tx, err := m.core.Postgres.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot verify code: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	var secret string
+	var lastUsedStep int64
+	const sql = `SELECT "totp_secret", "last_used_step" FROM users_mfa WHERE "user_id" = $1 AND "enabled_at" IS NOT NULL FOR UPDATE`
+	switch err := tx.QueryRow(ctx, sql, userID).Scan(&secret, &lastUsedStep); {
+	case err == pgx.ErrNoRows:
+		return ErrMFANotEnabled
+	case err != nil:
+		return fmt.Errorf("cannot verify code: %w", err)
+	}
+
+	if len(code) == totp.Digits {
+		// Rejecting codes of the last used step or before prevents replaying a code seen by someone else.
+		step, ok := totp.Validate(secret, code, time.Now())
+		if !ok || step <= lastUsedStep {
+			return ErrInvalidMFACode
+		}
+		const stepSQL = `UPDATE users_mfa SET "last_used_step" = $2 WHERE "user_id" = $1`
+		if _, err := tx.Exec(ctx, stepSQL, userID, step); err != nil {
+			return fmt.Errorf("cannot verify code: %w", err)
+		}
+	} else if err := useRecoveryCode(ctx, tx, userID, code); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("cannot verify code: %w", err)
+	}
+	return nil
+}
+
+// Disable two-factor authentication, after verifying a code of the user.
+func (m *MFA) Disable(ctx context.Context, u *User, code string) error {
+	if m.Required(u) {
+		return ErrMFARequired
+	}
+	if err := m.Verify(ctx, u.UserID, code); err != nil {
+		return err
+	}
+	tx, err := m.core.Postgres.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot disable two-factor authentication: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `DELETE FROM users_recovery_codes WHERE "user_id" = $1`, u.UserID); err != nil {
+		return fmt.Errorf("cannot delete recovery codes: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM users_mfa WHERE "user_id" = $1`, u.UserID); err != nil {
+		return fmt.Errorf("cannot disable two-factor authentication: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("cannot disable two-factor authentication: %w", err)
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes of the user after verifying a code, invalidating the previous ones.
+func (m *MFA) RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error) {
+	if err := m.Verify(ctx, userID, code); err != nil {
+		return nil, err
+	}
+	tx, err := m.core.Postgres.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create recovery codes: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("cannot create recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// RemainingRecoveryCodes of the user.
+func (m *MFA) RemainingRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	var n int
+	pg := m.core.Postgres
+	const sql = `SELECT COUNT(*) FROM users_recovery_codes WHERE "user_id" = $1`
+	if err := pg.QueryRow(ctx, sql, userID).Scan(&n); err != nil {
+		return 0, fmt.Errorf("cannot count recovery codes: %w", err)
+	}
+	return n, nil
+}
+
+// NewLoginChallenge is given to a user who entered the right password, but still needs to enter a code.
+// The challenge is signed, so it cannot be forged.
+func (m *MFA) NewLoginChallenge(userID string, rememberMe bool) (string, error) {
+	remember := "0"
+	if rememberMe {
+		remember = "1"
+	}
+	return signToken(m.core.Settings.SecretKey, mfaLoginPurpose, time.Now().Add(mfaLoginLifetime), userID, remember)
+}
+
+// ParseLoginChallenge returns the user who entered the right password.
+// The second login step is completed by verifying a code of the user.
+func (m *MFA) ParseLoginChallenge(challenge string) (userID string, rememberMe bool, err error) {
+	fields, err := verifySignedToken(m.core.Settings.SecretKey, mfaLoginPurpose, challenge)
+	if err != nil {
+		return "", false, err
+	}
+	if len(fields) != 2 {
+		return "", false, ErrInvalidSignedToken
+	}
+	return fields[0], fields[1] == "1", nil
+}
+
+// normalizeMFACode removes spaces and dashes users might type, and lowercases recovery codes.
+func normalizeMFACode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+func replaceRecoveryCodes(ctx context.Context, q pgQuerier, userID string) ([]string, error) {
+	if _, err := q.Exec(ctx, `DELETE FROM users_recovery_codes WHERE "user_id" = $1`, userID); err != nil {
+		return nil, fmt.Errorf("cannot delete recovery codes: %w", err)
+	}
+	codes := make([]string, recoveryCodesCount)
+	for i := range codes {
+		codes[i] = newRecoveryCode()
+		hash, err := bcrypt.GenerateFromPassword([]byte(normalizeMFACode(codes[i])), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("cannot hash recovery code: %w", err)
+		}
+		const sql = `INSERT INTO users_recovery_codes ("user_id", "code_hash", "created_at") VALUES ($1, $2, NOW())`
+		if _, err := q.Exec(ctx, sql, userID, hash); err != nil {
+			return nil, fmt.Errorf("cannot save recovery code: %w", err)
+		}
+	}
+	return codes, nil
+}
+
+// useRecoveryCode deletes the recovery code of the user, if it exists.
+// Recovery codes are hashed with bcrypt like passwords, so we need to compare the code with each hash.
+func useRecoveryCode(ctx context.Context, tx pgx.Tx, userID, code string) error {
+	rows, err := tx.Query(ctx, `SELECT "code_hash" FROM users_recovery_codes WHERE "user_id" = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("cannot get recovery codes: %w", err)
+	}
+	var hashes []string
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			rows.Close()
+			return fmt.Errorf("cannot read recovery code: %w", err)
+		}
+		hashes = append(hashes, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("cannot get recovery codes: %w", err)
+	}
+	for _, h := range hashes {
+		if bcrypt.CompareHashAndPassword([]byte(h), []byte(code)) != nil {
+			continue
+		}
+		const sql = `DELETE FROM users_recovery_codes WHERE "user_id" = $1 AND "code_hash" = $2`
+		if _, err := tx.Exec(ctx, sql, userID, h); err != nil {
+			return fmt.Errorf("cannot use recovery code: %w", err)
+		}
+		return nil
+	}
+	return ErrInvalidMFACode
+}
+
+// newRecoveryCode generates a code such as "7kq2m-x9fhd", with about 50 bits of entropy.
+func newRecoveryCode() string {
+	const alphabet = "23456789abcdefghjkmnpqrstuvwxyz" // Without easily confused characters such as 0, o, 1, l, and i.
+	var r = make([]byte, 10)
+	if _, err := rand.Read(r); err != nil {
+		panic(err)
+	}
+	code := make([]byte, len(r))
+	for i, b := range r {
+		code[i] = alphabet[int(b)%len(alphabet)]
+	}
+	return string(code[:5]) + "-" + string(code[5:])
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/plifk/market/internal/config"
+	"github.com/plifk/market/internal/totp"
+)
+
+func TestNewRecoveryCode(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		code := newRecoveryCode()
+		if !regexp.MustCompile(`^[2-9a-hjkmnp-z]{5}-[2-9a-hjkmnp-z]{5}$`).MatchString(code) {
+			t.Errorf("unexpected recovery code format: %q", code)
+		}
+		if seen[code] {
+			t.Errorf("recovery code %q generated twice", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestNormalizeMFACode(t *testing.T) {
+	testCases := map[string]string{
+		"123456":       "123456",
+		"123 456":      "123456",
+		"7KQ2M-X9FHD":  "7kq2mx9fhd",
+		" 7kq2m x9fhd": "7kq2mx9fhd",
+	}
+	for code, want := range testCases {
+		if got := normalizeMFACode(code); got != want {
+			t.Errorf("normalizeMFACode(%q) = %q, wanted %q", code, got, want)
+		}
+	}
+}
+
+func TestMFALoginChallenge(t *testing.T) {
+	m := MFA{core: &Core{Settings: config.Settings{SecretKey: "key"}}}
+	challenge, err := m.NewLoginChallenge("u1", true)
+	if err != nil {
+		t.Fatalf("cannot create login challenge: %v", err)
+	}
+	other, err := signToken("key", "verify-email", time.Now().Add(time.Hour), "u1", "1")
+	if err != nil {
+		t.Fatalf("cannot sign token: %v", err)
+	}
+	if userID, rememberMe, err := m.ParseLoginChallenge(challenge); err != nil || userID != "u1" || !rememberMe {
+		t.Errorf("cannot parse login challenge: got user %q, remember me %v (error: %v)", userID, rememberMe, err)
+	}
+	for _, c := range []string{"", challenge + "x", other} {
+		if _, _, err := m.ParseLoginChallenge(c); err != ErrInvalidSignedToken {
+			t.Errorf("wanted error %v for challenge %q, got %v instead", ErrInvalidSignedToken, c, err)
+		}
+	}
+}
+
+func TestMFA(t *testing.T) {
+	core := newTestCore(t)
+	core.Settings.SecretKey = "key"
+	ctx := context.Background()
+	accounts := Accounts{core: core}
+	m := MFA{core: core}
+
+	u, err := accounts.Signup(ctx, SignupParams{Name: "Jane Doe", Email: "jane@example.com", Password: "correct horse battery staple"})
+	if err != nil {
+		t.Fatalf("cannot sign up: %v", err)
+	}
+	if m.Required(u) {
+		t.Error("two-factor authentication should be optional for customers")
+	}
+	enrollment, err := m.StartEnrollment(ctx, u)
+	if err != nil {
+		t.Fatalf("cannot start enrollment: %v", err)
+	}
+	if again, err := m.StartEnrollment(ctx, u); err != nil || again.Secret != enrollment.Secret {
+		t.Errorf("wanted the same secret when starting enrollment again, got %+v (error: %v)", again, err)
+	}
+	if enabled, err := m.Enabled(ctx, u.UserID); err != nil || enabled {
+		t.Errorf("wanted two-factor authentication disabled before confirmation, got %v (error: %v)", enabled, err)
+	}
+	if _, err := m.ConfirmEnrollment(ctx, u.UserID, "000000"); err != ErrInvalidMFACode {
+		t.Errorf("wanted error %v confirming with a wrong code, got %v instead", ErrInvalidMFACode, err)
+	}
+
+	step := totp.Step(time.Now())
+	code, err := totp.Code(enrollment.Secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recoveryCodes, err := m.ConfirmEnrollment(ctx, u.UserID, code)
+	if err != nil {
+		t.Fatalf("cannot confirm enrollment: %v", err)
+	}
+	if len(recoveryCodes) != recoveryCodesCount {
+		t.Errorf("wanted %d recovery codes, got %v instead", recoveryCodesCount, recoveryCodes)
+	}
+	if enabled, err := m.Enabled(ctx, u.UserID); err != nil || !enabled {
+		t.Errorf("wanted two-factor authentication enabled, got %v (error: %v)", enabled, err)
+	}
+
+	if err := m.Verify(ctx, u.UserID, code); err != ErrInvalidMFACode {
+		t.Errorf("wanted error %v replaying code, got %v instead", ErrInvalidMFACode, err)
+	}
+	next, err := totp.Code(enrollment.Secret, step+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Verify(ctx, u.UserID, next); err != nil {
+		t.Errorf("cannot verify code: %v", err)
+	}
+
+	if err := m.Verify(ctx, u.UserID, recoveryCodes[0]); err != nil {
+		t.Errorf("cannot verify recovery code: %v", err)
+	}
+	if err := m.Verify(ctx, u.UserID, recoveryCodes[0]); err != ErrInvalidMFACode {
+		t.Errorf("wanted error %v reusing recovery code, got %v instead", ErrInvalidMFACode, err)
+	}
+	if n, err := m.RemainingRecoveryCodes(ctx, u.UserID); err != nil || n != recoveryCodesCount-1 {
+		t.Errorf("wanted %d remaining recovery codes, got %d (error: %v)", recoveryCodesCount-1, n, err)
+	}
+
+	if err := m.Disable(ctx, u, recoveryCodes[1]); err != nil {
+		t.Fatalf("cannot disable two-factor authentication: %v", err)
+	}
+	if enabled, err := m.Enabled(ctx, u.UserID); err != nil || enabled {
+		t.Errorf("wanted two-factor authentication disabled, got %v (error: %v)", enabled, err)
+	}
+	if err := m.Disable(ctx, &User{UserID: u.UserID, Access: AdminAuthorization}, "000000"); err != ErrMFARequired {
+		t.Errorf("wanted error %v disabling two-factor authentication of an admin, got %v instead", ErrMFARequired, err)
+	}
+}
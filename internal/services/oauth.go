@@ -0,0 +1,522 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// OAuth token lifetimes.
+const (
+	oauthCodeLifetime         = 10 * time.Minute
+	OAuthAccessTokenLifetime  = time.Hour
+	OAuthRefreshTokenLifetime = 30 * 24 * time.Hour
+)
+
+// OAuthClient is a third-party application that can act on behalf of users who authorize it.
+type OAuthClient struct {
+	ClientID     string
+	Name         string
+	RedirectURIs []string
+
+	// Scopes the client can ask users for.
+	Scopes []TokenScope
+
+	// Confidential clients authenticate with a secret on the token endpoint.
+	// Public clients, such as mobile apps, cannot keep a secret, and rely on PKCE only.
+	Confidential bool
+
+	CreatedAt time.Time
+}
+
+// OAuthError is an error defined by the OAuth 2.0 specification (RFC 6749), returned to clients as is.
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+var (
+	// ErrOAuthClientNotFound occurs when no OAuth client is found.
+	ErrOAuthClientNotFound = errors.New("OAuth client not found")
+
+	// ErrOAuthRedirectURIMismatch is returned when the redirect URI of an authorization request isn't registered for the client.
+	// Users must not be redirected to it.
+	ErrOAuthRedirectURIMismatch = errors.New("redirect URI is not registered for the OAuth client")
+)
+
+// OAuth authorization server.
+type OAuth struct {
+	core *Core
+}
+
+// NewOAuthClientParams to register an OAuth client.
+type NewOAuthClientParams struct {
+	Name         string
+	RedirectURIs []string
+	Scopes       []TokenScope
+	Confidential bool
+}
+
+// ValidateAndNormalize the parameters.
+func (p *NewOAuthClientParams) ValidateAndNormalize() error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return errors.New("missing client name")
+	}
+	if len(p.Name) > 100 {
+		return errors.New("client name is too long")
+	}
+	var uris []string
+	for _, uri := range p.RedirectURIs {
+		uri = strings.TrimSpace(uri)
+		if uri == "" {
+			continue
+		}
+		if err := validateRedirectURI(uri); err != nil {
+			return err
+		}
+		uris = append(uris, uri)
+	}
+	if len(uris) == 0 {
+		return errors.New("client must have at least one redirect URI")
+	}
+	p.RedirectURIs = uris
+	tp := NewTokenParams{Name: p.Name, Kind: PersonalToken, Scopes: p.Scopes}
+	if err := tp.ValidateAndNormalize(); err != nil {
+		return err
+	}
+	p.Scopes = tp.Scopes
+	return nil
+}
+
+// validateRedirectURI allows HTTPS URIs, loopback HTTP URIs, and private-use URI schemes for native apps (RFC 8252).
+func validateRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() {
+		return fmt.Errorf("redirect URI %q must be an absolute URI", uri)
+	}
+	if u.Fragment != "" {
+		return fmt.Errorf("redirect URI %q must not have a fragment", uri)
+	}
+	switch host := u.Hostname(); {
+	case u.Scheme == "https" && host != "":
+	case u.Scheme == "http" && (host == "localhost" || host == "127.0.0.1" || host == "::1"):
+	case strings.Contains(u.Scheme, "."):
+	default:
+		return fmt.Errorf("redirect URI %q must use HTTPS, a loopback address, or a private-use scheme such as com.example.app", uri)
+	}
+	return nil
+}
+
+// NewClient registers an OAuth client. The secret of confidential clients is returned only here.
+func (o *OAuth) NewClient(ctx context.Context, p NewOAuthClientParams) (client *OAuthClient, secret string, err error) {
+	if err = p.ValidateAndNormalize(); err != nil {
+		return nil, "", invalid(err)
+	}
+	client = &OAuthClient{
+		ClientID:     new11RandomID(),
+		Name:         p.Name,
+		RedirectURIs: p.RedirectURIs,
+		Scopes:       p.Scopes,
+		Confidential: p.Confidential,
+	}
+	var secretHash *string
+	if p.Confidential {
+		secret = newAPIToken()
+		h := hashAPIToken(secret)
+		secretHash = &h
+	}
+	pg := o.core.Postgres
+	const sql = `INSERT INTO oauth_clients ("client_id", "name", "secret_hash", "redirect_uris", "scopes", "created_at") VALUES ($1, $2, $3, $4, $5, NOW()) RETURNING "created_at"`
+	if err := pg.QueryRow(ctx, sql, client.ClientID, client.Name, secretHash, client.RedirectURIs, scopesToStrings(client.Scopes)).Scan(&client.CreatedAt); err != nil {
+		return nil, "", fmt.Errorf("cannot create OAuth client: %w", err)
+	}
+	return client, secret, nil
+}
+
+// GetClient registered with the given ID.
+func (o *OAuth) GetClient(ctx context.Context, clientID string) (*OAuthClient, error) {
+	client, _, err := o.getClient(ctx, o.core.Postgres, clientID)
+	return client, err
+}
+
+func (o *OAuth) getClient(ctx context.Context, q pgQuerier, clientID string) (client *OAuthClient, secretHash string, err error) {
+	const sql = `SELECT "client_id", "name", COALESCE("secret_hash", ''), "redirect_uris", "scopes", "created_at" FROM oauth_clients WHERE "client_id" = $1`
+	client, secretHash, err = scanOAuthClient(q.QueryRow(ctx, sql, clientID))
+	switch {
+	case err == pgx.ErrNoRows:
+		return nil, "", ErrOAuthClientNotFound
+	case err != nil:
+		return nil, "", fmt.Errorf("cannot get OAuth client: %w", err)
+	}
+	return client, secretHash, nil
+}
+
+// ListClients registered, most recent first.
+func (o *OAuth) ListClients(ctx context.Context) ([]OAuthClient, error) {
+	pg := o.core.Postgres
+	const sql = `SELECT "client_id", "name", COALESCE("secret_hash", ''), "redirect_uris", "scopes", "created_at" FROM oauth_clients ORDER BY "created_at" DESC`
+	rows, err := pg.Query(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list OAuth clients: %w", err)
+	}
+	defer rows.Close()
+	var clients []OAuthClient
+	for rows.Next() {
+		client, _, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read OAuth client: %w", err)
+		}
+		clients = append(clients, *client)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot list OAuth clients: %w", err)
+	}
+	return clients, nil
+}
+
+func scanOAuthClient(row pgx.Row) (*OAuthClient, string, error) {
+	var (
+		client     OAuthClient
+		secretHash string
+		scopes     []string
+	)
+	if err := row.Scan(&client.ClientID, &client.Name, &secretHash, &client.RedirectURIs, &scopes, &client.CreatedAt); err != nil {
+		return nil, "", err
+	}
+	for _, s := range scopes {
+		client.Scopes = append(client.Scopes, TokenScope(s))
+	}
+	client.Confidential = secretHash != ""
+	return &client, secretHash, nil
+}
+
+// DeleteClient and revoke all codes and tokens issued to it.
+func (o *OAuth) DeleteClient(ctx context.Context, clientID string) error {
+	tx, err := o.core.Postgres.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot delete OAuth client: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, sql := range []string{
+		`DELETE FROM oauth_codes WHERE "client_id" = $1`,
+		`DELETE FROM oauth_refresh_tokens WHERE "client_id" = $1`,
+		`DELETE FROM api_tokens WHERE "client_id" = $1`,
+	} {
+		if _, err := tx.Exec(ctx, sql, clientID); err != nil {
+			return fmt.Errorf("cannot revoke OAuth client grants: %w", err)
+		}
+	}
+	const sql = `DELETE FROM oauth_clients WHERE "client_id" = $1`
+	switch ct, err := tx.Exec(ctx, sql, clientID); {
+	case err != nil:
+		return fmt.Errorf("cannot delete OAuth client: %w", err)
+	case ct.RowsAffected() == 0:
+		return ErrOAuthClientNotFound
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("cannot delete OAuth client: %w", err)
+	}
+	return nil
+}
+
+// AuthorizationRequest from a client, asking the user to authorize it (RFC 6749 section 4.1.1 and RFC 7636).
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// ValidateAuthorizationRequest and return the client and the scopes it asks for.
+// The redirect URI is set to the client's one if it has a single redirect URI and the request omits it.
+//
+// If the client or the redirect URI are invalid, ErrOAuthClientNotFound or ErrOAuthRedirectURIMismatch are returned,
+// and the user must not be redirected to the client.
+// Otherwise, an *OAuthError that should be sent to the client on the redirect URI is returned.
+func (o *OAuth) ValidateAuthorizationRequest(ctx context.Context, req *AuthorizationRequest) (*OAuthClient, []TokenScope, error) {
+	client, err := o.GetClient(ctx, req.ClientID)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case req.RedirectURI == "" && len(client.RedirectURIs) == 1:
+		req.RedirectURI = client.RedirectURIs[0]
+	case !contains(client.RedirectURIs, req.RedirectURI):
+		return nil, nil, ErrOAuthRedirectURIMismatch
+	}
+	if req.ResponseType != "code" {
+		return nil, nil, oauthError("unsupported_response_type", "only the authorization code flow is supported")
+	}
+	if req.CodeChallengeMethod != "S256" || !validPKCEValue(req.CodeChallenge) {
+		return nil, nil, oauthError("invalid_request", "PKCE with the S256 code challenge method is required")
+	}
+	scopes, err := parseOAuthScopes(client, req.Scope)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, scopes, nil
+}
+
+// parseOAuthScopes from a space-separated list, checking that the client is allowed to ask for them.
+func parseOAuthScopes(client *OAuthClient, scope string) ([]TokenScope, error) {
+	var scopes []TokenScope
+	for _, s := range strings.Fields(scope) {
+		ts := TokenScope(s)
+		if !validTokenScope(ts) {
+			return nil, oauthError("invalid_scope", fmt.Sprintf("unknown scope %q", s))
+		}
+		var allowed bool
+		for _, cs := range client.Scopes {
+			allowed = allowed || cs == ts
+		}
+		if !allowed {
+			return nil, oauthError("invalid_scope", fmt.Sprintf("client is not allowed to ask for scope %q", s))
+		}
+		scopes = append(scopes, ts)
+	}
+	if len(scopes) == 0 {
+		return nil, oauthError("invalid_scope", "missing scope")
+	}
+	return scopes, nil
+}
+
+// validPKCEValue checks the format of a code verifier or code challenge (RFC 7636 section 4.1).
+func validPKCEValue(v string) bool {
+	if len(v) < 43 || len(v) > 128 {
+		return false
+	}
+	for _, c := range v {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~') {
+			return false
+		}
+	}
+	return true
+}
+
+// pkceChallenge for a code verifier, using the S256 method.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Authorize a client to act on behalf of the user, returning an authorization code to be exchanged for tokens.
+// It should be called only after the user consents.
+func (o *OAuth) Authorize(ctx context.Context, userID string, req AuthorizationRequest) (code string, err error) {
+	client, scopes, err := o.ValidateAuthorizationRequest(ctx, &req)
+	if err != nil {
+		return "", err
+	}
+	code = newAPIToken()
+	pg := o.core.Postgres
+	const sql = `INSERT INTO oauth_codes ("code_hash", "client_id", "user_id", "redirect_uri", "scopes", "code_challenge", "expires_at") VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	if _, err := pg.Exec(ctx, sql, hashAPIToken(code), client.ClientID, userID, req.RedirectURI, scopesToStrings(scopes), req.CodeChallenge, time.Now().Add(oauthCodeLifetime)); err != nil {
+		return "", fmt.Errorf("cannot save OAuth authorization code: %w", err)
+	}
+	return code, nil
+}
+
+// TokenRequest to the token endpoint (RFC 6749 sections 4.1.3 and 6).
+type TokenRequest struct {
+	GrantType    string
+	ClientID     string
+	ClientSecret string
+
+	// Code, RedirectURI, and CodeVerifier are used with the authorization_code grant type.
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+
+	// RefreshToken and Scope are used with the refresh_token grant type.
+	// Scope can narrow down the scopes of the refresh token.
+	RefreshToken string
+	Scope        string
+}
+
+// TokenResponse of the token endpoint (RFC 6749 section 5.1).
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// Token exchanges an authorization code or a refresh token for a new access token and refresh token.
+// Refresh tokens are rotated: each one can be used only once.
+// Errors caused by the request are returned as *OAuthError.
+func (o *OAuth) Token(ctx context.Context, req TokenRequest) (*TokenResponse, error) {
+	switch {
+	case req.ClientID == "":
+		return nil, oauthError("invalid_client", "missing client ID")
+	case req.GrantType != "authorization_code" && req.GrantType != "refresh_token":
+		return nil, oauthError("unsupported_grant_type", "grant type must be authorization_code or refresh_token")
+	}
+	tx, err := o.core.Postgres.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot issue OAuth token: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	client, err := o.authenticateClient(ctx, tx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	var resp *TokenResponse
+	switch req.GrantType {
+	case "authorization_code":
+		resp, err = o.exchangeCode(ctx, tx, client, req)
+	case "refresh_token":
+		resp, err = o.refresh(ctx, tx, client, req)
+	}
+	var oerr *OAuthError
+	if errors.As(err, &oerr) && oerr.Code == "invalid_grant" {
+		// Codes and refresh tokens are consumed even when the request fails, so they cannot be tried again.
+		if cerr := tx.Commit(ctx); cerr != nil {
+			return nil, fmt.Errorf("cannot invalidate OAuth grant: %w", cerr)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("cannot issue OAuth token: %w", err)
+	}
+	return resp, nil
+}
+
+func (o *OAuth) authenticateClient(ctx context.Context, q pgQuerier, clientID, secret string) (*OAuthClient, error) {
+	client, secretHash, err := o.getClient(ctx, q, clientID)
+	switch {
+	case err == ErrOAuthClientNotFound:
+		return nil, oauthError("invalid_client", "unknown client")
+	case err != nil:
+		return nil, err
+	}
+	if client.Confidential && subtle.ConstantTimeCompare([]byte(hashAPIToken(secret)), []byte(secretHash)) != 1 {
+		return nil, oauthError("invalid_client", "client authentication failed")
+	}
+	return client, nil
+}
+
+func (o *OAuth) exchangeCode(ctx context.Context, tx pgx.Tx, client *OAuthClient, req TokenRequest) (*TokenResponse, error) {
+	if req.Code == "" || req.CodeVerifier == "" {
+		return nil, oauthError("invalid_request", "code and code_verifier are required")
+	}
+	// Codes are deleted when used, so they cannot be used twice.
+	const sql = `DELETE FROM oauth_codes WHERE "code_hash" = $1 RETURNING "client_id", "user_id", "redirect_uri", "scopes", "code_challenge", "expires_at"`
+	var (
+		clientID, userID, redirectURI, challenge string
+		scopes                                   []string
+		expiresAt                                time.Time
+	)
+	switch err := tx.QueryRow(ctx, sql, hashAPIToken(req.Code)).Scan(&clientID, &userID, &redirectURI, &scopes, &challenge, &expiresAt); {
+	case err == pgx.ErrNoRows:
+		return nil, oauthError("invalid_grant", "invalid or already used authorization code")
+	case err != nil:
+		return nil, fmt.Errorf("cannot get OAuth authorization code: %w", err)
+	}
+	switch {
+	case clientID != client.ClientID:
+		return nil, oauthError("invalid_grant", "authorization code was issued to another client")
+	case time.Now().After(expiresAt):
+		return nil, oauthError("invalid_grant", "authorization code expired")
+	case redirectURI != req.RedirectURI:
+		return nil, oauthError("invalid_grant", "redirect URI doesn't match the one of the authorization request")
+	case !validPKCEValue(req.CodeVerifier) || subtle.ConstantTimeCompare([]byte(pkceChallenge(req.CodeVerifier)), []byte(challenge)) != 1:
+		return nil, oauthError("invalid_grant", "code verifier doesn't match the code challenge")
+	}
+	return o.issueTokens(ctx, tx, client, userID, stringsToScopes(scopes))
+}
+
+func (o *OAuth) refresh(ctx context.Context, tx pgx.Tx, client *OAuthClient, req TokenRequest) (*TokenResponse, error) {
+	if req.RefreshToken == "" {
+		return nil, oauthError("invalid_request", "refresh_token is required")
+	}
+	const sql = `DELETE FROM oauth_refresh_tokens WHERE "token_hash" = $1 RETURNING "client_id", "user_id", "scopes", "access_token_id", "expires_at"`
+	var (
+		clientID, userID, accessTokenID string
+		scopes                          []string
+		expiresAt                       time.Time
+	)
+	switch err := tx.QueryRow(ctx, sql, hashAPIToken(req.RefreshToken)).Scan(&clientID, &userID, &scopes, &accessTokenID, &expiresAt); {
+	case err == pgx.ErrNoRows:
+		return nil, oauthError("invalid_grant", "invalid or already used refresh token")
+	case err != nil:
+		return nil, fmt.Errorf("cannot get OAuth refresh token: %w", err)
+	}
+	switch {
+	case clientID != client.ClientID:
+		return nil, oauthError("invalid_grant", "refresh token was issued to another client")
+	case time.Now().After(expiresAt):
+		return nil, oauthError("invalid_grant", "refresh token expired")
+	}
+	granted := stringsToScopes(scopes)
+	if req.Scope != "" {
+		narrowed, err := parseOAuthScopes(&OAuthClient{Scopes: granted}, req.Scope)
+		if err != nil {
+			return nil, err
+		}
+		granted = narrowed
+	}
+	// The access token issued with the old refresh token is replaced.
+	const revokeSQL = `DELETE FROM api_tokens WHERE "token_id" = $1`
+	if _, err := tx.Exec(ctx, revokeSQL, accessTokenID); err != nil {
+		return nil, fmt.Errorf("cannot revoke previous OAuth access token: %w", err)
+	}
+	return o.issueTokens(ctx, tx, client, userID, granted)
+}
+
+func (o *OAuth) issueTokens(ctx context.Context, tx pgx.Tx, client *OAuthClient, userID string, scopes []TokenScope) (*TokenResponse, error) {
+	accessToken, at, err := insertAPIToken(ctx, tx, NewTokenParams{
+		UserID:    userID,
+		Name:      client.Name,
+		Kind:      OAuthToken,
+		Scopes:    scopes,
+		ClientID:  client.ClientID,
+		ExpiresAt: time.Now().Add(OAuthAccessTokenLifetime),
+	})
+	if err != nil {
+		return nil, err
+	}
+	refreshToken := newAPIToken()
+	const sql = `INSERT INTO oauth_refresh_tokens ("token_hash", "client_id", "user_id", "scopes", "access_token_id", "created_at", "expires_at") VALUES ($1, $2, $3, $4, $5, NOW(), $6)`
+	if _, err := tx.Exec(ctx, sql, hashAPIToken(refreshToken), client.ClientID, userID, scopesToStrings(scopes), at.TokenID, time.Now().Add(OAuthRefreshTokenLifetime)); err != nil {
+		return nil, fmt.Errorf("cannot save OAuth refresh token: %w", err)
+	}
+	return &TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(OAuthAccessTokenLifetime / time.Second),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopesToStrings(scopes), " "),
+	}, nil
+}
+
+func stringsToScopes(s []string) []TokenScope {
+	scopes := make([]TokenScope, len(s))
+	for i, scope := range s {
+		scopes[i] = TokenScope(scope)
+	}
+	return scopes
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateRedirectURI(t *testing.T) {
+	testCases := []struct {
+		uri string
+		ok  bool
+	}{
+		{uri: "https://partner.example.com/callback", ok: true},
+		{uri: "http://localhost:8080/callback", ok: true},
+		{uri: "http://127.0.0.1/callback", ok: true},
+		{uri: "http://[::1]:3000/callback", ok: true},
+		{uri: "com.example.app:/oauth", ok: true},
+		{uri: "http://partner.example.com/callback", ok: false},
+		{uri: "https://partner.example.com/callback#fragment", ok: false},
+		{uri: "/callback", ok: false},
+		{uri: "javascript:alert(1)", ok: false},
+		{uri: "https:///callback", ok: false},
+	}
+	for _, tc := range testCases {
+		if err := validateRedirectURI(tc.uri); (err == nil) != tc.ok {
+			t.Errorf("validateRedirectURI(%q) = %v, wanted ok = %v", tc.uri, err, tc.ok)
+		}
+	}
+}
+
+func TestPKCE(t *testing.T) {
+	// The challenge is the unpadded base64url encoding of the SHA-256 hash of the verifier.
+	const (
+		verifier  = "dBjftJeZ4CVP-mJ92K6Ix7Fv4DcFc7n4rbS1CVvY3Sc"
+		challenge = "oPW0TUoEnr7Qd6z2bRzQTpRkQSIyYQ9Y2Od1pqwa4sI"
+	)
+	if got := pkceChallenge(verifier); got != challenge {
+		t.Errorf("wanted challenge %q, got %q instead", challenge, got)
+	}
+	if !validPKCEValue(verifier) {
+		t.Errorf("wanted verifier %q to be valid", verifier)
+	}
+	for _, v := range []string{"", "short", strings.Repeat("a", 129), strings.Repeat("a", 42) + "!"} {
+		if validPKCEValue(v) {
+			t.Errorf("wanted PKCE value %q to be invalid", v)
+		}
+	}
+}
+
+func TestParseOAuthScopes(t *testing.T) {
+	client := &OAuthClient{Scopes: []TokenScope{ScopeProfile, ScopeOrders}}
+	if scopes, err := parseOAuthScopes(client, "profile  orders"); err != nil || len(scopes) != 2 {
+		t.Errorf("unexpected scopes %v (error: %v)", scopes, err)
+	}
+	for _, scope := range []string{"", "cart", "profile admin"} {
+		var oerr *OAuthError
+		if _, err := parseOAuthScopes(client, scope); !errors.As(err, &oerr) || oerr.Code != "invalid_scope" {
+			t.Errorf("wanted invalid_scope error for scope %q, got %v instead", scope, err)
+		}
+	}
+}
+
+// testOAuthClient is an in-process OAuth client using the authorization code flow with PKCE.
+type testOAuthClient struct {
+	oauth    OAuth
+	client   *OAuthClient
+	secret   string
+	verifier string
+}
+
+func (c *testOAuthClient) authorizationRequest(scope string) AuthorizationRequest {
+	c.verifier = strings.TrimPrefix(newAPIToken(), tokenPrefix)
+	return AuthorizationRequest{
+		ResponseType:        "code",
+		ClientID:            c.client.ClientID,
+		RedirectURI:         c.client.RedirectURIs[0],
+		Scope:               scope,
+		State:               "xyz",
+		CodeChallenge:       pkceChallenge(c.verifier),
+		CodeChallengeMethod: "S256",
+	}
+}
+
+func (c *testOAuthClient) exchange(ctx context.Context, code string) (*TokenResponse, error) {
+	return c.oauth.Token(ctx, TokenRequest{
+		GrantType:    "authorization_code",
+		ClientID:     c.client.ClientID,
+		ClientSecret: c.secret,
+		Code:         code,
+		RedirectURI:  c.client.RedirectURIs[0],
+		CodeVerifier: c.verifier,
+	})
+}
+
+func (c *testOAuthClient) refresh(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+	return c.oauth.Token(ctx, TokenRequest{
+		GrantType:    "refresh_token",
+		ClientID:     c.client.ClientID,
+		ClientSecret: c.secret,
+		RefreshToken: refreshToken,
+	})
+}
+
+func wantOAuthError(t *testing.T, err error, code string) {
+	t.Helper()
+	var oerr *OAuthError
+	if !errors.As(err, &oerr) || oerr.Code != code {
+		t.Errorf("wanted OAuth error %q, got %v instead", code, err)
+	}
+}
+
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	core := newTestCore(t)
+	ctx := context.Background()
+	userID := newTestUser(t, core)
+
+	oauth := OAuth{core: core}
+	client, secret, err := oauth.NewClient(ctx, NewOAuthClientParams{
+		Name:         "Partner",
+		RedirectURIs: []string{"https://partner.example.com/callback"},
+		Scopes:       []TokenScope{ScopeProfile, ScopeOrders},
+		Confidential: true,
+	})
+	if err != nil {
+		t.Fatalf("cannot create OAuth client: %v", err)
+	}
+	c := &testOAuthClient{oauth: oauth, client: client, secret: secret}
+
+	req := c.authorizationRequest("profile orders")
+	req.RedirectURI = "https://evil.example.com/callback"
+	if _, err := oauth.Authorize(ctx, userID, req); err != ErrOAuthRedirectURIMismatch {
+		t.Errorf("wanted error %v, got %v instead", ErrOAuthRedirectURIMismatch, err)
+	}
+	req = c.authorizationRequest("profile cart")
+	if _, err := oauth.Authorize(ctx, userID, req); err == nil {
+		t.Error("wanted error authorizing scope not allowed for the client")
+	}
+
+	code, err := oauth.Authorize(ctx, userID, c.authorizationRequest("profile orders"))
+	if err != nil {
+		t.Fatalf("cannot authorize client: %v", err)
+	}
+	wrongSecret := *c
+	wrongSecret.secret = "wrong"
+	_, err = wrongSecret.exchange(ctx, code)
+	wantOAuthError(t, err, "invalid_client")
+
+	resp, err := c.exchange(ctx, code)
+	if err != nil {
+		t.Fatalf("cannot exchange authorization code: %v", err)
+	}
+	if resp.TokenType != "Bearer" || resp.Scope != "profile orders" || resp.RefreshToken == "" {
+		t.Errorf("unexpected token response %+v", resp)
+	}
+	_, err = c.exchange(ctx, code)
+	wantOAuthError(t, err, "invalid_grant")
+
+	tokens := Tokens{core: core}
+	at, u, err := tokens.Authenticate(ctx, resp.AccessToken)
+	if err != nil {
+		t.Fatalf("cannot authenticate access token: %v", err)
+	}
+	if u.UserID != userID || at.Kind != OAuthToken || at.ClientID != client.ClientID || !at.HasScope(ScopeOrders) || at.HasScope(ScopeCart) {
+		t.Errorf("unexpected access token %+v for user %+v", at, u)
+	}
+
+	refreshed, err := c.refresh(ctx, resp.RefreshToken)
+	if err != nil {
+		t.Fatalf("cannot refresh token: %v", err)
+	}
+	if _, _, err := tokens.Authenticate(ctx, resp.AccessToken); err != ErrInvalidToken {
+		t.Errorf("wanted previous access token to be revoked after refresh, got %v instead", err)
+	}
+	_, err = c.refresh(ctx, resp.RefreshToken)
+	wantOAuthError(t, err, "invalid_grant")
+
+	// Revoking the access token from the account page revokes the refresh token too.
+	at, _, err = tokens.Authenticate(ctx, refreshed.AccessToken)
+	if err != nil {
+		t.Fatalf("cannot authenticate refreshed access token: %v", err)
+	}
+	if err := tokens.Revoke(ctx, userID, at.TokenID); err != nil {
+		t.Fatalf("cannot revoke token: %v", err)
+	}
+	_, err = c.refresh(ctx, refreshed.RefreshToken)
+	wantOAuthError(t, err, "invalid_grant")
+}
+
+func TestOAuthPKCEVerifierMismatch(t *testing.T) {
+	core := newTestCore(t)
+	ctx := context.Background()
+	userID := newTestUser(t, core)
+	oauth := OAuth{core: core}
+	client, _, err := oauth.NewClient(ctx, NewOAuthClientParams{
+		Name:         "Mobile app",
+		RedirectURIs: []string{"com.example.app:/oauth"},
+		Scopes:       []TokenScope{ScopeCart},
+	})
+	if err != nil {
+		t.Fatalf("cannot create OAuth client: %v", err)
+	}
+	c := &testOAuthClient{oauth: oauth, client: client}
+	code, err := oauth.Authorize(ctx, userID, c.authorizationRequest("cart"))
+	if err != nil {
+		t.Fatalf("cannot authorize client: %v", err)
+	}
+	verifier := c.verifier
+	c.verifier = strings.Repeat("x", 43)
+	_, err = c.exchange(ctx, code)
+	wantOAuthError(t, err, "invalid_grant")
+
+	// The code cannot be used after a failed attempt.
+	c.verifier = verifier
+	_, err = c.exchange(ctx, code)
+	wantOAuthError(t, err, "invalid_grant")
+}
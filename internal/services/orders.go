@@ -0,0 +1,422 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// OrderStatus of an order.
+type OrderStatus string
+
+// Order statuses.
+const (
+	OrderPending   OrderStatus = "pending"
+	OrderPaid      OrderStatus = "paid"
+	OrderShipped   OrderStatus = "shipped"
+	OrderDelivered OrderStatus = "delivered"
+	OrderCancelled OrderStatus = "cancelled"
+	OrderRefunding OrderStatus = "refunding"
+	OrderRefunded  OrderStatus = "refunded"
+)
+
+// orderTransitions lists the statuses an order can move to from each status.
+// Orders are refunding while the payment provider gives the money back, so that a refund is never lost or made twice.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderPending:   {OrderPaid, OrderCancelled},
+	OrderPaid:      {OrderShipped, OrderRefunding},
+	OrderShipped:   {OrderDelivered, OrderRefunding},
+	OrderDelivered: {OrderRefunding},
+	OrderRefunding: {OrderRefunded},
+}
+
+// CanTransition checks if an order can move from the status s to the given status.
+func (s OrderStatus) CanTransition(to OrderStatus) bool {
+	for _, t := range orderTransitions[s] {
+		if t == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Cancellable checks if customers can cancel an order with the status s, which they can do until it is shipped.
+func (s OrderStatus) Cancellable() bool {
+	return s == OrderPending || s == OrderPaid
+}
+
+// Order of a user.
+type Order struct {
+	OrderID         string
+	UserID          string
+	Status          OrderStatus
+	Items           []OrderItem
+	Total           Price
+	PaymentProvider string
+	PaymentID       string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// OrderItem is a product variant bought on an order.
+// The product name and price are copied from the catalog at the time the order was placed.
+type OrderItem struct {
+	VariantID   string
+	ProductID   string
+	ProductName string
+	VariantName string
+	Price       Price
+	Quantity    int
+}
+
+// Subtotal of the item.
+func (i OrderItem) Subtotal() Price {
+	return Price{
+		Amount:   i.Price.Amount * int64(i.Quantity),
+		Currency: i.Price.Currency,
+	}
+}
+
+var (
+	// ErrOrderNotFound occurs when no order is found.
+	ErrOrderNotFound = errors.New("order not found")
+
+	// ErrEmptyCart is returned when trying to checkout an empty cart.
+	ErrEmptyCart = errors.New("cart is empty")
+
+	// ErrCartItemUnavailable is returned when trying to checkout a cart with products that were archived.
+	ErrCartItemUnavailable = errors.New("an item on the cart is no longer available")
+
+	// ErrInvalidOrderTransition is returned when an order cannot change to a given status.
+	ErrInvalidOrderTransition = errors.New("invalid order status transition")
+)
+
+// Orders services.
+type Orders struct {
+	core *Core
+}
+
+// Checkout creates a pending order with the items on the cart of the user, reserves their stock, and empties the cart.
+// ErrOutOfStock is returned if there are not enough units of any of the items.
+func (o *Orders) Checkout(ctx context.Context, userID string) (*Order, error) {
+	tx, err := o.core.Postgres.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot checkout: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the cart to avoid placing the same order twice.
+	const lockSQL = `SELECT "cart_id" FROM carts WHERE "user_id" = $1 FOR UPDATE`
+	var cartID string
+	switch err := tx.QueryRow(ctx, lockSQL, userID).Scan(&cartID); {
+	case err == pgx.ErrNoRows:
+		return nil, ErrEmptyCart
+	case err != nil:
+		return nil, fmt.Errorf("cannot checkout: %w", err)
+	}
+	items, err := cartItems(ctx, tx, cartID)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, ErrEmptyCart
+	}
+
+	order := &Order{
+		OrderID: new11RandomID(),
+		UserID:  userID,
+		Status:  OrderPending,
+	}
+	for _, i := range items {
+		if !i.Available {
+			return nil, ErrCartItemUnavailable
+		}
+		if order.Total.Currency != "" && order.Total.Currency != i.Price.Currency {
+			return nil, errors.New("cannot checkout items with different currencies")
+		}
+		order.Total.Currency = i.Price.Currency
+		order.Total.Amount += i.Subtotal().Amount
+		order.Items = append(order.Items, OrderItem{
+			VariantID:   i.VariantID,
+			ProductID:   i.ProductID,
+			ProductName: i.ProductName,
+			VariantName: i.VariantName,
+			Price:       i.Price,
+			Quantity:    i.Quantity,
+		})
+	}
+
+	const sql = `INSERT INTO orders ("order_id", "user_id", "status", "total_amount", "total_currency", "created_at", "updated_at") VALUES ($1, $2, $3, $4, $5, NOW(), NOW()) RETURNING "created_at", "updated_at"`
+	if err := tx.QueryRow(ctx, sql, order.OrderID, userID, string(order.Status), order.Total.Amount, order.Total.Currency).Scan(&order.CreatedAt, &order.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("cannot create order: %w", err)
+	}
+	const itemSQL = `INSERT INTO orders_items ("order_id", "variant_id", "product_id", "product_name", "variant_name", "price_amount", "price_currency", "quantity") VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	for _, i := range order.Items {
+		if _, err := tx.Exec(ctx, itemSQL, order.OrderID, i.VariantID, i.ProductID, i.ProductName, i.VariantName, i.Price.Amount, i.Price.Currency, i.Quantity); err != nil {
+			return nil, fmt.Errorf("cannot add item to order: %w", err)
+		}
+	}
+	if err := reserveStock(ctx, tx, order.OrderID, order.Items); err != nil {
+		return nil, err
+	}
+	const clearSQL = `DELETE FROM carts_items WHERE "cart_id" = $1`
+	if _, err := tx.Exec(ctx, clearSQL, cartID); err != nil {
+		return nil, fmt.Errorf("cannot clear cart: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("cannot checkout: %w", err)
+	}
+	return order, nil
+}
+
+// GetOrder by its ID.
+func (o *Orders) GetOrder(ctx context.Context, orderID string) (*Order, error) {
+	pg := o.core.Postgres
+	const sql = `SELECT "order_id", "user_id", "status", "total_amount", "total_currency", COALESCE("payment_provider", ''), COALESCE("payment_id", ''), "created_at", "updated_at" FROM orders WHERE "order_id" = $1 LIMIT 1`
+	var order Order
+	var status string
+	switch err := pg.QueryRow(ctx, sql, orderID).Scan(&order.OrderID, &order.UserID, &status, &order.Total.Amount, &order.Total.Currency,
+		&order.PaymentProvider, &order.PaymentID, &order.CreatedAt, &order.UpdatedAt); {
+	case err == pgx.ErrNoRows:
+		return nil, ErrOrderNotFound
+	case err != nil:
+		return nil, fmt.Errorf("cannot get order: %w", err)
+	}
+	order.Status = OrderStatus(status)
+
+	const itemsSQL = `SELECT "variant_id", "product_id", "product_name", "variant_name", "price_amount", "price_currency", "quantity" FROM orders_items WHERE "order_id" = $1 ORDER BY "product_name", "variant_name"`
+	rows, err := pg.Query(ctx, itemsSQL, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get order items: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i OrderItem
+		if err := rows.Scan(&i.VariantID, &i.ProductID, &i.ProductName, &i.VariantName, &i.Price.Amount, &i.Price.Currency, &i.Quantity); err != nil {
+			return nil, fmt.Errorf("cannot read order item: %w", err)
+		}
+		order.Items = append(order.Items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot get order items: %w", err)
+	}
+	return &order, nil
+}
+
+// ListOrders of a user, starting with the most recent. Items are not loaded.
+func (o *Orders) ListOrders(ctx context.Context, userID string) ([]Order, error) {
+	pg := o.core.Postgres
+	const sql = `SELECT "order_id", "user_id", "status", "total_amount", "total_currency", COALESCE("payment_provider", ''), COALESCE("payment_id", ''), "created_at", "updated_at" FROM orders WHERE "user_id" = $1 ORDER BY "created_at" DESC LIMIT 100`
+	rows, err := pg.Query(ctx, sql, userID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list orders: %w", err)
+	}
+	defer rows.Close()
+	var orders []Order
+	for rows.Next() {
+		var order Order
+		var status string
+		if err := rows.Scan(&order.OrderID, &order.UserID, &status, &order.Total.Amount, &order.Total.Currency,
+			&order.PaymentProvider, &order.PaymentID, &order.CreatedAt, &order.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("cannot read order: %w", err)
+		}
+		order.Status = OrderStatus(status)
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot list orders: %w", err)
+	}
+	return orders, nil
+}
+
+// Pay a pending order using a token from the payment form of the payment provider.
+func (o *Orders) Pay(ctx context.Context, orderID, token string) error {
+	provider := o.core.Payments
+	if provider == nil {
+		return ErrPaymentProviderUnavailable
+	}
+	order, err := o.GetOrder(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if !order.Status.CanTransition(OrderPaid) {
+		return ErrInvalidOrderTransition
+	}
+	paymentID, err := provider.Charge(ctx, ChargeParams{
+		OrderID: orderID,
+		Amount:  order.Total,
+		Token:   token,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = o.savePayment(ctx, order, provider.Name(), paymentID)
+	if err == nil {
+		order.Status = OrderPaid
+		o.notify(ctx, order, "order-confirmation")
+		return nil
+	}
+	// The order changed while the payment was being processed, or couldn't be saved, so we give the money back.
+	if rerr := provider.Refund(ctx, paymentID, order.Total); rerr != nil {
+		log.Printf("cannot refund payment %q of order %q: %v", paymentID, orderID, rerr)
+	}
+	return err
+}
+
+// savePayment marks the order as paid and takes its reserved units out of the stock.
+func (o *Orders) savePayment(ctx context.Context, order *Order, provider, paymentID string) error {
+	tx, err := o.core.Postgres.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot save payment of order %q: %w", order.OrderID, err)
+	}
+	defer tx.Rollback(ctx)
+	const sql = `UPDATE orders SET "status" = $2, "payment_provider" = $3, "payment_id" = $4, "updated_at" = NOW() WHERE "order_id" = $1 AND "status" = $5`
+	switch ct, err := tx.Exec(ctx, sql, order.OrderID, string(OrderPaid), provider, paymentID, string(order.Status)); {
+	case err != nil:
+		return fmt.Errorf("cannot save payment of order %q: %w", order.OrderID, err)
+	case ct.RowsAffected() == 0:
+		return ErrInvalidOrderTransition
+	}
+	if err := fulfillStock(ctx, tx, order.OrderID); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("cannot save payment of order %q: %w", order.OrderID, err)
+	}
+	return nil
+}
+
+// Cancel an order of a customer. Pending orders are cancelled and their stock is released,
+// and paid orders are refunded if they weren't shipped yet.
+// ErrInvalidOrderTransition is returned for other orders: only admins can refund them, with Refund.
+func (o *Orders) Cancel(ctx context.Context, orderID string) error {
+	order, err := o.GetOrder(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !order.Status.Cancellable():
+		return ErrInvalidOrderTransition
+	case order.Status == OrderPending:
+		return o.cancelPending(ctx, orderID)
+	}
+	return o.refund(ctx, order)
+}
+
+// Refund a paid, shipped, or delivered order, or retry the refund of a refunding order.
+// Refunded units are not returned to the stock, as they might not be back in the warehouse.
+func (o *Orders) Refund(ctx context.Context, orderID string) error {
+	order, err := o.GetOrder(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	return o.refund(ctx, order)
+}
+
+// refund the order. It is saved as refunding before the payment provider is called,
+// so that it isn't left as paid if the refund cannot be saved afterwards.
+// Refunding orders are refunded again when retried, which the payment provider ignores if already done.
+func (o *Orders) refund(ctx context.Context, order *Order) error {
+	provider := o.core.Payments
+	if provider == nil || provider.Name() != order.PaymentProvider {
+		return fmt.Errorf("cannot refund order %q: %w", order.OrderID, ErrPaymentProviderUnavailable)
+	}
+	pg := o.core.Postgres
+	if order.Status != OrderRefunding {
+		if err := o.transition(ctx, pg, order, OrderRefunding); err != nil {
+			return err
+		}
+	}
+	if err := provider.Refund(ctx, order.PaymentID, order.Total); err != nil {
+		return fmt.Errorf("cannot refund order %q: %w", order.OrderID, err)
+	}
+	return o.transition(ctx, pg, order, OrderRefunded)
+}
+
+// cancelPending cancels a pending order and releases its stock.
+func (o *Orders) cancelPending(ctx context.Context, orderID string) error {
+	tx, err := o.core.Postgres.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot cancel order %q: %w", orderID, err)
+	}
+	defer tx.Rollback(ctx)
+	if err := o.transition(ctx, tx, &Order{OrderID: orderID, Status: OrderPending}, OrderCancelled); err != nil {
+		return err
+	}
+	if err := releaseStock(ctx, tx, orderID); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("cannot cancel order %q: %w", orderID, err)
+	}
+	return nil
+}
+
+// SetStatus of an order to shipped or delivered.
+// Use Pay, Cancel, and Refund for changes involving the payment provider.
+func (o *Orders) SetStatus(ctx context.Context, orderID string, status OrderStatus) error {
+	if status != OrderShipped && status != OrderDelivered {
+		return ErrInvalidOrderTransition
+	}
+	order, err := o.GetOrder(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if err := o.transition(ctx, o.core.Postgres, order, status); err != nil {
+		return err
+	}
+	if status == OrderShipped {
+		o.notify(ctx, order, "order-shipped")
+	}
+	return nil
+}
+
+// OrderMail is the content of emails about an order.
+type OrderMail struct {
+	Order *Order
+
+	// Link to the order page.
+	Link string
+}
+
+// notify the user about a change on their order by email.
+// Failing to send the email doesn't fail the change, as the user can still see it on the order page.
+func (o *Orders) notify(ctx context.Context, order *Order, mail string) {
+	accounts := Accounts{core: o.core}
+	u, err := accounts.GetUserByID(ctx, order.UserID)
+	if err == nil {
+		mailer := Mailer{core: o.core}
+		err = mailer.Send(ctx, u.Email, mail, MailData{
+			Name: u.Name,
+			Content: OrderMail{
+				Order: order,
+				Link:  strings.TrimSuffix(o.core.Settings.PublicURL, "/") + "/account/orders/" + order.OrderID,
+			},
+		})
+	}
+	if err != nil {
+		log.Printf("cannot send %s email for order %q: %v", mail, order.OrderID, err)
+	}
+}
+
+// transition changes the status of the order, unless it changed concurrently.
+func (o *Orders) transition(ctx context.Context, q pgQuerier, order *Order, to OrderStatus) error {
+	if !order.Status.CanTransition(to) {
+		return ErrInvalidOrderTransition
+	}
+	const sql = `UPDATE orders SET "status" = $2, "updated_at" = NOW() WHERE "order_id" = $1 AND "status" = $3`
+	switch ct, err := q.Exec(ctx, sql, order.OrderID, string(to), string(order.Status)); {
+	case err != nil:
+		return fmt.Errorf("cannot change status of order %q: %w", order.OrderID, err)
+	case ct.RowsAffected() == 0:
+		return ErrInvalidOrderTransition
+	}
+	order.Status = to
+	return nil
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"testing"
+)
+
+func TestOrderStatusCanTransition(t *testing.T) {
+	testCases := []struct {
+		from OrderStatus
+		to   OrderStatus
+		want bool
+	}{
+		{OrderPending, OrderPaid, true},
+		{OrderPending, OrderCancelled, true},
+		{OrderPending, OrderShipped, false},
+		{OrderPending, OrderRefunding, false},
+		{OrderPaid, OrderShipped, true},
+		{OrderPaid, OrderRefunding, true},
+		{OrderPaid, OrderRefunded, false},
+		{OrderPaid, OrderCancelled, false},
+		{OrderPaid, OrderPending, false},
+		{OrderShipped, OrderDelivered, true},
+		{OrderShipped, OrderRefunding, true},
+		{OrderDelivered, OrderRefunding, true},
+		{OrderDelivered, OrderShipped, false},
+		{OrderRefunding, OrderRefunded, true},
+		{OrderRefunding, OrderPaid, false},
+		{OrderCancelled, OrderPaid, false},
+		{OrderRefunded, OrderPaid, false},
+		{OrderRefunded, OrderRefunding, false},
+		{OrderStatus("unknown"), OrderPaid, false},
+	}
+	for _, tc := range testCases {
+		if got := tc.from.CanTransition(tc.to); got != tc.want {
+			t.Errorf("wanted %q.CanTransition(%q) = %v, got %v instead", tc.from, tc.to, tc.want, got)
+		}
+	}
+}
+
+func TestOrderStatusCancellable(t *testing.T) {
+	for _, s := range []OrderStatus{OrderPending, OrderPaid} {
+		if !s.Cancellable() {
+			t.Errorf("wanted %q to be cancellable", s)
+		}
+	}
+	for _, s := range []OrderStatus{OrderShipped, OrderDelivered, OrderCancelled, OrderRefunding, OrderRefunded} {
+		if s.Cancellable() {
+			t.Errorf("wanted %q not to be cancellable", s)
+		}
+	}
+}
+
+func TestFakePaymentProvider(t *testing.T) {
+	provider, err := NewPaymentProvider(FakePaymentProviderName)
+	if err != nil {
+		t.Fatalf("cannot create payment provider: %v", err)
+	}
+	amount := Price{Amount: 1000, Currency: "EUR"}
+	paymentID, err := provider.Charge(context.Background(), ChargeParams{OrderID: "x", Amount: amount, Token: FakePaymentApprovedToken})
+	if err != nil {
+		t.Fatalf("cannot charge: %v", err)
+	}
+	if err := provider.Refund(context.Background(), paymentID, amount); err != nil {
+		t.Errorf("cannot refund: %v", err)
+	}
+	if _, err := provider.Charge(context.Background(), ChargeParams{OrderID: "x", Amount: amount, Token: FakePaymentDeclinedToken}); err != ErrPaymentDeclined {
+		t.Errorf("wanted payment to be declined, got %v instead", err)
+	}
+	if _, err := provider.Charge(context.Background(), ChargeParams{OrderID: "x", Amount: amount, Token: "tok_visa"}); err != ErrInvalidPaymentToken {
+		t.Errorf("wanted error %v, got %v instead", ErrInvalidPaymentToken, err)
+	}
+	if err := provider.Refund(context.Background(), "ch_123", amount); err == nil {
+		t.Error("wanted unknown payment error, got nil instead")
+	}
+}
+
+func TestNewPaymentProvider(t *testing.T) {
+	if p, err := NewPaymentProvider(""); p != nil || err != nil {
+		t.Errorf("wanted no payment provider, got %v (error: %v) instead", p, err)
+	}
+	if _, err := NewPaymentProvider("unknown"); err == nil {
+		t.Error("wanted unknown payment provider error, got nil instead")
+	}
+}
+
+func TestOrdersCancelAndRefund(t *testing.T) {
+	core := newTestCore(t)
+	core.Payments = &FakePaymentProvider{}
+	ctx := context.Background()
+	_, variantID := newTestProduct(t, core, Price{Amount: 1000, Currency: "EUR"})
+	inventory := Inventory{core: core}
+	if err := inventory.SetStock(ctx, variantID, 2); err != nil {
+		t.Fatalf("cannot set stock: %v", err)
+	}
+	carts := Carts{core: core}
+	orders := Orders{core: core}
+	checkout := func(userID string) *Order {
+		if err := carts.AddItem(ctx, CartOwner{UserID: userID}, variantID, 1); err != nil {
+			t.Fatalf("cannot add item to cart: %v", err)
+		}
+		order, err := orders.Checkout(ctx, userID)
+		if err != nil {
+			t.Fatalf("cannot checkout: %v", err)
+		}
+		if err := orders.Pay(ctx, order.OrderID, FakePaymentApprovedToken); err != nil {
+			t.Fatalf("cannot pay order: %v", err)
+		}
+		return order
+	}
+	status := func(orderID string) OrderStatus {
+		order, err := orders.GetOrder(ctx, orderID)
+		if err != nil {
+			t.Fatalf("cannot get order: %v", err)
+		}
+		return order.Status
+	}
+
+	// Customers cannot cancel shipped orders, which only admins can refund.
+	shipped := checkout("buyer")
+	if err := orders.SetStatus(ctx, shipped.OrderID, OrderShipped); err != nil {
+		t.Fatalf("cannot ship order: %v", err)
+	}
+	if err := orders.Cancel(ctx, shipped.OrderID); err != ErrInvalidOrderTransition {
+		t.Errorf("wanted error %v cancelling shipped order, got %v instead", ErrInvalidOrderTransition, err)
+	}
+	if err := orders.Refund(ctx, shipped.OrderID); err != nil {
+		t.Errorf("cannot refund shipped order: %v", err)
+	}
+	if got := status(shipped.OrderID); got != OrderRefunded {
+		t.Errorf("wanted order to be refunded, got %q instead", got)
+	}
+	if err := orders.Refund(ctx, shipped.OrderID); err != ErrInvalidOrderTransition {
+		t.Errorf("wanted error %v refunding order twice, got %v instead", ErrInvalidOrderTransition, err)
+	}
+
+	// Refunds interrupted before being saved can be retried.
+	paid := checkout("another-buyer")
+	const sql = `UPDATE orders SET "status" = $2 WHERE "order_id" = $1`
+	if _, err := core.Postgres.Exec(ctx, sql, paid.OrderID, string(OrderRefunding)); err != nil {
+		t.Fatalf("cannot set order as refunding: %v", err)
+	}
+	if err := orders.Cancel(ctx, paid.OrderID); err != ErrInvalidOrderTransition {
+		t.Errorf("wanted error %v cancelling refunding order, got %v instead", ErrInvalidOrderTransition, err)
+	}
+	if err := orders.Refund(ctx, paid.OrderID); err != nil {
+		t.Errorf("cannot retry refund: %v", err)
+	}
+	if got := status(paid.OrderID); got != OrderRefunded {
+		t.Errorf("wanted order to be refunded, got %q instead", got)
+	}
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/plifk/market/internal/webauthn"
+)
+
+// Passkeys lets users log in without a password, using WebAuthn credentials such as passkeys and security keys.
+type Passkeys struct {
+	core *Core
+}
+
+// Passkey of a user.
+type Passkey struct {
+	// ID of the credential, encoded as base64url.
+	ID         string
+	UserID     string
+	Name       string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// Purposes of the WebAuthn challenges.
+const (
+	passkeyRegistrationPurpose = "registration"
+	passkeyLoginPurpose        = "login"
+)
+
+var (
+	// ErrPasskeysUnavailable is returned when the public URL of the market, which passkeys are scoped to, isn't set.
+	ErrPasskeysUnavailable = errors.New("passkeys require the public URL of the market")
+
+	// ErrPasskeyNotFound is returned when a passkey doesn't exist.
+	ErrPasskeyNotFound = errors.New("passkey not found")
+
+	// ErrInvalidPasskey is returned when a WebAuthn ceremony fails, or its challenge was used or expired.
+	ErrInvalidPasskey = errors.New("invalid passkey")
+)
+
+// relyingParty is the market, as seen by the authenticators. Passkeys are scoped to the host of the public URL.
+func (p *Passkeys) relyingParty() (*webauthn.RelyingParty, error) {
+	u, err := url.Parse(p.core.Settings.PublicURL)
+	if err != nil || u.Host == "" {
+		return nil, ErrPasskeysUnavailable
+	}
+	return &webauthn.RelyingParty{
+		ID:     u.Hostname(),
+		Name:   mfaIssuer,
+		Origin: u.Scheme + "://" + u.Host,
+	}, nil
+}
+
+// BeginRegistration returns the options for the browser to create a passkey for the user.
+func (p *Passkeys) BeginRegistration(ctx context.Context, u *User) (*webauthn.CreationOptions, error) {
+	rp, err := p.relyingParty()
+	if err != nil {
+		return nil, err
+	}
+	passkeys, err := p.List(ctx, u.UserID)
+	if err != nil {
+		return nil, err
+	}
+	var exclude [][]byte
+	for _, pk := range passkeys {
+		id, _ := base64.RawURLEncoding.DecodeString(pk.ID)
+		exclude = append(exclude, id)
+	}
+	challenge, err := p.newChallenge(ctx, passkeyRegistrationPurpose, u.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return rp.CreationOptions(challenge, webauthn.User{
+		ID:          []byte(u.UserID),
+		Name:        u.Email,
+		DisplayName: u.Name,
+	}, exclude), nil
+}
+
+// FinishRegistration verifies the passkey created by the browser, and saves it.
+// The current password of the user is required, as passkeys log in without the password or two-factor authentication,
+// so a stolen session must not be able to add one. It returns ErrWrongPassword if the password is wrong.
+func (p *Passkeys) FinishRegistration(ctx context.Context, u *User, name, password string, resp *webauthn.AttestationResponse) (*Passkey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if len(name) > 100 {
+		return nil, invalid(errors.New("passkey name is too long"))
+	}
+	accounts := Accounts{core: p.core}
+	if err := accounts.CheckPassword(ctx, u.UserID, password); err != nil {
+		return nil, err
+	}
+	rp, err := p.relyingParty()
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := p.useChallenge(ctx, resp.ClientDataJSON, passkeyRegistrationPurpose, u.UserID)
+	if err != nil {
+		return nil, err
+	}
+	cred, err := rp.VerifyRegistration(challenge, resp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	pk := &Passkey{
+		ID:     base64.RawURLEncoding.EncodeToString(cred.ID),
+		UserID: u.UserID,
+		Name:   name,
+	}
+	pg := p.core.Postgres
+	const sql = `INSERT INTO users_passkeys ("credential_id", "user_id", "name", "public_key", "sign_count", "created_at") VALUES ($1, $2, $3, $4, $5, NOW()) RETURNING "created_at"`
+	switch err := pg.QueryRow(ctx, sql, cred.ID, u.UserID, name, cred.PublicKey, int64(cred.SignCount)).Scan(&pk.CreatedAt); {
+	case isUniqueViolation(err):
+		return nil, fmt.Errorf("%w: already registered", ErrInvalidPasskey)
+	case err != nil:
+		return nil, fmt.Errorf("cannot save passkey: %w", err)
+	}
+	return pk, nil
+}
+
+// BeginLogin returns the options for the browser to log in with any passkey of the market.
+func (p *Passkeys) BeginLogin(ctx context.Context) (*webauthn.RequestOptions, error) {
+	rp, err := p.relyingParty()
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := p.newChallenge(ctx, passkeyLoginPurpose, "")
+	if err != nil {
+		return nil, err
+	}
+	return rp.RequestOptions(challenge), nil
+}
+
+// FinishLogin verifies the passkey assertion signed by the browser, returning the ID of its user.
+// Passkeys verify the user (PIN, biometrics), so they are enough to log in.
+func (p *Passkeys) FinishLogin(ctx context.Context, resp *webauthn.AssertionResponse) (userID string, err error) {
+	rp, err := p.relyingParty()
+	if err != nil {
+		return "", err
+	}
+	challenge, err := p.useChallenge(ctx, resp.ClientDataJSON, passkeyLoginPurpose, "")
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		cred      = webauthn.Credential{ID: resp.ID}
+		signCount int64
+	)
+	pg := p.core.Postgres
+	const sql = `SELECT "user_id", "public_key", "sign_count" FROM users_passkeys WHERE "credential_id" = $1`
+	switch err := pg.QueryRow(ctx, sql, []byte(resp.ID)).Scan(&userID, &cred.PublicKey, &signCount); {
+	case err == pgx.ErrNoRows:
+		return "", ErrPasskeyNotFound
+	case err != nil:
+		return "", fmt.Errorf("cannot get passkey: %w", err)
+	}
+	if len(resp.UserHandle) != 0 && string(resp.UserHandle) != userID {
+		return "", fmt.Errorf("%w: user handle mismatch", ErrInvalidPasskey)
+	}
+	cred.SignCount = uint32(signCount)
+	n, err := rp.VerifyAssertion(challenge, resp, &cred)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+	const updateSQL = `UPDATE users_passkeys SET "sign_count" = $2, "last_used_at" = NOW() WHERE "credential_id" = $1`
+	if _, err := pg.Exec(ctx, updateSQL, []byte(resp.ID), int64(n)); err != nil {
+		return "", fmt.Errorf("cannot update passkey: %w", err)
+	}
+	return userID, nil
+}
+
+// List passkeys of a user.
+func (p *Passkeys) List(ctx context.Context, userID string) ([]Passkey, error) {
+	pg := p.core.Postgres
+	const sql = `SELECT "credential_id", "user_id", "name", "created_at", "last_used_at" FROM users_passkeys WHERE "user_id" = $1 ORDER BY "created_at" DESC`
+	rows, err := pg.Query(ctx, sql, userID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list passkeys: %w", err)
+	}
+	defer rows.Close()
+	var passkeys []Passkey
+	for rows.Next() {
+		var (
+			pk Passkey
+			id []byte
+		)
+		if err := rows.Scan(&id, &pk.UserID, &pk.Name, &pk.CreatedAt, &pk.LastUsedAt); err != nil {
+			return nil, fmt.Errorf("cannot read passkey: %w", err)
+		}
+		pk.ID = base64.RawURLEncoding.EncodeToString(id)
+		passkeys = append(passkeys, pk)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot list passkeys: %w", err)
+	}
+	return passkeys, nil
+}
+
+// Delete a passkey of a user.
+func (p *Passkeys) Delete(ctx context.Context, userID, id string) error {
+	credentialID, err := base64.RawURLEncoding.DecodeString(id)
+	if err != nil {
+		return ErrPasskeyNotFound
+	}
+	pg := p.core.Postgres
+	const sql = `DELETE FROM users_passkeys WHERE "credential_id" = $1 AND "user_id" = $2`
+	ct, err := pg.Exec(ctx, sql, credentialID, userID)
+	if err != nil {
+		return fmt.Errorf("cannot delete passkey: %w", err)
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+// newChallenge for a ceremony, saved so that it can be used only once.
+func (p *Passkeys) newChallenge(ctx context.Context, purpose, userID string) ([]byte, error) {
+	challenge := webauthn.NewChallenge()
+	pg := p.core.Postgres
+	const cleanupSQL = `DELETE FROM webauthn_challenges WHERE "expires_at" <= NOW()`
+	if _, err := pg.Exec(ctx, cleanupSQL); err != nil {
+		return nil, fmt.Errorf("cannot delete expired WebAuthn challenges: %w", err)
+	}
+	const sql = `INSERT INTO webauthn_challenges ("challenge_hash", "purpose", "user_id", "expires_at") VALUES ($1, $2, NULLIF($3, ''), $4)`
+	if _, err := pg.Exec(ctx, sql, hashChallenge(challenge), purpose, userID, time.Now().Add(webauthn.Timeout)); err != nil {
+		return nil, fmt.Errorf("cannot create WebAuthn challenge: %w", err)
+	}
+	return challenge, nil
+}
+
+// useChallenge of the client data of a ceremony response, returning it if it was issued for the purpose and user.
+func (p *Passkeys) useChallenge(ctx context.Context, clientDataJSON []byte, purpose, userID string) ([]byte, error) {
+	clientData, err := webauthn.ParseClientData(clientDataJSON)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+	pg := p.core.Postgres
+	const sql = `DELETE FROM webauthn_challenges WHERE "challenge_hash" = $1 AND "purpose" = $2 AND "user_id" IS NOT DISTINCT FROM NULLIF($3, '') AND "expires_at" > NOW()`
+	ct, err := pg.Exec(ctx, sql, hashChallenge(clientData.Challenge), purpose, userID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot use WebAuthn challenge: %w", err)
+	}
+	if ct.RowsAffected() == 0 {
+		return nil, fmt.Errorf("%w: unknown or expired challenge", ErrInvalidPasskey)
+	}
+	return clientData.Challenge, nil
+}
+
+func hashChallenge(challenge []byte) string {
+	return hashAPIToken(base64.RawURLEncoding.EncodeToString(challenge))
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/plifk/market/internal/webauthn/webauthntest"
+)
+
+func TestPasskeysRelyingParty(t *testing.T) {
+	p := Passkeys{core: &Core{}}
+	if _, err := p.relyingParty(); err != ErrPasskeysUnavailable {
+		t.Errorf("wanted error %v without public URL, got %v instead", ErrPasskeysUnavailable, err)
+	}
+	p.core.Settings.PublicURL = "https://www.example.com:8443/"
+	rp, err := p.relyingParty()
+	if err != nil {
+		t.Fatalf("cannot get relying party: %v", err)
+	}
+	if rp.ID != "www.example.com" || rp.Origin != "https://www.example.com:8443" {
+		t.Errorf("unexpected relying party: %+v", rp)
+	}
+}
+
+func TestPasskeys(t *testing.T) {
+	core := newTestCore(t)
+	core.Settings.PublicURL = "https://www.example.com"
+	ctx := context.Background()
+	accounts := Accounts{core: core}
+	p := Passkeys{core: core}
+
+	u, err := accounts.Signup(ctx, SignupParams{Name: "Jane Doe", Email: "jane@example.com", Password: "correct horse battery staple"})
+	if err != nil {
+		t.Fatalf("cannot sign up: %v", err)
+	}
+	authenticator := &webauthntest.Authenticator{Origin: "https://www.example.com"}
+	creation, err := p.BeginRegistration(ctx, u)
+	if err != nil {
+		t.Fatalf("cannot begin registration: %v", err)
+	}
+	attestation, err := authenticator.Create(creation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.FinishRegistration(ctx, u, "Laptop", "wrong password", attestation); err != ErrWrongPassword {
+		t.Errorf("wanted error %v registering without the password, got %v instead", ErrWrongPassword, err)
+	}
+	pk, err := p.FinishRegistration(ctx, u, "Laptop", "correct horse battery staple", attestation)
+	if err != nil {
+		t.Fatalf("cannot finish registration: %v", err)
+	}
+	if _, err := p.FinishRegistration(ctx, u, "Laptop", "correct horse battery staple", attestation); !errors.Is(err, ErrInvalidPasskey) {
+		t.Errorf("wanted error %v reusing registration challenge, got %v instead", ErrInvalidPasskey, err)
+	}
+	if creation, err = p.BeginRegistration(ctx, u); err != nil {
+		t.Fatalf("cannot begin registration: %v", err)
+	}
+	if _, err := authenticator.Create(creation); err != webauthntest.ErrExcluded {
+		t.Errorf("wanted registered passkey to be excluded, got %v instead", err)
+	}
+
+	request, err := p.BeginLogin(ctx)
+	if err != nil {
+		t.Fatalf("cannot begin login: %v", err)
+	}
+	assertion, err := authenticator.Get(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, err := p.FinishLogin(ctx, assertion)
+	if err != nil || userID != u.UserID {
+		t.Fatalf("cannot finish login: got user %q (error: %v)", userID, err)
+	}
+	if _, err := p.FinishLogin(ctx, assertion); !errors.Is(err, ErrInvalidPasskey) {
+		t.Errorf("wanted error %v replaying assertion, got %v instead", ErrInvalidPasskey, err)
+	}
+
+	passkeys, err := p.List(ctx, u.UserID)
+	if err != nil || len(passkeys) != 1 || passkeys[0].ID != pk.ID || passkeys[0].LastUsedAt == nil {
+		t.Errorf("unexpected passkeys: %+v (error: %v)", passkeys, err)
+	}
+	if err := p.Delete(ctx, "other", pk.ID); err != ErrPasskeyNotFound {
+		t.Errorf("wanted error %v deleting passkey of another user, got %v instead", ErrPasskeyNotFound, err)
+	}
+	if err := p.Delete(ctx, u.UserID, pk.ID); err != nil {
+		t.Errorf("cannot delete passkey: %v", err)
+	}
+	if request, err = p.BeginLogin(ctx); err != nil {
+		t.Fatalf("cannot begin login: %v", err)
+	}
+	if assertion, err = authenticator.Get(request); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.FinishLogin(ctx, assertion); err != ErrPasskeyNotFound {
+		t.Errorf("wanted error %v logging in with deleted passkey, got %v instead", ErrPasskeyNotFound, err)
+	}
+}
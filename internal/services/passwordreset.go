@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/plifk/market/internal/passwords"
+)
+
+// passwordResetLifetime is how long a password reset link is valid.
+const passwordResetLifetime = time.Hour
+
+// ErrInvalidPasswordResetToken is returned when a password reset token doesn't exist, was used, or expired.
+var ErrInvalidPasswordResetToken = errors.New("invalid or expired password reset link")
+
+// ResetPasswordMail is the content of the password reset email.
+type ResetPasswordMail struct {
+	Link         string
+	ValidMinutes int
+}
+
+// RequestPasswordReset emails a link to reset the password to the user with the given email address.
+// Only the last link sent is valid, and it can be used only once.
+// To avoid disclosing which email addresses are registered, no error is returned if there is no such user.
+func (a *Accounts) RequestPasswordReset(ctx context.Context, email string) error {
+	if a.core.MailTransport == nil {
+		return ErrMailTransportUnavailable
+	}
+	u, err := a.GetUserByEmail(ctx, email)
+	switch {
+	case err == ErrUserNotFound:
+		return nil
+	case err != nil:
+		return err
+	}
+
+	token := newAPIToken()
+	tx, err := a.core.Postgres.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot create password reset token: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	const deleteSQL = `DELETE FROM password_resets WHERE "user_id" = $1`
+	if _, err := tx.Exec(ctx, deleteSQL, u.UserID); err != nil {
+		return fmt.Errorf("cannot delete previous password reset tokens: %w", err)
+	}
+	const sql = `INSERT INTO password_resets ("token_hash", "user_id", "created_at", "expires_at") VALUES ($1, $2, NOW(), $3)`
+	if _, err := tx.Exec(ctx, sql, hashAPIToken(token), u.UserID, time.Now().Add(passwordResetLifetime)); err != nil {
+		return fmt.Errorf("cannot create password reset token: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("cannot create password reset token: %w", err)
+	}
+
+	mailer := Mailer{core: a.core}
+	return mailer.Send(ctx, u.Email, "reset-password", MailData{
+		Name: u.Name,
+		Content: ResetPasswordMail{
+			Link:         strings.TrimSuffix(a.core.Settings.PublicURL, "/") + "/recover/reset?token=" + url.QueryEscape(token),
+			ValidMinutes: int(passwordResetLifetime.Minutes()),
+		},
+	})
+}
+
+// CheckPasswordResetToken checks if a password reset token is valid, without using it.
+func (a *Accounts) CheckPasswordResetToken(ctx context.Context, token string) error {
+	var userID string
+	pg := a.core.Postgres
+	const sql = `SELECT "user_id" FROM password_resets WHERE "token_hash" = $1 AND "expires_at" > NOW()`
+	switch err := pg.QueryRow(ctx, sql, hashAPIToken(token)).Scan(&userID); {
+	case err == pgx.ErrNoRows:
+		return ErrInvalidPasswordResetToken
+	case err != nil:
+		return fmt.Errorf("cannot check password reset token: %w", err)
+	}
+	return nil
+}
+
+// ResetPasswordParams to change a forgotten password.
+type ResetPasswordParams struct {
+	Token    string
+	Password string
+
+	// StickyID of the session the password is reset from.
+	// All other sessions of the user are closed.
+	StickyID string
+}
+
+// ResetPassword of the user with a password reset token, returning the user ID.
+// The token is used only if the new password is accepted.
+func (a *Accounts) ResetPassword(ctx context.Context, p ResetPasswordParams) (userID string, err error) {
+	tx, err := a.core.Postgres.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("cannot reset password: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var name, email string
+	const sql = `SELECT "users"."user_id", "users"."name", "users"."email" FROM password_resets
+JOIN users ON "users"."user_id" = "password_resets"."user_id"
+WHERE "token_hash" = $1 AND "expires_at" > NOW() FOR UPDATE OF password_resets`
+	switch err := tx.QueryRow(ctx, sql, hashAPIToken(p.Token)).Scan(&userID, &name, &email); {
+	case err == pgx.ErrNoRows:
+		return "", ErrInvalidPasswordResetToken
+	case err != nil:
+		return "", fmt.Errorf("cannot get password reset token: %w", err)
+	}
+	if err := passwords.Validate(p.Password, name, email); err != nil {
+		return "", invalid(err)
+	}
+
+	const deleteSQL = `DELETE FROM password_resets WHERE "user_id" = $1`
+	if _, err := tx.Exec(ctx, deleteSQL, userID); err != nil {
+		return "", fmt.Errorf("cannot use password reset token: %w", err)
+	}
+	if err := setCredentials(ctx, tx, SetPasswordParams{
+		UserID:   userID,
+		Password: p.Password,
+	}); err != nil {
+		return "", err
+	}
+	// Whoever had the password might still be logged in, or might have added a passkey to log in without it.
+	if err := closeUserSessions(ctx, tx, userID, p.StickyID); err != nil {
+		return "", err
+	}
+	const passkeysSQL = `DELETE FROM users_passkeys WHERE "user_id" = $1`
+	if _, err := tx.Exec(ctx, passkeysSQL, userID); err != nil {
+		return "", fmt.Errorf("cannot delete passkeys: %w", err)
+	}
+	// Opening the link proves the user owns the email address.
+	const verifySQL = `UPDATE users SET "email_verified_at" = COALESCE("email_verified_at", NOW()) WHERE "user_id" = $1`
+	if _, err := tx.Exec(ctx, verifySQL, userID); err != nil {
+		return "", fmt.Errorf("cannot verify email address: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("cannot reset password: %w", err)
+	}
+	invalidateCachedUsers(ctx, a.core, userID)
+	// Sessions on PostgreSQL were closed along with the password change, but other stores must close them too.
+	// Failing to do so isn't returned as an error, as the password was already reset and the token used.
+	if store := a.core.SessionStore; store != nil {
+		if err := store.CloseUserSessions(ctx, userID, p.StickyID); err != nil {
+			log.Printf("cannot close sessions of user %q after resetting password: %v", userID, err)
+		}
+	}
+	return userID, nil
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"testing"
+)
+
+func TestResetPassword(t *testing.T) {
+	core := newTestCore(t)
+	transport := useMemoryTransport(core)
+	core.Settings.PublicURL = "https://www.example.com"
+	ctx := context.Background()
+	accounts := Accounts{core: core}
+
+	u, err := accounts.Signup(ctx, SignupParams{Name: "Jane Doe", Email: "jane@example.com", Password: "correct horse battery staple"})
+	if err != nil {
+		t.Fatalf("cannot sign up: %v", err)
+	}
+	const sessionsSQL = `INSERT INTO http_sessions ("id", "sticky_id", "created_at", "expiration", "state", "user_id", "type") VALUES
+('s1', 'current', NOW(), NOW() + INTERVAL '1 DAY', 'active', $1, 'ephemeral'),
+('s2', 'other', NOW(), NOW() + INTERVAL '1 DAY', 'active', $1, 'ephemeral')`
+	if _, err := core.Postgres.Exec(ctx, sessionsSQL, u.UserID); err != nil {
+		t.Fatalf("cannot create test sessions: %v", err)
+	}
+	const passkeySQL = `INSERT INTO users_passkeys ("credential_id", "user_id", "name", "public_key", "sign_count", "created_at") VALUES ('\x01', $1, 'Laptop', '\x02', 0, NOW())`
+	if _, err := core.Postgres.Exec(ctx, passkeySQL, u.UserID); err != nil {
+		t.Fatalf("cannot create test passkey: %v", err)
+	}
+
+	if err := accounts.RequestPasswordReset(ctx, "john@example.com"); err != nil {
+		t.Errorf("wanted no error requesting password reset for unknown email address, got %v instead", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := accounts.RequestPasswordReset(ctx, "jane@example.com"); err != nil {
+			t.Fatalf("cannot request password reset: %v", err)
+		}
+	}
+	deliverTestMail(t, core)
+	messages := transport.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("wanted two password reset emails, got %+v instead", messages)
+	}
+	tokenFromEmail := func(m Message) string {
+		link := regexp.MustCompile(`https://www\.example\.com/recover/reset\?token=\S+`).FindString(m.Text)
+		l, err := url.Parse(link)
+		if err != nil || link == "" {
+			t.Fatalf("password reset link not found on email: %q", m.Text)
+		}
+		return l.Query().Get("token")
+	}
+	previous, token := tokenFromEmail(messages[0]), tokenFromEmail(messages[1])
+	if err := accounts.CheckPasswordResetToken(ctx, previous); err != ErrInvalidPasswordResetToken {
+		t.Errorf("wanted error %v for previous token, got %v instead", ErrInvalidPasswordResetToken, err)
+	}
+	if err := accounts.CheckPasswordResetToken(ctx, token); err != nil {
+		t.Fatalf("unexpected error checking token: %v", err)
+	}
+
+	if _, err := accounts.ResetPassword(ctx, ResetPasswordParams{Token: token, Password: "short"}); err == nil {
+		t.Fatal("wanted error resetting to a weak password")
+	}
+	userID, err := accounts.ResetPassword(ctx, ResetPasswordParams{Token: token, Password: "purple monkey dishwasher", StickyID: "current"})
+	if err != nil || userID != u.UserID {
+		t.Fatalf("cannot reset password: %v", err)
+	}
+	if err := accounts.CheckPassword(ctx, u.UserID, "purple monkey dishwasher"); err != nil {
+		t.Errorf("wanted new password to work, got %v instead", err)
+	}
+	if _, err := accounts.ResetPassword(ctx, ResetPasswordParams{Token: token, Password: "another long passphrase"}); err != ErrInvalidPasswordResetToken {
+		t.Errorf("wanted error %v reusing token, got %v instead", ErrInvalidPasswordResetToken, err)
+	}
+
+	rows, err := core.Postgres.Query(ctx, `SELECT "sticky_id", "state" FROM http_sessions ORDER BY "id"`)
+	if err != nil {
+		t.Fatalf("cannot get sessions: %v", err)
+	}
+	defer rows.Close()
+	got := map[string]string{}
+	for rows.Next() {
+		var stickyID, state string
+		if err := rows.Scan(&stickyID, &state); err != nil {
+			t.Fatal(err)
+		}
+		got[stickyID] = state
+	}
+	if got["current"] != "active" || got["other"] != "expired" {
+		t.Errorf("wanted only the other session to be closed, got %v instead", got)
+	}
+	passkeys := Passkeys{core: core}
+	if list, err := passkeys.List(ctx, u.UserID); err != nil || len(list) != 0 {
+		t.Errorf("wanted passkeys to be removed after reset, got %+v (error: %v)", list, err)
+	}
+	if u, err := accounts.GetUserByID(ctx, u.UserID); err != nil || !u.EmailVerified() {
+		t.Errorf("wanted email address to be verified after reset, got %+v (error: %v)", u, err)
+	}
+}
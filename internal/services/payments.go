@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// PaymentProvider processes the payments of orders.
+type PaymentProvider interface {
+	// Name of the provider, stored alongside the payment ID of an order.
+	Name() string
+
+	// Charge a payment and return its ID on the provider.
+	// ErrPaymentDeclined is returned if the payment is refused, and ErrInvalidPaymentToken if the token is invalid.
+	Charge(ctx context.Context, p ChargeParams) (paymentID string, err error)
+
+	// Refund a payment in full.
+	// Refunding a payment that was already refunded must succeed without giving the money back again,
+	// so that refunds interrupted before being saved can be retried.
+	Refund(ctx context.Context, paymentID string, amount Price) error
+}
+
+// ChargeParams for charging a payment.
+type ChargeParams struct {
+	// OrderID to use as a reference on the payment provider.
+	OrderID string
+
+	// Amount to charge.
+	Amount Price
+
+	// Token representing the payment method, as obtained by the payment form of the provider.
+	Token string
+}
+
+// ErrPaymentDeclined is returned when the payment provider refuses a payment.
+var ErrPaymentDeclined = errors.New("payment declined")
+
+// ErrInvalidPaymentToken is returned when the token of the payment method is invalid, such as when it expired.
+var ErrInvalidPaymentToken = errors.New("invalid payment token")
+
+// ErrPaymentProviderUnavailable is returned when no payment provider is configured.
+var ErrPaymentProviderUnavailable = errors.New("payment provider is not configured")
+
+// NewPaymentProvider returns the payment provider with the given name.
+// An empty name returns no provider, meaning orders cannot be paid.
+func NewPaymentProvider(name string) (PaymentProvider, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case FakePaymentProviderName:
+		return &FakePaymentProvider{}, nil
+	}
+	return nil, fmt.Errorf("unknown payment provider %q", name)
+}
+
+// FakePaymentProviderName is the name of the FakePaymentProvider.
+const FakePaymentProviderName = "fake"
+
+// Tokens accepted by the FakePaymentProvider.
+const (
+	FakePaymentApprovedToken = "fake-approved"
+	FakePaymentDeclinedToken = "fake-declined"
+)
+
+// FakePaymentProvider approves or declines payments depending on the token, without charging anything.
+// It allows testing the checkout flow locally and must not be used in production.
+type FakePaymentProvider struct{}
+
+// Name of the provider.
+func (f *FakePaymentProvider) Name() string {
+	return FakePaymentProviderName
+}
+
+// Charge a fake payment.
+func (f *FakePaymentProvider) Charge(ctx context.Context, p ChargeParams) (paymentID string, err error) {
+	if err := p.Amount.Validate(); err != nil {
+		return "", err
+	}
+	if p.Amount.Amount == 0 {
+		return "", errors.New("cannot charge zero")
+	}
+	switch p.Token {
+	case FakePaymentApprovedToken:
+		return "fake_" + new11RandomID(), nil
+	case FakePaymentDeclinedToken:
+		return "", ErrPaymentDeclined
+	}
+	return "", ErrInvalidPaymentToken
+}
+
+// Refund a fake payment.
+func (f *FakePaymentProvider) Refund(ctx context.Context, paymentID string, amount Price) error {
+	if !strings.HasPrefix(paymentID, "fake_") {
+		return fmt.Errorf("unknown payment %q", paymentID)
+	}
+	return amount.Validate()
+}
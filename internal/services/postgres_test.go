@@ -0,0 +1,307 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// testDatabaseEnv is the environment variable with the connection string of a PostgreSQL database used for testing.
+// Tests depending on PostgreSQL are skipped if it is not set.
+const testDatabaseEnv = "MARKET_TEST_DATABASE"
+
+// testTables are created on a temporary schema for each test.
+const testTables = `
+CREATE TABLE categories (
+	"category_id" text PRIMARY KEY,
+	"parent_id" text REFERENCES categories ("category_id"),
+	"slug" text NOT NULL UNIQUE,
+	"name" text NOT NULL,
+	"created_at" timestamptz NOT NULL,
+	"updated_at" timestamptz NOT NULL
+);
+CREATE TABLE products (
+	"product_id" text PRIMARY KEY,
+	"category_id" text REFERENCES categories ("category_id"),
+	"name" text NOT NULL,
+	"description" text NOT NULL,
+	"brand" text NOT NULL,
+	"images" text[] NOT NULL,
+	"attributes" jsonb NOT NULL,
+	"options" jsonb NOT NULL,
+	"status" text NOT NULL,
+	"created_at" timestamptz NOT NULL,
+	"updated_at" timestamptz NOT NULL
+);
+CREATE TABLE products_variants (
+	"variant_id" text PRIMARY KEY,
+	"product_id" text NOT NULL REFERENCES products ("product_id"),
+	"name" text NOT NULL,
+	"price_amount" bigint NOT NULL,
+	"price_currency" text NOT NULL,
+	"options" jsonb NOT NULL,
+	"images" text[] NOT NULL,
+	"created_at" timestamptz NOT NULL,
+	"updated_at" timestamptz NOT NULL
+);
+CREATE TABLE inventory (
+	"variant_id" text PRIMARY KEY REFERENCES products_variants ("variant_id"),
+	"on_hand" integer NOT NULL CHECK ("on_hand" >= 0),
+	"reserved" integer NOT NULL CHECK ("reserved" >= 0 AND "reserved" <= "on_hand"),
+	"updated_at" timestamptz NOT NULL
+);
+CREATE TABLE carts (
+	"cart_id" text PRIMARY KEY,
+	"user_id" text UNIQUE,
+	"sticky_id" text UNIQUE,
+	"created_at" timestamptz NOT NULL,
+	"updated_at" timestamptz NOT NULL
+);
+CREATE TABLE carts_items (
+	"cart_id" text NOT NULL REFERENCES carts ("cart_id"),
+	"variant_id" text NOT NULL REFERENCES products_variants ("variant_id"),
+	"quantity" integer NOT NULL,
+	"added_at" timestamptz NOT NULL,
+	PRIMARY KEY ("cart_id", "variant_id")
+);
+CREATE TABLE orders (
+	"order_id" text PRIMARY KEY,
+	"user_id" text NOT NULL,
+	"status" text NOT NULL,
+	"total_amount" bigint NOT NULL,
+	"total_currency" text NOT NULL,
+	"payment_provider" text,
+	"payment_id" text,
+	"created_at" timestamptz NOT NULL,
+	"updated_at" timestamptz NOT NULL
+);
+CREATE TABLE orders_items (
+	"order_id" text NOT NULL REFERENCES orders ("order_id"),
+	"variant_id" text NOT NULL,
+	"product_id" text NOT NULL,
+	"product_name" text NOT NULL,
+	"variant_name" text NOT NULL,
+	"price_amount" bigint NOT NULL,
+	"price_currency" text NOT NULL,
+	"quantity" integer NOT NULL
+);
+CREATE TABLE inventory_reservations (
+	"order_id" text NOT NULL REFERENCES orders ("order_id"),
+	"variant_id" text NOT NULL REFERENCES inventory ("variant_id"),
+	"quantity" integer NOT NULL,
+	"expires_at" timestamptz NOT NULL,
+	PRIMARY KEY ("order_id", "variant_id")
+);
+CREATE TABLE users (
+	"user_id" text PRIMARY KEY,
+	"name" text NOT NULL,
+	"email" text NOT NULL UNIQUE,
+	"phone" text NOT NULL,
+	"created_at" timestamptz NOT NULL,
+	"updated_at" timestamptz NOT NULL DEFAULT NOW(),
+	"access" text NOT NULL,
+	"email_verified_at" timestamptz
+);
+CREATE TABLE roles (
+	"role_id" text PRIMARY KEY,
+	"name" text NOT NULL,
+	"description" text NOT NULL,
+	"permissions" text[] NOT NULL,
+	"created_at" timestamptz NOT NULL,
+	"updated_at" timestamptz NOT NULL
+);
+CREATE TABLE users_roles (
+	"user_id" text NOT NULL REFERENCES users ("user_id"),
+	"role_id" text NOT NULL REFERENCES roles ("role_id"),
+	"granted_at" timestamptz NOT NULL,
+	"granted_by" text NOT NULL,
+	PRIMARY KEY ("user_id", "role_id")
+);
+CREATE TABLE users_credentials (
+	"user_id" text PRIMARY KEY REFERENCES users ("user_id"),
+	"password_hash" text NOT NULL,
+	"updated_at" timestamptz NOT NULL DEFAULT NOW()
+);
+CREATE TABLE users_mfa (
+	"user_id" text PRIMARY KEY REFERENCES users ("user_id"),
+	"totp_secret" text NOT NULL,
+	"enabled_at" timestamptz,
+	"last_used_step" bigint NOT NULL,
+	"created_at" timestamptz NOT NULL
+);
+CREATE TABLE users_recovery_codes (
+	"user_id" text NOT NULL REFERENCES users ("user_id"),
+	"code_hash" text NOT NULL,
+	"created_at" timestamptz NOT NULL,
+	PRIMARY KEY ("user_id", "code_hash")
+);
+CREATE TABLE users_passkeys (
+	"credential_id" bytea PRIMARY KEY,
+	"user_id" text NOT NULL REFERENCES users ("user_id"),
+	"name" text NOT NULL,
+	"public_key" bytea NOT NULL,
+	"sign_count" bigint NOT NULL,
+	"created_at" timestamptz NOT NULL,
+	"last_used_at" timestamptz
+);
+CREATE TABLE webauthn_challenges (
+	"challenge_hash" text PRIMARY KEY,
+	"purpose" text NOT NULL,
+	"user_id" text REFERENCES users ("user_id"),
+	"expires_at" timestamptz NOT NULL
+);
+CREATE TABLE password_resets (
+	"token_hash" text PRIMARY KEY,
+	"user_id" text NOT NULL REFERENCES users ("user_id"),
+	"created_at" timestamptz NOT NULL,
+	"expires_at" timestamptz NOT NULL
+);
+CREATE TABLE http_sessions (
+	"id" text PRIMARY KEY,
+	"sticky_id" text NOT NULL,
+	"created_at" timestamptz NOT NULL,
+	"expiration" timestamptz NOT NULL,
+	"state" text NOT NULL,
+	"user_id" text NOT NULL,
+	"type" text NOT NULL,
+	"user_agent" text NOT NULL DEFAULT '',
+	"ip" text NOT NULL DEFAULT '',
+	"last_seen_at" timestamptz NOT NULL DEFAULT NOW()
+);
+CREATE TABLE scheduled_tasks (
+	"name" text PRIMARY KEY,
+	"last_run_at" timestamptz NOT NULL,
+	"last_duration_ms" bigint NOT NULL,
+	"last_result" integer NOT NULL,
+	"last_error" text
+);
+CREATE TABLE mail_queue (
+	"mail_id" text PRIMARY KEY,
+	"to" text NOT NULL,
+	"subject" text NOT NULL,
+	"text" text NOT NULL,
+	"html" text NOT NULL,
+	"attempts" integer NOT NULL,
+	"last_error" text,
+	"next_attempt_at" timestamptz NOT NULL,
+	"created_at" timestamptz NOT NULL
+);
+CREATE TABLE api_tokens (
+	"token_id" text PRIMARY KEY,
+	"user_id" text NOT NULL REFERENCES users ("user_id"),
+	"name" text NOT NULL,
+	"kind" text NOT NULL,
+	"token_hash" text NOT NULL UNIQUE,
+	"scopes" text[] NOT NULL,
+	"client_id" text,
+	"created_at" timestamptz NOT NULL,
+	"expires_at" timestamptz,
+	"last_used_at" timestamptz
+);
+CREATE TABLE oauth_clients (
+	"client_id" text PRIMARY KEY,
+	"name" text NOT NULL,
+	"secret_hash" text,
+	"redirect_uris" text[] NOT NULL,
+	"scopes" text[] NOT NULL,
+	"created_at" timestamptz NOT NULL
+);
+CREATE TABLE oauth_codes (
+	"code_hash" text PRIMARY KEY,
+	"client_id" text NOT NULL REFERENCES oauth_clients ("client_id"),
+	"user_id" text NOT NULL REFERENCES users ("user_id"),
+	"redirect_uri" text NOT NULL,
+	"scopes" text[] NOT NULL,
+	"code_challenge" text NOT NULL,
+	"expires_at" timestamptz NOT NULL
+);
+CREATE TABLE oauth_refresh_tokens (
+	"token_hash" text PRIMARY KEY,
+	"client_id" text NOT NULL REFERENCES oauth_clients ("client_id"),
+	"user_id" text NOT NULL REFERENCES users ("user_id"),
+	"scopes" text[] NOT NULL,
+	"access_token_id" text NOT NULL,
+	"created_at" timestamptz NOT NULL,
+	"expires_at" timestamptz NOT NULL
+);
+`
+
+// newTestCore connects to the test database and creates the tables on a temporary schema.
+// The schema is dropped when the test finishes.
+func newTestCore(t *testing.T) *Core {
+	t.Helper()
+	dsn := os.Getenv(testDatabaseEnv)
+	if dsn == "" {
+		t.Skipf("skipping test depending on PostgreSQL: %s is not set", testDatabaseEnv)
+	}
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatalf("cannot connect to test database: %v", err)
+	}
+	defer conn.Close(ctx)
+
+	schema := "test_" + strings.ToLower(new11RandomID())
+	if _, err := conn.Exec(ctx, fmt.Sprintf("CREATE SCHEMA %s", schema)); err != nil {
+		t.Fatalf("cannot create test schema: %v", err)
+	}
+	t.Cleanup(func() {
+		conn, err := pgx.Connect(context.Background(), dsn)
+		if err != nil {
+			t.Errorf("cannot connect to test database to drop schema %q: %v", schema, err)
+			return
+		}
+		defer conn.Close(context.Background())
+		if _, err := conn.Exec(context.Background(), fmt.Sprintf("DROP SCHEMA %s CASCADE", schema)); err != nil {
+			t.Errorf("cannot drop test schema %q: %v", schema, err)
+		}
+	})
+	if _, err := conn.Exec(ctx, fmt.Sprintf("SET search_path TO %s;\n%s", schema, testTables)); err != nil {
+		t.Fatalf("cannot create test tables: %v", err)
+	}
+
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		t.Fatalf("cannot parse test database connection string: %v", err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema
+	config.MaxConns = 20
+	pool, err := pgxpool.ConnectConfig(ctx, config)
+	if err != nil {
+		t.Fatalf("cannot connect to test database: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	return &Core{Postgres: pool}
+}
+
+// newTestUser creates a user named Jane, returning its ID.
+func newTestUser(t *testing.T, core *Core) (userID string) {
+	t.Helper()
+	accounts := Accounts{core: core}
+	userID, err := accounts.NewUser(context.Background(), NewUserParams{Name: "Jane", Email: "jane@example.com"})
+	if err != nil {
+		t.Fatalf("cannot create test user: %v", err)
+	}
+	return userID
+}
+
+// newTestProduct creates a product with a single variant directly on the database, bypassing the search engine.
+func newTestProduct(t *testing.T, core *Core, price Price) (productID, variantID string) {
+	t.Helper()
+	productID, variantID = new11RandomID(), new11RandomID()
+	ctx := context.Background()
+	const productSQL = `INSERT INTO products ("product_id", "name", "description", "brand", "images", "attributes", "options", "status", "created_at", "updated_at") VALUES ($1, 'Test product', '', '', '{}', '{}', '[]', $2, NOW(), NOW())`
+	if _, err := core.Postgres.Exec(ctx, productSQL, productID, string(ProductActive)); err != nil {
+		t.Fatalf("cannot create test product: %v", err)
+	}
+	const variantSQL = `INSERT INTO products_variants ("variant_id", "product_id", "name", "price_amount", "price_currency", "options", "images", "created_at", "updated_at") VALUES ($1, $2, '', $3, $4, '{}', '{}', NOW(), NOW())`
+	if _, err := core.Postgres.Exec(ctx, variantSQL, variantID, productID, price.Amount, price.Currency); err != nil {
+		t.Fatalf("cannot create test variant: %v", err)
+	}
+	return productID, variantID
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/plifk/market/internal/passwords"
+)
+
+// UpdateProfileParams to change the name and phone number of a user.
+type UpdateProfileParams struct {
+	UserID string
+	Name   string
+	Phone  string
+}
+
+// UpdateProfile of a user. The phone number is normalized to the E.164 format.
+func (a *Accounts) UpdateProfile(ctx context.Context, p UpdateProfileParams) error {
+	u, err := a.GetUserByID(ctx, p.UserID)
+	if err != nil {
+		return err
+	}
+	params := NewUserParams{
+		Name:  strings.TrimSpace(p.Name),
+		Email: u.Email,
+		Phone: strings.TrimSpace(p.Phone),
+	}
+	if err := params.ValidateAndNormalize(); err != nil {
+		return invalid(err)
+	}
+	pg := a.core.Postgres
+	const sql = `UPDATE users SET "name" = $2, "phone" = $3, "updated_at" = NOW() WHERE "user_id" = $1`
+	if _, err := pg.Exec(ctx, sql, p.UserID, params.Name, params.Phone); err != nil {
+		return fmt.Errorf("cannot update profile: %w", err)
+	}
+	invalidateCachedUsers(ctx, a.core, p.UserID)
+	return nil
+}
+
+// ChangePasswordParams to change the password of a user who knows the current one.
+type ChangePasswordParams struct {
+	UserID          string
+	CurrentPassword string
+	NewPassword     string
+
+	// StickyID of the session the password is changed from.
+	// All other sessions of the user are closed.
+	StickyID string
+}
+
+// ChangePassword of the user, after checking the current password.
+// It returns ErrWrongPassword if the current password is wrong.
+func (a *Accounts) ChangePassword(ctx context.Context, p ChangePasswordParams) error {
+	u, err := a.GetUserByID(ctx, p.UserID)
+	if err != nil {
+		return err
+	}
+	if err := a.CheckPassword(ctx, u.UserID, p.CurrentPassword); err != nil {
+		return err
+	}
+	if err := passwords.Validate(p.NewPassword, u.Name, u.Email); err != nil {
+		return invalid(err)
+	}
+	if err := a.SetCredentials(ctx, SetPasswordParams{
+		UserID:   u.UserID,
+		Password: p.NewPassword,
+	}); err != nil {
+		return err
+	}
+	// Whoever else had the password might still be logged in.
+	// Failing to log them out isn't returned as an error, as the password was already changed.
+	sessions := Sessions{core: a.core}
+	if err := sessions.CloseUserSessions(ctx, u.UserID, p.StickyID); err != nil {
+		log.Printf("cannot close sessions of user %q after changing password: %v", u.UserID, err)
+	}
+	return nil
+}
+
+// emailChangeLifetime is how long an email change confirmation link is valid.
+const emailChangeLifetime = 48 * time.Hour
+
+// emailChangePurpose of the signed email change tokens.
+const emailChangePurpose = "change-email"
+
+// ErrSameEmail is returned when changing the email address to the current one.
+var ErrSameEmail = errors.New("this is already your email address")
+
+// ChangeEmailMail is the content of the email sent to confirm a new email address.
+type ChangeEmailMail struct {
+	Email      string
+	Link       string
+	ValidHours int
+}
+
+// EmailChangedMail is the content of the notice sent to the previous email address of a user.
+type EmailChangedMail struct {
+	Email string
+}
+
+// EmailInUseMail is the content of the notice sent when a user tries to change their email address to one already in use.
+type EmailInUseMail struct {
+	RecoverLink string
+}
+
+// RequestEmailChange sends a link to the new email address, which the user must open to confirm it.
+// The email address of the user only changes after that, with ConfirmEmailChange.
+//
+// If the email address is already in use, its owner is sent a notice instead, and no error is returned,
+// so that it doesn't disclose who is registered.
+func (a *Accounts) RequestEmailChange(ctx context.Context, u *User, email string) error {
+	email = strings.TrimSpace(email)
+	if err := validateEmail(email); err != nil {
+		return invalid(err)
+	}
+	if strings.EqualFold(email, u.Email) {
+		return ErrSameEmail
+	}
+	mailer := Mailer{core: a.core}
+	switch owner, err := a.GetUserByEmail(ctx, email); {
+	case err == nil:
+		return mailer.Send(ctx, owner.Email, "email-in-use", MailData{
+			Name: owner.Name,
+			Content: EmailInUseMail{
+				RecoverLink: strings.TrimSuffix(a.core.Settings.PublicURL, "/") + "/recover",
+			},
+		})
+	case err != ErrUserNotFound:
+		return err
+	}
+	settings := a.core.Settings
+	// The current email address is part of the token, so that it is invalid once the email address changes.
+	token, err := signToken(settings.SecretKey, emailChangePurpose, time.Now().Add(emailChangeLifetime), u.UserID, u.Email, email)
+	if err != nil {
+		return fmt.Errorf("cannot create email change token: %w", err)
+	}
+	return mailer.Send(ctx, email, "change-email", MailData{
+		Name: u.Name,
+		Content: ChangeEmailMail{
+			Email:      email,
+			Link:       strings.TrimSuffix(settings.PublicURL, "/") + "/account/email/confirm?token=" + url.QueryEscape(token),
+			ValidHours: int(emailChangeLifetime.Hours()),
+		},
+	})
+}
+
+// ConfirmEmailChange changes the email address of the user to the one confirmed by the token, returning the user.
+// A notice is sent to the previous email address.
+func (a *Accounts) ConfirmEmailChange(ctx context.Context, token string) (*User, error) {
+	fields, err := verifySignedToken(a.core.Settings.SecretKey, emailChangePurpose, token)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != 3 {
+		return nil, ErrInvalidSignedToken
+	}
+	userID, previous, email := fields[0], fields[1], fields[2]
+	pg := a.core.Postgres
+	// Opening the link proves the user owns the new email address.
+	const sql = `UPDATE users SET "email" = $3, "email_verified_at" = NOW(), "updated_at" = NOW() WHERE "user_id" = $1 AND "email" = $2`
+	switch ct, err := pg.Exec(ctx, sql, userID, previous, email); {
+	case isUniqueViolation(err):
+		return nil, ErrEmailTaken
+	case err != nil:
+		return nil, fmt.Errorf("cannot change email address: %w", err)
+	case ct.RowsAffected() == 0:
+		return nil, ErrInvalidSignedToken
+	}
+	invalidateCachedUsers(ctx, a.core, userID)
+	u, err := a.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	// The email address was changed anyway, so failing to send the notice isn't returned as an error.
+	mailer := Mailer{core: a.core}
+	if err := mailer.Send(ctx, previous, "email-changed", MailData{
+		Name:    u.Name,
+		Content: EmailChangedMail{Email: email},
+	}); err != nil {
+		log.Printf("cannot notify previous email address of user %q: %v", userID, err)
+	}
+	return u, nil
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNewUserParamsPhone(t *testing.T) {
+	testCases := []struct {
+		phone string
+		want  string
+		err   bool
+	}{
+		{phone: "", want: ""},
+		{phone: "(201) 555-0123", want: "+12015550123"},
+		{phone: "+44 20 7946 0958", want: "+442079460958"},
+		{phone: "123", err: true},
+		{phone: "phone", err: true},
+	}
+	for _, tc := range testCases {
+		p := NewUserParams{Name: "Jane Doe", Email: "jane@example.com", Phone: tc.phone}
+		err := p.ValidateAndNormalize()
+		if tc.err {
+			if err == nil {
+				t.Errorf("wanted error for phone %q", tc.phone)
+			}
+			continue
+		}
+		if err != nil || p.Phone != tc.want {
+			t.Errorf("wanted phone %q normalized to %q, got %q (error: %v)", tc.phone, tc.want, p.Phone, err)
+		}
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	core := newTestCore(t)
+	core.UserCache = NewUserCache()
+	ctx := context.Background()
+	accounts := Accounts{core: core}
+
+	u, err := accounts.Signup(ctx, SignupParams{Name: "Jane Doe", Email: "jane@example.com", Password: "correct horse battery staple"})
+	if err != nil {
+		t.Fatalf("cannot sign up: %v", err)
+	}
+	if _, err := accounts.GetCachedUserByID(ctx, u.UserID); err != nil {
+		t.Fatalf("cannot get user: %v", err)
+	}
+	if err := accounts.UpdateProfile(ctx, UpdateProfileParams{UserID: u.UserID, Name: " Jane Roe ", Phone: "(201) 555-0123"}); err != nil {
+		t.Fatalf("cannot update profile: %v", err)
+	}
+	if u, err := accounts.GetCachedUserByID(ctx, u.UserID); err != nil || u.Name != "Jane Roe" || u.Phone != "+12015550123" {
+		t.Errorf("wanted profile updated, got %+v (error: %v)", u, err)
+	}
+	if err := accounts.UpdateProfile(ctx, UpdateProfileParams{UserID: u.UserID, Name: ""}); err == nil {
+		t.Error("wanted error updating profile without a name")
+	}
+	if err := accounts.UpdateProfile(ctx, UpdateProfileParams{UserID: u.UserID, Name: "Jane", Phone: "123"}); err == nil {
+		t.Error("wanted error updating profile with an invalid phone number")
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	core := newTestCore(t)
+	ctx := context.Background()
+	accounts := Accounts{core: core}
+
+	u, err := accounts.Signup(ctx, SignupParams{Name: "Jane Doe", Email: "jane@example.com", Password: "correct horse battery staple"})
+	if err != nil {
+		t.Fatalf("cannot sign up: %v", err)
+	}
+	const sessionsSQL = `INSERT INTO http_sessions ("id", "sticky_id", "created_at", "expiration", "state", "user_id", "type") VALUES
+('s1', 'current', NOW(), NOW() + INTERVAL '1 DAY', 'active', $1, 'ephemeral'),
+('s2', 'other', NOW(), NOW() + INTERVAL '1 DAY', 'active', $1, 'ephemeral')`
+	if _, err := core.Postgres.Exec(ctx, sessionsSQL, u.UserID); err != nil {
+		t.Fatalf("cannot create test sessions: %v", err)
+	}
+
+	if err := accounts.ChangePassword(ctx, ChangePasswordParams{
+		UserID:          u.UserID,
+		CurrentPassword: "wrong password",
+		NewPassword:     "purple monkey dishwasher",
+	}); err != ErrWrongPassword {
+		t.Errorf("wanted error %v, got %v instead", ErrWrongPassword, err)
+	}
+	if err := accounts.ChangePassword(ctx, ChangePasswordParams{
+		UserID:          u.UserID,
+		CurrentPassword: "correct horse battery staple",
+		NewPassword:     "short",
+	}); err == nil {
+		t.Error("wanted error changing to a weak password")
+	}
+	if err := accounts.ChangePassword(ctx, ChangePasswordParams{
+		UserID:          u.UserID,
+		CurrentPassword: "correct horse battery staple",
+		NewPassword:     "purple monkey dishwasher",
+		StickyID:        "current",
+	}); err != nil {
+		t.Fatalf("cannot change password: %v", err)
+	}
+	if err := accounts.CheckPassword(ctx, u.UserID, "purple monkey dishwasher"); err != nil {
+		t.Errorf("wanted new password to work, got %v instead", err)
+	}
+	var active []string
+	rows, err := core.Postgres.Query(ctx, `SELECT "sticky_id" FROM http_sessions WHERE "state" = 'active'`)
+	if err != nil {
+		t.Fatalf("cannot list sessions: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			t.Fatalf("cannot read session: %v", err)
+		}
+		active = append(active, s)
+	}
+	if len(active) != 1 || active[0] != "current" {
+		t.Errorf("wanted only the current session active, got %v instead", active)
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	core := newTestCore(t)
+	transport := useMemoryTransport(core)
+	core.Settings.SecretKey = "secret"
+	core.Settings.PublicURL = "https://www.example.com"
+	ctx := context.Background()
+	accounts := Accounts{core: core}
+
+	u, err := accounts.Signup(ctx, SignupParams{Name: "Jane Doe", Email: "jane@example.com", Password: "correct horse battery staple"})
+	if err != nil {
+		t.Fatalf("cannot sign up: %v", err)
+	}
+	if _, err := accounts.Signup(ctx, SignupParams{Name: "John Doe", Email: "john@example.com", Password: "correct horse battery staple"}); err != nil {
+		t.Fatalf("cannot sign up: %v", err)
+	}
+	if err := accounts.RequestEmailChange(ctx, u, "jane@example.com"); err != ErrSameEmail {
+		t.Errorf("wanted error %v, got %v instead", ErrSameEmail, err)
+	}
+	// Changing to an email address in use looks the same, but only tells its owner.
+	if err := accounts.RequestEmailChange(ctx, u, "john@example.com"); err != nil {
+		t.Errorf("wanted no error changing to an email address in use, got %v instead", err)
+	}
+	deliverTestMail(t, core)
+	messages := transport.Messages()
+	if len(messages) != 1 || messages[0].To != "john@example.com" || strings.Contains(messages[0].Text, "/account/email/confirm") {
+		t.Fatalf("wanted notice sent to the owner of the email address, got %+v instead", messages)
+	}
+	if err := accounts.RequestEmailChange(ctx, u, "jane@example.net"); err != nil {
+		t.Fatalf("cannot request email change: %v", err)
+	}
+	if u, err := accounts.GetUserByID(ctx, u.UserID); err != nil || u.Email != "jane@example.com" {
+		t.Errorf("wanted email address unchanged until confirmed, got %+v (error: %v)", u, err)
+	}
+	deliverTestMail(t, core)
+	messages = transport.Messages()
+	if len(messages) != 2 || messages[1].To != "jane@example.net" {
+		t.Fatalf("wanted confirmation email sent to the new email address, got %+v instead", messages)
+	}
+	link := regexp.MustCompile(`https://www\.example\.com/account/email/confirm\?token=\S+`).FindString(messages[1].Text)
+	l, err := url.Parse(link)
+	if err != nil || link == "" {
+		t.Fatalf("confirmation link not found on email: %q", messages[1].Text)
+	}
+	token := l.Query().Get("token")
+
+	if _, err := accounts.ConfirmEmailChange(ctx, token+"x"); err != ErrInvalidSignedToken {
+		t.Errorf("wanted error %v, got %v instead", ErrInvalidSignedToken, err)
+	}
+	changed, err := accounts.ConfirmEmailChange(ctx, token)
+	if err != nil {
+		t.Fatalf("cannot confirm email change: %v", err)
+	}
+	if changed.Email != "jane@example.net" || !changed.EmailVerified() {
+		t.Errorf("wanted email address changed and verified, got %+v instead", changed)
+	}
+	if _, err := accounts.ConfirmEmailChange(ctx, token); err != ErrInvalidSignedToken {
+		t.Errorf("wanted error %v confirming again, got %v instead", ErrInvalidSignedToken, err)
+	}
+	deliverTestMail(t, core)
+	messages = transport.Messages()
+	if len(messages) != 3 || messages[2].To != "jane@example.com" {
+		t.Fatalf("wanted notice sent to the previous email address, got %+v instead", messages)
+	}
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"net/http"
+
+	"github.com/plifk/market/internal/ratelimit"
+)
+
+// RateLimits of HTTP requests, used by the frontend and the API.
+type RateLimits struct {
+	core *Core
+}
+
+// rateLimitPrefix of the Redis keys.
+const rateLimitPrefix = "market:ratelimit:"
+
+// Middleware limiting the rate of requests of each client according to the rules, or the default policy.
+// Clients are identified by user, if authenticated, or IP address otherwise.
+// Users with access to the admin area are never limited.
+//
+// Limits are counted on Redis, so they are shared between servers.
+// Without Redis, such as on tests, they are counted in memory by each middleware.
+func (rl *RateLimits) Middleware(rules []ratelimit.Rule, def ratelimit.Policy, denied http.Handler) *ratelimit.Middleware {
+	var (
+		store    ratelimit.Store = &ratelimit.MemoryStore{}
+		security Security
+	)
+	if rl.core != nil {
+		if rl.core.Redis != nil {
+			store = &ratelimit.RedisStore{Client: rl.core.Redis, Prefix: rateLimitPrefix}
+		}
+		security.behindProxy = rl.core.Settings.BehindProxy
+	}
+	return &ratelimit.Middleware{
+		Store:   store,
+		Rules:   rules,
+		Default: def,
+		Key: func(r *http.Request) string {
+			if u := UserFromRequest(r); u != nil {
+				return "user:" + u.UserID
+			}
+			return "ip:" + security.ClientIP(r)
+		},
+		Exempt: func(r *http.Request) bool {
+			return Can(UserFromRequest(r), PermissionAdminArea)
+		},
+		Denied: denied,
+	}
+}
@@ -0,0 +1,31 @@
+package services
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// testRedisEnv is the environment variable with the address of a Redis server used for testing.
+// Tests depending on Redis are skipped if it is not set.
+// Tests use random keys, so they don't interfere with each other, but don't use a server with data you care about.
+const testRedisEnv = "MARKET_TEST_REDIS"
+
+// newTestRedisCore connects to the test Redis server.
+func newTestRedisCore(t *testing.T) *Core {
+	t.Helper()
+	addr := os.Getenv(testRedisEnv)
+	if addr == "" {
+		t.Skipf("skipping test depending on Redis: %s is not set", testRedisEnv)
+	}
+	kv := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() {
+		kv.Close()
+	})
+	if err := kv.Ping(context.Background()).Err(); err != nil {
+		t.Fatalf("cannot connect to test Redis server: %v", err)
+	}
+	return &Core{Redis: kv}
+}
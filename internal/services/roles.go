@@ -0,0 +1,476 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Permission to do something on the admin area. Users get permissions through their roles.
+type Permission string
+
+// Permissions that can be granted to roles.
+const (
+	// PermissionAdminArea lets users into the admin area. Every role grants it.
+	PermissionAdminArea Permission = "admin.access"
+
+	PermissionManageCatalog  Permission = "catalog.manage"
+	PermissionViewOrders     Permission = "orders.view"
+	PermissionManageOrders   Permission = "orders.manage"
+	PermissionManageUsers    Permission = "users.manage"
+	PermissionManageSecurity Permission = "security.manage"
+	PermissionViewReports    Permission = "reports.view"
+	PermissionManageOAuth    Permission = "oauth.manage"
+	PermissionManageRoles    Permission = "roles.manage"
+)
+
+// Permissions lists the permissions that can be chosen for a role.
+var Permissions = []Permission{
+	PermissionManageCatalog,
+	PermissionViewOrders,
+	PermissionManageOrders,
+	PermissionManageUsers,
+	PermissionManageSecurity,
+	PermissionViewReports,
+	PermissionManageOAuth,
+	PermissionManageRoles,
+}
+
+// Description of the permission, shown to admins.
+func (p Permission) Description() string {
+	switch p {
+	case PermissionAdminArea:
+		return "Access the admin area"
+	case PermissionManageCatalog:
+		return "Edit products, categories, and inventory"
+	case PermissionViewOrders:
+		return "See orders of all users"
+	case PermissionManageOrders:
+		return "Cancel and refund orders"
+	case PermissionManageUsers:
+		return "See and edit user accounts"
+	case PermissionManageSecurity:
+		return "See and lift login lockouts"
+	case PermissionViewReports:
+		return "See sales reports"
+	case PermissionManageOAuth:
+		return "Register OAuth clients"
+	case PermissionManageRoles:
+		return "Manage roles and assign them to users"
+	}
+	return string(p)
+}
+
+func validPermission(p Permission) bool {
+	for _, v := range Permissions {
+		if v == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Can checks if the user has a permission.
+// Admins (users with AdminAuthorization access) have every permission, and nobody has any if u is nil.
+func Can(u *User, p Permission) bool {
+	if u == nil {
+		return false
+	}
+	if u.Access == AdminAuthorization {
+		return true
+	}
+	for _, v := range u.Permissions {
+		if v == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Role of users, granting them permissions.
+type Role struct {
+	RoleID      string
+	Name        string
+	Description string
+	Permissions []Permission
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Has checks if the role grants a permission.
+func (r *Role) Has(p Permission) bool {
+	for _, v := range r.Permissions {
+		if v == p {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultRoles that admins can add on /admin/roles, instead of creating them one by one.
+var DefaultRoles = []RoleParams{
+	{
+		RoleID:      "catalog-editor",
+		Name:        "Catalog editor",
+		Description: "Keeps products, categories, and inventory up to date.",
+		Permissions: []Permission{PermissionManageCatalog},
+	},
+	{
+		RoleID:      "support-agent",
+		Name:        "Support agent",
+		Description: "Helps customers with their accounts and orders.",
+		Permissions: []Permission{PermissionViewOrders, PermissionManageOrders, PermissionManageUsers, PermissionManageSecurity},
+	},
+	{
+		RoleID:      "finance",
+		Name:        "Finance",
+		Description: "Follows sales and payments.",
+		Permissions: []Permission{PermissionViewOrders, PermissionViewReports},
+	},
+}
+
+var (
+	// ErrRoleNotFound occurs when no role is found.
+	ErrRoleNotFound = errors.New("role not found")
+
+	// ErrRoleExists is returned when creating a role with the ID of an existing one.
+	ErrRoleExists = errors.New("a role with this ID already exists")
+
+	// ErrPermissionDenied is returned when a user tries to grant permissions they don't have.
+	ErrPermissionDenied = errors.New("you cannot grant permissions you don't have")
+)
+
+// Roles of users.
+type Roles struct {
+	core *Core
+}
+
+// RoleParams to create or update a role.
+type RoleParams struct {
+	RoleID      string
+	Name        string
+	Description string
+	Permissions []Permission
+}
+
+var roleIDRegexp = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// ValidateAndNormalize the parameters.
+func (p *RoleParams) ValidateAndNormalize() error {
+	p.RoleID = strings.TrimSpace(p.RoleID)
+	if len(p.RoleID) > 50 || !roleIDRegexp.MatchString(p.RoleID) {
+		return errors.New("role ID must have only lowercase letters, numbers, and hyphens, and at most 50 chars")
+	}
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return errors.New("missing role name")
+	}
+	if len(p.Name) > 100 {
+		return errors.New("role name must be at most 100 chars")
+	}
+	p.Description = strings.TrimSpace(p.Description)
+	if len(p.Description) > 500 {
+		return errors.New("role description must be at most 500 chars")
+	}
+	seen := map[Permission]bool{}
+	permissions := []Permission{PermissionAdminArea}
+	for _, perm := range p.Permissions {
+		if !validPermission(perm) {
+			return fmt.Errorf("invalid permission %q", perm)
+		}
+		if !seen[perm] {
+			seen[perm] = true
+			permissions = append(permissions, perm)
+		}
+	}
+	p.Permissions = permissions
+	return nil
+}
+
+// canGrant checks if the user has all the permissions, so that nobody can give others more power than they have.
+func canGrant(u *User, permissions []Permission) bool {
+	for _, p := range permissions {
+		if !Can(u, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// Create a role on behalf of the actor, who must have all of its permissions.
+func (r *Roles) Create(ctx context.Context, actor *User, p RoleParams) (*Role, error) {
+	if err := p.ValidateAndNormalize(); err != nil {
+		return nil, invalid(err)
+	}
+	if !canGrant(actor, p.Permissions) {
+		return nil, ErrPermissionDenied
+	}
+	role := &Role{
+		RoleID:      p.RoleID,
+		Name:        p.Name,
+		Description: p.Description,
+		Permissions: p.Permissions,
+	}
+	pg := r.core.Postgres
+	const sql = `INSERT INTO roles ("role_id", "name", "description", "permissions", "created_at", "updated_at") VALUES ($1, $2, $3, $4, NOW(), NOW()) RETURNING "created_at", "updated_at"`
+	switch err := pg.QueryRow(ctx, sql, p.RoleID, p.Name, p.Description, permissionsToStrings(p.Permissions)).Scan(&role.CreatedAt, &role.UpdatedAt); {
+	case isUniqueViolation(err):
+		return nil, ErrRoleExists
+	case err != nil:
+		return nil, fmt.Errorf("cannot create role: %w", err)
+	}
+	return role, nil
+}
+
+// Update a role on behalf of the actor, who must have all of its current and new permissions.
+func (r *Roles) Update(ctx context.Context, actor *User, p RoleParams) error {
+	if err := p.ValidateAndNormalize(); err != nil {
+		return invalid(err)
+	}
+	tx, err := r.core.Postgres.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot update role: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	role, err := getRole(ctx, tx, p.RoleID, true)
+	if err != nil {
+		return err
+	}
+	if !canGrant(actor, role.Permissions) || !canGrant(actor, p.Permissions) {
+		return ErrPermissionDenied
+	}
+	const sql = `UPDATE roles SET "name" = $2, "description" = $3, "permissions" = $4, "updated_at" = NOW() WHERE "role_id" = $1`
+	if _, err := tx.Exec(ctx, sql, p.RoleID, p.Name, p.Description, permissionsToStrings(p.Permissions)); err != nil {
+		return fmt.Errorf("cannot update role: %w", err)
+	}
+	members, err := roleMembers(ctx, tx, p.RoleID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("cannot update role: %w", err)
+	}
+	invalidateCachedUsers(ctx, r.core, members...)
+	return nil
+}
+
+// Delete a role on behalf of the actor, who must have all of its permissions. Users with the role lose it.
+func (r *Roles) Delete(ctx context.Context, actor *User, roleID string) error {
+	tx, err := r.core.Postgres.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot delete role: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	role, err := getRole(ctx, tx, roleID, true)
+	if err != nil {
+		return err
+	}
+	if !canGrant(actor, role.Permissions) {
+		return ErrPermissionDenied
+	}
+	members, err := roleMembers(ctx, tx, roleID)
+	if err != nil {
+		return err
+	}
+	const membersSQL = `DELETE FROM users_roles WHERE "role_id" = $1`
+	if _, err := tx.Exec(ctx, membersSQL, roleID); err != nil {
+		return fmt.Errorf("cannot remove role from users: %w", err)
+	}
+	const sql = `DELETE FROM roles WHERE "role_id" = $1`
+	if _, err := tx.Exec(ctx, sql, roleID); err != nil {
+		return fmt.Errorf("cannot delete role: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("cannot delete role: %w", err)
+	}
+	invalidateCachedUsers(ctx, r.core, members...)
+	return nil
+}
+
+// roleMembers returns the IDs of the users with a role.
+func roleMembers(ctx context.Context, q pgQuerier, roleID string) ([]string, error) {
+	const sql = `SELECT "user_id" FROM users_roles WHERE "role_id" = $1`
+	rows, err := q.Query(ctx, sql, roleID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list users with role: %w", err)
+	}
+	defer rows.Close()
+	var userIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("cannot read user with role: %w", err)
+		}
+		userIDs = append(userIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot list users with role: %w", err)
+	}
+	return userIDs, nil
+}
+
+// CreateDefaults creates the DefaultRoles that don't exist yet on behalf of the actor, returning how many were created.
+// Roles with permissions the actor doesn't have are skipped.
+func (r *Roles) CreateDefaults(ctx context.Context, actor *User) (created int, err error) {
+	for _, p := range DefaultRoles {
+		switch _, err := r.Create(ctx, actor, p); {
+		case err == ErrRoleExists, err == ErrPermissionDenied:
+		case err != nil:
+			return created, err
+		default:
+			created++
+		}
+	}
+	return created, nil
+}
+
+// Get role.
+func (r *Roles) Get(ctx context.Context, roleID string) (*Role, error) {
+	return getRole(ctx, r.core.Postgres, roleID, false)
+}
+
+func getRole(ctx context.Context, q pgQuerier, roleID string, forUpdate bool) (*Role, error) {
+	sql := `SELECT "role_id", "name", "description", "permissions", "created_at", "updated_at" FROM roles WHERE "role_id" = $1`
+	if forUpdate {
+		sql += " FOR UPDATE"
+	}
+	role, err := scanRole(q.QueryRow(ctx, sql, roleID))
+	if err == pgx.ErrNoRows {
+		return nil, ErrRoleNotFound
+	}
+	return role, err
+}
+
+// List roles.
+func (r *Roles) List(ctx context.Context) ([]Role, error) {
+	pg := r.core.Postgres
+	const sql = `SELECT "role_id", "name", "description", "permissions", "created_at", "updated_at" FROM roles ORDER BY "name"`
+	rows, err := pg.Query(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list roles: %w", err)
+	}
+	defer rows.Close()
+	var roles []Role
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, *role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot list roles: %w", err)
+	}
+	return roles, nil
+}
+
+func scanRole(row pgx.Row) (*Role, error) {
+	var (
+		role        Role
+		permissions []string
+	)
+	if err := row.Scan(&role.RoleID, &role.Name, &role.Description, &permissions, &role.CreatedAt, &role.UpdatedAt); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("cannot read role: %w", err)
+	}
+	role.Permissions = stringsToPermissions(permissions)
+	return &role, nil
+}
+
+// RoleMember is a user with a role.
+type RoleMember struct {
+	RoleID    string
+	UserID    string
+	Name      string
+	Email     string
+	GrantedAt time.Time
+}
+
+// Members of all roles, by role and name.
+func (r *Roles) Members(ctx context.Context) ([]RoleMember, error) {
+	pg := r.core.Postgres
+	const sql = `SELECT "users_roles"."role_id", "users"."user_id", "users"."name", "users"."email", "users_roles"."granted_at" FROM users_roles
+JOIN users ON "users"."user_id" = "users_roles"."user_id"
+ORDER BY "users_roles"."role_id", "users"."name"`
+	rows, err := pg.Query(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list role members: %w", err)
+	}
+	defer rows.Close()
+	var members []RoleMember
+	for rows.Next() {
+		var m RoleMember
+		if err := rows.Scan(&m.RoleID, &m.UserID, &m.Name, &m.Email, &m.GrantedAt); err != nil {
+			return nil, fmt.Errorf("cannot read role member: %w", err)
+		}
+		members = append(members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot list role members: %w", err)
+	}
+	return members, nil
+}
+
+// Assign a role to a user on behalf of the actor, who must have all of its permissions.
+// Assigning a role the user already has does nothing.
+func (r *Roles) Assign(ctx context.Context, actor *User, userID, roleID string) error {
+	role, err := r.Get(ctx, roleID)
+	if err != nil {
+		return err
+	}
+	if !canGrant(actor, role.Permissions) {
+		return ErrPermissionDenied
+	}
+	pg := r.core.Postgres
+	const sql = `INSERT INTO users_roles ("user_id", "role_id", "granted_at", "granted_by") VALUES ($1, $2, NOW(), $3) ON CONFLICT DO NOTHING`
+	if _, err := pg.Exec(ctx, sql, userID, roleID, actor.UserID); err != nil {
+		return fmt.Errorf("cannot assign role: %w", err)
+	}
+	invalidateCachedUsers(ctx, r.core, userID)
+	return nil
+}
+
+// Revoke a role of a user on behalf of the actor, who must have all of its permissions.
+func (r *Roles) Revoke(ctx context.Context, actor *User, userID, roleID string) error {
+	role, err := r.Get(ctx, roleID)
+	if err != nil {
+		return err
+	}
+	if !canGrant(actor, role.Permissions) {
+		return ErrPermissionDenied
+	}
+	pg := r.core.Postgres
+	const sql = `DELETE FROM users_roles WHERE "user_id" = $1 AND "role_id" = $2`
+	ct, err := pg.Exec(ctx, sql, userID, roleID)
+	if err != nil {
+		return fmt.Errorf("cannot revoke role: %w", err)
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrRoleNotFound
+	}
+	invalidateCachedUsers(ctx, r.core, userID)
+	return nil
+}
+
+func permissionsToStrings(permissions []Permission) []string {
+	s := make([]string, len(permissions))
+	for i, p := range permissions {
+		s[i] = string(p)
+	}
+	return s
+}
+
+func stringsToPermissions(s []string) []Permission {
+	var permissions []Permission
+	for _, p := range s {
+		permissions = append(permissions, Permission(p))
+	}
+	return permissions
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCan(t *testing.T) {
+	testCases := []struct {
+		name string
+		user *User
+		p    Permission
+		want bool
+	}{
+		{name: "nil user", user: nil, p: PermissionAdminArea, want: false},
+		{name: "customer", user: &User{Access: UserAuthorization}, p: PermissionAdminArea, want: false},
+		{name: "admin", user: &User{Access: AdminAuthorization}, p: PermissionManageRoles, want: true},
+		{name: "granted", user: &User{Permissions: []Permission{PermissionAdminArea, PermissionManageCatalog}}, p: PermissionManageCatalog, want: true},
+		{name: "not granted", user: &User{Permissions: []Permission{PermissionAdminArea, PermissionManageCatalog}}, p: PermissionManageOrders, want: false},
+	}
+	for _, tc := range testCases {
+		if got := Can(tc.user, tc.p); got != tc.want {
+			t.Errorf("%s: Can(%q) = %v, wanted %v instead", tc.name, tc.p, got, tc.want)
+		}
+	}
+}
+
+func TestRoleParams(t *testing.T) {
+	p := RoleParams{
+		RoleID:      "support-agent",
+		Name:        " Support agent ",
+		Permissions: []Permission{PermissionManageOrders, PermissionManageOrders, PermissionManageUsers},
+	}
+	if err := p.ValidateAndNormalize(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.Name != "Support agent" {
+		t.Errorf("wanted name to be trimmed, got %q instead", p.Name)
+	}
+	if want := []Permission{PermissionAdminArea, PermissionManageOrders, PermissionManageUsers}; !reflect.DeepEqual(p.Permissions, want) {
+		t.Errorf("wanted permissions %v, got %v instead", want, p.Permissions)
+	}
+
+	invalid := []RoleParams{
+		{RoleID: "", Name: "Empty ID"},
+		{RoleID: "Support Agent", Name: "Invalid ID"},
+		{RoleID: "support-", Name: "Invalid ID"},
+		{RoleID: "support"},
+		{RoleID: "support", Name: "Support", Permissions: []Permission{"everything"}},
+		{RoleID: "support", Name: "Support", Permissions: []Permission{PermissionAdminArea}},
+	}
+	for _, p := range invalid {
+		if err := p.ValidateAndNormalize(); err == nil {
+			t.Errorf("wanted error for %+v", p)
+		}
+	}
+	for _, p := range DefaultRoles {
+		if err := p.ValidateAndNormalize(); err != nil {
+			t.Errorf("invalid default role %q: %v", p.RoleID, err)
+		}
+	}
+}
+
+func TestRoles(t *testing.T) {
+	core := newTestCore(t)
+	ctx := context.Background()
+	accounts := Accounts{core: core}
+	roles := Roles{core: core}
+
+	admin := &User{UserID: "admin", Access: AdminAuthorization}
+	userID, err := accounts.NewUser(ctx, NewUserParams{Name: "Jane Doe", Email: "jane@example.com"})
+	if err != nil {
+		t.Fatalf("cannot create user: %v", err)
+	}
+
+	if n, err := roles.CreateDefaults(ctx, admin); err != nil || n != len(DefaultRoles) {
+		t.Fatalf("wanted %d default roles created, got %d (error: %v)", len(DefaultRoles), n, err)
+	}
+	if n, err := roles.CreateDefaults(ctx, admin); err != nil || n != 0 {
+		t.Errorf("wanted existing default roles to be skipped, got %d created (error: %v)", n, err)
+	}
+	if _, err := roles.Create(ctx, admin, DefaultRoles[0]); err != ErrRoleExists {
+		t.Errorf("wanted error %v, got %v instead", ErrRoleExists, err)
+	}
+	if err := roles.Assign(ctx, admin, userID, "catalog-editor"); err != nil {
+		t.Fatalf("cannot assign role: %v", err)
+	}
+	if err := roles.Assign(ctx, admin, userID, "catalog-editor"); err != nil {
+		t.Errorf("assigning a role again should do nothing, got error %v instead", err)
+	}
+	if err := roles.Assign(ctx, admin, userID, "unknown"); err != ErrRoleNotFound {
+		t.Errorf("wanted error %v, got %v instead", ErrRoleNotFound, err)
+	}
+
+	u, err := accounts.GetUserByID(ctx, userID)
+	if err != nil {
+		t.Fatalf("cannot get user: %v", err)
+	}
+	if !Can(u, PermissionAdminArea) || !Can(u, PermissionManageCatalog) || Can(u, PermissionManageRoles) {
+		t.Errorf("unexpected permissions %v", u.Permissions)
+	}
+
+	// Users cannot grant permissions they don't have, even with permission to manage roles.
+	if err := roles.Update(ctx, admin, RoleParams{
+		RoleID:      "catalog-editor",
+		Name:        "Catalog editor",
+		Permissions: []Permission{PermissionManageCatalog, PermissionManageRoles},
+	}); err != nil {
+		t.Fatalf("cannot update role: %v", err)
+	}
+	if u, err = accounts.GetUserByEmail(ctx, "jane@example.com"); err != nil {
+		t.Fatalf("cannot get user: %v", err)
+	}
+	if err := roles.Assign(ctx, u, userID, "finance"); err != ErrPermissionDenied {
+		t.Errorf("wanted error %v assigning role with other permissions, got %v instead", ErrPermissionDenied, err)
+	}
+	if _, err := roles.Create(ctx, u, RoleParams{RoleID: "boss", Name: "Boss", Permissions: []Permission{PermissionManageOAuth}}); err != ErrPermissionDenied {
+		t.Errorf("wanted error %v creating role with other permissions, got %v instead", ErrPermissionDenied, err)
+	}
+	if _, err := roles.Create(ctx, u, RoleParams{RoleID: "assistant", Name: "Assistant", Permissions: []Permission{PermissionManageCatalog}}); err != nil {
+		t.Errorf("cannot create role with own permissions: %v", err)
+	}
+
+	members, err := roles.Members(ctx)
+	if err != nil {
+		t.Fatalf("cannot list role members: %v", err)
+	}
+	if len(members) != 1 || members[0].UserID != userID || members[0].RoleID != "catalog-editor" {
+		t.Errorf("unexpected role members %+v", members)
+	}
+	if err := roles.Revoke(ctx, admin, userID, "catalog-editor"); err != nil {
+		t.Fatalf("cannot revoke role: %v", err)
+	}
+	if err := roles.Revoke(ctx, admin, userID, "catalog-editor"); err != ErrRoleNotFound {
+		t.Errorf("wanted error %v revoking role again, got %v instead", ErrRoleNotFound, err)
+	}
+	if u, err = accounts.GetUserByID(ctx, userID); err != nil || len(u.Permissions) != 0 {
+		t.Errorf("wanted no permissions after revoking role, got %v (error: %v)", u, err)
+	}
+
+	if err := roles.Delete(ctx, admin, "finance"); err != nil {
+		t.Fatalf("cannot delete role: %v", err)
+	}
+	if _, err := roles.Get(ctx, "finance"); err != ErrRoleNotFound {
+		t.Errorf("wanted error %v, got %v instead", ErrRoleNotFound, err)
+	}
+	list, err := roles.List(ctx)
+	if err != nil {
+		t.Fatalf("cannot list roles: %v", err)
+	}
+	if len(list) != len(DefaultRoles) {
+		t.Errorf("wanted %d roles, got %+v instead", len(DefaultRoles), list)
+	}
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Scheduler runs maintenance tasks in the background on an interval, such as closing expired sessions.
+//
+// Every replica of the market server runs the scheduler, but a PostgreSQL advisory lock and the time of the
+// last run, saved on the scheduled_tasks table, make sure only one of them runs each task per interval.
+// The status of the tasks on each replica is published with expvar as "tasks".
+type Scheduler struct {
+	core *Core
+}
+
+// Task run by the Scheduler.
+type Task struct {
+	Name     string
+	Interval time.Duration
+
+	// Run the task, returning the number of items processed (e.g., sessions closed).
+	Run func(ctx context.Context) (int, error)
+}
+
+// Tasks run by the scheduler.
+func (s *Scheduler) Tasks() []Task {
+	sessions := Sessions{core: s.core}
+	inventory := Inventory{core: s.core}
+	mailer := Mailer{core: s.core}
+	return []Task{
+		{Name: "cleanup-sessions", Interval: time.Hour, Run: sessions.CloseExpired},
+		{Name: "release-stock", Interval: 5 * time.Minute, Run: inventory.ReleaseExpired},
+		{Name: "purge-mail", Interval: 24 * time.Hour, Run: mailer.PurgeFailed},
+	}
+}
+
+// taskVars has the TaskStatus of each task run on this replica.
+var taskVars = expvar.NewMap("tasks")
+
+// TaskStatus of a task on this replica.
+type TaskStatus struct {
+	Interval string `json:"interval"`
+
+	// Runs of the task, and how many of them failed.
+	Runs     int `json:"runs"`
+	Failures int `json:"failures"`
+
+	// Skipped runs, as another replica was running the task or ran it recently.
+	Skipped int `json:"skipped"`
+
+	LastRunAt    *time.Time `json:"last_run_at,omitempty"`
+	LastDuration string     `json:"last_duration,omitempty"`
+	LastResult   int        `json:"last_result"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// taskStatus is a TaskStatus updated by the scheduler while published with expvar.
+type taskStatus struct {
+	mu     sync.Mutex
+	status TaskStatus
+}
+
+func (t *taskStatus) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b, err := json.Marshal(t.status)
+	if err != nil {
+		return "null"
+	}
+	return string(b)
+}
+
+func (t *taskStatus) update(fn func(s *TaskStatus)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fn(&t.status)
+}
+
+// Run the tasks until the context is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, task := range s.Tasks() {
+		wg.Add(1)
+		go func(task Task) {
+			defer wg.Done()
+			s.loop(ctx, task)
+		}(task)
+	}
+	wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, task Task) {
+	status := &taskStatus{status: TaskStatus{Interval: task.Interval.String()}}
+	taskVars.Set(task.Name, status)
+	ticker := time.NewTicker(task.Interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		ran, n, err := s.RunTask(ctx, task)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("task %s failed: %v", task.Name, err)
+		}
+		status.update(func(st *TaskStatus) {
+			if !ran && err == nil {
+				st.Skipped++
+				return
+			}
+			st.Runs++
+			st.LastRunAt = &start
+			st.LastDuration = time.Since(start).String()
+			st.LastResult = n
+			st.LastError = ""
+			if err != nil {
+				st.Failures++
+				st.LastError = err.Error()
+			}
+		})
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunTask runs a task, unless another replica is running it or ran it less than about an interval ago.
+// It returns whether the task ran, and its result.
+func (s *Scheduler) RunTask(ctx context.Context, task Task) (ran bool, n int, err error) {
+	tx, err := s.core.Postgres.Begin(ctx)
+	if err != nil {
+		return false, 0, fmt.Errorf("cannot start task %s: %w", task.Name, err)
+	}
+	defer tx.Rollback(ctx)
+
+	// The lock is released when the transaction ends, even if the connection is lost.
+	var locked bool
+	const lockSQL = `SELECT pg_try_advisory_xact_lock(hashtext('market.tasks.' || $1))`
+	if err := tx.QueryRow(ctx, lockSQL, task.Name).Scan(&locked); err != nil {
+		return false, 0, fmt.Errorf("cannot lock task %s: %w", task.Name, err)
+	}
+	if !locked {
+		return false, 0, nil
+	}
+	// Replicas don't tick at the same time, so runs a little less than an interval apart are skipped too.
+	var due bool
+	const dueSQL = `SELECT NOT EXISTS (SELECT 1 FROM scheduled_tasks WHERE "name" = $1 AND "last_run_at" > NOW() - $2 * INTERVAL '1 millisecond')`
+	if err := tx.QueryRow(ctx, dueSQL, task.Name, (task.Interval - task.Interval/10).Milliseconds()).Scan(&due); err != nil {
+		return false, 0, fmt.Errorf("cannot check last run of task %s: %w", task.Name, err)
+	}
+	if !due {
+		return false, 0, nil
+	}
+
+	start := time.Now()
+	n, runErr := task.Run(ctx)
+	var lastError *string
+	if runErr != nil {
+		e := runErr.Error()
+		lastError = &e
+	}
+	const sql = `INSERT INTO scheduled_tasks ("name", "last_run_at", "last_duration_ms", "last_result", "last_error") VALUES ($1, NOW(), $2, $3, $4)
+ON CONFLICT ("name") DO UPDATE SET "last_run_at" = EXCLUDED."last_run_at", "last_duration_ms" = EXCLUDED."last_duration_ms", "last_result" = EXCLUDED."last_result", "last_error" = EXCLUDED."last_error"`
+	if _, err := tx.Exec(ctx, sql, task.Name, time.Since(start).Milliseconds(), n, lastError); err != nil {
+		return true, n, fmt.Errorf("cannot save last run of task %s: %w", task.Name, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return true, n, fmt.Errorf("cannot save last run of task %s: %w", task.Name, err)
+	}
+	return true, n, runErr
+}
+
+// TaskRun is the last run of a task by any replica.
+type TaskRun struct {
+	Name         string
+	LastRunAt    time.Time
+	LastDuration time.Duration
+	LastResult   int
+	LastError    string
+}
+
+// LastRuns of the tasks, by name.
+func (s *Scheduler) LastRuns(ctx context.Context) ([]TaskRun, error) {
+	pg := s.core.Postgres
+	const sql = `SELECT "name", "last_run_at", "last_duration_ms", "last_result", COALESCE("last_error", '') FROM scheduled_tasks ORDER BY "name"`
+	rows, err := pg.Query(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list task runs: %w", err)
+	}
+	defer rows.Close()
+	var runs []TaskRun
+	for rows.Next() {
+		var (
+			run TaskRun
+			ms  int64
+		)
+		if err := rows.Scan(&run.Name, &run.LastRunAt, &ms, &run.LastResult, &run.LastError); err != nil {
+			return nil, fmt.Errorf("cannot read task run: %w", err)
+		}
+		run.LastDuration = time.Duration(ms) * time.Millisecond
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot list task runs: %w", err)
+	}
+	return runs, nil
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTaskStatus(t *testing.T) {
+	status := &taskStatus{status: TaskStatus{Interval: "1h0m0s"}}
+	if got, want := status.String(), `{"interval":"1h0m0s","runs":0,"failures":0,"skipped":0,"last_result":0}`; got != want {
+		t.Errorf("got %s, wanted %s instead", got, want)
+	}
+	at := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	status.update(func(s *TaskStatus) {
+		s.Runs, s.Failures = 2, 1
+		s.LastRunAt = &at
+		s.LastDuration = "1.5s"
+		s.LastResult = 3
+		s.LastError = "database unavailable"
+	})
+	want := `{"interval":"1h0m0s","runs":2,"failures":1,"skipped":0,"last_run_at":"2020-01-02T03:04:05Z","last_duration":"1.5s","last_result":3,"last_error":"database unavailable"}`
+	if got := status.String(); got != want {
+		t.Errorf("got %s, wanted %s instead", got, want)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	core := newTestCore(t)
+	ctx := context.Background()
+	s := Scheduler{core: core}
+
+	var calls int
+	task := Task{
+		Name:     "test",
+		Interval: time.Hour,
+		Run: func(ctx context.Context) (int, error) {
+			calls++
+			return 7, nil
+		},
+	}
+	if ran, n, err := s.RunTask(ctx, task); !ran || n != 7 || err != nil {
+		t.Fatalf("wanted task to run, got ran = %v, n = %d, error = %v", ran, n, err)
+	}
+	// Another replica (or this one) shouldn't run the task again within the interval.
+	if ran, _, err := s.RunTask(ctx, task); ran || err != nil {
+		t.Errorf("wanted task not to run again within the interval, got ran = %v, error = %v", ran, err)
+	}
+	if calls != 1 {
+		t.Errorf("wanted task to be called once, got %d calls", calls)
+	}
+
+	failing := Task{
+		Name:     "failing",
+		Interval: time.Minute,
+		Run: func(ctx context.Context) (int, error) {
+			return 1, errors.New("boom")
+		},
+	}
+	if ran, _, err := s.RunTask(ctx, failing); !ran || err == nil {
+		t.Errorf("wanted failing task to run and return an error, got ran = %v, error = %v", ran, err)
+	}
+
+	runs, err := s.LastRuns(ctx)
+	if err != nil {
+		t.Fatalf("cannot list last runs: %v", err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("wanted 2 task runs, got %+v instead", runs)
+	}
+	if runs[0].Name != "failing" || runs[0].LastError != "boom" || runs[0].LastResult != 1 {
+		t.Errorf("unexpected last run of failing task: %+v", runs[0])
+	}
+	if runs[1].Name != "test" || runs[1].LastError != "" || runs[1].LastResult != 7 {
+		t.Errorf("unexpected last run of test task: %+v", runs[1])
+	}
+}
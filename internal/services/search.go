@@ -0,0 +1,373 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+)
+
+// productsIndex is the name of the Elasticsearch index for the catalog products.
+const productsIndex = "products"
+
+// productsMapping of the productsIndex.
+// Changing it requires recreating the index and reindexing the catalog.
+const productsMapping = `{
+	"mappings": {
+		"properties": {
+			"product_id":  { "type": "keyword" },
+			"category_id": { "type": "keyword" },
+			"name":        { "type": "text" },
+			"description": { "type": "text" },
+			"brand":       { "type": "keyword", "fields": { "text": { "type": "text" } } },
+			"images":      { "type": "keyword", "index": false },
+			"attributes":  {
+				"type": "nested",
+				"properties": {
+					"name":  { "type": "keyword" },
+					"value": { "type": "keyword" }
+				}
+			},
+			"price":       { "type": "long" },
+			"currency":    { "type": "keyword" },
+			"updated_at":  { "type": "date" }
+		}
+	}
+}`
+
+// productDocument stored on the search engine.
+type productDocument struct {
+	ProductID   string              `json:"product_id"`
+	CategoryID  string              `json:"category_id,omitempty"`
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Brand       string              `json:"brand"`
+	Images      []string            `json:"images"`
+	Attributes  []attributeDocument `json:"attributes"`
+	Price       int64               `json:"price"`
+	Currency    string              `json:"currency"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+}
+
+// attributeDocument is a product attribute stored on the search engine.
+// Attributes are indexed as nested objects so that each name and value pair is matched together.
+type attributeDocument struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func makeProductDocument(p *Product) productDocument {
+	price := p.LowestPrice()
+	attributes := make([]attributeDocument, 0, len(p.Attributes))
+	for name, value := range p.Attributes {
+		attributes = append(attributes, attributeDocument{Name: name, Value: value})
+	}
+	// Option values available on any variant are indexed as attributes, so that they can be used as filters.
+	for _, o := range p.Options {
+		for _, value := range o.Values {
+			for _, v := range p.Variants {
+				if v.Options[o.Name] == value {
+					attributes = append(attributes, attributeDocument{Name: o.Name, Value: value})
+					break
+				}
+			}
+		}
+	}
+	sort.SliceStable(attributes, func(i, j int) bool {
+		return attributes[i].Name < attributes[j].Name
+	})
+	return productDocument{
+		ProductID:   p.ProductID,
+		CategoryID:  p.CategoryID,
+		Name:        p.Name,
+		Description: p.Description,
+		Brand:       p.Brand,
+		Images:      p.Images,
+		Attributes:  attributes,
+		Price:       price.Amount,
+		Currency:    price.Currency,
+		UpdatedAt:   p.UpdatedAt,
+	}
+}
+
+// Search engine for the catalog.
+type Search struct {
+	core *Core
+}
+
+// CreateIndex creates the search index for the products, if it doesn't exist yet.
+func (s *Search) CreateIndex(ctx context.Context) (created bool, err error) {
+	es := s.core.Elasticsearch
+	exists, err := es.Indices.Exists([]string{productsIndex}, es.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return false, fmt.Errorf("cannot check if search index exists: %w", err)
+	}
+	exists.Body.Close()
+	switch exists.StatusCode {
+	case http.StatusOK:
+		return false, nil
+	case http.StatusNotFound:
+	default:
+		return false, fmt.Errorf("cannot check if search index exists: %s", exists.Status())
+	}
+
+	res, err := es.Indices.Create(productsIndex,
+		es.Indices.Create.WithContext(ctx),
+		es.Indices.Create.WithBody(bytes.NewBufferString(productsMapping)))
+	if err != nil {
+		return false, fmt.Errorf("cannot create search index: %w", err)
+	}
+	defer res.Body.Close()
+	if err := searchResponseError(res); err != nil {
+		return false, fmt.Errorf("cannot create search index: %w", err)
+	}
+	return true, nil
+}
+
+// IndexProduct adds or replaces a product on the search engine.
+// Archived products are removed from the index.
+func (s *Search) IndexProduct(ctx context.Context, p *Product) error {
+	if p.Status == ProductArchived {
+		return s.DeleteProduct(ctx, p.ProductID)
+	}
+	body, err := json.Marshal(makeProductDocument(p))
+	if err != nil {
+		return fmt.Errorf("cannot encode product %q for indexing: %w", p.ProductID, err)
+	}
+	es := s.core.Elasticsearch
+	res, err := es.Index(productsIndex, bytes.NewReader(body),
+		es.Index.WithContext(ctx),
+		es.Index.WithDocumentID(p.ProductID))
+	if err != nil {
+		return fmt.Errorf("cannot index product %q: %w", p.ProductID, err)
+	}
+	defer res.Body.Close()
+	if err := searchResponseError(res); err != nil {
+		return fmt.Errorf("cannot index product %q: %w", p.ProductID, err)
+	}
+	return nil
+}
+
+// DeleteProduct from the search engine.
+func (s *Search) DeleteProduct(ctx context.Context, productID string) error {
+	es := s.core.Elasticsearch
+	res, err := es.Delete(productsIndex, productID, es.Delete.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("cannot remove product %q from search index: %w", productID, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err := searchResponseError(res); err != nil {
+		return fmt.Errorf("cannot remove product %q from search index: %w", productID, err)
+	}
+	return nil
+}
+
+// Reindex all active products of the catalog.
+func (s *Search) Reindex(ctx context.Context) (indexed int, err error) {
+	catalog := Catalog{core: s.core}
+	const limit = 200
+	for offset := 0; ; offset += limit {
+		products, err := catalog.ListProducts(ctx, ListProductsParams{
+			Limit:  limit,
+			Offset: offset,
+		})
+		if err != nil {
+			return indexed, err
+		}
+		for i := range products {
+			if err := s.IndexProduct(ctx, &products[i]); err != nil {
+				return indexed, err
+			}
+			indexed++
+		}
+		if len(products) < limit {
+			return indexed, nil
+		}
+	}
+}
+
+// reindexProduct is called after a product is changed on the catalog.
+// The search index is secondary to the catalog, so failures are only logged.
+func (s *Search) reindexProduct(productID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	catalog := Catalog{core: s.core}
+	p, err := catalog.GetProduct(ctx, productID)
+	if err == nil {
+		err = s.IndexProduct(ctx, p)
+	}
+	if err != nil {
+		log.Printf("cannot update search index for product %q: %v", productID, err)
+	}
+}
+
+// MaxSearchWindow is the maximum number of results Elasticsearch can page through (index.max_result_window).
+// Pages past it return ErrSearchPageOutOfRange.
+const MaxSearchWindow = 10000
+
+// SearchParams for querying products.
+type SearchParams struct {
+	// Query typed by the user. If empty, all products are matched.
+	Query string
+
+	// Page of the results, starting at 1.
+	Page int
+
+	// PerPage is the number of results per page (default: 16).
+	PerPage int
+
+	// Filters selected by the user.
+	Filters SearchFilters
+}
+
+// SearchResults of a query.
+type SearchResults struct {
+	Total   int
+	Page    int
+	PerPage int
+	Hits    []SearchHit
+	Facets  SearchFacets
+}
+
+// SearchHit is a product found by a query.
+type SearchHit struct {
+	ProductID string
+	Name      string
+	Brand     string
+	Images    []string
+	Price     Price
+	Score     float64
+}
+
+type searchResponse struct {
+	Hits struct {
+		Total struct {
+			Value int `json:"value"`
+		} `json:"total"`
+		Hits []struct {
+			ID     string          `json:"_id"`
+			Score  float64         `json:"_score"`
+			Source productDocument `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+	Aggregations searchAggregations `json:"aggregations"`
+}
+
+// ErrSearchPageOutOfRange is returned when trying to page too deep into the search results.
+var ErrSearchPageOutOfRange = errors.New("search page is out of range")
+
+// Query products ranked by relevance.
+func (s *Search) Query(ctx context.Context, p SearchParams) (*SearchResults, error) {
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	if p.PerPage <= 0 || p.PerPage > 100 {
+		p.PerPage = 16
+	}
+	// Check the page before multiplying, as a huge page would overflow.
+	if p.Page > MaxSearchWindow/p.PerPage {
+		return nil, ErrSearchPageOutOfRange
+	}
+	from := (p.Page - 1) * p.PerPage
+
+	body, err := json.Marshal(searchQuery(p, from))
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode search query: %w", err)
+	}
+	es := s.core.Elasticsearch
+	res, err := es.Search(
+		es.Search.WithContext(ctx),
+		es.Search.WithIndex(productsIndex),
+		es.Search.WithBody(bytes.NewReader(body)))
+	if err != nil {
+		return nil, fmt.Errorf("cannot search products: %w", err)
+	}
+	defer res.Body.Close()
+	if err := searchResponseError(res); err != nil {
+		return nil, fmt.Errorf("cannot search products: %w", err)
+	}
+	var sr searchResponse
+	if err := json.NewDecoder(res.Body).Decode(&sr); err != nil {
+		return nil, fmt.Errorf("cannot decode search response: %w", err)
+	}
+
+	results := &SearchResults{
+		Total:   sr.Hits.Total.Value,
+		Page:    p.Page,
+		PerPage: p.PerPage,
+		Hits:    make([]SearchHit, 0, len(sr.Hits.Hits)),
+		Facets:  sr.Aggregations.facets(),
+	}
+	for _, h := range sr.Hits.Hits {
+		results.Hits = append(results.Hits, SearchHit{
+			ProductID: h.ID,
+			Name:      h.Source.Name,
+			Brand:     h.Source.Brand,
+			Images:    h.Source.Images,
+			Price: Price{
+				Amount:   h.Source.Price,
+				Currency: h.Source.Currency,
+			},
+			Score: h.Score,
+		})
+	}
+	return results, nil
+}
+
+// searchQuery builds the Elasticsearch query DSL for the search params.
+func searchQuery(p SearchParams, from int) map[string]interface{} {
+	var match interface{} = map[string]interface{}{
+		"match_all": map[string]interface{}{},
+	}
+	if p.Query != "" {
+		match = map[string]interface{}{
+			"multi_match": map[string]interface{}{
+				"query":     p.Query,
+				"fields":    []string{"name^3", "brand.text^2", "description"},
+				"fuzziness": "AUTO",
+			},
+		}
+	}
+	query := map[string]interface{}{
+		"from":             from,
+		"size":             p.PerPage,
+		"track_total_hits": true,
+		"query":            match,
+		"aggs":             facetsAggregations(p.Filters),
+	}
+	// Filters are applied after the aggregations are computed, so that each facet still lists unselected values.
+	if filters := p.Filters.clauses(""); len(filters) != 0 {
+		query["post_filter"] = boolFilter(filters)
+	}
+	return query
+}
+
+// searchResponseError returns an error if the Elasticsearch response failed.
+func searchResponseError(res *esapi.Response) error {
+	if !res.IsError() {
+		return nil
+	}
+	var e struct {
+		Error struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	}
+	b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err := json.Unmarshal(b, &e); err != nil || e.Error.Type == "" {
+		return fmt.Errorf("elasticsearch: %s", res.Status())
+	}
+	return fmt.Errorf("elasticsearch: %s: %s (%s)", res.Status(), e.Error.Reason, e.Error.Type)
+}
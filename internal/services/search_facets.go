@@ -0,0 +1,224 @@
+package services
+
+import "sort"
+
+// SearchFilters to narrow down search results.
+// Values of the same filter are combined with OR, and different filters are combined with AND.
+type SearchFilters struct {
+	// Brands to filter.
+	Brands []string
+
+	// PriceMin in the minor unit of the currency (0 = unbounded).
+	PriceMin int64
+
+	// PriceMax in the minor unit of the currency (0 = unbounded).
+	PriceMax int64
+
+	// Attributes to filter, by attribute name.
+	Attributes map[string][]string
+}
+
+// Facets filtered by SearchFilters.
+const (
+	brandFacet     = "brand"
+	priceFacet     = "price"
+	attributeFacet = "attributes"
+)
+
+// clauses of the filters to use on a query, except for the filters of the given facet.
+func (f SearchFilters) clauses(except string) []interface{} {
+	var clauses []interface{}
+	if len(f.Brands) != 0 && except != brandFacet {
+		clauses = append(clauses, map[string]interface{}{
+			"terms": map[string]interface{}{"brand": f.Brands},
+		})
+	}
+	if (f.PriceMin != 0 || f.PriceMax != 0) && except != priceFacet {
+		r := map[string]interface{}{}
+		if f.PriceMin != 0 {
+			r["gte"] = f.PriceMin
+		}
+		if f.PriceMax != 0 {
+			r["lt"] = f.PriceMax
+		}
+		clauses = append(clauses, map[string]interface{}{
+			"range": map[string]interface{}{"price": r},
+		})
+	}
+	if except == attributeFacet {
+		return clauses
+	}
+	names := make([]string, 0, len(f.Attributes))
+	for name, values := range f.Attributes {
+		if len(values) != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		clauses = append(clauses, map[string]interface{}{
+			"nested": map[string]interface{}{
+				"path": "attributes",
+				"query": boolFilter([]interface{}{
+					map[string]interface{}{"term": map[string]interface{}{"attributes.name": name}},
+					map[string]interface{}{"terms": map[string]interface{}{"attributes.value": f.Attributes[name]}},
+				}),
+			},
+		})
+	}
+	return clauses
+}
+
+func boolFilter(clauses []interface{}) map[string]interface{} {
+	if len(clauses) == 0 {
+		return map[string]interface{}{"match_all": map[string]interface{}{}}
+	}
+	return map[string]interface{}{
+		"bool": map[string]interface{}{"filter": clauses},
+	}
+}
+
+// priceRanges used to group products by price on the search facets, in the minor unit of the currency.
+var priceRanges = []int64{5000, 10000, 25000, 50000, 100000, 250000}
+
+// facetsAggregations computes the facets of the search results.
+// Each facet is computed considering all filters but its own.
+func facetsAggregations(f SearchFilters) map[string]interface{} {
+	ranges := []map[string]interface{}{}
+	var from int64
+	for _, to := range priceRanges {
+		r := map[string]interface{}{"to": to}
+		if from != 0 {
+			r["from"] = from
+		}
+		ranges = append(ranges, r)
+		from = to
+	}
+	ranges = append(ranges, map[string]interface{}{"from": from})
+
+	return map[string]interface{}{
+		brandFacet: map[string]interface{}{
+			"filter": boolFilter(f.clauses(brandFacet)),
+			"aggs": map[string]interface{}{
+				"values": map[string]interface{}{
+					"terms": map[string]interface{}{"field": "brand", "size": 20},
+				},
+			},
+		},
+		priceFacet: map[string]interface{}{
+			"filter": boolFilter(f.clauses(priceFacet)),
+			"aggs": map[string]interface{}{
+				"values": map[string]interface{}{
+					"range": map[string]interface{}{"field": "price", "ranges": ranges},
+				},
+			},
+		},
+		attributeFacet: map[string]interface{}{
+			"filter": boolFilter(f.clauses(attributeFacet)),
+			"aggs": map[string]interface{}{
+				"nested": map[string]interface{}{
+					"nested": map[string]interface{}{"path": "attributes"},
+					"aggs": map[string]interface{}{
+						"names": map[string]interface{}{
+							"terms": map[string]interface{}{"field": "attributes.name", "size": 10},
+							"aggs": map[string]interface{}{
+								"values": map[string]interface{}{
+									"terms": map[string]interface{}{"field": "attributes.value", "size": 10},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// SearchFacets of the search results.
+type SearchFacets struct {
+	Brands     []FacetValue
+	Prices     []PriceRangeFacet
+	Attributes []AttributeFacet
+}
+
+// FacetValue is a possible value for a filter and how many results match it.
+type FacetValue struct {
+	Value string
+	Count int
+}
+
+// PriceRangeFacet is a price range and how many results match it.
+type PriceRangeFacet struct {
+	// Min price in the minor unit of the currency (0 = unbounded).
+	Min int64
+
+	// Max price in the minor unit of the currency (0 = unbounded).
+	Max int64
+
+	Count int
+}
+
+// AttributeFacet lists the values of a given product attribute.
+type AttributeFacet struct {
+	Name   string
+	Values []FacetValue
+}
+
+type termsBucket struct {
+	Key      string `json:"key"`
+	DocCount int    `json:"doc_count"`
+}
+
+type searchAggregations struct {
+	Brand struct {
+		Values struct {
+			Buckets []termsBucket `json:"buckets"`
+		} `json:"values"`
+	} `json:"brand"`
+	Price struct {
+		Values struct {
+			Buckets []struct {
+				From     float64 `json:"from"`
+				To       float64 `json:"to"`
+				DocCount int     `json:"doc_count"`
+			} `json:"buckets"`
+		} `json:"values"`
+	} `json:"price"`
+	Attributes struct {
+		Nested struct {
+			Names struct {
+				Buckets []struct {
+					termsBucket
+					Values struct {
+						Buckets []termsBucket `json:"buckets"`
+					} `json:"values"`
+				} `json:"buckets"`
+			} `json:"names"`
+		} `json:"nested"`
+	} `json:"attributes"`
+}
+
+func (a searchAggregations) facets() SearchFacets {
+	var facets SearchFacets
+	for _, b := range a.Brand.Values.Buckets {
+		facets.Brands = append(facets.Brands, FacetValue{Value: b.Key, Count: b.DocCount})
+	}
+	for _, b := range a.Price.Values.Buckets {
+		if b.DocCount == 0 {
+			continue
+		}
+		facets.Prices = append(facets.Prices, PriceRangeFacet{
+			Min:   int64(b.From),
+			Max:   int64(b.To),
+			Count: b.DocCount,
+		})
+	}
+	for _, b := range a.Attributes.Nested.Names.Buckets {
+		af := AttributeFacet{Name: b.Key}
+		for _, v := range b.Values.Buckets {
+			af.Values = append(af.Values, FacetValue{Value: v.Key, Count: v.DocCount})
+		}
+		facets.Attributes = append(facets.Attributes, af)
+	}
+	return facets
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSearchQuery(t *testing.T) {
+	q := searchQuery(SearchParams{Query: "lg 4k", PerPage: 16}, 32)
+	got, err := json.Marshal(q["query"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"multi_match":{"fields":["name^3","brand.text^2","description"],"fuzziness":"AUTO","query":"lg 4k"}}`
+	if string(got) != want {
+		t.Errorf("searchQuery() query = %s, wanted %s", got, want)
+	}
+	if q["from"] != 32 || q["size"] != 16 {
+		t.Errorf("unexpected pagination: from = %v, size = %v", q["from"], q["size"])
+	}
+	if _, ok := q["post_filter"]; ok {
+		t.Error("unexpected post_filter without filters")
+	}
+}
+
+func TestSearchQueryMatchAll(t *testing.T) {
+	q := searchQuery(SearchParams{PerPage: 10}, 0)
+	got, err := json.Marshal(q["query"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"match_all":{}}`; string(got) != want {
+		t.Errorf("searchQuery() query = %s, wanted %s", got, want)
+	}
+}
+
+func TestSearchQueryPageOutOfRange(t *testing.T) {
+	s := Search{core: &Core{}}
+	for _, page := range []int{MaxSearchWindow/16 + 1, math.MaxInt64 / 8, math.MaxInt64} {
+		if _, err := s.Query(context.Background(), SearchParams{Page: page, PerPage: 16}); err != ErrSearchPageOutOfRange {
+			t.Errorf("Query() page %d error = %v, wanted %v", page, err, ErrSearchPageOutOfRange)
+		}
+	}
+}
+
+func TestSearchQueryFilters(t *testing.T) {
+	q := searchQuery(SearchParams{
+		PerPage: 10,
+		Filters: SearchFilters{
+			Brands:     []string{"LG"},
+			PriceMin:   10000,
+			Attributes: map[string][]string{"Screen size": {"24 inches"}},
+		},
+	}, 0)
+	got, err := json.Marshal(q["post_filter"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"bool":{"filter":[{"terms":{"brand":["LG"]}},{"range":{"price":{"gte":10000}}},{"nested":{"path":"attributes","query":{"bool":{"filter":[{"term":{"attributes.name":"Screen size"}},{"terms":{"attributes.value":["24 inches"]}}]}}}}]}}`
+	if string(got) != want {
+		t.Errorf("searchQuery() post_filter = %s, wanted %s", got, want)
+	}
+
+	// The brand facet must not be filtered by the selected brands.
+	aggs := q["aggs"].(map[string]interface{})
+	got, err = json.Marshal(aggs["brand"].(map[string]interface{})["filter"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `{"bool":{"filter":[{"range":{"price":{"gte":10000}}},{"nested":{"path":"attributes","query":{"bool":{"filter":[{"term":{"attributes.name":"Screen size"}},{"terms":{"attributes.value":["24 inches"]}}]}}}}]}}`
+	if string(got) != want {
+		t.Errorf("brand facet filter = %s, wanted %s", got, want)
+	}
+}
+
+func TestSearchAggregationsFacets(t *testing.T) {
+	const response = `{
+		"brand": {"values": {"buckets": [{"key": "LG", "doc_count": 4}, {"key": "Dell", "doc_count": 2}]}},
+		"price": {"values": {"buckets": [
+			{"to": 5000, "doc_count": 0},
+			{"from": 5000, "to": 10000, "doc_count": 3},
+			{"from": 250000, "doc_count": 1}
+		]}},
+		"attributes": {"nested": {"names": {"buckets": [
+			{"key": "Screen size", "doc_count": 6, "values": {"buckets": [{"key": "24 inches", "doc_count": 5}, {"key": "27 inches", "doc_count": 1}]}}
+		]}}}
+	}`
+	var a searchAggregations
+	if err := json.Unmarshal([]byte(response), &a); err != nil {
+		t.Fatal(err)
+	}
+	want := SearchFacets{
+		Brands: []FacetValue{{Value: "LG", Count: 4}, {Value: "Dell", Count: 2}},
+		Prices: []PriceRangeFacet{{Min: 5000, Max: 10000, Count: 3}, {Min: 250000, Count: 1}},
+		Attributes: []AttributeFacet{
+			{Name: "Screen size", Values: []FacetValue{{Value: "24 inches", Count: 5}, {Value: "27 inches", Count: 1}}},
+		},
+	}
+	if got := a.facets(); !reflect.DeepEqual(got, want) {
+		t.Errorf("facets() = %+v, wanted %+v", got, want)
+	}
+}
+
+func TestProductsMapping(t *testing.T) {
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(productsMapping), &v); err != nil {
+		t.Errorf("invalid products mapping: %v", err)
+	}
+}
+
+func TestMakeProductDocumentOptions(t *testing.T) {
+	p := &Product{
+		Attributes: map[string]string{"Resolution": "3840-by-2160"},
+		Options: []ProductOption{
+			{Name: "Color", Values: []string{"White", "Black", "Orange"}},
+		},
+		Variants: []Variant{
+			{Options: map[string]string{"Color": "Black"}},
+			{Options: map[string]string{"Color": "White"}},
+		},
+	}
+	want := []attributeDocument{
+		{Name: "Color", Value: "White"},
+		{Name: "Color", Value: "Black"},
+		{Name: "Resolution", Value: "3840-by-2160"},
+	}
+	if got := makeProductDocument(p).Attributes; !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted attributes %+v, got %+v instead", want, got)
+	}
+}
@@ -1,7 +1,9 @@
 package services
 
 import (
+	"net"
 	"net/http"
+	"strings"
 
 	"github.com/justinas/nosurf"
 )
@@ -9,6 +11,31 @@ import (
 // Security module.
 type Security struct {
 	csrfProtection *CSRFProtection
+
+	// behindProxy tells whether to trust the X-Forwarded-For header.
+	behindProxy bool
+}
+
+// ClientIP returns the IP address of the client of the request.
+// When behind a reverse proxy, it is the last address of the X-Forwarded-For header, which the proxy adds.
+// Earlier addresses are ignored, as clients can send any value.
+func (s *Security) ClientIP(r *http.Request) string {
+	if s.behindProxy {
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) != 0 {
+			last := xff[len(xff)-1]
+			if i := strings.LastIndex(last, ","); i != -1 {
+				last = last[i+1:]
+			}
+			if ip := net.ParseIP(strings.TrimSpace(last)); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
 
 // RegenerateCSRFToken on a given request. Should be called during login/logout operations.
@@ -2,9 +2,12 @@ package services
 
 import (
 	"crypto/rand"
+	"errors"
 
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/plifk/market/internal/config"
 )
@@ -23,6 +26,18 @@ type Core struct {
 	// Elasticsearch client.
 	Elasticsearch *elasticsearch.Client
 
+	// Payments provider. Orders cannot be paid if nil.
+	Payments PaymentProvider
+
+	// MailTransport to deliver emails. Emails cannot be sent if nil.
+	MailTransport MailTransport
+
+	// SessionStore where HTTP sessions are kept. Sessions are stored on PostgreSQL if nil.
+	SessionStore SessionStore
+
+	// UserCache of this process, in front of the cache on Redis. Users are only cached on Redis if nil.
+	UserCache *UserCache
+
 	// CSRFProtection middleware.
 	CSRFProtection *CSRFProtection
 }
@@ -30,21 +45,51 @@ type Core struct {
 // NewModules creates an instance of each service in this package and returns a Module object that can be injected elsewhere.
 func NewModules(core *Core) (*Modules, error) {
 	return &Modules{
-		Settings: core.Settings,
-		Accounts: Accounts{core: core},
-		Sessions: Sessions{core: core},
-		Security: Security{csrfProtection: core.CSRFProtection},
-		Images:   Images{core: core},
+		Settings:      core.Settings,
+		Accounts:      Accounts{core: core},
+		Sessions:      Sessions{core: core},
+		Security:      Security{csrfProtection: core.CSRFProtection, behindProxy: core.Settings.BehindProxy},
+		Images:        Images{core: core},
+		Catalog:       Catalog{core: core},
+		Categories:    Categories{core: core},
+		Search:        Search{core: core},
+		Carts:         Carts{core: core},
+		Orders:        Orders{core: core},
+		Inventory:     Inventory{core: core},
+		Tokens:        Tokens{core: core},
+		OAuth:         OAuth{core: core},
+		Mailer:        Mailer{core: core},
+		MFA:           MFA{core: core},
+		Passkeys:      Passkeys{core: core},
+		LoginThrottle: LoginThrottle{core: core},
+		RateLimits:    RateLimits{core: core},
+		Roles:         Roles{core: core},
+		Scheduler:     Scheduler{core: core},
 	}, nil
 }
 
 // Modules exposes internal services to the HTTP handlers without giving direct unchecked access to the core services.
 type Modules struct {
-	Settings config.Settings
-	Accounts Accounts
-	Sessions Sessions
-	Security Security
-	Images   Images
+	Settings      config.Settings
+	Accounts      Accounts
+	Sessions      Sessions
+	Security      Security
+	Images        Images
+	Catalog       Catalog
+	Categories    Categories
+	Search        Search
+	Carts         Carts
+	Orders        Orders
+	Inventory     Inventory
+	Tokens        Tokens
+	OAuth         OAuth
+	Mailer        Mailer
+	MFA           MFA
+	Passkeys      Passkeys
+	LoginThrottle LoginThrottle
+	RateLimits    RateLimits
+	Roles         Roles
+	Scheduler     Scheduler
 }
 
 func new11RandomID() string {
@@ -62,3 +107,32 @@ func new11RandomID() string {
 	}
 	return string(id)
 }
+
+// ValidationError is returned when the input of a service is invalid, such as a missing name or a weak password.
+// Unlike other errors, its message is meant to be shown to users.
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// invalid wraps a validation error, so that it can be shown to users.
+func invalid(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ValidationError{Err: err}
+}
+
+// isUniqueViolation checks if a PostgreSQL error was caused by a unique constraint violation.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation
+}
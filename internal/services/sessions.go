@@ -8,8 +8,6 @@ import (
 	"log"
 	"net/http"
 	"time"
-
-	"github.com/jackc/pgx/v4"
 )
 
 const (
@@ -33,6 +31,11 @@ type Session struct {
 	State      string
 	UserID     string
 	RememberMe bool
+
+	// UserAgent and IP address of the client, and when it was last seen, to show users the devices they are logged in on.
+	UserAgent  string
+	IP         string
+	LastSeenAt time.Time
 }
 
 // SessionFromRequest extracts the session data from a request.
@@ -70,7 +73,7 @@ func (s *Sessions) Read(w http.ResponseWriter, r *http.Request) (*Session, error
 	now := time.Now()
 	cookie, err := r.Cookie(SessionIDCookieName)
 	if err == nil && len(cookie.Value) == sessionIDLength {
-		session, err = s.get(r.Context(), cookie.Value)
+		session, err = s.store().Get(r.Context(), cookie.Value)
 		if err != nil {
 			log.Printf("request %s failed to get session from database: %v\n", r.Header.Get("X-Request-ID"), err)
 		}
@@ -82,6 +85,11 @@ func (s *Sessions) Read(w http.ResponseWriter, r *http.Request) (*Session, error
 	}
 	// Check if token needs to be renewed.
 	if now.Before(session.CreatedAt.Add(time.Hour)) {
+		if now.Sub(session.LastSeenAt) > lastSeenInterval {
+			if err := s.seen(r.Context(), session, r); err != nil {
+				log.Printf("request %s failed to update session: %v\n", r.Header.Get("X-Request-ID"), err)
+			}
+		}
 		return session, nil
 	}
 	newSession, err := s.startNewSession(w, r, makeSession(&sessionParams{
@@ -98,7 +106,8 @@ func (s *Sessions) Read(w http.ResponseWriter, r *http.Request) (*Session, error
 }
 
 func (s *Sessions) startNewSession(w http.ResponseWriter, r *http.Request, session *Session) (*Session, error) {
-	if err := s.save(r.Context(), session); err != nil {
+	s.setClient(session, r)
+	if err := s.store().Save(r.Context(), session); err != nil {
 		return nil, fmt.Errorf("cannot write cookie: %w", err)
 	}
 	http.SetCookie(w, makeSessionCookie(session))
@@ -121,10 +130,21 @@ func (s *Sessions) Login(w http.ResponseWriter, r *http.Request, userID string,
 		UserID:     userID,
 		RememberMe: p.RememberMe,
 	})
-	if err := s.save(r.Context(), session); err != nil {
+	s.setClient(session, r)
+	if err := s.store().Save(r.Context(), session); err != nil {
 		return nil, fmt.Errorf("cannot write cookie: %w", err)
 	}
 	http.SetCookie(w, makeSessionCookie(session))
+	if oldSession == nil {
+		return session, nil
+	}
+	if oldSession.UserID == "" {
+		// Failing to keep the items of the anonymous cart shouldn't prevent the user from logging in.
+		carts := Carts{core: s.core}
+		if err := carts.mergeAnonymousCart(r.Context(), oldSession.StickyID, userID); err != nil {
+			log.Printf("cannot merge anonymous cart after user %q logged in: %v", userID, err)
+		}
+	}
 	go s.expireOldSessionAfterLogin(userID, oldSession)
 	return session, nil
 }
@@ -133,11 +153,28 @@ func (s *Sessions) Login(w http.ResponseWriter, r *http.Request, userID string,
 func (s *Sessions) expireOldSessionAfterLogin(userID string, session *Session) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	if err := s.expireInOneMinute(ctx, session.ID); err != nil {
+	if err := s.store().ExpireInOneMinute(ctx, session.ID); err != nil {
 		log.Printf("cannot expire old session after user %q logged in: %v", userID, err)
 	}
 }
 
+// lastSeenInterval is how often the last time a session was seen is updated, to avoid writing on every request.
+const lastSeenInterval = 5 * time.Minute
+
+// maxUserAgentLength saved with a session.
+const maxUserAgentLength = 500
+
+// setClient of the request on the session.
+func (s *Sessions) setClient(session *Session, r *http.Request) {
+	security := Security{behindProxy: s.core.Settings.BehindProxy}
+	session.IP = security.ClientIP(r)
+	session.UserAgent = r.UserAgent()
+	if len(session.UserAgent) > maxUserAgentLength {
+		session.UserAgent = session.UserAgent[:maxUserAgentLength]
+	}
+	session.LastSeenAt = time.Now()
+}
+
 type sessionParams struct {
 	ID         string
 	StickyID   string
@@ -212,63 +249,51 @@ func regenerateSessionID(stickyID string) string {
 	return stickyID + "," + enc.EncodeToString(nonSticky)
 }
 
-// Get session from database.
-func (s *Sessions) get(ctx context.Context, sessionID string) (*Session, error) {
-	var session Session
-	pg := s.core.Postgres
-	const sql = `SELECT "id", "sticky_id", "created_at", "expiration", "state", "user_id", "type" FROM http_sessions WHERE id = $1 LIMIT 1`
-	row := pg.QueryRow(ctx, sql, sessionID)
-	var t string
-	switch err := row.Scan(&session.ID, &session.StickyID, &session.CreatedAt, &session.Expire, &session.State, &session.UserID, &t); {
-	case err == pgx.ErrNoRows:
-		return nil, nil
-	case err != nil:
-		return nil, fmt.Errorf("error getting session: %w", err)
-	}
-	if t == PersistentSession {
-		session.RememberMe = true
+// store of the sessions. Sessions are stored on PostgreSQL unless another store is set.
+func (s *Sessions) store() SessionStore {
+	if s.core.SessionStore != nil {
+		return s.core.SessionStore
 	}
-	return &session, nil
+	return &PostgresSessionStore{Postgres: s.core.Postgres}
 }
 
-// Save session to database.
-// TODO(henvic): Cache session to Redis with a shorter lifetime like 5min.
-func (s *Sessions) save(ctx context.Context, session *Session) error {
-	var t = EphemeralSession
-	if session.RememberMe {
-		t = PersistentSession
-	}
-	pg := s.core.Postgres
-	const sql = `INSERT INTO http_sessions ("id", "sticky_id", "created_at", "expiration", "state", "user_id", "type") VALUES ($1, $2, NOW(), $3, $4, $5, $6)`
-	if _, err := pg.Exec(ctx, sql, session.ID, session.StickyID, session.Expire, session.State, session.UserID, t); err != nil {
-		return fmt.Errorf("cannot save session: %w", err)
-	}
-	return nil
-}
-
-// expireInOneMinute an existing session that was renewed.
-func (s *Sessions) expireInOneMinute(ctx context.Context, sessionID string) error {
-	pg := s.core.Postgres
-	const sql = `UPDATE http_sessions SET expiration = NOW() + INTERVAL '1 MINUTE' WHERE id = $1 AND expiration > NOW() AND state = 'active'`
-	if _, err := pg.Exec(ctx, sql, sessionID); err != nil {
-		return fmt.Errorf("error setting context to expire: %w", err)
-	}
-	return nil
+// seen updates the client of a session and when it was last seen.
+func (s *Sessions) seen(ctx context.Context, session *Session, r *http.Request) error {
+	s.setClient(session, r)
+	return s.store().Seen(ctx, session)
 }
 
 // Close session.
 // Revokes its sticky session id to make any existing cookie associated to it invalid.
 func (s *Sessions) Close(ctx context.Context, stickyID string) error {
-	pg := s.core.Postgres
-	const sql = `UPDATE http_sessions SET state = 'expired' WHERE sticky_id = $1`
-	if _, err := pg.Exec(ctx, sql, stickyID); err != nil {
-		return fmt.Errorf("error closing session (with sticky id %q): %w", stickyID, err)
-	}
-	return nil
+	return s.store().Close(ctx, stickyID)
 }
 
-// CloseExpired sessions changes the state of expired sessions to mark them as expired.
-// It should be called on a schedule.
+// CloseUserSessions closes all sessions of a user, except the one with the given sticky ID (if any).
+// Use it to log the user out of other devices, such as after their password changes.
+func (s *Sessions) CloseUserSessions(ctx context.Context, userID, exceptStickyID string) error {
+	return s.store().CloseUserSessions(ctx, userID, exceptStickyID)
+}
+
+// closeExpiredBatchSize is the number of sessions marked as expired at once, to avoid holding locks on many rows.
+const closeExpiredBatchSize = 1000
+
+// CloseExpired sessions changes the state of expired sessions to mark them as expired, returning how many were marked.
+// It should be called on a schedule. It only changes sessions on PostgreSQL, as other stores expire sessions themselves.
 func (s *Sessions) CloseExpired(ctx context.Context) (int, error) {
-	panic("not implemented")
+	pg := s.core.Postgres
+	const sql = `UPDATE http_sessions SET state = 'expired' WHERE id IN (
+	SELECT id FROM http_sessions WHERE state = 'active' AND expiration <= NOW() LIMIT $1 FOR UPDATE SKIP LOCKED
+)`
+	var closed int
+	for {
+		ct, err := pg.Exec(ctx, sql, closeExpiredBatchSize)
+		if err != nil {
+			return closed, fmt.Errorf("cannot close expired sessions: %w", err)
+		}
+		closed += int(ct.RowsAffected())
+		if ct.RowsAffected() < closeExpiredBatchSize {
+			return closed, nil
+		}
+	}
 }
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisSessionStore keeps sessions on Redis, which expires them with key TTLs.
+// Closed sessions are deleted right away, so they cannot be audited, unless an Audit store is set.
+//
+// Each session is a hash. The sessions of each sticky ID, and when it was signed in, are kept on another hash,
+// and the sticky IDs of each user on a set, so that devices can be listed and closed.
+// Scripts build the keys they delete from these, so Redis Cluster isn't supported.
+type RedisSessionStore struct {
+	Client *redis.Client
+
+	// Audit store, such as PostgreSQL, where new, renewed, and closed sessions are recorded too.
+	// Sessions are only read from Redis, and when they were last seen isn't recorded, to avoid hitting the audit store on requests.
+	Audit SessionStore
+}
+
+const (
+	// sessionPrefix of the Redis keys.
+	sessionPrefix = "market:session:"
+
+	sessionIDPrefix     = sessionPrefix + "id:"
+	sessionStickyPrefix = sessionPrefix + "sticky:"
+	sessionUserPrefix   = sessionPrefix + "user:"
+
+	// stickySessionField prefixes the fields with the hashed session IDs on the hash of a sticky ID.
+	stickySessionField = "s:"
+
+	// sessionUserTTL of the set of sticky IDs of a user, longer than any session.
+	sessionUserTTL = 366 * 24 * time.Hour
+)
+
+// redisSessionKey of a session. The session ID is hashed, so it is never exposed on Redis.
+func redisSessionKey(sessionID string) string {
+	return sessionIDPrefix + hashAPIToken(sessionID)
+}
+
+// Get session from Redis.
+func (s *RedisSessionStore) Get(ctx context.Context, sessionID string) (*Session, error) {
+	m, err := s.Client.HGetAll(ctx, redisSessionKey(sessionID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error getting session: %w", err)
+	}
+	if len(m) == 0 {
+		return nil, nil
+	}
+	session, err := parseRedisSession(m)
+	if err != nil {
+		return nil, fmt.Errorf("error getting session: %w", err)
+	}
+	session.ID = sessionID
+	return session, nil
+}
+
+func parseRedisSession(m map[string]string) (*Session, error) {
+	session := &Session{
+		StickyID:   m["sticky_id"],
+		State:      m["state"],
+		UserID:     m["user_id"],
+		RememberMe: m["type"] == PersistentSession,
+		UserAgent:  m["user_agent"],
+		IP:         m["ip"],
+	}
+	for field, t := range map[string]*time.Time{
+		"created_at":   &session.CreatedAt,
+		"expiration":   &session.Expire,
+		"last_seen_at": &session.LastSeenAt,
+	} {
+		ms, err := strconv.ParseInt(m[field], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", field, err)
+		}
+		*t = time.Unix(0, ms*int64(time.Millisecond))
+	}
+	return session, nil
+}
+
+// Save session to Redis. It expires at the session expiration.
+func (s *RedisSessionStore) Save(ctx context.Context, session *Session) error {
+	now := unixMilli(time.Now())
+	key := redisSessionKey(session.ID)
+	stickyKey := sessionStickyPrefix + session.StickyID
+	if _, err := s.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"sticky_id", session.StickyID,
+			"created_at", now,
+			"expiration", unixMilli(session.Expire),
+			"state", session.State,
+			"user_id", session.UserID,
+			"type", sessionType(session),
+			"user_agent", session.UserAgent,
+			"ip", session.IP,
+			"last_seen_at", now,
+		)
+		pipe.PExpireAt(ctx, key, session.Expire)
+		// Renewed sessions expire later than the ones they replace, so the hash lives as long as the last one.
+		pipe.HSetNX(ctx, stickyKey, "signed_in_at", now)
+		pipe.HSet(ctx, stickyKey, stickySessionField+hashAPIToken(session.ID), 1)
+		pipe.PExpireAt(ctx, stickyKey, session.Expire)
+		if session.UserID != "" {
+			pipe.SAdd(ctx, sessionUserPrefix+session.UserID, session.StickyID)
+			pipe.PExpire(ctx, sessionUserPrefix+session.UserID, sessionUserTTL)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("cannot save session: %w", err)
+	}
+	if s.Audit != nil {
+		if err := s.Audit.Save(ctx, session); err != nil {
+			log.Printf("cannot record session for auditing: %v", err)
+		}
+	}
+	return nil
+}
+
+// seenSession updates an existing session. KEYS: session. ARGV: user agent, IP, last seen (ms).
+var seenSession = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	redis.call("HSET", KEYS[1], "user_agent", ARGV[1], "ip", ARGV[2], "last_seen_at", ARGV[3])
+end
+return 0
+`)
+
+// Seen updates the client of a session and when it was last seen.
+func (s *RedisSessionStore) Seen(ctx context.Context, session *Session) error {
+	if err := seenSession.Run(ctx, s.Client, []string{redisSessionKey(session.ID)},
+		session.UserAgent, session.IP, unixMilli(time.Now())).Err(); err != nil {
+		return fmt.Errorf("cannot update session: %w", err)
+	}
+	return nil
+}
+
+// expireSession sets a session to expire sooner. KEYS: session. ARGV: TTL (ms), expiration (ms).
+var expireSession = redis.NewScript(`
+if redis.call("PTTL", KEYS[1]) > tonumber(ARGV[1]) then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+	redis.call("HSET", KEYS[1], "expiration", ARGV[2])
+end
+return 0
+`)
+
+// ExpireInOneMinute an existing session that was renewed.
+func (s *RedisSessionStore) ExpireInOneMinute(ctx context.Context, sessionID string) error {
+	if err := expireSession.Run(ctx, s.Client, []string{redisSessionKey(sessionID)},
+		time.Minute.Milliseconds(), unixMilli(time.Now().Add(time.Minute))).Err(); err != nil {
+		return fmt.Errorf("error setting context to expire: %w", err)
+	}
+	if s.Audit != nil {
+		if err := s.Audit.ExpireInOneMinute(ctx, sessionID); err != nil {
+			log.Printf("cannot record session expiration for auditing: %v", err)
+		}
+	}
+	return nil
+}
+
+// closeSticky deletes the sessions of a sticky ID. KEYS: sticky. ARGV: session key prefix, session field prefix.
+var closeSticky = redis.NewScript(`
+for _, field in ipairs(redis.call("HKEYS", KEYS[1])) do
+	if string.sub(field, 1, #ARGV[2]) == ARGV[2] then
+		redis.call("DEL", ARGV[1] .. string.sub(field, #ARGV[2] + 1))
+	end
+end
+redis.call("DEL", KEYS[1])
+return 0
+`)
+
+// Close session.
+// The sticky ID is left on the set of the user until the devices of the user are listed or closed.
+func (s *RedisSessionStore) Close(ctx context.Context, stickyID string) error {
+	if err := closeSticky.Run(ctx, s.Client, []string{sessionStickyPrefix + stickyID}, sessionIDPrefix, stickySessionField).Err(); err != nil {
+		return fmt.Errorf("error closing session (with sticky id %q): %w", stickyID, err)
+	}
+	if s.Audit != nil {
+		if err := s.Audit.Close(ctx, stickyID); err != nil {
+			log.Printf("cannot record closed session for auditing: %v", err)
+		}
+	}
+	return nil
+}
+
+// closeUserStickies deletes the sessions of a user, except the ones of a sticky ID.
+// KEYS: user. ARGV: sticky key prefix, session key prefix, session field prefix, sticky ID to keep.
+var closeUserStickies = redis.NewScript(`
+for _, sticky in ipairs(redis.call("SMEMBERS", KEYS[1])) do
+	if sticky ~= ARGV[4] then
+		local key = ARGV[1] .. sticky
+		for _, field in ipairs(redis.call("HKEYS", key)) do
+			if string.sub(field, 1, #ARGV[3]) == ARGV[3] then
+				redis.call("DEL", ARGV[2] .. string.sub(field, #ARGV[3] + 1))
+			end
+		end
+		redis.call("DEL", key)
+		redis.call("SREM", KEYS[1], sticky)
+	end
+end
+return 0
+`)
+
+// CloseUserSessions closes all sessions of a user, except the one with the given sticky ID (if any).
+func (s *RedisSessionStore) CloseUserSessions(ctx context.Context, userID, exceptStickyID string) error {
+	if err := closeUserStickies.Run(ctx, s.Client, []string{sessionUserPrefix + userID},
+		sessionStickyPrefix, sessionIDPrefix, stickySessionField, exceptStickyID).Err(); err != nil {
+		return fmt.Errorf("error closing sessions of user %q: %w", userID, err)
+	}
+	if s.Audit != nil {
+		if err := s.Audit.CloseUserSessions(ctx, userID, exceptStickyID); err != nil {
+			log.Printf("cannot record closed sessions for auditing: %v", err)
+		}
+	}
+	return nil
+}
+
+// Devices the user is logged in on, last seen first.
+// Sticky IDs without sessions left are removed from the set of the user.
+func (s *RedisSessionStore) Devices(ctx context.Context, userID string) ([]Device, error) {
+	kv := s.Client
+	userKey := sessionUserPrefix + userID
+	stickies, err := kv.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("cannot list devices: %w", err)
+	}
+	var (
+		devices []Device
+		stale   []interface{}
+	)
+	for _, sticky := range stickies {
+		fields, err := kv.HGetAll(ctx, sessionStickyPrefix+sticky).Result()
+		if err != nil {
+			return nil, fmt.Errorf("cannot list devices: %w", err)
+		}
+		var cmds []*redis.StringStringMapCmd
+		if _, err := kv.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for field := range fields {
+				if strings.HasPrefix(field, stickySessionField) {
+					cmds = append(cmds, pipe.HGetAll(ctx, sessionIDPrefix+strings.TrimPrefix(field, stickySessionField)))
+				}
+			}
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("cannot list devices: %w", err)
+		}
+		d := Device{stickyID: sticky}
+		if ms, err := strconv.ParseInt(fields["signed_in_at"], 10, 64); err == nil {
+			d.SignedInAt = time.Unix(0, ms*int64(time.Millisecond))
+		}
+		var active bool
+		for _, cmd := range cmds {
+			if len(cmd.Val()) == 0 {
+				continue // expired
+			}
+			session, err := parseRedisSession(cmd.Val())
+			if err != nil {
+				return nil, fmt.Errorf("cannot read device: %w", err)
+			}
+			if session.State != "active" || time.Now().After(session.Expire) {
+				continue
+			}
+			if d.SignedInAt.IsZero() || session.CreatedAt.Before(d.SignedInAt) {
+				d.SignedInAt = session.CreatedAt
+			}
+			if !active || session.LastSeenAt.After(d.LastSeenAt) {
+				d.LastSeenAt = session.LastSeenAt
+				d.UserAgent = session.UserAgent
+				d.IP = session.IP
+			}
+			active = true
+		}
+		if !active {
+			stale = append(stale, sticky)
+			continue
+		}
+		devices = append(devices, d)
+	}
+	if len(stale) != 0 {
+		if err := kv.SRem(ctx, userKey, stale...).Err(); err != nil {
+			log.Printf("cannot remove devices logged out of user %q: %v", userID, err)
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].LastSeenAt.After(devices[j].LastSeenAt)
+	})
+	return devices, nil
+}
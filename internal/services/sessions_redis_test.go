@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRedisSessionStore(t *testing.T) {
+	core := newTestRedisCore(t)
+	ctx := context.Background()
+	store := &RedisSessionStore{Client: core.Redis}
+	userID := "user-" + new11RandomID()
+
+	newSession := func(stickyID string) *Session {
+		id, sticky := newSessionID()
+		if stickyID != "" {
+			id, sticky = regenerateSessionID(stickyID), stickyID
+		}
+		session := makeSession(&sessionParams{ID: id, StickyID: sticky, UserID: userID, RememberMe: true})
+		session.UserAgent = "Firefox/118.0"
+		session.IP = "192.0.2.1"
+		if err := store.Save(ctx, session); err != nil {
+			t.Fatalf("cannot save session: %v", err)
+		}
+		return session
+	}
+	a := newSession("")
+	b := newSession("")
+	renewed := newSession(a.StickyID)
+
+	got, err := store.Get(ctx, a.ID)
+	if err != nil {
+		t.Fatalf("cannot get session: %v", err)
+	}
+	if got.ID != a.ID || got.StickyID != a.StickyID || got.UserID != userID || !got.RememberMe || got.State != "active" ||
+		got.UserAgent != a.UserAgent || got.IP != a.IP || !got.Expire.Equal(a.Expire.Truncate(time.Millisecond)) {
+		t.Errorf("wanted session %+v, got %+v instead", a, got)
+	}
+	if ttl := core.Redis.PTTL(ctx, redisSessionKey(a.ID)).Val(); ttl < 364*24*time.Hour {
+		t.Errorf("wanted session to expire with the session, got TTL %v instead", ttl)
+	}
+	if got, err := store.Get(ctx, "unknown"); got != nil || err != nil {
+		t.Errorf("wanted no session, got %+v (error: %v)", got, err)
+	}
+
+	b.UserAgent, b.IP = "Chrome/118.0", "192.0.2.2"
+	if err := store.Seen(ctx, b); err != nil {
+		t.Fatalf("cannot update session: %v", err)
+	}
+	if got, err := store.Get(ctx, b.ID); err != nil || got.UserAgent != "Chrome/118.0" || got.IP != "192.0.2.2" {
+		t.Errorf("wanted client of session updated, got %+v (error: %v)", got, err)
+	}
+	if err := store.Seen(ctx, &Session{ID: "unknown"}); err != nil {
+		t.Fatalf("cannot update session: %v", err)
+	}
+	if n := core.Redis.Exists(ctx, redisSessionKey("unknown")).Val(); n != 0 {
+		t.Error("updating a session that doesn't exist shouldn't create it")
+	}
+
+	if err := store.ExpireInOneMinute(ctx, a.ID); err != nil {
+		t.Fatalf("cannot expire session: %v", err)
+	}
+	if ttl := core.Redis.PTTL(ctx, redisSessionKey(a.ID)).Val(); ttl > time.Minute {
+		t.Errorf("wanted session to expire in a minute, got TTL %v instead", ttl)
+	}
+
+	devices, err := store.Devices(ctx, userID)
+	if err != nil {
+		t.Fatalf("cannot list devices: %v", err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("wanted 2 devices, got %+v instead", devices)
+	}
+	for _, d := range devices {
+		if d.stickyID != a.StickyID && d.stickyID != b.StickyID {
+			t.Errorf("unexpected device %+v", d)
+		}
+	}
+
+	if err := store.Close(ctx, a.StickyID); err != nil {
+		t.Fatalf("cannot close session: %v", err)
+	}
+	for _, id := range []string{a.ID, renewed.ID} {
+		if got, err := store.Get(ctx, id); got != nil || err != nil {
+			t.Errorf("wanted session closed, got %+v (error: %v)", got, err)
+		}
+	}
+	if devices, err := store.Devices(ctx, userID); err != nil || len(devices) != 1 || devices[0].stickyID != b.StickyID {
+		t.Errorf("wanted only device b, got %+v (error: %v)", devices, err)
+	}
+
+	c := newSession("")
+	if err := store.CloseUserSessions(ctx, userID, c.StickyID); err != nil {
+		t.Fatalf("cannot close sessions of user: %v", err)
+	}
+	if got, err := store.Get(ctx, b.ID); got != nil || err != nil {
+		t.Errorf("wanted session b closed, got %+v (error: %v)", got, err)
+	}
+	if got, err := store.Get(ctx, c.ID); got == nil || err != nil {
+		t.Errorf("wanted session c kept, got %+v (error: %v)", got, err)
+	}
+	if err := store.CloseUserSessions(ctx, userID, ""); err != nil {
+		t.Fatalf("cannot close sessions of user: %v", err)
+	}
+	if devices, err := store.Devices(ctx, userID); err != nil || len(devices) != 0 {
+		t.Errorf("wanted no devices, got %+v (error: %v)", devices, err)
+	}
+}
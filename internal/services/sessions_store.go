@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/plifk/market/internal/config"
+)
+
+// SessionStore persists the HTTP sessions.
+// Use the Sessions module instead of a store directly, as it handles the cookies and renewal of sessions.
+type SessionStore interface {
+	// Get a session by ID. It returns nil if the session doesn't exist.
+	Get(ctx context.Context, sessionID string) (*Session, error)
+
+	// Save a new session.
+	Save(ctx context.Context, session *Session) error
+
+	// Seen updates the client of a session and when it was last seen.
+	Seen(ctx context.Context, session *Session) error
+
+	// ExpireInOneMinute an existing session that was renewed.
+	ExpireInOneMinute(ctx context.Context, sessionID string) error
+
+	// Close the sessions with a sticky ID.
+	Close(ctx context.Context, stickyID string) error
+
+	// CloseUserSessions closes all sessions of a user, except the ones with the given sticky ID (if any).
+	CloseUserSessions(ctx context.Context, userID, exceptStickyID string) error
+
+	// Devices the user is logged in on, last seen first. Only the sticky ID of the devices is set to identify them.
+	Devices(ctx context.Context, userID string) ([]Device, error)
+}
+
+// Session storages.
+const (
+	PostgresSessionStorage = "postgres"
+	RedisSessionStorage    = "redis"
+)
+
+// NewSessionStore returns the session store of the settings.
+// An empty storage returns no store, meaning sessions are stored on PostgreSQL.
+func NewSessionStore(s config.Settings, pg *pgxpool.Pool, kv *redis.Client) (SessionStore, error) {
+	switch s.SessionStorage {
+	case "", PostgresSessionStorage:
+		return nil, nil
+	case RedisSessionStorage:
+		store := &RedisSessionStore{Client: kv}
+		if s.SessionAudit {
+			store.Audit = &PostgresSessionStore{Postgres: pg}
+		}
+		return store, nil
+	}
+	return nil, fmt.Errorf("unknown session storage %q", s.SessionStorage)
+}
+
+// PostgresSessionStore keeps sessions on the http_sessions table.
+// Sessions are kept after they expire or are closed, until deleted by hand, so they can be audited.
+type PostgresSessionStore struct {
+	Postgres *pgxpool.Pool
+}
+
+// Get session from database.
+func (s *PostgresSessionStore) Get(ctx context.Context, sessionID string) (*Session, error) {
+	var session Session
+	const sql = `SELECT "id", "sticky_id", "created_at", "expiration", "state", "user_id", "type", "user_agent", "ip", "last_seen_at" FROM http_sessions WHERE id = $1 LIMIT 1`
+	row := s.Postgres.QueryRow(ctx, sql, sessionID)
+	var t string
+	switch err := row.Scan(&session.ID, &session.StickyID, &session.CreatedAt, &session.Expire, &session.State, &session.UserID, &t,
+		&session.UserAgent, &session.IP, &session.LastSeenAt); {
+	case err == pgx.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("error getting session: %w", err)
+	}
+	if t == PersistentSession {
+		session.RememberMe = true
+	}
+	return &session, nil
+}
+
+// Save session to database.
+func (s *PostgresSessionStore) Save(ctx context.Context, session *Session) error {
+	const sql = `INSERT INTO http_sessions ("id", "sticky_id", "created_at", "expiration", "state", "user_id", "type", "user_agent", "ip", "last_seen_at") VALUES ($1, $2, NOW(), $3, $4, $5, $6, $7, $8, NOW())`
+	if _, err := s.Postgres.Exec(ctx, sql, session.ID, session.StickyID, session.Expire, session.State, session.UserID, sessionType(session),
+		session.UserAgent, session.IP); err != nil {
+		return fmt.Errorf("cannot save session: %w", err)
+	}
+	return nil
+}
+
+// Seen updates the client of a session and when it was last seen.
+func (s *PostgresSessionStore) Seen(ctx context.Context, session *Session) error {
+	const sql = `UPDATE http_sessions SET "user_agent" = $2, "ip" = $3, "last_seen_at" = NOW() WHERE id = $1`
+	if _, err := s.Postgres.Exec(ctx, sql, session.ID, session.UserAgent, session.IP); err != nil {
+		return fmt.Errorf("cannot update session: %w", err)
+	}
+	return nil
+}
+
+// ExpireInOneMinute an existing session that was renewed.
+func (s *PostgresSessionStore) ExpireInOneMinute(ctx context.Context, sessionID string) error {
+	const sql = `UPDATE http_sessions SET expiration = NOW() + INTERVAL '1 MINUTE' WHERE id = $1 AND expiration > NOW() AND state = 'active'`
+	if _, err := s.Postgres.Exec(ctx, sql, sessionID); err != nil {
+		return fmt.Errorf("error setting context to expire: %w", err)
+	}
+	return nil
+}
+
+// Close session.
+func (s *PostgresSessionStore) Close(ctx context.Context, stickyID string) error {
+	const sql = `UPDATE http_sessions SET state = 'expired' WHERE sticky_id = $1`
+	if _, err := s.Postgres.Exec(ctx, sql, stickyID); err != nil {
+		return fmt.Errorf("error closing session (with sticky id %q): %w", stickyID, err)
+	}
+	return nil
+}
+
+// CloseUserSessions closes all sessions of a user, except the one with the given sticky ID (if any).
+func (s *PostgresSessionStore) CloseUserSessions(ctx context.Context, userID, exceptStickyID string) error {
+	return closeUserSessions(ctx, s.Postgres, userID, exceptStickyID)
+}
+
+func closeUserSessions(ctx context.Context, q pgQuerier, userID, exceptStickyID string) error {
+	const sql = `UPDATE http_sessions SET state = 'expired' WHERE user_id = $1 AND sticky_id <> $2 AND state = 'active'`
+	if _, err := q.Exec(ctx, sql, userID, exceptStickyID); err != nil {
+		return fmt.Errorf("error closing sessions of user %q: %w", userID, err)
+	}
+	return nil
+}
+
+// Devices the user is logged in on, last seen first.
+func (s *PostgresSessionStore) Devices(ctx context.Context, userID string) ([]Device, error) {
+	const sql = `SELECT "sticky_id", MIN("created_at"), MAX("last_seen_at"),
+(ARRAY_AGG("user_agent" ORDER BY "last_seen_at" DESC))[1], (ARRAY_AGG("ip" ORDER BY "last_seen_at" DESC))[1]
+FROM http_sessions WHERE "user_id" = $1
+GROUP BY "sticky_id" HAVING BOOL_OR("state" = 'active' AND "expiration" > NOW())
+ORDER BY MAX("last_seen_at") DESC`
+	rows, err := s.Postgres.Query(ctx, sql, userID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list devices: %w", err)
+	}
+	defer rows.Close()
+	var devices []Device
+	for rows.Next() {
+		var d Device
+		if err := rows.Scan(&d.stickyID, &d.SignedInAt, &d.LastSeenAt, &d.UserAgent, &d.IP); err != nil {
+			return nil, fmt.Errorf("cannot read device: %w", err)
+		}
+		devices = append(devices, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot list devices: %w", err)
+	}
+	return devices, nil
+}
+
+// sessionType of a session: PersistentSession or EphemeralSession.
+func sessionType(session *Session) string {
+	if session.RememberMe {
+		return PersistentSession
+	}
+	return EphemeralSession
+}
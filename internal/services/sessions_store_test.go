@@ -0,0 +1,29 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/plifk/market/internal/config"
+)
+
+func TestNewSessionStore(t *testing.T) {
+	kv := redis.NewClient(&redis.Options{})
+	defer kv.Close()
+	for _, storage := range []string{"", PostgresSessionStorage} {
+		if store, err := NewSessionStore(config.Settings{SessionStorage: storage}, nil, kv); store != nil || err != nil {
+			t.Errorf("wanted no store for storage %q, got %v (error: %v)", storage, store, err)
+		}
+	}
+	store, err := NewSessionStore(config.Settings{SessionStorage: RedisSessionStorage}, nil, kv)
+	if rs, ok := store.(*RedisSessionStore); !ok || rs.Client != kv || rs.Audit != nil || err != nil {
+		t.Errorf("wanted Redis store without audit, got %+v (error: %v)", store, err)
+	}
+	store, err = NewSessionStore(config.Settings{SessionStorage: RedisSessionStorage, SessionAudit: true}, nil, kv)
+	if rs, ok := store.(*RedisSessionStore); !ok || rs.Audit == nil || err != nil {
+		t.Errorf("wanted Redis store with audit, got %+v (error: %v)", store, err)
+	}
+	if _, err := NewSessionStore(config.Settings{SessionStorage: "memcached"}, nil, kv); err == nil {
+		t.Error("wanted error for unknown session storage")
+	}
+}
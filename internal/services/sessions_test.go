@@ -0,0 +1,37 @@
+package services
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCloseExpired(t *testing.T) {
+	core := newTestCore(t)
+	ctx := context.Background()
+	s := Sessions{core: core}
+
+	const sql = `INSERT INTO http_sessions ("id", "sticky_id", "created_at", "expiration", "state", "user_id", "type")
+SELECT 'session-' || i, 'sticky-' || i, NOW(), CASE WHEN i <= $1 THEN NOW() - INTERVAL '1 day' ELSE NOW() + INTERVAL '1 day' END, 'active', '', 'ephemeral'
+FROM generate_series(1, $1 + 10) AS i`
+	expired := closeExpiredBatchSize + 5 // more than a batch
+	if _, err := core.Postgres.Exec(ctx, sql, expired); err != nil {
+		t.Fatalf("cannot create sessions: %v", err)
+	}
+	n, err := s.CloseExpired(ctx)
+	if err != nil {
+		t.Fatalf("cannot close expired sessions: %v", err)
+	}
+	if n != expired {
+		t.Errorf("wanted %d sessions closed, got %d instead", expired, n)
+	}
+	if n, err := s.CloseExpired(ctx); err != nil || n != 0 {
+		t.Errorf("wanted no sessions closed again, got %d (error: %v)", n, err)
+	}
+	var active int
+	if err := core.Postgres.QueryRow(ctx, `SELECT COUNT(*) FROM http_sessions WHERE state = 'active'`).Scan(&active); err != nil {
+		t.Fatalf("cannot count active sessions: %v", err)
+	}
+	if active != 10 {
+		t.Errorf("wanted 10 active sessions, got %d instead", active)
+	}
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrMissingSecretKey is returned when signing or verifying a token without a secret key configured.
+	ErrMissingSecretKey = errors.New("secret key is not configured")
+
+	// ErrInvalidSignedToken is returned when a signed token is malformed, was tampered with, or expired.
+	ErrInvalidSignedToken = errors.New("invalid or expired token")
+)
+
+// signToken creates a token with the given fields, signed with HMAC-SHA256.
+// The purpose is part of the signature, so a token created for one purpose cannot be used for another.
+// Fields must not contain new lines.
+func signToken(key, purpose string, expires time.Time, fields ...string) (string, error) {
+	if key == "" {
+		return "", ErrMissingSecretKey
+	}
+	for _, f := range fields {
+		if strings.Contains(f, "\n") {
+			return "", errors.New("token field must not contain new lines")
+		}
+	}
+	payload := strings.Join(append([]string{strconv.FormatInt(expires.Unix(), 10)}, fields...), "\n")
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(signature(key, purpose, payload)), nil
+}
+
+// verifySignedToken created for the purpose, and return its fields.
+func verifySignedToken(key, purpose, token string) ([]string, error) {
+	if key == "" {
+		return nil, ErrMissingSecretKey
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidSignedToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidSignedToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, signature(key, purpose, string(payload))) {
+		return nil, ErrInvalidSignedToken
+	}
+	fields := strings.Split(string(payload), "\n")
+	expires, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || time.Now().Unix() >= expires {
+		return nil, ErrInvalidSignedToken
+	}
+	return fields[1:], nil
+}
+
+func signature(key, purpose, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(purpose + "\n" + payload))
+	return mac.Sum(nil)
+}
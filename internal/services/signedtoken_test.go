@@ -0,0 +1,49 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedToken(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+	token, err := signToken("key", "verify-email", expires, "u1", "jane@example.com")
+	if err != nil {
+		t.Fatalf("cannot sign token: %v", err)
+	}
+	fields, err := verifySignedToken("key", "verify-email", token)
+	if err != nil {
+		t.Fatalf("cannot verify token: %v", err)
+	}
+	if want := []string{"u1", "jane@example.com"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("wanted fields %v, got %v instead", want, fields)
+	}
+
+	payload := token[:strings.Index(token, ".")]
+	expired, err := signToken("key", "verify-email", time.Now().Add(-time.Second), "u1", "jane@example.com")
+	if err != nil {
+		t.Fatalf("cannot sign token: %v", err)
+	}
+	invalid := map[string]struct{ key, purpose, token string }{
+		"other key":     {"other", "verify-email", token},
+		"other purpose": {"key", "reset-password", token},
+		"tampered":      {"key", "verify-email", payload + "x" + token[len(payload):]},
+		"no signature":  {"key", "verify-email", payload},
+		"expired":       {"key", "verify-email", expired},
+		"empty":         {"key", "verify-email", ""},
+	}
+	for name, tc := range invalid {
+		if _, err := verifySignedToken(tc.key, tc.purpose, tc.token); err != ErrInvalidSignedToken {
+			t.Errorf("%s: wanted error %v, got %v instead", name, ErrInvalidSignedToken, err)
+		}
+	}
+
+	if _, err := signToken("", "verify-email", expires, "u1"); err != ErrMissingSecretKey {
+		t.Errorf("wanted error %v, got %v instead", ErrMissingSecretKey, err)
+	}
+	if _, err := signToken("key", "verify-email", expires, "a\nb"); err == nil {
+		t.Error("wanted error signing field with a new line")
+	}
+}
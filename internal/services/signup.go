@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/plifk/market/internal/passwords"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// emailVerificationLifetime is how long an email verification link is valid.
+const emailVerificationLifetime = 48 * time.Hour
+
+// emailVerificationPurpose of the signed email verification tokens.
+const emailVerificationPurpose = "verify-email"
+
+var (
+	// ErrEmailTaken is returned when signing up with, or confirming a change to, an email address already in use.
+	ErrEmailTaken = errors.New("email address is already in use")
+
+	// ErrEmailNotVerified is returned when logging in before verifying the email address.
+	ErrEmailNotVerified = errors.New("email address is not verified yet")
+)
+
+// SignupParams to create an account.
+type SignupParams struct {
+	Name     string
+	Email    string
+	Password string
+}
+
+// Signup creates a user account, which cannot be used until its email address is verified.
+// Call SendVerificationEmail afterwards.
+//
+// If the email address is already in use, its owner is emailed about it instead, and ErrEmailTaken is returned.
+// Don't tell the user: show the same page as when the account is created, so that signing up doesn't disclose who is registered.
+func (a *Accounts) Signup(ctx context.Context, p SignupParams) (*User, error) {
+	if err := passwords.Validate(p.Password, p.Name, p.Email); err != nil {
+		return nil, invalid(err)
+	}
+	tx, err := a.core.Postgres.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot sign up: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	id, err := newUser(ctx, tx, NewUserParams{
+		Name:   strings.TrimSpace(p.Name),
+		Email:  p.Email,
+		Access: UserAuthorization,
+	})
+	switch {
+	case isUniqueViolation(err):
+		// Comparing a password anyway takes about the same time as hashing the password of a new account.
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(p.Password))
+		if err := a.sendAccountExistsEmail(ctx, p.Email); err != nil {
+			return nil, err
+		}
+		return nil, ErrEmailTaken
+	case err != nil:
+		return nil, err
+	}
+	if err := setCredentials(ctx, tx, SetPasswordParams{
+		UserID:   id,
+		Password: p.Password,
+	}); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("cannot sign up: %w", err)
+	}
+	return a.GetUserByID(ctx, id)
+}
+
+// AccountExistsMail is the content of the email sent when signing up with an email address already in use.
+type AccountExistsMail struct {
+	LoginLink   string
+	RecoverLink string
+}
+
+func (a *Accounts) sendAccountExistsEmail(ctx context.Context, email string) error {
+	u, err := a.GetUserByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(a.core.Settings.PublicURL, "/")
+	mailer := Mailer{core: a.core}
+	return mailer.Send(ctx, u.Email, "account-exists", MailData{
+		Name: u.Name,
+		Content: AccountExistsMail{
+			LoginLink:   base + "/login",
+			RecoverLink: base + "/recover",
+		},
+	})
+}
+
+// VerifyEmailMail is the content of the email verification email.
+type VerifyEmailMail struct {
+	Link       string
+	ValidHours int
+}
+
+// SendVerificationEmail sends a link the user must open to verify their email address.
+// Nothing is sent if the email address is already verified.
+func (a *Accounts) SendVerificationEmail(ctx context.Context, u *User) error {
+	if u.EmailVerified() {
+		return nil
+	}
+	settings := a.core.Settings
+	token, err := signToken(settings.SecretKey, emailVerificationPurpose, time.Now().Add(emailVerificationLifetime), u.UserID, u.Email)
+	if err != nil {
+		return fmt.Errorf("cannot create email verification token: %w", err)
+	}
+	mailer := Mailer{core: a.core}
+	return mailer.Send(ctx, u.Email, "verify-email", MailData{
+		Name: u.Name,
+		Content: VerifyEmailMail{
+			Link:       strings.TrimSuffix(settings.PublicURL, "/") + "/signup/verify?token=" + url.QueryEscape(token),
+			ValidHours: int(emailVerificationLifetime.Hours()),
+		},
+	})
+}
+
+// ResendVerificationEmail to the user with the given email address.
+// To avoid disclosing which email addresses are registered, no error is returned if there is no such user.
+func (a *Accounts) ResendVerificationEmail(ctx context.Context, email string) error {
+	u, err := a.GetUserByEmail(ctx, email)
+	switch {
+	case err == ErrUserNotFound:
+		return nil
+	case err != nil:
+		return err
+	}
+	return a.SendVerificationEmail(ctx, u)
+}
+
+// VerifyEmail marks the email address of a user as verified, returning the user ID.
+// Verifying an email address again has no effect, but the token must still be valid.
+func (a *Accounts) VerifyEmail(ctx context.Context, token string) (userID string, err error) {
+	fields, err := verifySignedToken(a.core.Settings.SecretKey, emailVerificationPurpose, token)
+	if err != nil {
+		return "", err
+	}
+	if len(fields) != 2 {
+		return "", ErrInvalidSignedToken
+	}
+	userID, email := fields[0], fields[1]
+	pg := a.core.Postgres
+	// The email address must match, as a token for a previous email address of the user must not verify the current one.
+	const sql = `UPDATE users SET "email_verified_at" = COALESCE("email_verified_at", NOW()) WHERE "user_id" = $1 AND "email" = $2`
+	switch ct, err := pg.Exec(ctx, sql, userID, email); {
+	case err != nil:
+		return "", fmt.Errorf("cannot verify email address: %w", err)
+	case ct.RowsAffected() == 0:
+		return "", ErrInvalidSignedToken
+	}
+	invalidateCachedUsers(ctx, a.core, userID)
+	return userID, nil
+}
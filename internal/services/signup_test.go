@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"testing"
+)
+
+func TestSignup(t *testing.T) {
+	core := newTestCore(t)
+	transport := useMemoryTransport(core)
+	core.Settings.SecretKey = "secret"
+	core.Settings.PublicURL = "https://www.example.com/"
+	ctx := context.Background()
+	accounts := Accounts{core: core}
+
+	u, err := accounts.Signup(ctx, SignupParams{Name: "Jane Doe", Email: "jane@example.com", Password: "correct horse battery staple"})
+	if err != nil {
+		t.Fatalf("cannot sign up: %v", err)
+	}
+	if u.Email != "jane@example.com" || u.Phone != "" || u.Access != UserAuthorization || u.EmailVerified() {
+		t.Errorf("unexpected user %+v", u)
+	}
+	if _, err := accounts.Signup(ctx, SignupParams{Name: "Jane", Email: "jane@example.com", Password: "correct horse battery staple"}); err != ErrEmailTaken {
+		t.Errorf("wanted error %v, got %v instead", ErrEmailTaken, err)
+	}
+	deliverTestMail(t, core)
+	messages := transport.Messages()
+	if len(messages) != 1 || messages[0].To != "jane@example.com" || messages[0].Subject != "You already have an account" {
+		t.Fatalf("wanted account exists email to be sent to user, got %+v instead", messages)
+	}
+	if u, err := accounts.GetUserByEmail(ctx, "jane@example.com"); err != nil || u.Name != "Jane Doe" {
+		t.Errorf("wanted existing user to be kept, got %+v (error: %v) instead", u, err)
+	}
+
+	if err := accounts.SendVerificationEmail(ctx, u); err != nil {
+		t.Fatalf("cannot send verification email: %v", err)
+	}
+	deliverTestMail(t, core)
+	messages = transport.Messages()
+	if len(messages) != 2 || messages[1].To != "jane@example.com" {
+		t.Fatalf("wanted verification email to be sent to user, got %+v instead", messages)
+	}
+	link := regexp.MustCompile(`https://www\.example\.com/signup/verify\?token=\S+`).FindString(messages[1].Text)
+	if link == "" {
+		t.Fatalf("verification link not found on email: %q", messages[1].Text)
+	}
+	l, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("cannot parse verification link: %v", err)
+	}
+	token := l.Query().Get("token")
+
+	if _, err := accounts.VerifyEmail(ctx, token+"x"); err != ErrInvalidSignedToken {
+		t.Errorf("wanted error %v, got %v instead", ErrInvalidSignedToken, err)
+	}
+	if id, err := accounts.VerifyEmail(ctx, token); err != nil || id != u.UserID {
+		t.Fatalf("cannot verify email address: %v", err)
+	}
+	if u, err = accounts.GetUserByID(ctx, u.UserID); err != nil || !u.EmailVerified() {
+		t.Errorf("wanted email address to be verified, got %+v (error: %v) instead", u, err)
+	}
+	if err := accounts.ResendVerificationEmail(ctx, "jane@example.com"); err != nil {
+		t.Errorf("cannot resend verification email: %v", err)
+	}
+	deliverTestMail(t, core)
+	if messages := transport.Messages(); len(messages) != 2 {
+		t.Errorf("wanted no email to be sent to verified user, got %d emails", len(messages))
+	}
+	if err := accounts.ResendVerificationEmail(ctx, "john@example.com"); err != nil {
+		t.Errorf("wanted no error resending to unknown email address, got %v instead", err)
+	}
+}
@@ -0,0 +1,301 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// TokenKind tells how an API token is used.
+type TokenKind string
+
+var (
+	// PersonalToken is created by a user to access the API on their own behalf.
+	PersonalToken TokenKind = "personal"
+
+	// ServiceToken is created by an operator for a machine integration acting as a user.
+	ServiceToken TokenKind = "service"
+
+	// OAuthToken is issued to an OAuth client the user authorized to act on their behalf.
+	OAuthToken TokenKind = "oauth"
+)
+
+// TokenScope restricts what an API token can be used for.
+type TokenScope string
+
+// API token scopes.
+const (
+	ScopeProfile TokenScope = "profile"
+	ScopeCart    TokenScope = "cart"
+	ScopeOrders  TokenScope = "orders"
+)
+
+// TokenScopes lists all valid API token scopes.
+var TokenScopes = []TokenScope{ScopeProfile, ScopeCart, ScopeOrders}
+
+// tokenPrefix of every API token, to make them easy to identify (e.g., by secret scanners).
+const tokenPrefix = "mkt_"
+
+// APIToken to authenticate on the API.
+// Only a hash of the token is stored, so the token itself is only known when it is created.
+type APIToken struct {
+	TokenID    string
+	UserID     string
+	Name       string
+	Kind       TokenKind
+	Scopes     []TokenScope
+	ClientID   string
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+// HasScope checks if the token grants the given scope.
+func (t *APIToken) HasScope(scope TokenScope) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenFromRequest gets the API token used to authenticate the request.
+func TokenFromRequest(r *http.Request) *APIToken {
+	if t, ok := r.Context().Value(tokenCtxKey{}).(*APIToken); ok {
+		return t
+	}
+	return nil
+}
+
+// TokenContext adds an API token to a given context.
+func TokenContext(ctx context.Context, t *APIToken) context.Context {
+	return context.WithValue(ctx, tokenCtxKey{}, t)
+}
+
+type tokenCtxKey struct{}
+
+var (
+	// ErrTokenNotFound occurs when no API token is found.
+	ErrTokenNotFound = errors.New("API token not found")
+
+	// ErrInvalidToken is returned when an API token doesn't exist, was revoked, or expired.
+	ErrInvalidToken = errors.New("invalid API token")
+)
+
+// Tokens manages API tokens.
+type Tokens struct {
+	core *Core
+}
+
+// NewTokenParams to create an API token.
+type NewTokenParams struct {
+	UserID string
+	Name   string
+	Kind   TokenKind
+	Scopes []TokenScope
+
+	// ClientID of the OAuth client the token is issued to, for OAuth tokens.
+	ClientID string
+
+	// ExpiresAt is optional. Tokens without it never expire.
+	ExpiresAt time.Time
+}
+
+// ValidateAndNormalize the parameters.
+func (p *NewTokenParams) ValidateAndNormalize() error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return errors.New("missing token name")
+	}
+	if len(p.Name) > 100 {
+		return errors.New("token name is too long")
+	}
+	switch {
+	case p.Kind != PersonalToken && p.Kind != ServiceToken && p.Kind != OAuthToken:
+		return fmt.Errorf("invalid token kind %q", p.Kind)
+	case (p.Kind == OAuthToken) != (p.ClientID != ""):
+		return errors.New("only OAuth tokens must have a client ID")
+	}
+	if len(p.Scopes) == 0 {
+		return errors.New("token must have at least one scope")
+	}
+	seen := map[TokenScope]bool{}
+	var scopes []TokenScope
+	for _, s := range p.Scopes {
+		if !validTokenScope(s) {
+			return fmt.Errorf("invalid token scope %q", s)
+		}
+		if !seen[s] {
+			scopes = append(scopes, s)
+		}
+		seen[s] = true
+	}
+	p.Scopes = scopes
+	if !p.ExpiresAt.IsZero() && p.ExpiresAt.Before(time.Now()) {
+		return errors.New("token expiration must be in the future")
+	}
+	return nil
+}
+
+func validTokenScope(scope TokenScope) bool {
+	for _, s := range TokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Create an API token. The token is returned only here, and cannot be retrieved later.
+func (t *Tokens) Create(ctx context.Context, p NewTokenParams) (token string, at *APIToken, err error) {
+	return insertAPIToken(ctx, t.core.Postgres, p)
+}
+
+func insertAPIToken(ctx context.Context, q pgQuerier, p NewTokenParams) (token string, at *APIToken, err error) {
+	if err = p.ValidateAndNormalize(); err != nil {
+		return "", nil, invalid(err)
+	}
+	token = newAPIToken()
+	at = &APIToken{
+		TokenID:  new11RandomID(),
+		UserID:   p.UserID,
+		Name:     p.Name,
+		Kind:     p.Kind,
+		Scopes:   p.Scopes,
+		ClientID: p.ClientID,
+	}
+	if !p.ExpiresAt.IsZero() {
+		at.ExpiresAt = &p.ExpiresAt
+	}
+	const sql = `INSERT INTO api_tokens ("token_id", "user_id", "name", "kind", "token_hash", "scopes", "client_id", "created_at", "expires_at") VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NOW(), $8) RETURNING "created_at"`
+	if err := q.QueryRow(ctx, sql, at.TokenID, at.UserID, at.Name, string(at.Kind), hashAPIToken(token), scopesToStrings(at.Scopes), at.ClientID, at.ExpiresAt).Scan(&at.CreatedAt); err != nil {
+		return "", nil, fmt.Errorf("cannot create API token: %w", err)
+	}
+	return token, at, nil
+}
+
+// List API tokens of a user, including the expired ones, most recent first.
+func (t *Tokens) List(ctx context.Context, userID string) ([]APIToken, error) {
+	pg := t.core.Postgres
+	const sql = `SELECT "token_id", "user_id", "name", "kind", "scopes", COALESCE("client_id", ''), "created_at", "expires_at", "last_used_at" FROM api_tokens WHERE "user_id" = $1 ORDER BY "created_at" DESC`
+	rows, err := pg.Query(ctx, sql, userID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list API tokens: %w", err)
+	}
+	defer rows.Close()
+	var tokens []APIToken
+	for rows.Next() {
+		at, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read API token: %w", err)
+		}
+		tokens = append(tokens, *at)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot list API tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// Revoke an API token of a user.
+// Revoking an OAuth token also revokes its refresh token, so the client loses access.
+func (t *Tokens) Revoke(ctx context.Context, userID, tokenID string) error {
+	tx, err := t.core.Postgres.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot revoke API token: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	const sql = `DELETE FROM api_tokens WHERE "token_id" = $1 AND "user_id" = $2`
+	switch ct, err := tx.Exec(ctx, sql, tokenID, userID); {
+	case err != nil:
+		return fmt.Errorf("cannot revoke API token: %w", err)
+	case ct.RowsAffected() == 0:
+		return ErrTokenNotFound
+	}
+	const refreshSQL = `DELETE FROM oauth_refresh_tokens WHERE "access_token_id" = $1`
+	if _, err := tx.Exec(ctx, refreshSQL, tokenID); err != nil {
+		return fmt.Errorf("cannot revoke OAuth refresh token: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("cannot revoke API token: %w", err)
+	}
+	return nil
+}
+
+// Authenticate an API token, returning it and its user.
+// The last used timestamp of the token is updated at most once a minute.
+func (t *Tokens) Authenticate(ctx context.Context, token string) (*APIToken, *User, error) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return nil, nil, ErrInvalidToken
+	}
+	pg := t.core.Postgres
+	const sql = `SELECT "token_id", "user_id", "name", "kind", "scopes", COALESCE("client_id", ''), "created_at", "expires_at", "last_used_at" FROM api_tokens WHERE "token_hash" = $1 AND ("expires_at" IS NULL OR "expires_at" > NOW()) LIMIT 1`
+	at, err := scanAPIToken(pg.QueryRow(ctx, sql, hashAPIToken(token)))
+	switch {
+	case err == pgx.ErrNoRows:
+		return nil, nil, ErrInvalidToken
+	case err != nil:
+		return nil, nil, fmt.Errorf("cannot get API token: %w", err)
+	}
+	const touchSQL = `UPDATE api_tokens SET "last_used_at" = NOW() WHERE "token_id" = $1 AND ("last_used_at" IS NULL OR "last_used_at" < NOW() - INTERVAL '1 MINUTE')`
+	if _, err := pg.Exec(ctx, touchSQL, at.TokenID); err != nil {
+		return nil, nil, fmt.Errorf("cannot update API token last used time: %w", err)
+	}
+	accounts := Accounts{core: t.core}
+	u, err := accounts.GetUserByID(ctx, at.UserID)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, nil, ErrInvalidToken
+	case err != nil:
+		return nil, nil, err
+	}
+	return at, u, nil
+}
+
+func scanAPIToken(row pgx.Row) (*APIToken, error) {
+	var (
+		at     APIToken
+		scopes []string
+	)
+	if err := row.Scan(&at.TokenID, &at.UserID, &at.Name, &at.Kind, &scopes, &at.ClientID, &at.CreatedAt, &at.ExpiresAt, &at.LastUsedAt); err != nil {
+		return nil, err
+	}
+	for _, s := range scopes {
+		at.Scopes = append(at.Scopes, TokenScope(s))
+	}
+	return &at, nil
+}
+
+func scopesToStrings(scopes []TokenScope) []string {
+	s := make([]string, len(scopes))
+	for i, scope := range scopes {
+		s[i] = string(scope)
+	}
+	return s
+}
+
+// newAPIToken generates a token with 256 bits of entropy.
+func newAPIToken() string {
+	var r = make([]byte, 32)
+	if _, err := rand.Read(r); err != nil {
+		panic(err)
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(r)
+}
+
+// hashAPIToken for storage. A fast hash is fine, as tokens have enough entropy to resist brute-force attacks.
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewTokenParamsValidateAndNormalize(t *testing.T) {
+	testCases := []struct {
+		name    string
+		params  NewTokenParams
+		want    []TokenScope
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			params: NewTokenParams{Name: " CI ", Kind: PersonalToken, Scopes: []TokenScope{ScopeCart, ScopeOrders, ScopeCart}},
+			want:   []TokenScope{ScopeCart, ScopeOrders},
+		},
+		{
+			name:    "missing name",
+			params:  NewTokenParams{Kind: PersonalToken, Scopes: []TokenScope{ScopeCart}},
+			wantErr: "missing token name",
+		},
+		{
+			name:    "invalid kind",
+			params:  NewTokenParams{Name: "x", Kind: "robot", Scopes: []TokenScope{ScopeCart}},
+			wantErr: `invalid token kind "robot"`,
+		},
+		{
+			name:    "no scopes",
+			params:  NewTokenParams{Name: "x", Kind: ServiceToken},
+			wantErr: "token must have at least one scope",
+		},
+		{
+			name:    "invalid scope",
+			params:  NewTokenParams{Name: "x", Kind: ServiceToken, Scopes: []TokenScope{"admin"}},
+			wantErr: `invalid token scope "admin"`,
+		},
+		{
+			name:    "expired",
+			params:  NewTokenParams{Name: "x", Kind: ServiceToken, Scopes: []TokenScope{ScopeCart}, ExpiresAt: time.Now().Add(-time.Hour)},
+			wantErr: "token expiration must be in the future",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.params.ValidateAndNormalize()
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Errorf("wanted error %q, got %v instead", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.params.Name != "CI" {
+				t.Errorf("wanted name to be trimmed, got %q instead", tc.params.Name)
+			}
+			if !reflect.DeepEqual(tc.params.Scopes, tc.want) {
+				t.Errorf("wanted scopes %v, got %v instead", tc.want, tc.params.Scopes)
+			}
+		})
+	}
+}
+
+func TestNewAPIToken(t *testing.T) {
+	a, b := newAPIToken(), newAPIToken()
+	if !strings.HasPrefix(a, tokenPrefix) || a == b {
+		t.Errorf("wanted unique tokens with prefix %q, got %q and %q instead", tokenPrefix, a, b)
+	}
+	if hashAPIToken(a) == a || hashAPIToken(a) != hashAPIToken(a) || len(hashAPIToken(a)) != 64 {
+		t.Errorf("unexpected hash %q for token", hashAPIToken(a))
+	}
+}
+
+func TestTokensAuthenticate(t *testing.T) {
+	core := newTestCore(t)
+	ctx := context.Background()
+	userID := newTestUser(t, core)
+
+	tokens := Tokens{core: core}
+	token, at, err := tokens.Create(ctx, NewTokenParams{
+		UserID: userID,
+		Name:   "mobile",
+		Kind:   PersonalToken,
+		Scopes: []TokenScope{ScopeProfile},
+	})
+	if err != nil {
+		t.Fatalf("cannot create token: %v", err)
+	}
+
+	got, u, err := tokens.Authenticate(ctx, token)
+	if err != nil {
+		t.Fatalf("cannot authenticate token: %v", err)
+	}
+	if got.TokenID != at.TokenID || u.UserID != userID || !got.HasScope(ScopeProfile) || got.HasScope(ScopeOrders) {
+		t.Errorf("unexpected token %+v for user %+v", got, u)
+	}
+	if _, _, err := tokens.Authenticate(ctx, token+"x"); err != ErrInvalidToken {
+		t.Errorf("wanted error %v for wrong token, got %v instead", ErrInvalidToken, err)
+	}
+
+	list, err := tokens.List(ctx, userID)
+	if err != nil {
+		t.Fatalf("cannot list tokens: %v", err)
+	}
+	if len(list) != 1 || list[0].LastUsedAt == nil {
+		t.Errorf("wanted a single used token, got %+v instead", list)
+	}
+
+	if err := tokens.Revoke(ctx, "other", at.TokenID); err != ErrTokenNotFound {
+		t.Errorf("wanted error %v revoking token of another user, got %v instead", ErrTokenNotFound, err)
+	}
+	if err := tokens.Revoke(ctx, userID, at.TokenID); err != nil {
+		t.Fatalf("cannot revoke token: %v", err)
+	}
+	if _, _, err := tokens.Authenticate(ctx, token); err != ErrInvalidToken {
+		t.Errorf("wanted error %v for revoked token, got %v instead", ErrInvalidToken, err)
+	}
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// UserCache is an in-process LRU cache of users, in front of the cache on Redis.
+// Use GetCachedUserByID to read users through it.
+// Hits and misses of each process are published with expvar as "user_cache".
+type UserCache struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	ll    *list.List
+	items map[string]*list.Element
+
+	// gen is incremented whenever users are removed, so that users read before that aren't added back.
+	gen uint64
+
+	now func() time.Time
+}
+
+type userCacheEntry struct {
+	user    User
+	expires time.Time
+}
+
+const (
+	// userCacheSize is the number of users kept on the in-process cache.
+	userCacheSize = 10000
+
+	// userCacheTTL on the in-process cache, which bounds how long users are stale
+	// if an invalidation published by another server is missed.
+	userCacheTTL = 30 * time.Second
+
+	// userCacheRedisTTL on Redis.
+	userCacheRedisTTL = 5 * time.Minute
+
+	// userCachePrefix of the Redis keys.
+	userCachePrefix = "market:user:"
+
+	// userCacheTombstone replaces invalidated users on Redis for userCacheTombstoneTTL, so that servers that read
+	// a user from PostgreSQL before it changed cannot put it back, as users are only cached if their key is missing.
+	// It must be longer than reading a user from PostgreSQL takes.
+	userCacheTombstone    = "invalidated"
+	userCacheTombstoneTTL = 10 * time.Second
+
+	// userCacheChannel where invalidated user IDs are published, so that every server removes them from its in-process cache.
+	userCacheChannel = "market:user-invalidations"
+)
+
+// userCacheVars has the hits and misses of the cache on this process.
+var userCacheVars = expvar.NewMap("user_cache")
+
+// NewUserCache creates an in-process cache of users.
+func NewUserCache() *UserCache {
+	return &UserCache{
+		size:  userCacheSize,
+		ttl:   userCacheTTL,
+		ll:    list.New(),
+		items: map[string]*list.Element{},
+		now:   time.Now,
+	}
+}
+
+// get a copy of a cached user.
+func (c *UserCache) get(userID string) (*User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[userID]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*userCacheEntry)
+	if c.now().After(entry.expires) {
+		c.ll.Remove(e)
+		delete(c.items, userID)
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return entry.user.clone(), true
+}
+
+// generation of the cache, to pass to add.
+func (c *UserCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// add a copy of the user, evicting the least recently used if the cache is full.
+// The user isn't added if users were removed since the given generation, as it might have been read before changing.
+func (c *UserCache) add(u *User, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return
+	}
+	entry := &userCacheEntry{user: *u.clone(), expires: c.now().Add(c.ttl)}
+	if e, ok := c.items[u.UserID]; ok {
+		e.Value = entry
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[u.UserID] = c.ll.PushFront(entry)
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*userCacheEntry).user.UserID)
+	}
+}
+
+// remove users from the cache.
+func (c *UserCache) remove(userIDs ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for _, id := range userIDs {
+		if e, ok := c.items[id]; ok {
+			c.ll.Remove(e)
+			delete(c.items, id)
+		}
+	}
+}
+
+// clone the user, so that cached users cannot be changed by callers.
+func (u *User) clone() *User {
+	c := *u
+	c.Permissions = append([]Permission(nil), u.Permissions...)
+	if u.EmailVerifiedAt != nil {
+		t := *u.EmailVerifiedAt
+		c.EmailVerifiedAt = &t
+	}
+	return &c
+}
+
+// GetCachedUserByID reads the user through the in-process cache and Redis, used to get the user on every request.
+// Users might be a few seconds stale, so use GetUserByID to check a user before changing anything important.
+func (a *Accounts) GetCachedUserByID(ctx context.Context, userID string) (*User, error) {
+	local := a.core.UserCache
+	var gen uint64
+	if local != nil {
+		if u, ok := local.get(userID); ok {
+			userCacheVars.Add("local_hits", 1)
+			return u, nil
+		}
+		gen = local.generation()
+	}
+	if kv := a.core.Redis; kv != nil {
+		switch b, err := kv.Get(ctx, userCachePrefix+userID).Bytes(); {
+		case err == redis.Nil, err == nil && string(b) == userCacheTombstone:
+		case err != nil:
+			log.Printf("cannot get cached user %q: %v", userID, err)
+		default:
+			var u User
+			if err := json.Unmarshal(b, &u); err != nil {
+				log.Printf("cannot read cached user %q: %v", userID, err)
+				break
+			}
+			userCacheVars.Add("redis_hits", 1)
+			if local != nil {
+				local.add(&u, gen)
+			}
+			return &u, nil
+		}
+	}
+	userCacheVars.Add("misses", 1)
+	u, err := a.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if kv := a.core.Redis; kv != nil {
+		b, err := json.Marshal(u)
+		if err != nil {
+			return nil, fmt.Errorf("cannot cache user: %w", err)
+		}
+		// If the user was invalidated since, the key has a tombstone, and the user isn't cached.
+		if err := kv.SetNX(ctx, userCachePrefix+userID, b, userCacheRedisTTL).Err(); err != nil {
+			log.Printf("cannot cache user %q: %v", userID, err)
+		}
+	}
+	if local != nil {
+		local.add(u, gen)
+	}
+	return u, nil
+}
+
+// invalidateCachedUsers removes users from the caches after they change, such as their profile, access, or credentials.
+// Failing to do so isn't returned as an error, as the change was already made: users expire from the caches soon anyway.
+func invalidateCachedUsers(ctx context.Context, core *Core, userIDs ...string) {
+	if len(userIDs) == 0 {
+		return
+	}
+	userCacheVars.Add("invalidations", int64(len(userIDs)))
+	if core.UserCache != nil {
+		core.UserCache.remove(userIDs...)
+	}
+	kv := core.Redis
+	if kv == nil {
+		return
+	}
+	if _, err := kv.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, id := range userIDs {
+			pipe.Set(ctx, userCachePrefix+id, userCacheTombstone, userCacheTombstoneTTL)
+			pipe.Publish(ctx, userCacheChannel, id)
+		}
+		return nil
+	}); err != nil {
+		log.Printf("cannot invalidate cached users %v: %v", userIDs, err)
+	}
+}
+
+// SyncUserCache removes users invalidated by other servers from the in-process cache until the context is cancelled.
+func (a *Accounts) SyncUserCache(ctx context.Context) {
+	if a.core.UserCache == nil || a.core.Redis == nil {
+		return
+	}
+	sub := a.core.Redis.Subscribe(ctx, userCacheChannel)
+	defer sub.Close()
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			a.core.UserCache.remove(msg.Payload)
+		}
+	}
+}
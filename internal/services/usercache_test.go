@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestUserCache(t *testing.T) {
+	now := time.Now()
+	c := NewUserCache()
+	c.size = 2
+	c.now = func() time.Time { return now }
+
+	c.add(&User{UserID: "a", Permissions: []Permission{PermissionAdminArea}}, c.generation())
+	c.add(&User{UserID: "b"}, c.generation())
+	u, ok := c.get("a")
+	if !ok {
+		t.Fatal("wanted user a to be cached")
+	}
+	// Changing a user returned by the cache must not change the cached one.
+	u.Name = "changed"
+	u.Permissions[0] = PermissionManageRoles
+	if u, _ := c.get("a"); u.Name != "" || u.Permissions[0] != PermissionAdminArea {
+		t.Errorf("cached user changed: %+v", u)
+	}
+
+	// User b is the least recently used, so it is evicted.
+	c.add(&User{UserID: "c"}, c.generation())
+	if _, ok := c.get("b"); ok {
+		t.Error("wanted user b to be evicted")
+	}
+	if _, ok := c.get("c"); !ok {
+		t.Error("wanted user c to be cached")
+	}
+
+	gen := c.generation()
+	c.remove("c", "unknown")
+	if _, ok := c.get("c"); ok {
+		t.Error("wanted user c to be removed")
+	}
+	// A user read before the removal must not be added back.
+	c.add(&User{UserID: "c"}, gen)
+	if _, ok := c.get("c"); ok {
+		t.Error("wanted user c read before its removal not to be cached")
+	}
+
+	now = now.Add(userCacheTTL + time.Second)
+	if _, ok := c.get("a"); ok {
+		t.Error("wanted user a to expire")
+	}
+	if len(c.items) != 0 || c.ll.Len() != 0 {
+		t.Errorf("wanted cache to be empty, got %d items", len(c.items))
+	}
+}
+
+func TestInvalidateCachedUsersRedis(t *testing.T) {
+	core := newTestRedisCore(t)
+	ctx := context.Background()
+	userID := new11RandomID()
+	key := userCachePrefix + userID
+	t.Cleanup(func() {
+		core.Redis.Del(context.Background(), key)
+	})
+
+	if err := core.Redis.Set(ctx, key, `{"UserID":"`+userID+`"}`, userCacheRedisTTL).Err(); err != nil {
+		t.Fatal(err)
+	}
+	invalidateCachedUsers(ctx, core, userID)
+	if got, err := core.Redis.Get(ctx, key).Result(); err != nil || got != userCacheTombstone {
+		t.Fatalf("wanted tombstone on invalidated user, got %q (error: %v)", got, err)
+	}
+	if ttl, err := core.Redis.TTL(ctx, key).Result(); err != nil || ttl <= 0 || ttl > userCacheTombstoneTTL {
+		t.Errorf("wanted tombstone to expire within %v, got %v (error: %v)", userCacheTombstoneTTL, ttl, err)
+	}
+	// A server that read the user before the invalidation cannot put it back.
+	if ok, err := core.Redis.SetNX(ctx, key, `{"UserID":"`+userID+`"}`, userCacheRedisTTL).Result(); err != nil || ok {
+		t.Errorf("wanted stale user not to be cached, got %v (error: %v)", ok, err)
+	}
+}
+
+func TestGetCachedUserByID(t *testing.T) {
+	core := newTestCore(t)
+	core.UserCache = NewUserCache()
+	ctx := context.Background()
+	accounts := Accounts{core: core}
+	roles := Roles{core: core}
+
+	admin := &User{UserID: "admin", Access: AdminAuthorization}
+	userID, err := accounts.NewUser(ctx, NewUserParams{Name: "Jane Doe", Email: "jane@example.com"})
+	if err != nil {
+		t.Fatalf("cannot create user: %v", err)
+	}
+	if u, err := accounts.GetCachedUserByID(ctx, userID); err != nil || u.Email != "jane@example.com" {
+		t.Fatalf("wanted user, got %+v (error: %v)", u, err)
+	}
+	if _, ok := core.UserCache.get(userID); !ok {
+		t.Fatal("wanted user to be cached")
+	}
+	if _, err := accounts.GetCachedUserByID(ctx, "unknown"); err == nil {
+		t.Error("wanted error getting unknown user")
+	}
+
+	if _, err := roles.CreateDefaults(ctx, admin); err != nil {
+		t.Fatalf("cannot create default roles: %v", err)
+	}
+	if err := roles.Assign(ctx, admin, userID, "catalog-editor"); err != nil {
+		t.Fatalf("cannot assign role: %v", err)
+	}
+	if u, err := accounts.GetCachedUserByID(ctx, userID); err != nil || !Can(u, PermissionManageCatalog) {
+		t.Errorf("wanted cached user invalidated after assigning role, got %+v (error: %v)", u, err)
+	}
+	if err := roles.Update(ctx, admin, RoleParams{RoleID: "catalog-editor", Name: "Catalog editor", Permissions: []Permission{PermissionViewReports}}); err != nil {
+		t.Fatalf("cannot update role: %v", err)
+	}
+	if u, err := accounts.GetCachedUserByID(ctx, userID); err != nil || Can(u, PermissionManageCatalog) || !Can(u, PermissionViewReports) {
+		t.Errorf("wanted cached user invalidated after updating role, got %+v (error: %v)", u, err)
+	}
+	if err := roles.Delete(ctx, admin, "catalog-editor"); err != nil {
+		t.Fatalf("cannot delete role: %v", err)
+	}
+	if u, err := accounts.GetCachedUserByID(ctx, userID); err != nil || Can(u, PermissionAdminArea) {
+		t.Errorf("wanted cached user invalidated after deleting role, got %+v (error: %v)", u, err)
+	}
+}
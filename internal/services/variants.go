@@ -0,0 +1,230 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ProductOption is a dimension in which the variants of a product differ, such as color or size.
+type ProductOption struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// maxProductOptions is the maximum number of option dimensions of a product.
+const maxProductOptions = 3
+
+// validateProductOptions checks that option names and values are present and unique.
+func validateProductOptions(options []ProductOption) error {
+	if len(options) > maxProductOptions {
+		return fmt.Errorf("product can have at most %d options", maxProductOptions)
+	}
+	names := map[string]bool{}
+	for i := range options {
+		o := &options[i]
+		o.Name = strings.TrimSpace(o.Name)
+		if o.Name == "" {
+			return errors.New("missing option name")
+		}
+		if names[o.Name] {
+			return fmt.Errorf("duplicated option %q", o.Name)
+		}
+		names[o.Name] = true
+		if len(o.Values) == 0 {
+			return fmt.Errorf("option %q has no values", o.Name)
+		}
+		values := map[string]bool{}
+		for j, v := range o.Values {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				return fmt.Errorf("option %q has an empty value", o.Name)
+			}
+			if values[v] {
+				return fmt.Errorf("option %q has duplicated value %q", o.Name, v)
+			}
+			values[v] = true
+			o.Values[j] = v
+		}
+	}
+	return nil
+}
+
+// validateVariantOptions checks that a variant has a valid value for each option of the product.
+func validateVariantOptions(options []ProductOption, variant map[string]string) error {
+	if len(variant) != len(options) {
+		return errors.New("variant must have exactly one value for each product option")
+	}
+	for _, o := range options {
+		v, ok := variant[o.Name]
+		if !ok {
+			return fmt.Errorf("variant is missing a value for option %q", o.Name)
+		}
+		if !contains(o.Values, v) {
+			return fmt.Errorf("invalid value %q for option %q", v, o.Name)
+		}
+	}
+	return nil
+}
+
+// combinationKey identifies the combination of option values of a variant.
+func combinationKey(options []ProductOption, variant map[string]string) string {
+	values := make([]string, len(options))
+	for i, o := range options {
+		values[i] = variant[o.Name]
+	}
+	return strings.Join(values, "\x00")
+}
+
+// validateVariantsMatrix checks that each variant is a unique and valid combination of the product options.
+func validateVariantsMatrix(options []ProductOption, variants []map[string]string) error {
+	seen := map[string]bool{}
+	for _, v := range variants {
+		if err := validateVariantOptions(options, v); err != nil {
+			return err
+		}
+		if len(options) == 0 {
+			continue // Variants of products without options are told apart only by their names.
+		}
+		key := combinationKey(options, v)
+		if seen[key] {
+			return fmt.Errorf("duplicated variant for options %v", v)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// Variant with the given ID. Returns nil if not found.
+func (p *Product) Variant(variantID string) *Variant {
+	for i := range p.Variants {
+		if p.Variants[i].VariantID == variantID {
+			return &p.Variants[i]
+		}
+	}
+	return nil
+}
+
+// DefaultVariant of the product: the first one in stock, or the first one if all are out of stock.
+func (p *Product) DefaultVariant() *Variant {
+	for i := range p.Variants {
+		if p.Variants[i].Stock > 0 {
+			return &p.Variants[i]
+		}
+	}
+	if len(p.Variants) == 0 {
+		return nil
+	}
+	return &p.Variants[0]
+}
+
+// OptionSelector lists the choices for an option of a product, given the selected variant.
+type OptionSelector struct {
+	// Name of the option. Empty for products without options, where each variant is a choice.
+	Name string
+
+	// Selected value of the option.
+	Selected string
+
+	Choices []OptionChoice
+}
+
+// OptionChoice is a value of an option that leads to a variant.
+type OptionChoice struct {
+	Value string
+
+	// VariantID to select when choosing this value.
+	// It is the variant that keeps most of the other selected option values.
+	VariantID string
+
+	// Selected is true if the value is the one of the selected variant.
+	Selected bool
+
+	// Exact is true if choosing this value keeps all the other selected option values.
+	Exact bool
+
+	// InStock is true if the variant of this choice is available for sale.
+	InStock bool
+}
+
+// OptionSelectors for choosing a variant of the product, given the selected one.
+// Values that don't exist on any variant are omitted.
+func (p *Product) OptionSelectors(selected *Variant) []OptionSelector {
+	if selected == nil || len(p.Variants) < 2 {
+		return nil
+	}
+	if len(p.Options) == 0 {
+		s := OptionSelector{Selected: selected.Name}
+		for _, v := range p.Variants {
+			s.Choices = append(s.Choices, OptionChoice{
+				Value:     v.Name,
+				VariantID: v.VariantID,
+				Selected:  v.VariantID == selected.VariantID,
+				Exact:     true,
+				InStock:   v.Stock > 0,
+			})
+		}
+		return []OptionSelector{s}
+	}
+
+	var selectors []OptionSelector
+	for _, o := range p.Options {
+		s := OptionSelector{Name: o.Name, Selected: selected.Options[o.Name]}
+		for _, value := range o.Values {
+			best, matches := p.closestVariant(selected, o.Name, value)
+			if best == nil {
+				continue
+			}
+			s.Choices = append(s.Choices, OptionChoice{
+				Value:     value,
+				VariantID: best.VariantID,
+				Selected:  value == s.Selected,
+				Exact:     matches == len(p.Options)-1,
+				InStock:   best.Stock > 0,
+			})
+		}
+		selectors = append(selectors, s)
+	}
+	return selectors
+}
+
+// closestVariant with the given option value, keeping as many of the other selected option values as possible.
+// Variants in stock are preferred when they keep the same number of option values.
+func (p *Product) closestVariant(selected *Variant, option, value string) (best *Variant, matches int) {
+	var candidates []*Variant
+	for i := range p.Variants {
+		if p.Variants[i].Options[option] == value {
+			candidates = append(candidates, &p.Variants[i])
+		}
+	}
+	count := func(v *Variant) int {
+		var n int
+		for _, o := range p.Options {
+			if o.Name != option && v.Options[o.Name] == selected.Options[o.Name] {
+				n++
+			}
+		}
+		return n
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ci, cj := count(candidates[i]), count(candidates[j])
+		if ci != cj {
+			return ci > cj
+		}
+		return candidates[i].Stock > 0 && candidates[j].Stock <= 0
+	})
+	if len(candidates) == 0 {
+		return nil, 0
+	}
+	return candidates[0], count(candidates[0])
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateProductOptions(t *testing.T) {
+	options := []ProductOption{
+		{Name: " Color ", Values: []string{"Black ", "White"}},
+		{Name: "Size", Values: []string{"S", "M"}},
+	}
+	if err := validateProductOptions(options); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if options[0].Name != "Color" || options[0].Values[0] != "Black" {
+		t.Errorf("wanted options to be normalized, got %+v instead", options)
+	}
+
+	invalid := [][]ProductOption{
+		{{Name: "", Values: []string{"a"}}},
+		{{Name: "Color", Values: nil}},
+		{{Name: "Color", Values: []string{"Black", "Black"}}},
+		{{Name: "Color", Values: []string{" "}}},
+		{{Name: "Color", Values: []string{"a"}}, {Name: "Color", Values: []string{"b"}}},
+		{{Name: "A", Values: []string{"a"}}, {Name: "B", Values: []string{"b"}}, {Name: "C", Values: []string{"c"}}, {Name: "D", Values: []string{"d"}}},
+	}
+	for _, options := range invalid {
+		if err := validateProductOptions(options); err == nil {
+			t.Errorf("wanted error for options %+v, got nil instead", options)
+		}
+	}
+}
+
+func TestValidateVariantsMatrix(t *testing.T) {
+	options := []ProductOption{
+		{Name: "Color", Values: []string{"Black", "White"}},
+		{Name: "Power cord", Values: []string{"US", "UK"}},
+	}
+	valid := []map[string]string{
+		{"Color": "Black", "Power cord": "US"},
+		{"Color": "White", "Power cord": "US"},
+		{"Color": "Black", "Power cord": "UK"},
+	}
+	if err := validateVariantsMatrix(options, valid); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := validateVariantsMatrix(nil, []map[string]string{{}, {}}); err != nil {
+		t.Errorf("wanted variants of products without options to be valid, got %v instead", err)
+	}
+
+	invalid := [][]map[string]string{
+		{{"Color": "Black"}},
+		{{"Color": "Black", "Power cord": "EU"}},
+		{{"Color": "Black", "Size": "US"}},
+		{{"Color": "Black", "Power cord": "US", "Size": "M"}},
+		{{"Color": "Black", "Power cord": "US"}, {"Color": "Black", "Power cord": "US"}},
+	}
+	for _, variants := range invalid {
+		if err := validateVariantsMatrix(options, variants); err == nil {
+			t.Errorf("wanted error for variants %v, got nil instead", variants)
+		}
+	}
+}
+
+func TestProductOptionSelectors(t *testing.T) {
+	p := &Product{
+		Options: []ProductOption{
+			{Name: "Color", Values: []string{"White", "Black", "Dark grey", "Orange", "Pink"}},
+			{Name: "Power cord", Values: []string{"US", "UK", "Europlug"}},
+		},
+		Variants: []Variant{
+			{VariantID: "white-us", Options: map[string]string{"Color": "White", "Power cord": "US"}, Stock: 1},
+			{VariantID: "black-us", Options: map[string]string{"Color": "Black", "Power cord": "US"}, Stock: 1},
+			{VariantID: "black-uk", Options: map[string]string{"Color": "Black", "Power cord": "UK"}, Stock: 1},
+			{VariantID: "black-eu", Options: map[string]string{"Color": "Black", "Power cord": "Europlug"}},
+			{VariantID: "grey-uk", Options: map[string]string{"Color": "Dark grey", "Power cord": "UK"}, Stock: 1},
+			{VariantID: "orange-us", Options: map[string]string{"Color": "Orange", "Power cord": "US"}},
+		},
+	}
+	if got := p.DefaultVariant().VariantID; got != "white-us" {
+		t.Errorf("wanted default variant to be white-us, got %q instead", got)
+	}
+	got := p.OptionSelectors(p.Variant("black-us"))
+	want := []OptionSelector{
+		{
+			Name:     "Color",
+			Selected: "Black",
+			Choices: []OptionChoice{
+				{Value: "White", VariantID: "white-us", Exact: true, InStock: true},
+				{Value: "Black", VariantID: "black-us", Selected: true, Exact: true, InStock: true},
+				{Value: "Dark grey", VariantID: "grey-uk", InStock: true},
+				{Value: "Orange", VariantID: "orange-us", Exact: true},
+				// Pink is omitted because there is no variant with it.
+			},
+		},
+		{
+			Name:     "Power cord",
+			Selected: "US",
+			Choices: []OptionChoice{
+				{Value: "US", VariantID: "black-us", Selected: true, Exact: true, InStock: true},
+				{Value: "UK", VariantID: "black-uk", Exact: true, InStock: true},
+				{Value: "Europlug", VariantID: "black-eu", Exact: true},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted selectors %+v, got %+v instead", want, got)
+	}
+}
+
+func TestProductOptionSelectorsPrefersInStock(t *testing.T) {
+	p := &Product{
+		Options: []ProductOption{
+			{Name: "Color", Values: []string{"White", "Black"}},
+			{Name: "Size", Values: []string{"S", "M", "L"}},
+		},
+		Variants: []Variant{
+			{VariantID: "white-s", Options: map[string]string{"Color": "White", "Size": "S"}, Stock: 1},
+			{VariantID: "black-m", Options: map[string]string{"Color": "Black", "Size": "M"}},
+			{VariantID: "black-l", Options: map[string]string{"Color": "Black", "Size": "L"}, Stock: 1},
+		},
+	}
+	got := p.OptionSelectors(p.Variant("white-s"))
+	if choice := got[0].Choices[1]; choice.VariantID != "black-l" || !choice.InStock || choice.Exact {
+		t.Errorf("wanted Black to lead to black-l, got %+v instead", choice)
+	}
+}
+
+func TestProductOptionSelectorsWithoutOptions(t *testing.T) {
+	p := &Product{
+		Variants: []Variant{
+			{VariantID: "a", Name: "128 GB", Stock: 1},
+			{VariantID: "b", Name: "256 GB"},
+		},
+	}
+	want := []OptionSelector{
+		{
+			Selected: "256 GB",
+			Choices: []OptionChoice{
+				{Value: "128 GB", VariantID: "a", Exact: true, InStock: true},
+				{Value: "256 GB", VariantID: "b", Selected: true, Exact: true},
+			},
+		},
+	}
+	if got := p.OptionSelectors(p.Variant("b")); !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted selectors %+v, got %+v instead", want, got)
+	}
+	if got := (&Product{Variants: p.Variants[:1]}).OptionSelectors(&p.Variants[0]); got != nil {
+		t.Errorf("wanted no selectors for product with a single variant, got %+v instead", got)
+	}
+}
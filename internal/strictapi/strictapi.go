@@ -22,8 +22,39 @@ func (e *AllowedEndpoints) Check(r *http.Request) bool {
 	return false
 }
 
+// Methods allowed for the path of the request, regardless of its method.
+// If no endpoint matches the path, it returns nil.
+func (e *AllowedEndpoints) Methods(r *http.Request) []string {
+	var methods []string
+	for _, endpoint := range e.endpoints {
+		if matchPath(r.URL.Path, endpoint.path) {
+			methods = append(methods, endpoint.methods...)
+		}
+	}
+	return methods
+}
+
+// Endpoint on the allow-list.
+type Endpoint struct {
+	Path    string
+	Methods []string
+}
+
+// Endpoints on the allow-list, in the order they were added.
+func (e *AllowedEndpoints) Endpoints() []Endpoint {
+	var endpoints []Endpoint
+	for _, endpoint := range e.endpoints {
+		endpoints = append(endpoints, Endpoint{
+			Path:    endpoint.path,
+			Methods: append([]string{}, endpoint.methods...),
+		})
+	}
+	return endpoints
+}
+
 // Add endpoints.
 // Uses %s for parameters (that must always be on their own).
+// Adding a path again extends the methods allowed for it.
 //
 // Must be called during initialization (not concurrency safe).
 func (e *AllowedEndpoints) Add(path string, methods ...string) {
@@ -37,6 +68,12 @@ func (e *AllowedEndpoints) Add(path string, methods ...string) {
 		}
 	}
 
+	for x := range e.endpoints {
+		if e.endpoints[x].path == path {
+			e.endpoints[x].methods = append(e.endpoints[x].methods, methods...)
+			return
+		}
+	}
 	e.endpoints = append(e.endpoints, endpoint{
 		path:    path,
 		methods: methods,
@@ -44,18 +81,9 @@ func (e *AllowedEndpoints) Add(path string, methods ...string) {
 }
 
 func (e *AllowedEndpoints) matchRoute(r *http.Request, endpoint *endpoint) bool {
-	route := strings.FieldsFunc(endpoint.path, isPathSeparator)
-	visited := strings.FieldsFunc(r.URL.Path, isPathSeparator)
-
-	// The API endpoint URIs follow a rigid structure and can only match if the pattern matches exactly.
-	if len(route) != len(visited) {
+	if !matchPath(r.URL.Path, endpoint.path) {
 		return false
 	}
-	for x := 0; x < len(route); x++ {
-		if route[x] != "%s" && route[x] != visited[x] {
-			return false
-		}
-	}
 
 	// Check if method is allowed.
 	for _, method := range endpoint.methods {
@@ -66,6 +94,22 @@ func (e *AllowedEndpoints) matchRoute(r *http.Request, endpoint *endpoint) bool
 	return false
 }
 
+func matchPath(path, pattern string) bool {
+	route := strings.FieldsFunc(pattern, isPathSeparator)
+	visited := strings.FieldsFunc(path, isPathSeparator)
+
+	// The API endpoint URIs follow a rigid structure and can only match if the pattern matches exactly.
+	if len(route) != len(visited) {
+		return false
+	}
+	for x := 0; x < len(route); x++ {
+		if route[x] != "%s" && route[x] != visited[x] {
+			return false
+		}
+	}
+	return true
+}
+
 func isPathSeparator(r rune) bool {
 	return r == '/'
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"reflect"
 	"testing"
 )
 
@@ -89,3 +90,40 @@ func TestAllowedEndpointsInvalid(t *testing.T) {
 	aa.Add("/", http.MethodGet, "xyz") // This is going to panic because we expect uppercase HTTP methods only.
 
 }
+
+func TestAllowedEndpointsMethods(t *testing.T) {
+	var aa AllowedEndpoints
+	aa.Add("/products", http.MethodGet)
+	aa.Add("/products/%s", http.MethodGet)
+	aa.Add("/products/%s", http.MethodDelete)
+
+	testCases := []struct {
+		path string
+		want []string
+	}{
+		{path: "/", want: nil},
+		{path: "/products", want: []string{http.MethodGet}},
+		{path: "/products/foo", want: []string{http.MethodGet, http.MethodDelete}},
+		{path: "/products/foo/bar", want: nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			got := aa.Methods(&http.Request{
+				URL: &url.URL{
+					Path: tc.path,
+				},
+			})
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("wanted Methods(request) = %v, got %v instead", tc.want, got)
+			}
+		})
+	}
+
+	want := []Endpoint{
+		{Path: "/products", Methods: []string{http.MethodGet}},
+		{Path: "/products/%s", Methods: []string{http.MethodGet, http.MethodDelete}},
+	}
+	if got := aa.Endpoints(); !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted Endpoints() = %v, got %v instead", want, got)
+	}
+}
@@ -0,0 +1,98 @@
+// Package totp implements time-based one-time passwords, as used by authenticator apps.
+//
+// See https://tools.ietf.org/html/rfc6238
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" // #nosec SHA-1 is what authenticator apps support, and HMAC-SHA-1 is still safe.
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits of each code.
+	Digits = 6
+
+	// Period each code is valid for.
+	Period = 30 * time.Second
+
+	// Skew is the number of periods before and after the current one whose codes are also accepted,
+	// to tolerate clock drift and the time the user takes to type the code.
+	Skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret generates a random secret with 160 bits, encoded as base32.
+func NewSecret() string {
+	var r = make([]byte, 20)
+	if _, err := rand.Read(r); err != nil {
+		panic(err)
+	}
+	return encoding.EncodeToString(r)
+}
+
+// Step is the time step (counter) for the given time.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code for the secret on the given time step.
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("invalid secret: %w", err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, n%1000000), nil
+}
+
+// Validate a code for the secret at the given time.
+// It returns the time step the code matched, so that callers can reject codes that were already used.
+func Validate(secret, code string, t time.Time) (step int64, ok bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+	now := Step(t)
+	for s := now - Skew; s <= now+Skew; s++ {
+		want, err := Code(secret, s)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// URI to add the secret to an authenticator app, usually shown as a QR code.
+//
+// See https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func URI(secret, issuer, account string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period/time.Second)))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
@@ -0,0 +1,74 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret of the test vectors of RFC 6238, whose codes have 8 digits.
+// We use 6 digits, which are the last 6 digits of the codes.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	testCases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tc := range testCases {
+		got, err := Code(rfcSecret, Step(time.Unix(tc.unix, 0)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != tc.want {
+			t.Errorf("wanted code %q at %d, got %q instead", tc.want, tc.unix, got)
+		}
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("wanted error for invalid secret")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, err := Code(rfcSecret, Step(now.Add(-Period)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step, ok := Validate(rfcSecret, code, now); !ok || step != Step(now)-1 {
+		t.Errorf("wanted code of previous period to be valid for step %d, got %d (%v) instead", Step(now)-1, step, ok)
+	}
+	if _, ok := Validate(rfcSecret, code, now.Add(2*Period)); ok {
+		t.Error("wanted old code to be invalid")
+	}
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("wanted code %q to be invalid", code)
+		}
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	a, b := NewSecret(), NewSecret()
+	if a == b || len(a) != 32 {
+		t.Errorf("wanted unique 32 chars secrets, got %q and %q instead", a, b)
+	}
+	if _, err := Code(a, 1); err != nil {
+		t.Errorf("cannot use new secret: %v", err)
+	}
+}
+
+func TestURI(t *testing.T) {
+	got := URI("JBSWY3DPEHPK3PXP", "Market", "jane@example.com")
+	if !strings.HasPrefix(got, "otpauth://totp/Market:jane@example.com?") || !strings.Contains(got, "secret=JBSWY3DPEHPK3PXP") || !strings.Contains(got, "issuer=Market") {
+		t.Errorf("unexpected URI %q", got)
+	}
+}
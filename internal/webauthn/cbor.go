@@ -0,0 +1,142 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// errInvalidCBOR is returned when decoding malformed or unsupported CBOR.
+var errInvalidCBOR = errors.New("invalid CBOR")
+
+// maxCBORDepth of nested arrays and maps. WebAuthn structures are shallow.
+const maxCBORDepth = 8
+
+// decodeCBOR decodes the first CBOR data item of b, returning it and the remaining bytes.
+//
+// Only what WebAuthn uses is supported (https://tools.ietf.org/html/rfc8949):
+// integers (as int64), byte strings ([]byte), text strings (string), arrays ([]interface{}),
+// maps with integer or text keys (map[interface{}]interface{}), booleans, and null.
+// Tags are ignored, and indefinite-length items and floats are rejected.
+func decodeCBOR(b []byte) (v interface{}, rest []byte, err error) {
+	return decodeCBORItem(b, 0)
+}
+
+func decodeCBORItem(b []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, fmt.Errorf("%w: too deep", errInvalidCBOR)
+	}
+	major, arg, b, err := decodeCBORHead(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: integer overflow", errInvalidCBOR)
+		}
+		return int64(arg), b, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: integer overflow", errInvalidCBOR)
+		}
+		return -1 - int64(arg), b, nil
+	case 2, 3:
+		if arg > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", errInvalidCBOR)
+		}
+		if major == 2 {
+			return append([]byte{}, b[:arg]...), b[arg:], nil
+		}
+		return string(b[:arg]), b[arg:], nil
+	case 4:
+		if arg > uint64(len(b)) { // Each item takes at least a byte.
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", errInvalidCBOR)
+		}
+		a := make([]interface{}, arg)
+		for i := range a {
+			if a[i], b, err = decodeCBORItem(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+		}
+		return a, b, nil
+	case 5:
+		if arg > uint64(len(b))/2 {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", errInvalidCBOR)
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var k, v interface{}
+			if k, b, err = decodeCBORItem(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("%w: unsupported map key type %T", errInvalidCBOR, k)
+			}
+			if _, ok := m[k]; ok {
+				return nil, nil, fmt.Errorf("%w: duplicate map key %v", errInvalidCBOR, k)
+			}
+			if v, b, err = decodeCBORItem(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			m[k] = v
+		}
+		return m, b, nil
+	case 6:
+		return decodeCBORItem(b, depth+1)
+	}
+	// Major type 7: simple values and floats.
+	switch arg {
+	case 20:
+		return false, b, nil
+	case 21:
+		return true, b, nil
+	case 22, 23:
+		return nil, b, nil
+	}
+	return nil, nil, fmt.Errorf("%w: unsupported simple value or float", errInvalidCBOR)
+}
+
+// decodeCBORHead decodes the major type and argument of a data item.
+func decodeCBORHead(b []byte) (major byte, arg uint64, rest []byte, err error) {
+	if len(b) == 0 {
+		return 0, 0, nil, fmt.Errorf("%w: unexpected end of data", errInvalidCBOR)
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	b = b[1:]
+	if major == 7 && info >= 25 && info <= 27 {
+		return 0, 0, nil, fmt.Errorf("%w: floats are not supported", errInvalidCBOR)
+	}
+	var size int
+	switch {
+	case info < 24:
+		return major, uint64(info), b, nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, 0, nil, fmt.Errorf("%w: indefinite-length and reserved items are not supported", errInvalidCBOR)
+	}
+	if len(b) < size {
+		return 0, 0, nil, fmt.Errorf("%w: unexpected end of data", errInvalidCBOR)
+	}
+	switch size {
+	case 1:
+		arg = uint64(b[0])
+	case 2:
+		arg = uint64(binary.BigEndian.Uint16(b))
+	case 4:
+		arg = uint64(binary.BigEndian.Uint32(b))
+	case 8:
+		arg = binary.BigEndian.Uint64(b)
+	}
+	return major, arg, b[size:], nil
+}
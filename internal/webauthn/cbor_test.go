@@ -0,0 +1,60 @@
+package webauthn
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	testCases := []struct {
+		in   []byte
+		want interface{}
+	}{
+		{[]byte{0x00}, int64(0)},
+		{[]byte{0x17}, int64(23)},
+		{[]byte{0x18, 0x18}, int64(24)},
+		{[]byte{0x19, 0x03, 0xe8}, int64(1000)},
+		{[]byte{0x20}, int64(-1)},
+		{[]byte{0x39, 0x01, 0x00}, int64(-257)},
+		{[]byte{0x42, 0x01, 0x02}, []byte{1, 2}},
+		{[]byte{0x63, 'f', 'm', 't'}, "fmt"},
+		{[]byte{0x82, 0x01, 0xf5}, []interface{}{int64(1), true}},
+		{[]byte{0xa2, 0x01, 0x02, 0x61, 'a', 0xf6}, map[interface{}]interface{}{int64(1): int64(2), "a": nil}},
+		{[]byte{0xc2, 0x41, 0x01}, []byte{1}},
+	}
+	for _, tc := range testCases {
+		got, rest, err := decodeCBOR(append(tc.in, 0xff))
+		if err != nil {
+			t.Errorf("cannot decode %x: %v", tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("decoding %x: wanted %#v, got %#v instead", tc.in, tc.want, got)
+		}
+		if !reflect.DeepEqual(rest, []byte{0xff}) {
+			t.Errorf("decoding %x: wanted remaining bytes to be kept, got %x instead", tc.in, rest)
+		}
+	}
+}
+
+func TestDecodeCBORInvalid(t *testing.T) {
+	testCases := [][]byte{
+		{},
+		{0x18},
+		{0x43, 0x01},
+		{0x9a, 0xff, 0xff, 0xff, 0xff},
+		{0xa1, 0x01},
+		{0xa2, 0x01, 0x01, 0x01, 0x02},
+		{0xa1, 0x41, 0x01, 0x01},
+		{0x5f, 0x41, 0x01, 0xff},
+		{0xf9, 0x3c, 0x00},
+		{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		{0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x00},
+	}
+	for _, tc := range testCases {
+		if _, _, err := decodeCBOR(tc); !errors.Is(err, errInvalidCBOR) {
+			t.Errorf("wanted error decoding %x, got %v instead", tc, err)
+		}
+	}
+}
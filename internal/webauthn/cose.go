@@ -0,0 +1,90 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// COSE key parameters (https://tools.ietf.org/html/rfc8152#section-13).
+const (
+	coseKeyType   = 1
+	coseAlgorithm = 3
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+type publicKey struct {
+	algorithm int64
+	ecdsa     *ecdsa.PublicKey
+	ed25519   ed25519.PublicKey
+	rsa       *rsa.PublicKey
+}
+
+// parsePublicKey in the COSE_Key format.
+func parsePublicKey(b []byte) (*publicKey, error) {
+	v, _, err := decodeCBOR(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedKey, err)
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+	kty, _ := m[int64(coseKeyType)].(int64)
+	alg, _ := m[int64(coseAlgorithm)].(int64)
+	switch {
+	case kty == coseKeyTypeEC2 && alg == AlgorithmES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, ErrUnsupportedKey
+		}
+		k := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !k.Curve.IsOnCurve(k.X, k.Y) {
+			return nil, ErrUnsupportedKey
+		}
+		return &publicKey{algorithm: alg, ecdsa: k}, nil
+	case kty == coseKeyTypeOKP && alg == AlgorithmEdDSA:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		if crv != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, ErrUnsupportedKey
+		}
+		return &publicKey{algorithm: alg, ed25519: ed25519.PublicKey(x)}, nil
+	case kty == coseKeyTypeRSA && alg == AlgorithmRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, ErrUnsupportedKey
+		}
+		exp := new(big.Int).SetBytes(e)
+		return &publicKey{algorithm: alg, rsa: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}}, nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+func (k *publicKey) verify(data, sig []byte) bool {
+	switch k.algorithm {
+	case AlgorithmES256:
+		h := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(k.ecdsa, h[:], sig)
+	case AlgorithmEdDSA:
+		return ed25519.Verify(k.ed25519, data, sig)
+	case AlgorithmRS256:
+		h := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(k.rsa, crypto.SHA256, h[:], sig) == nil
+	}
+	return false
+}
@@ -0,0 +1,376 @@
+// Package webauthn implements the relying party side of Web Authentication,
+// letting users log in with passkeys and security keys instead of passwords.
+//
+// Attestation statements are not verified, as we don't restrict which authenticators users might use,
+// so options always ask for "none" attestation. User verification (PIN, biometrics) is always required,
+// so a credential is enough to log in.
+//
+// See https://www.w3.org/TR/webauthn-2/
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Timeout of the ceremonies, given to the browser.
+const Timeout = 5 * time.Minute
+
+// ChallengeSize in bytes.
+const ChallengeSize = 32
+
+var (
+	// ErrInvalidClientData is returned when the client data doesn't match the ceremony, challenge, or origin.
+	ErrInvalidClientData = errors.New("invalid WebAuthn client data")
+
+	// ErrInvalidAuthenticatorData is returned when the authenticator data is malformed or for another relying party.
+	ErrInvalidAuthenticatorData = errors.New("invalid WebAuthn authenticator data")
+
+	// ErrUserNotVerified is returned when the authenticator didn't verify the user.
+	ErrUserNotVerified = errors.New("the authenticator didn't verify the user")
+
+	// ErrUnsupportedKey is returned when the credential public key has an unsupported algorithm.
+	ErrUnsupportedKey = errors.New("unsupported WebAuthn credential key")
+
+	// ErrInvalidSignature is returned when the assertion signature is wrong.
+	ErrInvalidSignature = errors.New("invalid WebAuthn signature")
+
+	// ErrSignCount is returned when the signature counter didn't increase, which means the authenticator might have been cloned.
+	ErrSignCount = errors.New("WebAuthn signature counter didn't increase")
+)
+
+// Bytes encoded as base64url on JSON, as the browsers don't handle binary data on JSON.
+type Bytes []byte
+
+// MarshalJSON encodes the bytes as a base64url string.
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// UnmarshalJSON decodes a base64url string, with or without padding.
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
+
+// NewChallenge generates a random challenge for a ceremony.
+func NewChallenge() []byte {
+	var r = make([]byte, ChallengeSize)
+	if _, err := rand.Read(r); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// RelyingParty is the application users authenticate to.
+type RelyingParty struct {
+	// ID of the relying party, a domain such as "www.example.com". Credentials are scoped to it.
+	ID string
+
+	// Name shown by the authenticator.
+	Name string
+
+	// Origin of the pages using WebAuthn, such as "https://www.example.com".
+	Origin string
+}
+
+// User the credential is created for.
+type User struct {
+	// ID of the user. It is stored on the authenticator, and returned as the user handle when logging in.
+	ID Bytes `json:"id"`
+
+	// Name of the user, such as the email address.
+	Name string `json:"name"`
+
+	// DisplayName of the user.
+	DisplayName string `json:"displayName"`
+}
+
+// CredentialDescriptor identifies a credential.
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   Bytes  `json:"id"`
+}
+
+// CredentialParameters of a credential the relying party accepts.
+type CredentialParameters struct {
+	Type      string `json:"type"`
+	Algorithm int    `json:"alg"`
+}
+
+// AuthenticatorSelection criteria.
+type AuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+// RelyingPartyEntity describes the relying party to the authenticator.
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// CreationOptions for navigator.credentials.create, to register a credential.
+type CreationOptions struct {
+	Challenge              Bytes                  `json:"challenge"`
+	RelyingParty           RelyingPartyEntity     `json:"rp"`
+	User                   User                   `json:"user"`
+	Parameters             []CredentialParameters `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions for navigator.credentials.get, to log in.
+type RequestOptions struct {
+	Challenge        Bytes                  `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RelyingPartyID   string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// COSE algorithms supported.
+const (
+	AlgorithmES256 = -7
+	AlgorithmEdDSA = -8
+	AlgorithmRS256 = -257
+)
+
+// CreationOptions to register a credential for the user.
+// The credentials the user already has are excluded, so the same authenticator isn't registered twice.
+// Credentials are discoverable (passkeys), so users don't need to type their email address to log in.
+func (rp *RelyingParty) CreationOptions(challenge []byte, user User, exclude [][]byte) *CreationOptions {
+	o := &CreationOptions{
+		Challenge:    challenge,
+		RelyingParty: RelyingPartyEntity{ID: rp.ID, Name: rp.Name},
+		User:         user,
+		Parameters: []CredentialParameters{
+			{Type: "public-key", Algorithm: AlgorithmES256},
+			{Type: "public-key", Algorithm: AlgorithmEdDSA},
+			{Type: "public-key", Algorithm: AlgorithmRS256},
+		},
+		Timeout:            Timeout.Milliseconds(),
+		ExcludeCredentials: []CredentialDescriptor{},
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   "required",
+		},
+		Attestation: "none",
+	}
+	for _, id := range exclude {
+		o.ExcludeCredentials = append(o.ExcludeCredentials, CredentialDescriptor{Type: "public-key", ID: id})
+	}
+	return o
+}
+
+// RequestOptions to log in with any discoverable credential of the relying party.
+func (rp *RelyingParty) RequestOptions(challenge []byte) *RequestOptions {
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          Timeout.Milliseconds(),
+		RelyingPartyID:   rp.ID,
+		AllowCredentials: []CredentialDescriptor{},
+		UserVerification: "required",
+	}
+}
+
+// ClientData collected by the browser, and signed by the authenticator.
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   Bytes  `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// ParseClientData from its JSON encoding.
+// The challenge isn't checked, so it can be used to find the ceremony the response is for.
+func ParseClientData(clientDataJSON []byte) (*ClientData, error) {
+	var c ClientData
+	if err := json.Unmarshal(clientDataJSON, &c); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidClientData, err)
+	}
+	return &c, nil
+}
+
+func (rp *RelyingParty) verifyClientData(clientDataJSON []byte, typ string, challenge []byte) error {
+	c, err := ParseClientData(clientDataJSON)
+	if err != nil {
+		return err
+	}
+	if c.Type != typ || c.Origin != rp.Origin || c.CrossOrigin {
+		return ErrInvalidClientData
+	}
+	if len(challenge) == 0 || subtle.ConstantTimeCompare(c.Challenge, challenge) != 1 {
+		return ErrInvalidClientData
+	}
+	return nil
+}
+
+// Authenticator data flags.
+const (
+	flagUserPresent            = 0x01
+	flagUserVerified           = 0x04
+	flagAttestedCredentialData = 0x40
+)
+
+type authenticatorData struct {
+	flags     byte
+	signCount uint32
+
+	// Attested credential data, only present when registering.
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthenticatorData checks it is for the relying party, and that the user was verified.
+func (rp *RelyingParty) parseAuthenticatorData(b []byte) (*authenticatorData, error) {
+	const headerSize = 32 + 1 + 4 // rpIdHash, flags, signCount.
+	if len(b) < headerSize {
+		return nil, ErrInvalidAuthenticatorData
+	}
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(b[:32], rpIDHash[:]) {
+		return nil, ErrInvalidAuthenticatorData
+	}
+	d := &authenticatorData{
+		flags:     b[32],
+		signCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	if d.flags&flagUserPresent == 0 || d.flags&flagUserVerified == 0 {
+		return nil, ErrUserNotVerified
+	}
+	if d.flags&flagAttestedCredentialData == 0 {
+		return d, nil
+	}
+
+	b = b[headerSize:]
+	const aaguidSize = 16
+	if len(b) < aaguidSize+2 {
+		return nil, ErrInvalidAuthenticatorData
+	}
+	n := int(binary.BigEndian.Uint16(b[aaguidSize:]))
+	b = b[aaguidSize+2:]
+	if n == 0 || len(b) < n {
+		return nil, ErrInvalidAuthenticatorData
+	}
+	d.credentialID, b = append([]byte{}, b[:n]...), b[n:]
+	_, rest, err := decodeCBOR(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAuthenticatorData, err)
+	}
+	d.publicKey = append([]byte{}, b[:len(b)-len(rest)]...) // Extensions might follow the key.
+	return d, nil
+}
+
+// Credential registered by an authenticator.
+type Credential struct {
+	ID []byte
+
+	// PublicKey in the COSE_Key format.
+	PublicKey []byte
+
+	// SignCount is the signature counter of the authenticator, used to detect cloned authenticators.
+	// Many authenticators, such as synced passkeys, always return zero.
+	SignCount uint32
+}
+
+// AttestationResponse of navigator.credentials.create, sent by the browser.
+type AttestationResponse struct {
+	ID                Bytes `json:"id"`
+	ClientDataJSON    Bytes `json:"clientDataJSON"`
+	AttestationObject Bytes `json:"attestationObject"`
+}
+
+// VerifyRegistration of a credential created with the given challenge.
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, resp *AttestationResponse) (*Credential, error) {
+	if err := rp.verifyClientData(resp.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+	v, _, err := decodeCBOR(resp.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation object: %w", err)
+	}
+	obj, _ := v.(map[interface{}]interface{})
+	authData, ok := obj["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("invalid attestation object: missing authenticator data")
+	}
+	d, err := rp.parseAuthenticatorData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if d.credentialID == nil {
+		return nil, fmt.Errorf("%w: missing attested credential data", ErrInvalidAuthenticatorData)
+	}
+	if !bytes.Equal(d.credentialID, resp.ID) {
+		return nil, fmt.Errorf("%w: credential ID mismatch", ErrInvalidAuthenticatorData)
+	}
+	if _, err := parsePublicKey(d.publicKey); err != nil {
+		return nil, err
+	}
+	return &Credential{
+		ID:        d.credentialID,
+		PublicKey: d.publicKey,
+		SignCount: d.signCount,
+	}, nil
+}
+
+// AssertionResponse of navigator.credentials.get, sent by the browser.
+type AssertionResponse struct {
+	ID                Bytes `json:"id"`
+	ClientDataJSON    Bytes `json:"clientDataJSON"`
+	AuthenticatorData Bytes `json:"authenticatorData"`
+	Signature         Bytes `json:"signature"`
+
+	// UserHandle is the ID of the user the credential was created for.
+	UserHandle Bytes `json:"userHandle"`
+}
+
+// VerifyAssertion of the credential signed with the given challenge, returning the new signature counter.
+// The credential must be looked up with the ID of the response.
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, resp *AssertionResponse, cred *Credential) (signCount uint32, err error) {
+	if !bytes.Equal(resp.ID, cred.ID) {
+		return 0, errors.New("credential ID mismatch")
+	}
+	if err := rp.verifyClientData(resp.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+	d, err := rp.parseAuthenticatorData(resp.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	key, err := parsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(resp.ClientDataJSON)
+	signed := append(append([]byte{}, resp.AuthenticatorData...), clientDataHash[:]...)
+	if !key.verify(signed, resp.Signature) {
+		return 0, ErrInvalidSignature
+	}
+	if (d.signCount != 0 || cred.SignCount != 0) && d.signCount <= cred.SignCount {
+		return 0, ErrSignCount
+	}
+	return d.signCount, nil
+}
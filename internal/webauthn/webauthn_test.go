@@ -0,0 +1,134 @@
+package webauthn_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/plifk/market/internal/webauthn"
+	"github.com/plifk/market/internal/webauthn/webauthntest"
+)
+
+var rp = &webauthn.RelyingParty{
+	ID:     "www.example.com",
+	Name:   "Market",
+	Origin: "https://www.example.com",
+}
+
+func register(t *testing.T, a *webauthntest.Authenticator) *webauthn.Credential {
+	t.Helper()
+	challenge := webauthn.NewChallenge()
+	resp, err := a.Create(rp.CreationOptions(challenge, webauthn.User{ID: []byte("u1"), Name: "jane@example.com"}, nil))
+	if err != nil {
+		t.Fatalf("cannot create credential: %v", err)
+	}
+	cred, err := rp.VerifyRegistration(challenge, resp)
+	if err != nil {
+		t.Fatalf("cannot verify registration: %v", err)
+	}
+	return cred
+}
+
+func TestCeremonies(t *testing.T) {
+	a := &webauthntest.Authenticator{Origin: rp.Origin}
+	cred := register(t, a)
+
+	for i := 1; i <= 2; i++ {
+		challenge := webauthn.NewChallenge()
+		resp, err := a.Get(rp.RequestOptions(challenge))
+		if err != nil {
+			t.Fatalf("cannot get assertion: %v", err)
+		}
+		if string(resp.UserHandle) != "u1" {
+			t.Errorf("wanted user handle u1, got %q instead", resp.UserHandle)
+		}
+		signCount, err := rp.VerifyAssertion(challenge, resp, cred)
+		if err != nil {
+			t.Fatalf("cannot verify assertion: %v", err)
+		}
+		if signCount != uint32(i) {
+			t.Errorf("wanted sign count %d, got %d instead", i, signCount)
+		}
+		cred.SignCount = signCount
+	}
+
+	if _, err := a.Create(rp.CreationOptions(webauthn.NewChallenge(), webauthn.User{ID: []byte("u1")}, [][]byte{cred.ID})); err != webauthntest.ErrExcluded {
+		t.Errorf("wanted error %v creating an excluded credential, got %v instead", webauthntest.ErrExcluded, err)
+	}
+}
+
+func TestVerifyRegistrationInvalid(t *testing.T) {
+	challenge := webauthn.NewChallenge()
+	options := rp.CreationOptions(challenge, webauthn.User{ID: []byte("u1")}, nil)
+
+	other := &webauthn.RelyingParty{ID: "evil.example.com", Origin: rp.Origin}
+	phishing := &webauthntest.Authenticator{Origin: "https://www.examp1e.com"}
+	resp, err := phishing.Create(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rp.VerifyRegistration(challenge, resp); !errors.Is(err, webauthn.ErrInvalidClientData) {
+		t.Errorf("wanted error %v for another origin, got %v instead", webauthn.ErrInvalidClientData, err)
+	}
+
+	a := &webauthntest.Authenticator{Origin: rp.Origin}
+	if resp, err = a.Create(options); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rp.VerifyRegistration(webauthn.NewChallenge(), resp); !errors.Is(err, webauthn.ErrInvalidClientData) {
+		t.Errorf("wanted error %v for another challenge, got %v instead", webauthn.ErrInvalidClientData, err)
+	}
+	if _, err := other.VerifyRegistration(challenge, resp); !errors.Is(err, webauthn.ErrInvalidAuthenticatorData) {
+		t.Errorf("wanted error %v for another relying party, got %v instead", webauthn.ErrInvalidAuthenticatorData, err)
+	}
+
+	unverified := &webauthntest.Authenticator{Origin: rp.Origin, SkipUserVerification: true}
+	if resp, err = unverified.Create(options); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rp.VerifyRegistration(challenge, resp); err != webauthn.ErrUserNotVerified {
+		t.Errorf("wanted error %v, got %v instead", webauthn.ErrUserNotVerified, err)
+	}
+}
+
+func TestVerifyAssertionInvalid(t *testing.T) {
+	a := &webauthntest.Authenticator{Origin: rp.Origin}
+	cred := register(t, a)
+	challenge := webauthn.NewChallenge()
+	resp, err := a.Get(rp.RequestOptions(challenge))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rp.VerifyAssertion(webauthn.NewChallenge(), resp, cred); !errors.Is(err, webauthn.ErrInvalidClientData) {
+		t.Errorf("wanted error %v for another challenge, got %v instead", webauthn.ErrInvalidClientData, err)
+	}
+	tampered := *resp
+	tampered.Signature = append(append([]byte{}, resp.Signature[:len(resp.Signature)-1]...), resp.Signature[len(resp.Signature)-1]^1)
+	if _, err := rp.VerifyAssertion(challenge, &tampered, cred); err != webauthn.ErrInvalidSignature {
+		t.Errorf("wanted error %v for tampered signature, got %v instead", webauthn.ErrInvalidSignature, err)
+	}
+	other := register(t, &webauthntest.Authenticator{Origin: rp.Origin})
+	other.ID = cred.ID
+	if _, err := rp.VerifyAssertion(challenge, resp, other); err != webauthn.ErrInvalidSignature {
+		t.Errorf("wanted error %v for another key, got %v instead", webauthn.ErrInvalidSignature, err)
+	}
+	cloned := *cred
+	cloned.SignCount = 5
+	if _, err := rp.VerifyAssertion(challenge, resp, &cloned); err != webauthn.ErrSignCount {
+		t.Errorf("wanted error %v, got %v instead", webauthn.ErrSignCount, err)
+	}
+}
+
+func TestBytesJSON(t *testing.T) {
+	b, err := json.Marshal(webauthn.Bytes{0xfb, 0xff})
+	if err != nil || string(b) != `"-_8"` {
+		t.Errorf("wanted base64url encoding, got %s (error: %v)", b, err)
+	}
+	for _, s := range []string{`"-_8"`, `"-_8="`} {
+		var got webauthn.Bytes
+		if err := json.Unmarshal([]byte(s), &got); err != nil || string(got) != "\xfb\xff" {
+			t.Errorf("cannot decode %s: got %x (error: %v)", s, got, err)
+		}
+	}
+}
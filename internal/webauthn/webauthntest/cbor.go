@@ -0,0 +1,52 @@
+package webauthntest
+
+import "encoding/binary"
+
+// cborMap keeps the order of its keys when encoded.
+type cborMap []struct {
+	key, value interface{}
+}
+
+// encodeCBOR encodes the values a WebAuthn authenticator returns: integers, strings, byte strings, and maps.
+func encodeCBOR(v interface{}) []byte {
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case cborMap:
+		b := cborHead(5, uint64(len(v)))
+		for _, kv := range v {
+			b = append(b, encodeCBOR(kv.key)...)
+			b = append(b, encodeCBOR(kv.value)...)
+		}
+		return b
+	}
+	panic("webauthntest: cannot encode value as CBOR")
+}
+
+func cborHead(major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return []byte{major | byte(n)}
+	case n <= 0xff:
+		return []byte{major | 24, byte(n)}
+	case n <= 0xffff:
+		b := []byte{major | 25, 0, 0}
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		return b
+	case n <= 0xffffffff:
+		b := []byte{major | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		return b
+	}
+	b := []byte{major | 27, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(b[1:], n)
+	return b
+}
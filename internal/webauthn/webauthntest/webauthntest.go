@@ -0,0 +1,162 @@
+// Package webauthntest provides a software authenticator, to test WebAuthn ceremonies without a browser.
+package webauthntest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"github.com/plifk/market/internal/webauthn"
+)
+
+// ErrNoCredential is returned when the authenticator has no credential the relying party accepts.
+var ErrNoCredential = errors.New("no credential available")
+
+// ErrExcluded is returned when creating a credential on an authenticator that already has an excluded credential.
+var ErrExcluded = errors.New("credential already registered")
+
+// Authenticator with discoverable ES256 credentials (passkeys), kept in memory.
+type Authenticator struct {
+	// Origin of the browser the authenticator is used from, such as "https://www.example.com".
+	Origin string
+
+	// SkipUserVerification simulates an authenticator that only checks for the user presence.
+	SkipUserVerification bool
+
+	credentials []*credential
+}
+
+type credential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+}
+
+// Create a credential, like navigator.credentials.create.
+func (a *Authenticator) Create(o *webauthn.CreationOptions) (*webauthn.AttestationResponse, error) {
+	for _, c := range a.credentials {
+		for _, e := range o.ExcludeCredentials {
+			if bytes.Equal(c.id, e.ID) {
+				return nil, ErrExcluded
+			}
+		}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	c := &credential{
+		id:         make([]byte, 16),
+		rpID:       o.RelyingParty.ID,
+		userHandle: o.User.ID,
+		key:        key,
+	}
+	if _, err := rand.Read(c.id); err != nil {
+		return nil, err
+	}
+	a.credentials = append(a.credentials, c)
+
+	clientDataJSON, err := a.clientData("webauthn.create", o.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	authData := a.authenticatorData(c, 0x40)
+	authData = append(authData, make([]byte, 16)...) // AAGUID.
+	authData = append(authData, byte(len(c.id)>>8), byte(len(c.id)))
+	authData = append(authData, c.id...)
+	authData = append(authData, encodeCBOR(cborMap{
+		{1, 2},  // kty: EC2.
+		{3, -7}, // alg: ES256.
+		{-1, 1}, // crv: P-256.
+		{-2, padded(key.X.Bytes())},
+		{-3, padded(key.Y.Bytes())},
+	})...)
+	return &webauthn.AttestationResponse{
+		ID:             c.id,
+		ClientDataJSON: clientDataJSON,
+		AttestationObject: encodeCBOR(cborMap{
+			{"fmt", "none"},
+			{"attStmt", cborMap{}},
+			{"authData", authData},
+		}),
+	}, nil
+}
+
+// Get an assertion, like navigator.credentials.get.
+func (a *Authenticator) Get(o *webauthn.RequestOptions) (*webauthn.AssertionResponse, error) {
+	c := a.find(o)
+	if c == nil {
+		return nil, ErrNoCredential
+	}
+	clientDataJSON, err := a.clientData("webauthn.get", o.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	c.signCount++
+	authData := a.authenticatorData(c, 0)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	h := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, c.key, h[:])
+	if err != nil {
+		return nil, err
+	}
+	return &webauthn.AssertionResponse{
+		ID:                c.id,
+		ClientDataJSON:    clientDataJSON,
+		AuthenticatorData: authData,
+		Signature:         sig,
+		UserHandle:        c.userHandle,
+	}, nil
+}
+
+func (a *Authenticator) find(o *webauthn.RequestOptions) *credential {
+	for i := len(a.credentials) - 1; i >= 0; i-- {
+		c := a.credentials[i]
+		if c.rpID != o.RelyingPartyID {
+			continue
+		}
+		if len(o.AllowCredentials) == 0 {
+			return c
+		}
+		for _, allowed := range o.AllowCredentials {
+			if bytes.Equal(c.id, allowed.ID) {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) clientData(typ string, challenge []byte) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"type":        typ,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+}
+
+func (a *Authenticator) authenticatorData(c *credential, flags byte) []byte {
+	flags |= 0x01 // User present.
+	if !a.SkipUserVerification {
+		flags |= 0x04
+	}
+	rpIDHash := sha256.Sum256([]byte(c.rpID))
+	b := append(rpIDHash[:], flags)
+	var counter [4]byte
+	binary.BigEndian.PutUint32(counter[:], c.signCount)
+	return append(b, counter[:]...)
+}
+
+// padded coordinate of a P-256 point to 32 bytes.
+func padded(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
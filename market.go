@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v7"
@@ -48,11 +49,27 @@ func (s *System) Load(filename string) (err error) {
 	if err != nil {
 		return fmt.Errorf("cannot configure Elasticsearch: %w", err)
 	}
+	payments, err := services.NewPaymentProvider(settings.PaymentProvider)
+	if err != nil {
+		return fmt.Errorf("cannot configure payments: %w", err)
+	}
+	mailTransport, err := services.NewMailTransport(settings)
+	if err != nil {
+		return fmt.Errorf("cannot configure mail transport: %w", err)
+	}
+	sessionStore, err := services.NewSessionStore(settings, postgres, kv)
+	if err != nil {
+		return fmt.Errorf("cannot configure session storage: %w", err)
+	}
 	s.core = &services.Core{
 		Settings:       settings,
 		Postgres:       postgres,
 		Redis:          kv,
 		Elasticsearch:  elasticsearch,
+		Payments:       payments,
+		MailTransport:  mailTransport,
+		SessionStore:   sessionStore,
+		UserCache:      services.NewUserCache(),
 		CSRFProtection: csrfProtectionMiddleware(s),
 	}
 	s.Modules, err = services.NewModules(s.core)
@@ -80,6 +97,9 @@ func (s *System) ListenAndServe(ctx context.Context) (err error) {
 	go s.checkRedis(ctx)
 	defer s.core.Postgres.Close()
 	s.httpHandlers()
+	go s.Modules.Mailer.Run(ctx)
+	go s.Modules.Scheduler.Run(ctx)
+	go s.Modules.Accounts.SyncUserCache(ctx)
 	go s.handleShutdown(ctx)
 	settings := s.core.Settings
 	if settings.HTTPCertFile == "" && settings.HTTPKeyFile == "" {
@@ -151,5 +171,9 @@ func csrfProtectionMiddleware(h http.Handler) *services.CSRFProtection {
 		http.Error(w, `400 Bad Request: service denied. Possible HTTP request forgery.
 CSRF token not matching expected value. Try again.`, http.StatusBadRequest)
 	}))
+	// The API doesn't use cookies for authentication, so requests to it cannot be forged by browsers.
+	middleware.ExemptFunc(func(r *http.Request) bool {
+		return strings.HasPrefix(r.Host, "api.")
+	})
 	return middleware
 }